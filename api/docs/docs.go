@@ -17,6 +17,11 @@ const docTemplate = `{
     "paths": {
         "/admin/add": {
             "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
                 "description": "Add reference image to database",
                 "consumes": [
                     "multipart/form-data"
@@ -41,6 +46,43 @@ const docTemplate = `{
                         "description": "Custom image name",
                         "name": "name",
                         "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Comma-separated tags to scope recognize searches by",
+                        "name": "tags",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "reject (default) to refuse an image whose hash is already stored, or alias to save it and record its filename as an alias of the stored image",
+                        "name": "on_duplicate",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Store only the hashes and thumbnail, marking the image features_pending until POST /admin/reindex; it is matched by hash alone meanwhile",
+                        "name": "skip_features",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Only report whether the image would be accepted and its closest stored near-duplicate; nothing is saved",
+                        "name": "dry_run",
+                        "in": "query"
+                    },
+                    {
+                        "type": "number",
+                        "default": 95,
+                        "description": "Hash similarity (0-100) at which dry_run reports a near-duplicate",
+                        "name": "threshold",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Tenant whose isolated database is used, created when it does not exist",
+                        "name": "X-Tenant",
+                        "in": "header"
                     }
                 ],
                 "responses": {
@@ -74,19 +116,97 @@ const docTemplate = `{
                 }
             }
         },
-        "/admin/hello": {
+        "/admin/clear": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Remove every reference image from memory and flush cached results. With delete_files=true the files of the removed images are deleted from the image directory too; otherwise they are reloaded on the next restart.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Image Database Management"
+                ],
+                "summary": "Clear database",
+                "parameters": [
+                    {
+                        "type": "boolean",
+                        "description": "Also delete the removed images' files",
+                        "name": "delete_files",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Tenant whose isolated database is used",
+                        "name": "X-Tenant",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/config": {
             "get": {
-                "description": "Test connection endpoint",
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Return the effective configuration: every setting keyed by its environment variable, with its value and whether /admin/config/reload can change it, and the state changed through other admin endpoints (match method, metric) or fixed at startup (feature extractor, result cache TTL). PHOTOT_ADMIN_API_KEY and PHOTOT_WEBHOOK_SECRET read [redacted] when set.",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
                     "Image Database Management"
                 ],
-                "summary": "Hello endpoint",
+                "summary": "Current configuration",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/config/reload": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Re-read configuration from the environment and apply hot-reloadable settings",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Image Database Management"
+                ],
+                "summary": "Reload configuration",
                 "responses": {
                     "200": {
                         "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
                         "schema": {
                             "type": "object",
                             "additionalProperties": {
@@ -97,25 +217,40 @@ const docTemplate = `{
                 }
             }
         },
-        "/admin/toggle-ml": {
-            "post": {
-                "description": "Enable/disable ML-based recognition",
-                "consumes": [
-                    "multipart/form-data"
+        "/admin/duplicates": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
                 ],
+                "description": "Cluster stored images whose perceptual hashes are near-identical",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
                     "Image Database Management"
                 ],
-                "summary": "Toggle ML mode",
+                "summary": "Find duplicate images",
                 "parameters": [
+                    {
+                        "type": "number",
+                        "default": 95,
+                        "description": "Hash similarity (0-100) for two images to count as duplicates",
+                        "name": "threshold",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Run as a background job and return its job_id",
+                        "name": "async",
+                        "in": "query"
+                    },
                     {
                         "type": "string",
-                        "description": "Set to 'true' or 'false'",
-                        "name": "enable",
-                        "in": "formData"
+                        "description": "Tenant whose isolated database is used",
+                        "name": "X-Tenant",
+                        "in": "header"
                     }
                 ],
                 "responses": {
@@ -125,43 +260,93 @@ const docTemplate = `{
                             "type": "object",
                             "additionalProperties": true
                         }
+                    },
+                    "202": {
+                        "description": "Accepted",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
                     }
                 }
             }
         },
-        "/recognize": {
+        "/admin/features/export": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Stream the ML feature vector of every stored image as newline-delimited JSON, one {\"id\", \"filename\", \"features\", \"feature_type\"} object per line ordered by filename, e.g. to build an external ANN index. Quantized vectors are expanded to float64.",
+                "produces": [
+                    "application/x-ndjson"
+                ],
+                "tags": [
+                    "Image Database Management"
+                ],
+                "summary": "Export feature vectors",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Tenant whose isolated database is used",
+                        "name": "X-Tenant",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/database.FeatureRecord"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/features/import": {
             "post": {
-                "description": "Compare uploaded image against database using ML or hashing",
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Replace the ML feature vectors of stored images with precomputed ones, in the newline-delimited JSON format of /admin/features/export. Lines are matched to images by filename; unknown files, malformed lines, vectors whose length differs from the stored ones and vectors whose feature_type is not PHOTOT_FEATURE_EXTRACTOR are reported and skipped without failing the import. Vectors are quantized per PHOTOT_FEATURE_QUANTIZATION. Imported vectors last until the next restart, which extracts features afresh.",
                 "consumes": [
-                    "multipart/form-data"
+                    "application/x-ndjson"
                 ],
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "Image Recognition"
+                    "Image Database Management"
                 ],
-                "summary": "Recognize image",
+                "summary": "Import feature vectors",
                 "parameters": [
                     {
-                        "type": "file",
-                        "description": "Image file to check",
-                        "name": "image",
-                        "in": "formData",
-                        "required": true
-                    },
-                    {
-                        "type": "number",
-                        "description": "Similarity threshold (0-100)",
-                        "name": "threshold",
-                        "in": "formData"
+                        "type": "string",
+                        "description": "Tenant whose isolated database is used",
+                        "name": "X-Tenant",
+                        "in": "header"
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/database.RecognizeResponse"
+                            "type": "object",
+                            "additionalProperties": true
                         }
                     },
                     "400": {
@@ -172,9 +357,28 @@ const docTemplate = `{
                                 "type": "string"
                             }
                         }
-                    },
-                    "500": {
-                        "description": "Internal Server Error",
+                    }
+                }
+            }
+        },
+        "/admin/hello": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Test connection endpoint",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Image Database Management"
+                ],
+                "summary": "Hello endpoint",
+                "responses": {
+                    "200": {
+                        "description": "OK",
                         "schema": {
                             "type": "object",
                             "additionalProperties": {
@@ -184,29 +388,1671 @@ const docTemplate = `{
                     }
                 }
             }
-        }
-    },
-    "definitions": {
-        "database.RecognizeResponse": {
-            "type": "object",
-            "properties": {
-                "matched_image": {
-                    "type": "string"
-                },
-                "method": {
-                    "description": "\"ml\" or \"hash\"",
-                    "type": "string"
-                },
-                "processing_time_ms": {
-                    "type": "integer"
-                },
-                "result": {
-                    "type": "string"
-                },
-                "similarity": {
-                    "type": "number"
+        },
+        "/admin/image/{id}": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Return the full record of one reference image, found by its stable ID or its DCT hash",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Image Database Management"
+                ],
+                "summary": "Get image",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Stable image ID or DCT hash",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Include the ML feature vector",
+                        "name": "include_features",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Tenant whose isolated database is used",
+                        "name": "X-Tenant",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/database.ImageInfo"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
                 }
-            }
+            },
+            "put": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Replace the pixels of a reference image, recomputing its hashes, features and thumbnail while keeping its stable ID, filename, tags and added_at",
+                "consumes": [
+                    "multipart/form-data"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Image Database Management"
+                ],
+                "summary": "Replace image",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Stable image ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "file",
+                        "description": "New image file",
+                        "name": "image",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Tenant whose isolated database is used",
+                        "name": "X-Tenant",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Remove a reference image and its file, and the files of its aliases, by stable ID",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Image Database Management"
+                ],
+                "summary": "Delete image",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Stable image ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Tenant whose isolated database is used",
+                        "name": "X-Tenant",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/image/{id}/thumbnail": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Serve the stored thumbnail of a reference image",
+                "produces": [
+                    "image/jpeg",
+                    "image/png",
+                    "image/webp"
+                ],
+                "tags": [
+                    "Image Database Management"
+                ],
+                "summary": "Get image thumbnail",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Stored image filename",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Tenant whose isolated database is used",
+                        "name": "X-Tenant",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "file"
+                        }
+                    },
+                    "304": {
+                        "description": "Not modified"
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/jobs/{id}": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Report the status, progress and result of a background job",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Image Database Management"
+                ],
+                "summary": "Get job status",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Job ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/jobs.Job"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/list": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "List one page of stored reference images with their stable IDs and thumbnails",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Image Database Management"
+                ],
+                "summary": "List images",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "1-based page number, default 1",
+                        "name": "page",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Images per page, default 50, at most 500",
+                        "name": "page_size",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "added_at (default) or filename",
+                        "name": "sort",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "asc (default) or desc",
+                        "name": "order",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Tenant whose isolated database is used",
+                        "name": "X-Tenant",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/database.ImagePage"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/method": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Choose the branches recognition runs: auto tries ML and falls back to hashing (blending both when PHOTOT_ML_WEIGHT and PHOTOT_HASH_WEIGHT are set), ml matches by features alone, hash by perceptual hashes alone, combined always blends both, and strict matches only when both clear their thresholds",
+                "consumes": [
+                    "multipart/form-data"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Image Database Management"
+                ],
+                "summary": "Set match method",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "auto, ml, hash, combined or strict; omit to read the current method",
+                        "name": "method",
+                        "in": "formData"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/metric": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Choose how ML feature vectors are compared: cosine, euclidean or manhattan",
+                "consumes": [
+                    "multipart/form-data"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Image Database Management"
+                ],
+                "summary": "Set feature distance metric",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "cosine, euclidean or manhattan; omit to read the current metric",
+                        "name": "metric",
+                        "in": "formData"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/reindex": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Extract the ML features of every image added with skip_features, reading each from the image directory, so the ML branch can match it again. Files that cannot be read are reported in failed and stay pending. Restarts extract every image's features anyway.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Image Database Management"
+                ],
+                "summary": "Extract pending features",
+                "parameters": [
+                    {
+                        "type": "boolean",
+                        "description": "Run as a background job and return its job_id",
+                        "name": "async",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Tenant whose isolated database is used",
+                        "name": "X-Tenant",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/database.ReindexResult"
+                        }
+                    },
+                    "202": {
+                        "description": "Accepted",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/selftest": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Match edited copies of the uploaded image (half size, JPEG quality 30, rotated 5 degrees, center 90% crop) against the original alone, with the current match method, metric, weights and thresholds, to pick a threshold for your own images. Nothing is stored.",
+                "consumes": [
+                    "multipart/form-data"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Image Database Management"
+                ],
+                "summary": "Self-similarity check",
+                "parameters": [
+                    {
+                        "type": "file",
+                        "description": "Image to test",
+                        "name": "image",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "number",
+                        "description": "Similarity threshold (0-100), defaults to PHOTOT_DEFAULT_THRESHOLD",
+                        "name": "threshold",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "number",
+                        "description": "Similarity threshold for the ML branch (0-100), defaults to threshold",
+                        "name": "ml_threshold",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "number",
+                        "description": "Similarity threshold for the hash fallback (0-100), defaults to threshold",
+                        "name": "hash_threshold",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Tenant whose match settings are used",
+                        "name": "X-Tenant",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/toggle-ml": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Enable/disable ML-based recognition. Superseded by /admin/method: enabling selects the auto method and disabling the hash method.",
+                "consumes": [
+                    "multipart/form-data"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Image Database Management"
+                ],
+                "summary": "Toggle ML mode",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Set to 'true' or 'false'",
+                        "name": "enable",
+                        "in": "formData"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/compare": {
+            "post": {
+                "description": "Score how similar two uploaded images are without touching the database. method picks the measure: ml compares HOG feature vectors under the configured metric, hash compares DCT hashes, ssim computes the structural similarity of grayscale copies. All report a 0-100 similarity; ssim is the strictest about layout and the loosest about being a copy.",
+                "consumes": [
+                    "multipart/form-data"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Image Recognition"
+                ],
+                "summary": "Compare two images",
+                "parameters": [
+                    {
+                        "type": "file",
+                        "description": "First image",
+                        "name": "image1",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "file",
+                        "description": "Second image",
+                        "name": "image2",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "ml, hash or ssim; defaults to ml when ML is on, hash otherwise",
+                        "name": "method",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Tenant whose metric and hash settings are used",
+                        "name": "X-Tenant",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/database.CompareResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/compare-to/{id}": {
+            "post": {
+                "description": "Score the uploaded image against one stored image, given by stable ID, hash or filename, as /compare scores two uploads, skipping the scan of the whole database. ml and hash use the stored vector and hash; ssim decodes the stored file. 409 when the stored image lacks what method compares: a feature vector still pending or from another extractor, or a hash computed with other hash settings.",
+                "consumes": [
+                    "multipart/form-data"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Image Recognition"
+                ],
+                "summary": "Compare an image with a stored one",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Stable image ID, DCT hash or filename",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "file",
+                        "description": "Image to compare",
+                        "name": "image",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "ml, hash or ssim; defaults to ml when ML is on, hash otherwise",
+                        "name": "method",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Tenant whose isolated database is used",
+                        "name": "X-Tenant",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/database.CompareResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/compare/matrix": {
+            "post": {
+                "description": "Score every pair of the uploaded images as /compare does, for clustering and other offline analysis. matrix[i][j] compares the i-th and j-th image parts and the diagonal is 100. Each image is prepared once and each pair compared once, PHOTOT_BATCH_WORKERS pairs at a time. Nothing is stored.",
+                "consumes": [
+                    "multipart/form-data"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Image Recognition"
+                ],
+                "summary": "Similarity matrix",
+                "parameters": [
+                    {
+                        "type": "file",
+                        "description": "Images to compare, one part per image, 2 to PHOTOT_MAX_MATRIX_SIZE",
+                        "name": "image",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "ml, hash or ssim; defaults to ml when ML is on, hash otherwise",
+                        "name": "method",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Tenant whose metric and hash settings are used",
+                        "name": "X-Tenant",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/hash": {
+            "post": {
+                "description": "Return the perceptual hash of an uploaded image without storing or matching it, along with the ID of the hash config (PHOTOT_HASH_SIZE, PHOTOT_HASH_GRID) it was computed with",
+                "consumes": [
+                    "multipart/form-data"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Image Recognition"
+                ],
+                "summary": "Compute image hash",
+                "parameters": [
+                    {
+                        "type": "file",
+                        "description": "Image file to hash",
+                        "name": "image",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Also return the HOG feature vector",
+                        "name": "features",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/health": {
+            "get": {
+                "description": "Report that the server is up, how many reference images it holds and the recognize load",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Image Recognition"
+                ],
+                "summary": "Health check",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/metadata": {
+            "post": {
+                "description": "Return the dimensions, format and EXIF camera, capture time and GPS location of an uploaded image without storing or matching it. Images without EXIF get an empty exif object; GPS is omitted when PHOTOT_METADATA_STRIP_GPS is set.",
+                "consumes": [
+                    "multipart/form-data"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Image Recognition"
+                ],
+                "summary": "Read image metadata",
+                "parameters": [
+                    {
+                        "type": "file",
+                        "description": "Image file to inspect",
+                        "name": "image",
+                        "in": "formData",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/recognize": {
+            "post": {
+                "description": "Compare uploaded image against database using ML or hashing. Every response carries an ETag derived from the image pixels, the options, the configuration and the database version; retrying with that value in If-None-Match returns the stored result of the first request, unchanged and without reprocessing, for up to 5 minutes.",
+                "consumes": [
+                    "multipart/form-data"
+                ],
+                "produces": [
+                    "application/json",
+                    "application/x-protobuf"
+                ],
+                "tags": [
+                    "Image Recognition"
+                ],
+                "summary": "Recognize image",
+                "parameters": [
+                    {
+                        "type": "file",
+                        "description": "Image file to check",
+                        "name": "image",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "number",
+                        "description": "Similarity threshold (0-100), defaults to PHOTOT_DEFAULT_THRESHOLD",
+                        "name": "threshold",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "number",
+                        "description": "Similarity threshold for the ML branch (0-100), defaults to threshold",
+                        "name": "ml_threshold",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "number",
+                        "description": "Similarity threshold for the hash fallback (0-100), defaults to threshold",
+                        "name": "hash_threshold",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Region to match as x,y,w,h; reference images should be added uncropped",
+                        "name": "crop",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Units of crop: px (default) or fraction",
+                        "name": "crop_units",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Also try the query rotated by 90, 180 and 270 degrees (4x slower)",
+                        "name": "rotation_invariant",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "tiled to hash square windows of the query instead of the whole image, e.g. to find a logo within a scene; robust to compare a 4x4 grid of blocks one by one, tolerating part of the query being covered",
+                        "name": "mode",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Window side in pixels for mode=tiled, default 128 capped at the shorter image side",
+                        "name": "tile_size",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Step between windows in pixels for mode=tiled, default half of tile_size",
+                        "name": "tile_stride",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "number",
+                        "description": "Share (0-1] of blocks that must clear hash_threshold for mode=robust, defaults to PHOTOT_MIN_BLOCK_FRACTION",
+                        "name": "min_block_fraction",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Match the hash branch when at most this many DCT hash bits differ, instead of by hash_threshold",
+                        "name": "max_distance",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Also return up to N ranked candidates",
+                        "name": "top_n",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "number",
+                        "description": "Minimum similarity (0-100) for a candidate to be included in top_n",
+                        "name": "min_similarity",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "number",
+                        "description": "Similarity points (0-100) the best image must lead the second best by to match; ignored in tiled and robust modes",
+                        "name": "min_margin",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Include the matched image's stored base64 thumbnail on a match",
+                        "name": "include_matched_thumbnail",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Include the query's feature vector, as extracted by the configured extractor named in feature_type, e.g. to feed downstream models; adds kilobytes to the response",
+                        "name": "return_features",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Comma-separated tags; only images carrying all of them are compared",
+                        "name": "tags",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only compare images whose filename, ignoring the upload timestamp, starts with this",
+                        "name": "filename_prefix",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Tenant whose isolated database is used",
+                        "name": "X-Tenant",
+                        "in": "header"
+                    },
+                    {
+                        "type": "string",
+                        "description": "ETag of an earlier identical request whose result should be replayed",
+                        "name": "If-None-Match",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/database.RecognizeResponse"
+                        },
+                        "headers": {
+                            "ETag": {
+                                "type": "string",
+                                "description": "Identifies this result"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/recognize/batch": {
+            "post": {
+                "description": "Match every image part against the database as /recognize does, PHOTOT_BATCH_WORKERS at a time, e.g. the frames of a video. Results keep the order of the parts, each with its index; an image that cannot be decoded gets an error instead of a response without failing the rest. The batch counts as one request towards the rate and concurrency limits.",
+                "consumes": [
+                    "multipart/form-data"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Image Recognition"
+                ],
+                "summary": "Recognize a batch of images",
+                "parameters": [
+                    {
+                        "type": "file",
+                        "description": "Image files to check, one part per image, at most PHOTOT_MAX_BATCH_SIZE",
+                        "name": "image",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "number",
+                        "description": "Similarity threshold (0-100), defaults to PHOTOT_DEFAULT_THRESHOLD",
+                        "name": "threshold",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "number",
+                        "description": "Similarity threshold for the ML branch (0-100), defaults to threshold",
+                        "name": "ml_threshold",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "number",
+                        "description": "Similarity threshold for the hash fallback (0-100), defaults to threshold",
+                        "name": "hash_threshold",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Match the hash branch when at most this many DCT hash bits differ, instead of by hash_threshold",
+                        "name": "max_distance",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Also try each image rotated by 90, 180 and 270 degrees (4x slower)",
+                        "name": "rotation_invariant",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Comma-separated tags; only images carrying all of them are compared",
+                        "name": "tags",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only compare images whose filename, ignoring the upload timestamp, starts with this",
+                        "name": "filename_prefix",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Tenant whose isolated database is used",
+                        "name": "X-Tenant",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/recognize/batch/stream": {
+            "post": {
+                "description": "Match every image part as /recognize/batch does, but write each result as a line of newline-delimited JSON as soon as it is ready instead of buffering the whole batch, for batches of thousands of frames. Lines come in order of completion, not of the parts; each carries the index of its part. Errors of individual images are reported on their line; once streaming has started the status is 200.",
+                "consumes": [
+                    "multipart/form-data"
+                ],
+                "produces": [
+                    "application/x-ndjson"
+                ],
+                "tags": [
+                    "Image Recognition"
+                ],
+                "summary": "Stream the recognition of a batch of images",
+                "parameters": [
+                    {
+                        "type": "file",
+                        "description": "Image files to check, one part per image, at most PHOTOT_MAX_STREAM_BATCH_SIZE",
+                        "name": "image",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "number",
+                        "description": "Similarity threshold (0-100), defaults to PHOTOT_DEFAULT_THRESHOLD",
+                        "name": "threshold",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "number",
+                        "description": "Similarity threshold for the ML branch (0-100), defaults to threshold",
+                        "name": "ml_threshold",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "number",
+                        "description": "Similarity threshold for the hash fallback (0-100), defaults to threshold",
+                        "name": "hash_threshold",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Match the hash branch when at most this many DCT hash bits differ, instead of by hash_threshold",
+                        "name": "max_distance",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Also try each image rotated by 90, 180 and 270 degrees (4x slower)",
+                        "name": "rotation_invariant",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Comma-separated tags; only images carrying all of them are compared",
+                        "name": "tags",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only compare images whose filename, ignoring the upload timestamp, starts with this",
+                        "name": "filename_prefix",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Tenant whose isolated database is used",
+                        "name": "X-Tenant",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/version": {
+            "get": {
+                "description": "Identify the running binary: semantic version, git commit, build time and whether ML matching is available",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Image Recognition"
+                ],
+                "summary": "Build version",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        }
+    },
+    "definitions": {
+        "database.CompareResponse": {
+            "type": "object",
+            "properties": {
+                "filename": {
+                    "type": "string"
+                },
+                "id": {
+                    "description": "The stored image compared against, set by /compare-to only",
+                    "type": "string"
+                },
+                "method": {
+                    "type": "string"
+                },
+                "processing_time_ms": {
+                    "type": "integer"
+                },
+                "schema_version": {
+                    "type": "integer"
+                },
+                "similarity": {
+                    "type": "number"
+                },
+                "similarity_normalized": {
+                    "type": "number"
+                }
+            }
+        },
+        "database.FeatureRecord": {
+            "type": "object",
+            "properties": {
+                "feature_type": {
+                    "description": "FeatureType names the extractor of Features; empty in exports from\nbefore it was kept, which are taken to match the database",
+                    "type": "string"
+                },
+                "features": {
+                    "type": "array",
+                    "items": {
+                        "type": "number"
+                    }
+                },
+                "filename": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "string"
+                }
+            }
+        },
+        "database.ImageInfo": {
+            "type": "object",
+            "properties": {
+                "added_at": {
+                    "type": "string"
+                },
+                "aliases": {
+                    "description": "Aliases are the files of later uploads that collided with this image's\nhash and were recorded against it instead of being rejected, oldest\nfirst",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "content_hash": {
+                    "type": "string"
+                },
+                "feature_type": {
+                    "description": "FeatureType names the extractor that produced the vector, as returned\nby im.FeatureTypeOf; empty in records from before it was kept, which\nhold HOG vectors",
+                    "type": "string"
+                },
+                "features": {
+                    "description": "ML feature vector, nil when stored quantized",
+                    "type": "array",
+                    "items": {
+                        "type": "number"
+                    }
+                },
+                "features_pending": {
+                    "description": "FeaturesPending is set on images added with AddOptions.SkipFeatures\nuntil ExtractPending fills their vector in. Until then they are\nmatched by hash alone.",
+                    "type": "boolean"
+                },
+                "filename": {
+                    "type": "string"
+                },
+                "format": {
+                    "type": "string"
+                },
+                "hash": {
+                    "type": "string"
+                },
+                "hash_config": {
+                    "description": "im.HashConfig.ID of the config Hash was computed with",
+                    "type": "string"
+                },
+                "height": {
+                    "type": "integer"
+                },
+                "id": {
+                    "description": "Stable ID, unaffected by the hash algorithm",
+                    "type": "string"
+                },
+                "quantized_features": {
+                    "description": "Quantized replaces Features when vectors are stored at reduced precision",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/image.QuantizedVector"
+                        }
+                    ]
+                },
+                "tags": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "thumbnail": {
+                    "type": "string"
+                },
+                "wavelet_hash": {
+                    "description": "WaveletHash is the Haar wavelet hash, blended into hash similarity by\nMatchOptions.WaveletWeight",
+                    "type": "string"
+                },
+                "width": {
+                    "description": "Dimensions of the original image and its format, as named by\nim.SupportedImageFormats; unset in records from before they were kept",
+                    "type": "integer"
+                }
+            }
+        },
+        "database.ImagePage": {
+            "type": "object",
+            "properties": {
+                "images": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/database.ImageInfo"
+                    }
+                },
+                "page": {
+                    "type": "integer"
+                },
+                "page_size": {
+                    "type": "integer"
+                },
+                "total": {
+                    "description": "Images across all pages",
+                    "type": "integer"
+                },
+                "total_pages": {
+                    "type": "integer"
+                }
+            }
+        },
+        "database.MatchCandidate": {
+            "type": "object",
+            "properties": {
+                "filename": {
+                    "type": "string"
+                },
+                "similarity": {
+                    "type": "number"
+                }
+            }
+        },
+        "database.RecognizeResponse": {
+            "type": "object",
+            "properties": {
+                "block_fraction": {
+                    "description": "Share of blocks that agreed in robust mode",
+                    "type": "number"
+                },
+                "candidates": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/database.MatchCandidate"
+                    }
+                },
+                "confidence": {
+                    "description": "ConfidenceHigh, ConfidenceMedium, ConfidenceLow or ConfidenceNone",
+                    "type": "string"
+                },
+                "degraded": {
+                    "description": "Set when ML timed out and hashing decided",
+                    "type": "string"
+                },
+                "feature_type": {
+                    "description": "Extractor of features: hog, edge or custom",
+                    "type": "string"
+                },
+                "features": {
+                    "description": "Query feature vector, when requested",
+                    "type": "array",
+                    "items": {
+                        "type": "number"
+                    }
+                },
+                "hash_distance": {
+                    "description": "DCT hamming distance to matched_image, when hashing decided",
+                    "type": "integer"
+                },
+                "hash_similarity": {
+                    "description": "Best hash score, when both branches ran",
+                    "type": "number"
+                },
+                "low_entropy": {
+                    "description": "Query is nearly a solid color, see PHOTOT_MIN_CONTRAST",
+                    "type": "boolean"
+                },
+                "margin": {
+                    "description": "Lead of matched_image over the second-best image",
+                    "type": "number"
+                },
+                "matched_image": {
+                    "type": "string"
+                },
+                "matched_thumbnail": {
+                    "description": "Base64 thumbnail of matched_image, when requested",
+                    "type": "string"
+                },
+                "method": {
+                    "description": "\"ml\", \"hash\", \"combined\", \"strict\" or \"none\"",
+                    "type": "string"
+                },
+                "ml_similarity": {
+                    "description": "Best ML score, when both branches ran",
+                    "type": "number"
+                },
+                "processing_time_ms": {
+                    "type": "integer"
+                },
+                "result": {
+                    "type": "string"
+                },
+                "rotation": {
+                    "description": "Winning counter-clockwise query rotation in degrees",
+                    "type": "integer"
+                },
+                "schema_version": {
+                    "type": "integer"
+                },
+                "similarity": {
+                    "type": "number"
+                },
+                "similarity_normalized": {
+                    "type": "number"
+                },
+                "tile": {
+                    "description": "Best-matching query tile in tiled mode",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/database.Tile"
+                        }
+                    ]
+                }
+            }
+        },
+        "database.ReindexResult": {
+            "type": "object",
+            "properties": {
+                "extracted": {
+                    "description": "Images whose features were filled in",
+                    "type": "integer"
+                },
+                "failed": {
+                    "description": "Files that could not be read or extracted, still pending",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "pending": {
+                    "description": "Images still pending afterwards",
+                    "type": "integer"
+                }
+            }
+        },
+        "database.Tile": {
+            "type": "object",
+            "properties": {
+                "size": {
+                    "type": "integer"
+                },
+                "x": {
+                    "type": "integer"
+                },
+                "y": {
+                    "type": "integer"
+                }
+            }
+        },
+        "image.QuantizedVector": {
+            "type": "object",
+            "properties": {
+                "float16": {
+                    "type": "array",
+                    "items": {
+                        "type": "integer"
+                    }
+                },
+                "int8": {
+                    "type": "array",
+                    "items": {
+                        "type": "integer"
+                    }
+                },
+                "scale": {
+                    "description": "Value of one Int8 step",
+                    "type": "number"
+                }
+            }
+        },
+        "jobs.Job": {
+            "type": "object",
+            "properties": {
+                "created_at": {
+                    "type": "string"
+                },
+                "error": {
+                    "type": "string"
+                },
+                "finished_at": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "kind": {
+                    "type": "string"
+                },
+                "progress": {
+                    "type": "number"
+                },
+                "result": {},
+                "status": {
+                    "$ref": "#/definitions/jobs.Status"
+                }
+            }
+        },
+        "jobs.Status": {
+            "type": "string",
+            "enum": [
+                "pending",
+                "running",
+                "done",
+                "failed"
+            ],
+            "x-enum-varnames": [
+                "StatusPending",
+                "StatusRunning",
+                "StatusDone",
+                "StatusFailed"
+            ]
+        }
+    },
+    "securityDefinitions": {
+        "ApiKeyAuth": {
+            "type": "apiKey",
+            "name": "X-API-Key",
+            "in": "header"
         }
     }
 }`
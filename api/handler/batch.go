@@ -0,0 +1,226 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"mime/multipart"
+	"net/http"
+	"photot/api/middleware"
+	"photot/helper/database"
+	"photot/helper/i18n"
+	im "photot/helper/image"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// batchItem is the outcome of one image of a batch: a response, or an error
+// body in the format of a failed /recognize
+type batchItem struct {
+	Index    int                         `json:"index"`
+	Filename string                      `json:"filename"`
+	Response *database.RecognizeResponse `json:"response,omitempty"`
+	Error    gin.H                       `json:"error,omitempty"`
+
+	match  database.MatchResult
+	code   i18n.Code
+	detail string
+}
+
+// @Summary Recognize a batch of images
+// @Description Match every image part against the database as /recognize does, PHOTOT_BATCH_WORKERS at a time, e.g. the frames of a video. Results keep the order of the parts, each with its index; an image that cannot be decoded gets an error instead of a response without failing the rest. The batch counts as one request towards the rate and concurrency limits.
+// @Tags Image Recognition
+// @Accept multipart/form-data
+// @Produce json
+// @Param image formData file true "Image files to check, one part per image, at most PHOTOT_MAX_BATCH_SIZE"
+// @Param threshold formData number false "Similarity threshold (0-100), defaults to PHOTOT_DEFAULT_THRESHOLD"
+// @Param ml_threshold formData number false "Similarity threshold for the ML branch (0-100), defaults to threshold"
+// @Param hash_threshold formData number false "Similarity threshold for the hash fallback (0-100), defaults to threshold"
+// @Param max_distance formData integer false "Match the hash branch when at most this many DCT hash bits differ, instead of by hash_threshold"
+// @Param rotation_invariant formData boolean false "Also try each image rotated by 90, 180 and 270 degrees (4x slower)"
+// @Param tags formData string false "Comma-separated tags; only images carrying all of them are compared"
+// @Param filename_prefix formData string false "Only compare images whose filename, ignoring the upload timestamp, starts with this"
+// @Param X-Tenant header string false "Tenant whose isolated database is used"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]string
+// @Router /recognize/batch [post]
+func (h *Handler) RecognizeBatchHandler(c *gin.Context) {
+	cfg := h.config()
+	db, files, matchOpts, ok := h.batchRequest(c, cfg.MaxBatchSize)
+	if !ok {
+		return
+	}
+
+	items := make([]batchItem, len(files))
+	h.recognizeBatch(c, db, files, matchOpts, cfg.BatchWorkers, func(item batchItem) {
+		items[item.Index] = item
+	})
+	if c.Request.Context().Err() != nil {
+		return // Nobody is left to answer, and items may have gaps
+	}
+	for i := range items {
+		h.finishBatchItem(c, &items[i])
+	}
+	c.JSON(http.StatusOK, gin.H{"results": items})
+}
+
+// @Summary Stream the recognition of a batch of images
+// @Description Match every image part as /recognize/batch does, but write each result as a line of newline-delimited JSON as soon as it is ready instead of buffering the whole batch, for batches of thousands of frames. Lines come in order of completion, not of the parts; each carries the index of its part. Errors of individual images are reported on their line; once streaming has started the status is 200.
+// @Tags Image Recognition
+// @Accept multipart/form-data
+// @Produce application/x-ndjson
+// @Param image formData file true "Image files to check, one part per image, at most PHOTOT_MAX_STREAM_BATCH_SIZE"
+// @Param threshold formData number false "Similarity threshold (0-100), defaults to PHOTOT_DEFAULT_THRESHOLD"
+// @Param ml_threshold formData number false "Similarity threshold for the ML branch (0-100), defaults to threshold"
+// @Param hash_threshold formData number false "Similarity threshold for the hash fallback (0-100), defaults to threshold"
+// @Param max_distance formData integer false "Match the hash branch when at most this many DCT hash bits differ, instead of by hash_threshold"
+// @Param rotation_invariant formData boolean false "Also try each image rotated by 90, 180 and 270 degrees (4x slower)"
+// @Param tags formData string false "Comma-separated tags; only images carrying all of them are compared"
+// @Param filename_prefix formData string false "Only compare images whose filename, ignoring the upload timestamp, starts with this"
+// @Param X-Tenant header string false "Tenant whose isolated database is used"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]string
+// @Router /recognize/batch/stream [post]
+func (h *Handler) RecognizeBatchStreamHandler(c *gin.Context) {
+	cfg := h.config()
+	db, files, matchOpts, ok := h.batchRequest(c, cfg.MaxStreamBatchSize)
+	if !ok {
+		return
+	}
+
+	items := make(chan batchItem)
+	go func() {
+		h.recognizeBatch(c, db, files, matchOpts, cfg.BatchWorkers, func(item batchItem) {
+			items <- item
+		})
+		close(items)
+	}()
+
+	c.Header("Content-Type", ndjsonContentType)
+	c.Status(http.StatusOK)
+	encoder := json.NewEncoder(c.Writer)
+	gone := false
+	for item := range items {
+		h.finishBatchItem(c, &item)
+		if gone {
+			continue // Drain so the workers finish
+		}
+		if err := encoder.Encode(item); err != nil {
+			// Client went away. Its request context is cancelled once the
+			// connection closes, which stops recognizeBatch handing out the
+			// rest of the images
+			gone = true
+			continue
+		}
+		c.Writer.Flush()
+	}
+}
+
+// batchRequest reads the images and match options of a batch of at most
+// limit images. On failure the error response has been written and ok is
+// false.
+func (h *Handler) batchRequest(c *gin.Context, limit int) (db *database.ImageDatabase, files []*multipart.FileHeader, opts database.MatchOptions, ok bool) {
+	db, _, ok = h.tenant(c)
+	if !ok {
+		return nil, nil, opts, false
+	}
+	form, err := c.MultipartForm()
+	if err != nil || len(form.File["image"]) == 0 {
+		middleware.Error(c, http.StatusBadRequest, i18n.ImageMissing)
+		return nil, nil, opts, false
+	}
+	files = form.File["image"]
+	if len(files) > limit {
+		middleware.ErrorDetail(c, http.StatusBadRequest, i18n.InvalidParameter,
+			fmt.Sprintf("a batch may hold at most %d images, got %d", limit, len(files)))
+		return nil, nil, opts, false
+	}
+
+	opts = h.matchOptions(c)
+	if opts.MaxDistance, err = formMaxDistance(c); err != nil {
+		middleware.ErrorDetail(c, http.StatusBadRequest, i18n.InvalidParameter, err.Error())
+		return nil, nil, opts, false
+	}
+	return db, files, opts, true
+}
+
+// recognizeBatch matches files workers at a time, passing each item to done
+// as it completes. done is called from the worker goroutines, one item at a
+// time per worker. Once the request is cancelled no further files are
+// started, so done may not see every index.
+func (h *Handler) recognizeBatch(c *gin.Context, db *database.ImageDatabase, files []*multipart.FileHeader, opts database.MatchOptions, workers int, done func(batchItem)) {
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for range min(workers, len(files)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				item := h.recognizeBatchItem(c, db, files[i], opts)
+				item.Index = i
+				done(item)
+			}
+		}()
+	}
+dispatch:
+	for i := range files {
+		select {
+		case indexes <- i:
+		case <-c.Request.Context().Done():
+			break dispatch
+		}
+	}
+	close(indexes)
+	wg.Wait()
+}
+
+// finishBatchItem fills in the error body of a failed item, or sends the
+// match webhook of a successful one
+func (h *Handler) finishBatchItem(c *gin.Context, item *batchItem) {
+	if item.code != "" {
+		item.Error = middleware.ErrorBody(c, item.code, item.detail)
+		return
+	}
+	h.notifyMatch(c, item.match)
+}
+
+// recognizeBatchItem decodes and matches one image of a batch
+func (h *Handler) recognizeBatchItem(c *gin.Context, db *database.ImageDatabase, header *multipart.FileHeader, opts database.MatchOptions) batchItem {
+	startTime := time.Now()
+	item := batchItem{Filename: header.Filename}
+	img, code, detail := h.decodePart(header)
+	if code != "" {
+		item.code, item.detail = code, detail
+		return item
+	}
+
+	item.match = h.findMatch(c.Request.Context(), db, img, opts)
+	response := h.matchResponse(item.match)
+	response.ProcessingTimeMs = time.Since(startTime).Milliseconds()
+	item.Response = &response
+	return item
+}
+
+// decodePart decodes one file part of a multipart form as /recognize decodes
+// its upload, returning the error code and detail when it cannot be used
+func (h *Handler) decodePart(header *multipart.FileHeader) (img image.Image, code i18n.Code, detail string) {
+	if header.Size > maxUploadSize {
+		return nil, i18n.FileTooLarge, ""
+	}
+	file, err := header.Open()
+	if err != nil {
+		return nil, i18n.InvalidImage, ""
+	}
+	defer file.Close()
+
+	img, err = im.DecodeImage(file)
+	if err != nil {
+		return nil, decodeErrorCode(err), ""
+	}
+	if err := h.checkDimensions(img); err != nil {
+		return nil, i18n.InvalidDimensions, err.Error()
+	}
+	return img, "", ""
+}
@@ -0,0 +1,211 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"net/http"
+	"photot/api/middleware"
+	"photot/helper/database"
+	"photot/helper/i18n"
+	im "photot/helper/image"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// @Summary Compare two images
+// @Description Score how similar two uploaded images are without touching the database. method picks the measure: ml compares HOG feature vectors under the configured metric, hash compares DCT hashes, ssim computes the structural similarity of grayscale copies. All report a 0-100 similarity; ssim is the strictest about layout and the loosest about being a copy.
+// @Tags Image Recognition
+// @Accept multipart/form-data
+// @Produce json
+// @Param image1 formData file true "First image"
+// @Param image2 formData file true "Second image"
+// @Param method formData string false "ml, hash or ssim; defaults to ml when ML is on, hash otherwise"
+// @Param X-Tenant header string false "Tenant whose metric and hash settings are used"
+// @Success 200 {object} database.CompareResponse
+// @Failure 400 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /compare [post]
+func (h *Handler) CompareHandler(c *gin.Context) {
+	startTime := time.Now()
+	db, _, ok := h.tenant(c)
+	if !ok {
+		return
+	}
+	method, err := db.ParseCompareMethod(c.DefaultPostForm("method", ""))
+	if err != nil {
+		middleware.ErrorDetail(c, http.StatusBadRequest, i18n.InvalidParameter, err.Error())
+		return
+	}
+	img1, ok := h.readCompareUpload(c, "image1")
+	if !ok {
+		return
+	}
+	img2, ok := h.readCompareUpload(c, "image2")
+	if !ok {
+		return
+	}
+
+	similarity, err := db.Compare(c.Request.Context(), img1, img2, method)
+	if err != nil {
+		middleware.ErrorDetail(c, http.StatusInternalServerError, i18n.InternalError, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, database.CompareResponse{
+		SchemaVersion:        database.SchemaVersion,
+		Similarity:           similarity,
+		SimilarityNormalized: database.NormalizeSimilarity(similarity),
+		Method:               method,
+		ProcessingTimeMs:     time.Since(startTime).Milliseconds(),
+	})
+}
+
+// @Summary Compare an image with a stored one
+// @Description Score the uploaded image against one stored image, given by stable ID, hash or filename, as /compare scores two uploads, skipping the scan of the whole database. ml and hash use the stored vector and hash; ssim decodes the stored file. 409 when the stored image lacks what method compares: a feature vector still pending or from another extractor, or a hash computed with other hash settings.
+// @Tags Image Recognition
+// @Accept multipart/form-data
+// @Produce json
+// @Param id path string true "Stable image ID, DCT hash or filename"
+// @Param image formData file true "Image to compare"
+// @Param method formData string false "ml, hash or ssim; defaults to ml when ML is on, hash otherwise"
+// @Param X-Tenant header string false "Tenant whose isolated database is used"
+// @Success 200 {object} database.CompareResponse
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /compare-to/{id} [post]
+func (h *Handler) CompareToHandler(c *gin.Context) {
+	startTime := time.Now()
+	db, imageDir, ok := h.tenant(c)
+	if !ok {
+		return
+	}
+	info, ok := db.Lookup(c.Param("id"))
+	if !ok {
+		info, ok = db.Image(database.ImageID(c.Param("id")))
+	}
+	if !ok {
+		middleware.Error(c, http.StatusNotFound, i18n.ImageNotFound)
+		return
+	}
+	method, err := db.ParseCompareMethod(c.DefaultPostForm("method", ""))
+	if err != nil {
+		middleware.ErrorDetail(c, http.StatusBadRequest, i18n.InvalidParameter, err.Error())
+		return
+	}
+	img, ok := h.readCompareUpload(c, "image")
+	if !ok {
+		return
+	}
+
+	similarity, err := db.CompareStored(c.Request.Context(), img, info, method, imageDir)
+	if errors.Is(err, database.ErrNotComparable) {
+		middleware.ErrorDetail(c, http.StatusConflict, i18n.NotComparable, err.Error())
+		return
+	}
+	if err != nil {
+		middleware.ErrorDetail(c, http.StatusInternalServerError, i18n.InternalError, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, database.CompareResponse{
+		SchemaVersion:        database.SchemaVersion,
+		Similarity:           similarity,
+		SimilarityNormalized: database.NormalizeSimilarity(similarity),
+		Method:               method,
+		ProcessingTimeMs:     time.Since(startTime).Milliseconds(),
+		ID:                   info.ID,
+		Filename:             info.Filename,
+	})
+}
+
+// @Summary Similarity matrix
+// @Description Score every pair of the uploaded images as /compare does, for clustering and other offline analysis. matrix[i][j] compares the i-th and j-th image parts and the diagonal is 100. Each image is prepared once and each pair compared once, PHOTOT_BATCH_WORKERS pairs at a time. Nothing is stored.
+// @Tags Image Recognition
+// @Accept multipart/form-data
+// @Produce json
+// @Param image formData file true "Images to compare, one part per image, 2 to PHOTOT_MAX_MATRIX_SIZE"
+// @Param method formData string false "ml, hash or ssim; defaults to ml when ML is on, hash otherwise"
+// @Param X-Tenant header string false "Tenant whose metric and hash settings are used"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /compare/matrix [post]
+func (h *Handler) CompareMatrixHandler(c *gin.Context) {
+	startTime := time.Now()
+	db, _, ok := h.tenant(c)
+	if !ok {
+		return
+	}
+	method, err := db.ParseCompareMethod(c.DefaultPostForm("method", ""))
+	if err != nil {
+		middleware.ErrorDetail(c, http.StatusBadRequest, i18n.InvalidParameter, err.Error())
+		return
+	}
+	form, err := c.MultipartForm()
+	if err != nil || len(form.File["image"]) == 0 {
+		middleware.Error(c, http.StatusBadRequest, i18n.ImageMissing)
+		return
+	}
+	files := form.File["image"]
+	cfg := h.config()
+	if len(files) < 2 || len(files) > cfg.MaxMatrixSize {
+		middleware.ErrorDetail(c, http.StatusBadRequest, i18n.InvalidParameter,
+			fmt.Sprintf("a matrix needs 2 to %d images, got %d", cfg.MaxMatrixSize, len(files)))
+		return
+	}
+
+	imgs := make([]image.Image, len(files))
+	filenames := make([]string, len(files))
+	for i, file := range files {
+		img, code, detail := h.decodePart(file)
+		if code != "" {
+			if detail != "" {
+				detail = ": " + detail
+			}
+			middleware.ErrorDetail(c, http.StatusBadRequest, code, fmt.Sprintf("image %d%s", i, detail))
+			return
+		}
+		imgs[i], filenames[i] = img, file.Filename
+	}
+
+	matrix, err := db.CompareMatrix(c.Request.Context(), imgs, method, cfg.BatchWorkers)
+	if err != nil {
+		middleware.ErrorDetail(c, http.StatusInternalServerError, i18n.InternalError, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"schema_version":     database.SchemaVersion,
+		"method":             method,
+		"filenames":          filenames,
+		"matrix":             matrix,
+		"processing_time_ms": time.Since(startTime).Milliseconds(),
+	})
+}
+
+// readCompareUpload decodes the named form file as /recognize decodes its
+// upload. On failure the error response has been written and ok is false.
+func (h *Handler) readCompareUpload(c *gin.Context, field string) (img image.Image, ok bool) {
+	file, header, err := c.Request.FormFile(field)
+	if err != nil {
+		middleware.ErrorDetail(c, http.StatusBadRequest, i18n.ImageMissing, field)
+		return nil, false
+	}
+	defer file.Close()
+
+	if header.Size > maxUploadSize {
+		middleware.ErrorDetail(c, http.StatusBadRequest, i18n.FileTooLarge, field)
+		return nil, false
+	}
+	img, err = im.DecodeImage(file)
+	if err != nil {
+		middleware.ErrorDetail(c, http.StatusBadRequest, decodeErrorCode(err), field)
+		return nil, false
+	}
+	if err := h.checkDimensions(img); err != nil {
+		middleware.ErrorDetail(c, http.StatusBadRequest, i18n.InvalidDimensions, field+": "+err.Error())
+		return nil, false
+	}
+	return img, true
+}
@@ -0,0 +1,274 @@
+package handler
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"photot/api/middleware"
+	"photot/helper/config"
+	"photot/helper/database"
+	"photot/helper/i18n"
+	im "photot/helper/image"
+	"photot/helper/logging"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SetConfig validates cfg, applies its database settings and atomically
+// replaces the handler configuration
+func (h *Handler) SetConfig(cfg *config.Config) error {
+	logLevel, err := logging.ParseLevel(cfg.LogLevel)
+	if err != nil {
+		return err
+	}
+	thumbnailFormat, err := im.ParseThumbnailFormat(cfg.ThumbnailFormat)
+	if err != nil {
+		return err
+	}
+	quantization, err := im.ParseQuantizationMode(cfg.Quantization)
+	if err != nil {
+		return err
+	}
+	extractor, err := im.ParseFeatureExtractor(cfg.FeatureExtractor)
+	if err != nil {
+		return err
+	}
+	layout, err := database.ParseStorageLayout(cfg.StorageLayout)
+	if err != nil {
+		return err
+	}
+	grayWeights, err := im.ParseGrayWeights(cfg.GrayWeights)
+	if err != nil {
+		return err
+	}
+	hashFilter, err := im.ParseResizeFilter(cfg.HashResizeFilter)
+	if err != nil {
+		return fmt.Errorf("hash: %v", err)
+	}
+	thumbnailFilter, err := im.ParseResizeFilter(cfg.ThumbnailResizeFilter)
+	if err != nil {
+		return fmt.Errorf("thumbnail: %v", err)
+	}
+	hashConfig := im.HashConfig{Size: cfg.HashSize, Grid: cfg.HashGrid, Gray: grayWeights, Filter: hashFilter}
+	if err := hashConfig.Validate(); err != nil {
+		return err
+	}
+	background, err := im.ParseHexColor(cfg.BackgroundColor)
+	if err != nil {
+		return fmt.Errorf("background: %v", err)
+	}
+	if cfg.MinImageDimension < 1 {
+		return fmt.Errorf("min image dimension must be at least 1, got %d", cfg.MinImageDimension)
+	}
+	if cfg.ThumbnailWidth <= 0 {
+		return fmt.Errorf("thumbnail width must be positive, got %d", cfg.ThumbnailWidth)
+	}
+	if cfg.LSHTables < 0 {
+		return fmt.Errorf("lsh tables must not be negative, got %d", cfg.LSHTables)
+	}
+	if cfg.LoadWorkers <= 0 {
+		return fmt.Errorf("load workers must be positive, got %d", cfg.LoadWorkers)
+	}
+	if cfg.ThumbnailQuality < 1 || cfg.ThumbnailQuality > 100 {
+		return fmt.Errorf("thumbnail quality must be between 1 and 100, got %d", cfg.ThumbnailQuality)
+	}
+	if cfg.DefaultThreshold < 0 || cfg.DefaultThreshold > 100 {
+		return fmt.Errorf("default threshold must be between 0 and 100, got %g", cfg.DefaultThreshold)
+	}
+	if cfg.ConfidenceMedium < 0 || cfg.ConfidenceMedium > cfg.ConfidenceHigh || cfg.ConfidenceHigh > 100 {
+		return fmt.Errorf("confidence bounds must satisfy 0 <= medium (%g) <= high (%g) <= 100", cfg.ConfidenceMedium, cfg.ConfidenceHigh)
+	}
+	if cfg.MLWeight < 0 || cfg.HashWeight < 0 {
+		return fmt.Errorf("match weights must not be negative")
+	}
+	if cfg.MaxConcurrentRecognize < 0 || cfg.RecognizeQueueDepth < 0 {
+		return fmt.Errorf("recognize concurrency and queue depth must not be negative")
+	}
+	if cfg.BatchWorkers <= 0 || cfg.MaxBatchSize <= 0 || cfg.MaxStreamBatchSize <= 0 {
+		return fmt.Errorf("batch workers and max batch sizes must be positive")
+	}
+	for _, proxy := range cfg.TrustedProxies {
+		if _, _, err := net.ParseCIDR(proxy); err != nil && net.ParseIP(proxy) == nil {
+			return fmt.Errorf("trusted proxy must be an IP or CIDR, got %q", proxy)
+		}
+	}
+	if err := corsPolicy(cfg).Validate(); err != nil {
+		return err
+	}
+	if cfg.CacheMaxEntries < 0 {
+		return fmt.Errorf("cache max entries must not be negative, got %d", cfg.CacheMaxEntries)
+	}
+	if cfg.MaxBodyMB < 0 {
+		return fmt.Errorf("max body size must not be negative, got %d", cfg.MaxBodyMB)
+	}
+	if cfg.MinBlockFraction <= 0 || cfg.MinBlockFraction > 1 {
+		return fmt.Errorf("min block fraction must be greater than 0 and at most 1, got %g", cfg.MinBlockFraction)
+	}
+	if cfg.MinContrast < 0 {
+		return fmt.Errorf("min contrast must not be negative, got %g", cfg.MinContrast)
+	}
+	if cfg.MaxMatrixSize < 2 {
+		return fmt.Errorf("max matrix size must be at least 2, got %d", cfg.MaxMatrixSize)
+	}
+	if cfg.MaxTiles <= 0 {
+		return fmt.Errorf("max tiles must be positive, got %d", cfg.MaxTiles)
+	}
+	if cfg.WaveletWeight < 0 || cfg.WaveletWeight > 1 {
+		return fmt.Errorf("wavelet weight must be between 0 and 1, got %g", cfg.WaveletWeight)
+	}
+	if cfg.RecencyBoost < 0 || cfg.RecencyHalfLifeHours <= 0 {
+		return fmt.Errorf("recency boost must not be negative and its half-life must be positive")
+	}
+	if cfg.WebhookMinSimilarity < 0 || cfg.WebhookMinSimilarity > 100 {
+		return fmt.Errorf("webhook min similarity must be between 0 and 100, got %g", cfg.WebhookMinSimilarity)
+	}
+	for _, entry := range cfg.ExtraImageFormats {
+		ext, format, ok := strings.Cut(entry, "=")
+		if !ok {
+			return fmt.Errorf("extra image format must be .ext=format, got %q", entry)
+		}
+		if err := im.RegisterExtension(ext, format); err != nil {
+			return err
+		}
+	}
+
+	thumbnailOpts := im.ThumbnailOptions{Width: cfg.ThumbnailWidth, Format: thumbnailFormat, Quality: cfg.ThumbnailQuality, Filter: thumbnailFilter}
+	h.eachDB(func(db *database.ImageDatabase) {
+		db.SetThumbnailOptions(thumbnailOpts)
+		db.SetFeatureOptions(im.FeatureOptions{Equalize: cfg.EqualizeFeatures, ColorHistogram: cfg.ColorFeatures, Filter: hashFilter})
+		db.SetExtractor(extractor)
+		db.SetStorageLayout(layout)
+		db.SetQuantization(quantization)
+		db.SetHashConfig(hashConfig)
+		db.SetBackground(background)
+		db.SetLSHTables(cfg.LSHTables)
+		db.Cache.SetMaxEntries(cfg.CacheMaxEntries)
+	})
+	logging.SetLevel(logLevel)
+	h.cfg.Store(cfg)
+	h.cfgGen.Add(1)
+	return nil
+}
+
+// config returns the handler configuration, falling back to the defaults
+func (h *Handler) config() *config.Config {
+	if cfg := h.cfg.Load(); cfg != nil {
+		return cfg
+	}
+	return config.Default()
+}
+
+// confidence labels match using the configured confidence bounds
+func (h *Handler) confidence(match database.MatchResult) string {
+	cfg := h.config()
+	bounds := database.ConfidenceBounds{High: cfg.ConfidenceHigh, Medium: cfg.ConfidenceMedium}
+	return database.Confidence(match.Similarity, match.IsMatch, bounds)
+}
+
+// AdminAuth returns the key required on /admin routes, empty when unset,
+// and whether the check is turned off
+func (h *Handler) AdminAuth() (string, bool) {
+	cfg := h.config()
+	return cfg.AdminAPIKey, cfg.AdminAuthDisabled
+}
+
+// MaxBodyBytes returns the cap on request bodies, 0 when there is none
+func (h *Handler) MaxBodyBytes() int64 {
+	return int64(h.config().MaxBodyMB) << 20
+}
+
+// CORSPolicy returns which cross-origin browser requests are allowed
+func (h *Handler) CORSPolicy() middleware.CORSPolicy {
+	return corsPolicy(h.config())
+}
+
+// corsPolicy returns the CORS policy cfg sets
+func corsPolicy(cfg *config.Config) middleware.CORSPolicy {
+	return middleware.CORSPolicy{
+		AllowedOrigins:   cfg.CORSAllowedOrigins,
+		AllowedMethods:   cfg.CORSAllowedMethods,
+		AllowedHeaders:   cfg.CORSAllowedHeaders,
+		AllowCredentials: cfg.CORSAllowCredentials,
+	}
+}
+
+// TrustedProxies returns the proxies whose X-Forwarded-For header is
+// believed when telling clients apart, none when unset
+func (h *Handler) TrustedProxies() []string {
+	return h.config().TrustedProxies
+}
+
+// RateLimit returns the per-IP request rate and burst for /recognize
+func (h *Handler) RateLimit() (float64, int) {
+	cfg := h.config()
+	return cfg.RateLimitRPS, cfg.RateLimitBurst
+}
+
+// RecognizeConcurrency returns how many recognize requests may run at once
+// and how many more may queue for a slot
+func (h *Handler) RecognizeConcurrency() (int, int) {
+	cfg := h.config()
+	return cfg.MaxConcurrentRecognize, cfg.RecognizeQueueDepth
+}
+
+// BatchWorkers returns how many images of one batch are processed at once
+func (h *Handler) BatchWorkers() int {
+	return h.config().BatchWorkers
+}
+
+// WebhookSettings returns the match webhook URL and signing secret
+func (h *Handler) WebhookSettings() (string, string) {
+	cfg := h.config()
+	return cfg.WebhookURL, cfg.WebhookSecret
+}
+
+// @Summary Current configuration
+// @Description Return the effective configuration: every setting keyed by its environment variable, with its value and whether /admin/config/reload can change it, and the state changed through other admin endpoints (match method, metric) or fixed at startup (feature extractor, result cache TTL). PHOTOT_ADMIN_API_KEY and PHOTOT_WEBHOOK_SECRET read [redacted] when set.
+// @Tags Image Database Management
+// @Produce json
+// @Success 200 {object} map[string]interface{}
+// @Security ApiKeyAuth
+// @Router /admin/config [get]
+func (h *Handler) ConfigHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"settings": config.Settings(h.config()),
+		"runtime": gin.H{
+			"match_method":      h.DB.MatchMethod(),
+			"ml_enabled":        h.DB.UsesML(),
+			"metric":            h.DB.Metric(),
+			"feature_type":      h.DB.FeatureType(),
+			"cache_ttl_seconds": h.DB.Cache.TTL().Seconds(),
+		},
+	})
+}
+
+// @Summary Reload configuration
+// @Description Re-read configuration from the environment and apply hot-reloadable settings
+// @Tags Image Database Management
+// @Produce json
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /admin/config/reload [post]
+func (h *Handler) ReloadConfigHandler(c *gin.Context) {
+	next, err := config.Load()
+	if err != nil {
+		middleware.ErrorDetail(c, http.StatusBadRequest, i18n.InvalidConfig, err.Error())
+		return
+	}
+
+	merged, applied, restart := config.Merge(h.config(), next)
+	if err := h.SetConfig(merged); err != nil {
+		middleware.ErrorDetail(c, http.StatusBadRequest, i18n.InvalidConfig, err.Error())
+		return
+	}
+	slog.Info("config reloaded", "applied", applied, "requires_restart", restart)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":          "config reloaded",
+		"applied":          applied,
+		"requires_restart": restart,
+	})
+}
@@ -0,0 +1,48 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"photot/api/middleware"
+	"photot/helper/i18n"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// @Summary Find duplicate images
+// @Description Cluster stored images whose perceptual hashes are near-identical
+// @Tags Image Database Management
+// @Produce json
+// @Param threshold query number false "Hash similarity (0-100) for two images to count as duplicates" default(95)
+// @Param async query boolean false "Run as a background job and return its job_id"
+// @Param X-Tenant header string false "Tenant whose isolated database is used"
+// @Success 200 {object} map[string]interface{}
+// @Success 202 {object} map[string]string
+// @Failure 400 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /admin/duplicates [get]
+func (h *Handler) DuplicatesHandler(c *gin.Context) {
+	db, _, ok := h.tenant(c)
+	if !ok {
+		return
+	}
+	threshold, err := strconv.ParseFloat(c.DefaultQuery("threshold", "95"), 64)
+	if err != nil || threshold < 0 || threshold > 100 {
+		middleware.ErrorDetail(c, http.StatusBadRequest, i18n.InvalidParameter, "threshold must be between 0 and 100")
+		return
+	}
+
+	if c.Query("async") == "true" {
+		h.submitJob(c, "duplicates", func(ctx context.Context, progress func(float64)) (any, error) {
+			return gin.H{"threshold": threshold, "clusters": db.FindDuplicates(threshold)}, nil
+		})
+		return
+	}
+
+	clusters := db.FindDuplicates(threshold)
+	c.JSON(http.StatusOK, gin.H{
+		"threshold": threshold,
+		"clusters":  clusters,
+	})
+}
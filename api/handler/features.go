@@ -0,0 +1,151 @@
+package handler
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"photot/api/middleware"
+	"photot/helper/database"
+	"photot/helper/i18n"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ndjsonContentType is the media type of newline-delimited JSON
+const ndjsonContentType = "application/x-ndjson"
+
+// maxFeatureLine caps a single line of a feature import; a HOG vector with a
+// color histogram is a few kilobytes
+const maxFeatureLine = 1 << 20
+
+// maxImportErrors caps the per-line errors an import reports
+const maxImportErrors = 100
+
+// featureImportError describes a rejected line of a feature import
+type featureImportError struct {
+	Line     int    `json:"line"`
+	Filename string `json:"filename,omitempty"`
+	Error    string `json:"error"`
+}
+
+// @Summary Export feature vectors
+// @Description Stream the ML feature vector of every stored image as newline-delimited JSON, one {"id", "filename", "features", "feature_type"} object per line ordered by filename, e.g. to build an external ANN index. Quantized vectors are expanded to float64.
+// @Tags Image Database Management
+// @Produce application/x-ndjson
+// @Param X-Tenant header string false "Tenant whose isolated database is used"
+// @Success 200 {object} database.FeatureRecord
+// @Security ApiKeyAuth
+// @Router /admin/features/export [get]
+func (h *Handler) ExportFeaturesHandler(c *gin.Context) {
+	db, _, ok := h.tenant(c)
+	if !ok {
+		return
+	}
+
+	c.Header("Content-Type", ndjsonContentType)
+	c.Status(http.StatusOK)
+	encoder := json.NewEncoder(c.Writer)
+	for _, record := range db.ExportFeatures() {
+		if err := encoder.Encode(record); err != nil {
+			return // Client went away
+		}
+	}
+}
+
+// @Summary Import feature vectors
+// @Description Replace the ML feature vectors of stored images with precomputed ones, in the newline-delimited JSON format of /admin/features/export. Lines are matched to images by filename; unknown files, malformed lines, vectors whose length differs from the stored ones and vectors whose feature_type is not PHOTOT_FEATURE_EXTRACTOR are reported and skipped without failing the import. Vectors are quantized per PHOTOT_FEATURE_QUANTIZATION. Imported vectors last until the next restart, which extracts features afresh.
+// @Tags Image Database Management
+// @Accept application/x-ndjson
+// @Produce json
+// @Param X-Tenant header string false "Tenant whose isolated database is used"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /admin/features/import [post]
+func (h *Handler) ImportFeaturesHandler(c *gin.Context) {
+	db, _, ok := h.tenant(c)
+	if !ok {
+		return
+	}
+
+	imported, failed := 0, 0
+	errs := []featureImportError{}
+	reject := func(line int, filename string, err error) {
+		failed++
+		if len(errs) < maxImportErrors {
+			errs = append(errs, featureImportError{Line: line, Filename: filename, Error: err.Error()})
+		}
+	}
+
+	scanner := bufio.NewScanner(c.Request.Body)
+	scanner.Buffer(make([]byte, 0, 64<<10), maxFeatureLine)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var record database.FeatureRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			reject(line, "", err)
+			continue
+		}
+		if err := db.ImportFeatures(record); err != nil {
+			reject(line, record.Filename, err)
+			continue
+		}
+		imported++
+	}
+	if err := scanner.Err(); err != nil {
+		detail := err.Error()
+		if errors.Is(err, bufio.ErrTooLong) {
+			detail = "a line exceeds 1MB"
+		}
+		if middleware.BodyTooLarge(err) {
+			middleware.Error(c, http.StatusRequestEntityTooLarge, i18n.RequestTooLarge)
+			return
+		}
+		middleware.ErrorDetail(c, http.StatusBadRequest, i18n.InvalidParameter, detail)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"imported": imported,
+		"failed":   failed,
+		"errors":   errs,
+	})
+}
+
+// @Summary Extract pending features
+// @Description Extract the ML features of every image added with skip_features, reading each from the image directory, so the ML branch can match it again. Files that cannot be read are reported in failed and stay pending. Restarts extract every image's features anyway.
+// @Tags Image Database Management
+// @Produce json
+// @Param async query boolean false "Run as a background job and return its job_id"
+// @Param X-Tenant header string false "Tenant whose isolated database is used"
+// @Success 200 {object} database.ReindexResult
+// @Success 202 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /admin/reindex [post]
+func (h *Handler) ReindexHandler(c *gin.Context) {
+	db, imageDir, ok := h.tenant(c)
+	if !ok {
+		return
+	}
+
+	if c.Query("async") == "true" {
+		h.submitJob(c, "reindex", func(ctx context.Context, progress func(float64)) (any, error) {
+			return db.ExtractPending(ctx, imageDir, progress)
+		})
+		return
+	}
+
+	result, err := db.ExtractPending(c.Request.Context(), imageDir, nil)
+	if err != nil {
+		middleware.ErrorDetail(c, http.StatusInternalServerError, i18n.InternalError, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
@@ -1,103 +1,480 @@
 package handler
 
 import (
-	"bytes"
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
-	"log"
+	"image"
+	"log/slog"
 	"net/http"
 	"os"
 	"path/filepath"
+	"photot/api/middleware"
+	"photot/helper/config"
 	"photot/helper/database"
+	"photot/helper/i18n"
+	im "photot/helper/image"
+	"photot/helper/jobs"
+	"photot/helper/version"
+	"photot/helper/webhook"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/disintegration/imaging"
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
 )
 
 type Handler struct {
 	DB       *database.ImageDatabase
 	ImageDir string
+	Tenants  *database.Registry // Nil when tenants are disabled
+	Webhooks *webhook.Notifier  // Nil when match webhooks are disabled
+	Jobs     *jobs.Manager      // Runs async admin operations
+	cfg      atomic.Pointer[config.Config]
+	cfgGen   atomic.Uint64 // Counts SetConfig calls, bumped after cfg is stored
+
+	Recognitions *middleware.ConcurrencyLimiter // Bounds concurrent recognize work, nil disables
+}
+
+// checkDimensions rejects images too small to hash meaningfully or too large to resize cheaply
+func (h *Handler) checkDimensions(img image.Image) error {
+	cfg := h.config()
+	width, height := img.Bounds().Dx(), img.Bounds().Dy()
+	if width < cfg.MinImageDimension || height < cfg.MinImageDimension {
+		return fmt.Errorf("image is %dx%d, minimum size is %dx%d",
+			width, height, cfg.MinImageDimension, cfg.MinImageDimension)
+	}
+	if cfg.MaxImagePixels > 0 && width*height > cfg.MaxImagePixels {
+		return fmt.Errorf("image is %dx%d (%d pixels), maximum is %d pixels",
+			width, height, width*height, cfg.MaxImagePixels)
+	}
+	return nil
+}
+
+// checkContrast refuses a nearly solid-color reference image, whose
+// degenerate hash would match every other near-solid query. On failure the
+// error response has been written and ok is false.
+func (h *Handler) checkContrast(c *gin.Context, img image.Image) (ok bool) {
+	minContrast := h.config().MinContrast
+	if minContrast <= 0 {
+		return true
+	}
+	if contrast := im.Contrast(img); contrast < minContrast {
+		middleware.ErrorDetail(c, http.StatusBadRequest, i18n.LowEntropy,
+			fmt.Sprintf("luminance standard deviation is %.2f, minimum is %g", contrast, minContrast))
+		return false
+	}
+	return true
+}
+
+// maxUploadSize caps the size of a single uploaded file. The multipart parser
+// sets each file header's Size from the bytes it actually received, so checking
+// it is enough; the body as a whole is capped by middleware.BodyLimit.
+const maxUploadSize = 10 << 20
+
+// decodeErrorCode maps an image decoding failure to a client-facing error code
+func decodeErrorCode(err error) i18n.Code {
+	switch {
+	case errors.Is(err, im.ErrHEICNotEnabled):
+		return i18n.HEICNotEnabled
+	case errors.Is(err, im.ErrCorruptImage):
+		return i18n.CorruptImage
+	}
+	return i18n.InvalidImage
+}
+
+// formThreshold reads a 0-100 threshold form field, keeping fallback when it is absent or invalid
+func formThreshold(c *gin.Context, field string, fallback float64) float64 {
+	thresholdStr := c.DefaultPostForm(field, "")
+	if thresholdStr != "" {
+		parsedThreshold, err := strconv.ParseFloat(thresholdStr, 64)
+		if err == nil && parsedThreshold >= 0 && parsedThreshold <= 100 {
+			return parsedThreshold
+		}
+	}
+	return fallback
+}
+
+// formMaxDistance reads the optional max_distance form field, a hamming
+// distance cutoff that replaces the hash similarity threshold
+func formMaxDistance(c *gin.Context) (*int, error) {
+	distanceStr := c.DefaultPostForm("max_distance", "")
+	if distanceStr == "" {
+		return nil, nil
+	}
+	distance, err := strconv.Atoi(distanceStr)
+	if err != nil || distance < 0 {
+		return nil, fmt.Errorf("max_distance must be a non-negative integer")
+	}
+	return &distance, nil
+}
+
+// formTags reads the comma-separated tags form field
+func formTags(c *gin.Context) []string {
+	return database.NormalizeTags(strings.Split(c.DefaultPostForm("tags", ""), ","))
+}
+
+// parseCrop reads the crop form field ("x,y,w,h" in pixels, or fractions of the
+// image size when crop_units=fraction) and checks it lies within bounds
+func parseCrop(c *gin.Context, bounds image.Rectangle) (image.Rectangle, bool, error) {
+	cropStr := c.DefaultPostForm("crop", "")
+	if cropStr == "" {
+		return image.Rectangle{}, false, nil
+	}
+
+	parts := strings.Split(cropStr, ",")
+	if len(parts) != 4 {
+		return image.Rectangle{}, false, fmt.Errorf("crop must be x,y,w,h")
+	}
+	values := make([]float64, 4)
+	for i, part := range parts {
+		value, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil || value < 0 {
+			return image.Rectangle{}, false, fmt.Errorf("crop values must be non-negative numbers")
+		}
+		values[i] = value
+	}
+
+	switch units := c.DefaultPostForm("crop_units", "px"); units {
+	case "px":
+	case "fraction":
+		values[0] *= float64(bounds.Dx())
+		values[1] *= float64(bounds.Dy())
+		values[2] *= float64(bounds.Dx())
+		values[3] *= float64(bounds.Dy())
+	default:
+		return image.Rectangle{}, false, fmt.Errorf("crop_units must be px or fraction")
+	}
+
+	x, y, w, hgt := int(values[0]), int(values[1]), int(values[2]), int(values[3])
+	rect := image.Rect(x, y, x+w, y+hgt).Add(bounds.Min)
+	if w == 0 || hgt == 0 || !rect.In(bounds) {
+		return image.Rectangle{}, false, fmt.Errorf("crop %dx%d at (%d,%d) is outside the %dx%d image",
+			w, hgt, x, y, bounds.Dx(), bounds.Dy())
+	}
+	return rect, true, nil
 }
 
-func isImageFile(ext string) bool {
-	supportedExts := map[string]bool{
-		".jpg":  true,
-		".jpeg": true,
-		".png":  true,
-		".gif":  true,
-		".bmp":  true,
-		".tiff": true,
-		".webp": true,
+// defaultTileSize is the tiled-mode window side used when tile_size is absent
+const defaultTileSize = 128
+
+// parseTiles reads the mode, tile_size and tile_stride form fields, returning
+// a zero size unless mode=tiled. tile_size defaults to defaultTileSize, capped
+// at the shorter side of bounds, and tile_stride to half of it.
+func (h *Handler) parseTiles(c *gin.Context, bounds image.Rectangle) (size, stride int, err error) {
+	switch mode := c.DefaultPostForm("mode", ""); mode {
+	case "", "robust":
+		return 0, 0, nil
+	case "tiled":
+	default:
+		return 0, 0, fmt.Errorf("mode must be tiled or robust when set")
+	}
+
+	cfg := h.config()
+	size = min(defaultTileSize, bounds.Dx(), bounds.Dy())
+	if sizeStr := c.DefaultPostForm("tile_size", ""); sizeStr != "" {
+		size, err = strconv.Atoi(sizeStr)
+		if err != nil || size < cfg.MinImageDimension || size > min(bounds.Dx(), bounds.Dy()) {
+			return 0, 0, fmt.Errorf("tile_size must be an integer from %d to the shorter image side, %d",
+				cfg.MinImageDimension, min(bounds.Dx(), bounds.Dy()))
+		}
+	}
+	stride = max(size/2, 1)
+	if strideStr := c.DefaultPostForm("tile_stride", ""); strideStr != "" {
+		stride, err = strconv.Atoi(strideStr)
+		if err != nil || stride < 1 {
+			return 0, 0, fmt.Errorf("tile_stride must be a positive integer")
+		}
+	}
+	if tiles := im.TileCount(bounds, size, stride); tiles > cfg.MaxTiles {
+		return 0, 0, fmt.Errorf("tile_size %d and tile_stride %d give %d tiles, at most %d are allowed",
+			size, stride, tiles, cfg.MaxTiles)
 	}
-	return supportedExts[ext]
+	return size, stride, nil
+}
+
+// parseBlockFraction reads the min_block_fraction form field, returning 0
+// unless mode=robust. It defaults to PHOTOT_MIN_BLOCK_FRACTION.
+func (h *Handler) parseBlockFraction(c *gin.Context) (float64, error) {
+	if c.DefaultPostForm("mode", "") != "robust" {
+		return 0, nil
+	}
+	fractionStr := c.DefaultPostForm("min_block_fraction", "")
+	if fractionStr == "" {
+		return h.config().MinBlockFraction, nil
+	}
+	fraction, err := strconv.ParseFloat(fractionStr, 64)
+	if err != nil || fraction <= 0 || fraction > 1 {
+		return 0, fmt.Errorf("min_block_fraction must be greater than 0 and at most 1")
+	}
+	return fraction, nil
+}
+
+// recognizeETag identifies the result of a recognize request: the pixels
+// compared, every option that affects the outcome and the database version
+// they were compared against. extra holds the remaining inputs, including the
+// config generation, since hot-reloaded settings such as the confidence
+// bounds change the response too.
+func recognizeETag(db *database.ImageDatabase, img image.Image, opts database.MatchOptions, extra ...any) string {
+	// JSON rather than %v, which would print the address of MaxDistance
+	options, _ := json.Marshal(opts)
+	sum := sha256.New()
+	fmt.Fprintf(sum, "%d:%s:%d:%s:%v", database.SchemaVersion, im.ContentHash(img), db.Version(), options, extra)
+	return fmt.Sprintf(`"%x"`, sum.Sum(nil)[:16])
+}
+
+// writeRecognizeResponse sends response as protobuf when the client accepts
+// application/x-protobuf ahead of JSON, and as JSON otherwise. Errors are
+// always JSON.
+func writeRecognizeResponse(c *gin.Context, response database.RecognizeResponse) {
+	c.Header("Vary", "Accept")
+	if c.NegotiateFormat(binding.MIMEJSON, binding.MIMEPROTOBUF) == binding.MIMEPROTOBUF {
+		c.Data(http.StatusOK, binding.MIMEPROTOBUF, response.MarshalProto())
+		return
+	}
+	c.JSON(http.StatusOK, response)
 }
 
 // @Summary Recognize image
-// @Description Compare uploaded image against database using ML or hashing
+// @Description Compare uploaded image against database using ML or hashing. Every response carries an ETag derived from the image pixels, the options, the configuration and the database version; retrying with that value in If-None-Match returns the stored result of the first request, unchanged and without reprocessing, for up to 5 minutes.
 // @Tags Image Recognition
 // @Accept multipart/form-data
-// @Produce json
+// @Produce json,application/x-protobuf
 // @Param image formData file true "Image file to check"
-// @Param threshold formData number false "Similarity threshold (0-100)"
+// @Param threshold formData number false "Similarity threshold (0-100), defaults to PHOTOT_DEFAULT_THRESHOLD"
+// @Param ml_threshold formData number false "Similarity threshold for the ML branch (0-100), defaults to threshold"
+// @Param hash_threshold formData number false "Similarity threshold for the hash fallback (0-100), defaults to threshold"
+// @Param crop formData string false "Region to match as x,y,w,h; reference images should be added uncropped"
+// @Param crop_units formData string false "Units of crop: px (default) or fraction"
+// @Param rotation_invariant formData boolean false "Also try the query rotated by 90, 180 and 270 degrees (4x slower)"
+// @Param mode formData string false "tiled to hash square windows of the query instead of the whole image, e.g. to find a logo within a scene; robust to compare a 4x4 grid of blocks one by one, tolerating part of the query being covered"
+// @Param tile_size formData integer false "Window side in pixels for mode=tiled, default 128 capped at the shorter image side"
+// @Param tile_stride formData integer false "Step between windows in pixels for mode=tiled, default half of tile_size"
+// @Param min_block_fraction formData number false "Share (0-1] of blocks that must clear hash_threshold for mode=robust, defaults to PHOTOT_MIN_BLOCK_FRACTION"
+// @Param max_distance formData integer false "Match the hash branch when at most this many DCT hash bits differ, instead of by hash_threshold"
+// @Param top_n formData integer false "Also return up to N ranked candidates"
+// @Param min_similarity formData number false "Minimum similarity (0-100) for a candidate to be included in top_n"
+// @Param min_margin formData number false "Similarity points (0-100) the best image must lead the second best by to match; ignored in tiled and robust modes"
+// @Param include_matched_thumbnail formData boolean false "Include the matched image's stored base64 thumbnail on a match"
+// @Param return_features query boolean false "Include the query's feature vector, as extracted by the configured extractor named in feature_type, e.g. to feed downstream models; adds kilobytes to the response"
+// @Param tags formData string false "Comma-separated tags; only images carrying all of them are compared"
+// @Param filename_prefix formData string false "Only compare images whose filename, ignoring the upload timestamp, starts with this"
+// @Param X-Tenant header string false "Tenant whose isolated database is used"
+// @Param If-None-Match header string false "ETag of an earlier identical request whose result should be replayed"
 // @Success 200 {object} database.RecognizeResponse
+// @Header 200 {string} ETag "Identifies this result"
 // @Failure 400 {object} map[string]string
 // @Failure 500 {object} map[string]string
 // @Router /recognize [post]
 func (h *Handler) RecognizeHandler(c *gin.Context) {
 	startTime := time.Now()
+	// Read before any setting, so a result is never tagged with a newer
+	// generation than the config it was computed with
+	configGen := h.cfgGen.Load()
+	db, _, ok := h.tenant(c)
+	if !ok {
+		return
+	}
 	file, header, err := c.Request.FormFile("image")
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Image file not found"})
+		middleware.Error(c, http.StatusBadRequest, i18n.ImageMissing)
 		return
 	}
 	defer file.Close()
 
-	if header.Size > 10<<20 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "File size exceeds 10MB"})
+	if header.Size > maxUploadSize {
+		middleware.Error(c, http.StatusBadRequest, i18n.FileTooLarge)
 		return
 	}
 
-	var similarityThreshold float64 = 85.0
-	thresholdStr := c.DefaultPostForm("threshold", "")
-	if thresholdStr != "" {
-		parsedThreshold, err := strconv.ParseFloat(thresholdStr, 64)
-		if err == nil && parsedThreshold >= 0 && parsedThreshold <= 100 {
-			similarityThreshold = parsedThreshold
+	matchOpts := h.matchOptions(c)
+	if matchOpts.MaxDistance, err = formMaxDistance(c); err != nil {
+		middleware.ErrorDetail(c, http.StatusBadRequest, i18n.InvalidParameter, err.Error())
+		return
+	}
+
+	topN := 0
+	if topNStr := c.DefaultPostForm("top_n", ""); topNStr != "" {
+		parsedTopN, err := strconv.Atoi(topNStr)
+		if err != nil || parsedTopN < 1 {
+			middleware.ErrorDetail(c, http.StatusBadRequest, i18n.InvalidParameter, "top_n must be a positive integer")
+			return
 		}
+		topN = parsedTopN
 	}
 
-	fileBytes, err := io.ReadAll(file)
+	var minSimilarity float64
+	if minStr := c.DefaultPostForm("min_similarity", ""); minStr != "" {
+		parsedMin, err := strconv.ParseFloat(minStr, 64)
+		if err != nil || parsedMin < 0 || parsedMin > 100 {
+			middleware.ErrorDetail(c, http.StatusBadRequest, i18n.InvalidParameter, "min_similarity must be between 0 and 100")
+			return
+		}
+		minSimilarity = parsedMin
+	}
+
+	img, err := im.DecodeImage(file)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "File could not be read."})
+		middleware.Error(c, http.StatusBadRequest, decodeErrorCode(err))
+		return
+	}
+	if err := h.checkDimensions(img); err != nil {
+		middleware.ErrorDetail(c, http.StatusBadRequest, i18n.InvalidDimensions, err.Error())
 		return
 	}
 
-	img, err := imaging.Decode(bytes.NewReader(fileBytes))
+	cropRect, cropped, err := parseCrop(c, img.Bounds())
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid image format"})
+		middleware.ErrorDetail(c, http.StatusBadRequest, i18n.InvalidCrop, err.Error())
 		return
 	}
+	if cropped {
+		img = imaging.Crop(img, cropRect)
+		if err := h.checkDimensions(img); err != nil {
+			middleware.ErrorDetail(c, http.StatusBadRequest, i18n.InvalidDimensions, "cropped "+err.Error())
+			return
+		}
+	}
+	matchOpts.TileSize, matchOpts.TileStride, err = h.parseTiles(c, img.Bounds())
+	if err != nil {
+		middleware.ErrorDetail(c, http.StatusBadRequest, i18n.InvalidParameter, err.Error())
+		return
+	}
+	if matchOpts.MinBlockFraction, err = h.parseBlockFraction(c); err != nil {
+		middleware.ErrorDetail(c, http.StatusBadRequest, i18n.InvalidParameter, err.Error())
+		return
+	}
+	if marginStr := c.DefaultPostForm("min_margin", ""); marginStr != "" {
+		matchOpts.MinMargin, err = strconv.ParseFloat(marginStr, 64)
+		if err != nil || matchOpts.MinMargin < 0 || matchOpts.MinMargin > 100 {
+			middleware.ErrorDetail(c, http.StatusBadRequest, i18n.InvalidParameter, "min_margin must be between 0 and 100")
+			return
+		}
+	}
+
+	includeThumbnail := c.DefaultPostForm("include_matched_thumbnail", "") == "true"
+	returnFeatures := c.Query("return_features") == "true"
+	etag := recognizeETag(db, img, matchOpts, configGen, topN, minSimilarity, includeThumbnail, returnFeatures)
+	c.Header("ETag", etag)
+	if c.GetHeader("If-None-Match") == etag {
+		if cached, ok := db.Cache.Get("recognize:" + etag); ok {
+			writeRecognizeResponse(c, cached.(database.RecognizeResponse))
+			return
+		}
+	}
 
-	isMatch, matchedImage, similarity, method := h.DB.FindMatch(img, similarityThreshold)
+	match := h.findMatch(c.Request.Context(), db, img, matchOpts)
+	response := h.matchResponse(match)
+	if topN > 0 {
+		response.Candidates, _ = db.FindMatches(img, topN, minSimilarity, matchOpts)
+	}
+	if match.IsMatch && includeThumbnail {
+		response.MatchedThumbnail, _ = db.Thumbnail(match.MatchedImage)
+	}
+	if returnFeatures {
+		response.Features, response.FeatureType, err = db.QueryFeatures(c.Request.Context(), img)
+		if err != nil {
+			middleware.ErrorDetail(c, http.StatusInternalServerError, i18n.InternalError, err.Error())
+			return
+		}
+	}
+	response.ProcessingTimeMs = time.Since(startTime).Milliseconds()
 
-	response := database.RecognizeResponse{
-		ProcessingTimeMs: time.Since(startTime).Milliseconds(),
-		Similarity:       similarity,
-		Method:           method,
+	// A degraded result depends on timing, so a retry should get a fresh one.
+	// Add keeps the first result, which is the one a retry must replay.
+	if match.Degraded == "" {
+		db.Cache.Add("recognize:"+etag, response)
 	}
+	writeRecognizeResponse(c, response)
+	h.notifyMatch(c, match)
+}
+
+// matchOptions reads the match thresholds and search scope shared by
+// /recognize and /recognize/batch from the form, with the configured weights
+func (h *Handler) matchOptions(c *gin.Context) database.MatchOptions {
+	cfg := h.config()
+	similarityThreshold := formThreshold(c, "threshold", cfg.DefaultThreshold)
+	return database.MatchOptions{
+		Threshold:     similarityThreshold,
+		MLThreshold:   formThreshold(c, "ml_threshold", similarityThreshold),
+		HashThreshold: formThreshold(c, "hash_threshold", similarityThreshold),
+		MLWeight:      cfg.MLWeight,
+		HashWeight:    cfg.HashWeight,
+		TryRotations:  c.DefaultPostForm("rotation_invariant", "") == "true",
+		Tags:          formTags(c),
+
+		FilenamePrefix: c.DefaultPostForm("filename_prefix", ""),
+
+		RecencyBoost:    cfg.RecencyBoost,
+		RecencyHalfLife: time.Duration(cfg.RecencyHalfLifeHours * float64(time.Hour)),
+		WaveletWeight:   cfg.WaveletWeight,
+		MinContrast:     cfg.MinContrast,
+	}
+}
 
-	if isMatch {
+// findMatch runs FindMatch under the configured ML deadline
+func (h *Handler) findMatch(ctx context.Context, db *database.ImageDatabase, img image.Image, opts database.MatchOptions) database.MatchResult {
+	if timeout := h.config().MLTimeoutMs; timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(timeout)*time.Millisecond)
+		defer cancel()
+	}
+	match := db.FindMatch(ctx, img, opts)
+	if match.Degraded != "" {
+		slog.Warn("recognize degraded to hash-only", "reason", match.Degraded)
+	}
+	slog.Debug("best match", "image", match.MatchedImage, "similarity", match.Similarity,
+		"method", match.Method, "match", match.IsMatch)
+	return match
+}
+
+// matchResponse builds the recognize response for match, leaving the
+// processing time and the per-request extras to the caller
+func (h *Handler) matchResponse(match database.MatchResult) database.RecognizeResponse {
+	response := database.RecognizeResponse{
+		SchemaVersion:        database.SchemaVersion,
+		Similarity:           match.Similarity,
+		SimilarityNormalized: database.NormalizeSimilarity(match.Similarity),
+		Confidence:           h.confidence(match),
+		MatchedImage:         match.MatchedImage,
+		Method:               match.Method,
+		Degraded:             match.Degraded,
+		MLSimilarity:         match.MLSimilarity,
+		HashSimilarity:       match.HashSimilarity,
+		Rotation:             match.Rotation,
+		Tile:                 match.Tile,
+		BlockFraction:        match.BlockFraction,
+		HashDistance:         match.HashDistance,
+		Margin:               match.Margin,
+		LowEntropy:           match.LowEntropy,
+	}
+	switch {
+	case match.NoData:
+		response.Result = "NO_DATA"
+	case match.IsMatch:
 		response.Result = "OK"
-		response.MatchedImage = matchedImage
-	} else {
+	default:
 		response.Result = "NOT OK"
-		response.MatchedImage = matchedImage
 	}
+	return response
+}
 
-	c.JSON(http.StatusOK, response)
+// notifyMatch sends the match webhook when match is confident enough
+func (h *Handler) notifyMatch(c *gin.Context, match database.MatchResult) {
+	if match.IsMatch && match.Similarity >= h.config().WebhookMinSimilarity {
+		h.Webhooks.Notify(webhook.Event{
+			RequestID:    c.GetString(middleware.RequestIDKey),
+			MatchedImage: match.MatchedImage,
+			Similarity:   match.Similarity,
+			Method:       match.Method,
+		})
+	}
 }
 
 // @Summary Add new image
@@ -107,89 +484,434 @@ func (h *Handler) RecognizeHandler(c *gin.Context) {
 // @Produce json
 // @Param image formData file true "Image file to upload"
 // @Param name formData string false "Custom image name"
+// @Param tags formData string false "Comma-separated tags to scope recognize searches by"
+// @Param on_duplicate formData string false "reject (default) to refuse an image whose hash is already stored, or alias to save it and record its filename as an alias of the stored image"
+// @Param skip_features formData boolean false "Store only the hashes and thumbnail, marking the image features_pending until POST /admin/reindex; it is matched by hash alone meanwhile"
+// @Param dry_run query boolean false "Only report whether the image would be accepted and its closest stored near-duplicate; nothing is saved"
+// @Param threshold query number false "Hash similarity (0-100) at which dry_run reports a near-duplicate" default(95)
+// @Param X-Tenant header string false "Tenant whose isolated database is used, created when it does not exist"
 // @Success 200 {object} map[string]string
 // @Failure 400 {object} map[string]string
 // @Failure 500 {object} map[string]string
+// @Security ApiKeyAuth
 // @Router /admin/add [post]
 func (h *Handler) AddImageHandler(c *gin.Context) {
-	file, header, err := c.Request.FormFile("image")
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Image file not found"})
-		return
-	}
-	defer file.Close()
-
-	if header.Size > 10<<20 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "File size exceeds 10MB"})
+	db, imageDir, ok := h.createTenant(c)
+	if !ok {
 		return
 	}
-	ext := strings.ToLower(filepath.Ext(header.Filename))
-	if !isImageFile(ext) {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported file format. Please upload a valid image.."})
+	img, filename, ok := h.readImageUpload(c)
+	if !ok || !h.checkContrast(c, img) {
 		return
 	}
-	filename := header.Filename
+
+	ext := strings.ToLower(filepath.Ext(filename))
 	customName := c.PostForm("name")
 	if customName != "" {
 		filename = customName + ext
 	}
-	uniqueFilename := fmt.Sprintf("%d_%s", time.Now().UnixNano(), filename)
-	savePath := filepath.Join(h.ImageDir, uniqueFilename)
-	fileBytes, err := io.ReadAll(file)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "File could not be read."})
+	// imaging cannot encode HEIC or SVG, so those uploads are stored as JPEG
+	// and as the rasterized PNG respectively
+	if ext == ".heic" || ext == ".heif" {
+		filename = strings.TrimSuffix(filename, filepath.Ext(filename)) + ".jpg"
+	}
+	if ext == ".svg" {
+		filename = strings.TrimSuffix(filename, filepath.Ext(filename)) + ".png"
+	}
+	filename = safeFilename(filename)
+	if filename == "" {
+		middleware.Error(c, http.StatusBadRequest, i18n.InvalidFilename)
 		return
 	}
-
-	img, err := imaging.Decode(bytes.NewReader(fileBytes))
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid image format"})
+	aliasDuplicates := false
+	switch c.DefaultPostForm("on_duplicate", "reject") {
+	case "reject":
+	case "alias":
+		aliasDuplicates = true
+	default:
+		middleware.ErrorDetail(c, http.StatusBadRequest, i18n.InvalidParameter, "on_duplicate must be reject or alias")
 		return
 	}
-	err = imaging.Save(img, savePath)
-	if err != nil {
-		log.Printf("Error saving image to %s: %v", savePath, err)
-		if os.IsPermission(err) {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Permission denied to save image"})
-		} else {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error saving image"})
+	if c.Query("dry_run") == "true" {
+		threshold, err := strconv.ParseFloat(c.DefaultQuery("threshold", "95"), 64)
+		if err != nil || threshold < 0 || threshold > 100 {
+			middleware.ErrorDetail(c, http.StatusBadRequest, i18n.InvalidParameter, "threshold must be between 0 and 100")
+			return
+		}
+		check := db.CheckAdd(img, threshold)
+		c.JSON(http.StatusOK, gin.H{
+			"dry_run":   true,
+			"filename":  filename,
+			"accepted":  check.Accepted,
+			"reason":    check.Reason,
+			"duplicate": check.Duplicate,
+		})
+		return
+	}
+	// Content-addressed files are named by their bytes, so only flat files
+	// need their name reserved on disk. The stamp is the image's AddedAt, which
+	// LoadImages reads back from it after a restart.
+	addedAt := time.Now()
+	uniqueFilename := fmt.Sprintf("%d_%s", addedAt.UnixNano(), filename)
+	var err error
+	if db.StorageLayout() == database.LayoutFlat {
+		uniqueFilename, err = reserveFilename(imageDir, uniqueFilename)
+		if err != nil {
+			writeSaveError(c, filepath.Join(imageDir, filename), err)
+			return
 		}
+	}
+	// The image is written beside its reserved name and only renamed into
+	// place once the database has accepted it, so a crash mid-write never
+	// leaves a partial file for the next LoadImages
+	savePath := filepath.Join(imageDir, uniqueFilename)
+	sync := h.config().SyncImageWrites
+	pending, ok := saveImage(c, img, savePath, sync)
+	if !ok {
+		os.Remove(savePath)
 		return
 	}
 
-	hash, err := h.DB.AddImage(img, uniqueFilename)
+	info, aliased, err := db.AddImageWithOptions(img, uniqueFilename, database.AddOptions{
+		Tags:            formTags(c),
+		AliasDuplicates: aliasDuplicates,
+		SkipFeatures:    c.PostForm("skip_features") == "true",
+		AddedAt:         addedAt,
+	})
 	if err != nil {
+		os.Remove(pending)
 		os.Remove(savePath)
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		middleware.ErrorDetail(c, http.StatusBadRequest, i18n.ImageExists, err.Error())
+		return
+	}
+	if err := storeImage(db, imageDir, pending, uniqueFilename, sync); err != nil {
+		if aliased {
+			db.RemoveAlias(info.ID, uniqueFilename)
+		} else {
+			db.DeleteImage(info.ID)
+		}
+		os.Remove(savePath)
+		writeSaveError(c, savePath, err)
 		return
 	}
 
+	if aliased {
+		c.JSON(http.StatusOK, gin.H{
+			"message":  "image added as an alias",
+			"id":       info.ID,
+			"filename": uniqueFilename,
+			"hash":     info.Hash,
+			"aliased":  true,
+			"alias_of": info.Filename,
+		})
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{
 		"message":  "image added successfully",
+		"id":       info.ID,
 		"filename": uniqueFilename,
-		"hash":     hash,
+		"hash":     info.Hash,
 	})
 }
 
+// readImageUpload decodes the "image" form file after checking its size,
+// extension and sniffed content, and returns it with the uploaded filename.
+// On failure the error response has been written and ok is false.
+func (h *Handler) readImageUpload(c *gin.Context) (img image.Image, filename string, ok bool) {
+	file, header, err := c.Request.FormFile("image")
+	if err != nil {
+		middleware.Error(c, http.StatusBadRequest, i18n.ImageMissing)
+		return nil, "", false
+	}
+	defer file.Close()
+
+	if header.Size > maxUploadSize {
+		middleware.Error(c, http.StatusBadRequest, i18n.FileTooLarge)
+		return nil, "", false
+	}
+	ext := strings.ToLower(filepath.Ext(header.Filename))
+	if !im.IsImageFile(ext) {
+		middleware.Error(c, http.StatusBadRequest, i18n.UnsupportedFormat)
+		return nil, "", false
+	}
+	content := bufio.NewReader(file)
+	leading, _ := content.Peek(im.SniffLen)
+	if detected := im.DetectFormat(leading); detected != im.SupportedImageFormats[ext] {
+		if detected == "" {
+			detected = "non-image"
+		}
+		middleware.ErrorDetail(c, http.StatusBadRequest, i18n.FormatMismatch,
+			fmt.Sprintf("%s file contains %s data", ext, detected))
+		return nil, "", false
+	}
+
+	img, err = im.DecodeImage(content)
+	if err != nil {
+		middleware.Error(c, http.StatusBadRequest, decodeErrorCode(err))
+		return nil, "", false
+	}
+	if err := h.checkDimensions(img); err != nil {
+		middleware.ErrorDetail(c, http.StatusBadRequest, i18n.InvalidDimensions, err.Error())
+		return nil, "", false
+	}
+	return img, header.Filename, true
+}
+
+// maxFilenameStem caps the encoded stem of a stored filename in bytes, leaving
+// room for the upload stamp, a collision suffix and the extension
+const maxFilenameStem = 128
+
+// safeFilename drops any directories from name, including Windows-style
+// ones, and leading dots, then percent-encodes every byte of the stem outside
+// [A-Za-z0-9._-] so the result is one portable path segment that is also safe
+// in URLs. The extension, already checked by readImageUpload, is kept as is.
+// It returns "" when no stem is left.
+func safeFilename(name string) string {
+	name = strings.ReplaceAll(name, `\`, "/")
+	name = name[strings.LastIndex(name, "/")+1:]
+	ext := filepath.Ext(name)
+	stem := strings.TrimLeft(strings.TrimSuffix(name, ext), ".")
+	if stem == "" {
+		return ""
+	}
+
+	var encoded strings.Builder
+	for i := 0; i < len(stem); i++ {
+		piece := string(stem[i])
+		if !isFilenameByte(stem[i]) {
+			piece = fmt.Sprintf("%%%02X", stem[i])
+		}
+		if encoded.Len()+len(piece) > maxFilenameStem {
+			break
+		}
+		encoded.WriteString(piece)
+	}
+	return encoded.String() + ext
+}
+
+// isFilenameByte reports whether b is kept as is by safeFilename
+func isFilenameByte(b byte) bool {
+	return b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z' || b >= '0' && b <= '9' || b == '.' || b == '_' || b == '-'
+}
+
+// reserveFilename creates an empty file named name in dir, or name with a
+// -1, -2, ... suffix on its stem while that is taken, and returns the name
+// created. The caller overwrites the file with the image.
+func reserveFilename(dir, name string) (string, error) {
+	ext := filepath.Ext(name)
+	stem := strings.TrimSuffix(name, ext)
+	for attempt := 0; attempt < 100; attempt++ {
+		candidate := name
+		if attempt > 0 {
+			candidate = fmt.Sprintf("%s-%d%s", stem, attempt, ext)
+		}
+		file, err := os.OpenFile(filepath.Join(dir, candidate), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if errors.Is(err, os.ErrExist) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		return candidate, file.Close()
+	}
+	return "", fmt.Errorf("no free filename like %s", name)
+}
+
+// saveImage encodes img, in the format the extension of path names, to a
+// hidden temporary file beside path, which LoadImages skips, and returns its
+// name for commitImage. With sync the data is flushed to disk first. On
+// failure the temporary file is removed, the error response has been written
+// and ok is false.
+func saveImage(c *gin.Context, img image.Image, path string, sync bool) (pending string, ok bool) {
+	pending, err := writeImageTemp(img, path, sync)
+	if err != nil {
+		writeSaveError(c, path, err)
+		return "", false
+	}
+	return pending, true
+}
+
+// writeImageTemp does the work of saveImage
+func writeImageTemp(img image.Image, path string, sync bool) (string, error) {
+	format, err := imaging.FormatFromFilename(path)
+	if err != nil {
+		return "", err
+	}
+	file, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return "", err
+	}
+	err = imaging.Encode(file, img, format)
+	if err == nil && sync {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+	return file.Name(), nil
+}
+
+// storeImage moves a file written by saveImage into imageDir under the
+// logical filename, as the database's storage layout places it. A content
+// blob the filename had before the layout was switched to flat is released.
+func storeImage(db *database.ImageDatabase, imageDir, pending, filename string, sync bool) error {
+	if db.StorageLayout() == database.LayoutContent {
+		return db.StoreContent(imageDir, pending, filename, sync)
+	}
+	if err := commitImage(pending, filepath.Join(imageDir, filename), sync); err != nil {
+		return err
+	}
+	if db.FilePath(filename) != filename {
+		return db.RemoveFile(imageDir, filename)
+	}
+	return nil
+}
+
+// commitImage atomically renames a file written by saveImage to path,
+// removing it if the rename fails. With sync the directory is flushed too, so
+// the rename itself survives a crash.
+func commitImage(pending, path string, sync bool) error {
+	if err := os.Rename(pending, path); err != nil {
+		os.Remove(pending)
+		return err
+	}
+	if sync {
+		// Not every platform can sync a directory; the rename is done either way
+		if dir, err := os.Open(filepath.Dir(path)); err == nil {
+			dir.Sync()
+			dir.Close()
+		}
+	}
+	return nil
+}
+
+// writeSaveError logs a failure to write path and responds with 500
+func writeSaveError(c *gin.Context, path string, err error) {
+	slog.Error("error saving image", "path", path, "error", err)
+	if os.IsPermission(err) {
+		middleware.Error(c, http.StatusInternalServerError, i18n.SavePermissionDenied)
+	} else {
+		middleware.Error(c, http.StatusInternalServerError, i18n.SaveFailed)
+	}
+}
+
 // @Summary Toggle ML mode
-// @Description Enable/disable ML-based recognition
+// @Description Enable/disable ML-based recognition. Superseded by /admin/method: enabling selects the auto method and disabling the hash method.
 // @Tags Image Database Management
 // @Accept multipart/form-data
 // @Produce json
 // @Param enable formData string false "Set to 'true' or 'false'"
 // @Success 200 {object} map[string]interface{}
+// @Security ApiKeyAuth
 // @Router /admin/toggle-ml [post]
 func (h *Handler) ToggleMLHandler(c *gin.Context) {
 	enable := c.DefaultPostForm("enable", "")
 	if enable == "true" {
-		h.DB.UseML = true
+		h.eachDB(func(db *database.ImageDatabase) { db.SetMatchMethod(database.MethodAuto) })
 		c.JSON(http.StatusOK, gin.H{"message": "ML enabled", "status": "enabled"})
 	} else if enable == "false" {
-		h.DB.UseML = false
+		h.eachDB(func(db *database.ImageDatabase) { db.SetMatchMethod(database.MethodHash) })
 		c.JSON(http.StatusOK, gin.H{"message": "ML disabled", "status": "disabled"})
 	} else {
-		c.JSON(http.StatusOK, gin.H{"message": "ML status", "status": h.DB.UseML})
+		c.JSON(http.StatusOK, gin.H{"message": "ML status", "status": h.DB.UsesML()})
+	}
+}
+
+// @Summary Set match method
+// @Description Choose the branches recognition runs: auto tries ML and falls back to hashing (blending both when PHOTOT_ML_WEIGHT and PHOTOT_HASH_WEIGHT are set), ml matches by features alone, hash by perceptual hashes alone, combined always blends both, and strict matches only when both clear their thresholds
+// @Tags Image Database Management
+// @Accept multipart/form-data
+// @Produce json
+// @Param method formData string false "auto, ml, hash, combined or strict; omit to read the current method"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /admin/method [post]
+func (h *Handler) MatchMethodHandler(c *gin.Context) {
+	name := c.DefaultPostForm("method", "")
+	if name == "" {
+		c.JSON(http.StatusOK, gin.H{"message": "method status", "method": h.DB.MatchMethod()})
+		return
+	}
+
+	method, err := database.ParseMatchMethod(name)
+	if err != nil {
+		middleware.ErrorDetail(c, http.StatusBadRequest, i18n.InvalidParameter, err.Error())
+		return
 	}
+	h.eachDB(func(db *database.ImageDatabase) { db.SetMatchMethod(method) })
+	c.JSON(http.StatusOK, gin.H{"message": "method updated", "method": method})
+}
+
+// @Summary Set feature distance metric
+// @Description Choose how ML feature vectors are compared: cosine, euclidean or manhattan
+// @Tags Image Database Management
+// @Accept multipart/form-data
+// @Produce json
+// @Param metric formData string false "cosine, euclidean or manhattan; omit to read the current metric"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /admin/metric [post]
+func (h *Handler) MetricHandler(c *gin.Context) {
+	name := c.DefaultPostForm("metric", "")
+	if name == "" {
+		c.JSON(http.StatusOK, gin.H{"message": "metric status", "metric": h.DB.Metric()})
+		return
+	}
+
+	metric, err := im.ParseDistanceMetric(name)
+	if err != nil {
+		middleware.ErrorDetail(c, http.StatusBadRequest, i18n.InvalidParameter, err.Error())
+		return
+	}
+	h.eachDB(func(db *database.ImageDatabase) { db.SetMetric(metric) })
+	c.JSON(http.StatusOK, gin.H{"message": "metric updated", "metric": metric})
+}
+
+// @Summary Health check
+// @Description Report that the server is up, how many reference images it holds and the recognize load
+// @Tags Image Recognition
+// @Produce json
+// @Success 200 {object} map[string]interface{}
+// @Router /health [get]
+func (h *Handler) HealthHandler(c *gin.Context) {
+	inFlight, queued := h.Recognitions.Stats()
+	h.DB.Mutex.RLock()
+	images := len(h.DB.Hashes)
+	h.DB.Mutex.RUnlock()
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "ok",
+		"images": images,
+		"recognize": gin.H{
+			"in_flight": inFlight,
+			"queued":    queued,
+		},
+		"cache": h.DB.Cache.Stats(),
+	})
+}
+
+// @Summary Build version
+// @Description Identify the running binary: semantic version, git commit, build time and whether ML matching is available
+// @Tags Image Recognition
+// @Produce json
+// @Success 200 {object} map[string]interface{}
+// @Router /version [get]
+func (h *Handler) VersionHandler(c *gin.Context) {
+	build := version.Get()
+	c.JSON(http.StatusOK, gin.H{
+		"version":    build.Version,
+		"commit":     build.Commit,
+		"build_time": build.BuildTime,
+		"go_version": build.GoVersion,
+		"ml_loaded":  true, // HOG features are computed in-process, so there is no model to fail
+		"ml_enabled": h.DB.UsesML(),
+	})
 }
 
 // @Summary Hello endpoint
@@ -197,6 +919,7 @@ func (h *Handler) ToggleMLHandler(c *gin.Context) {
 // @Tags Image Database Management
 // @Produce json
 // @Success 200 {object} map[string]string
+// @Security ApiKeyAuth
 // @Router /admin/hello [get]
 func (h *Handler) Hello(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Hello, world"})
@@ -0,0 +1,63 @@
+package handler
+
+import (
+	"net/http"
+	"photot/api/middleware"
+	"photot/helper/i18n"
+	im "photot/helper/image"
+
+	"github.com/gin-gonic/gin"
+)
+
+// @Summary Compute image hash
+// @Description Return the perceptual hash of an uploaded image without storing or matching it, along with the ID of the hash config (PHOTOT_HASH_SIZE, PHOTOT_HASH_GRID) it was computed with
+// @Tags Image Recognition
+// @Accept multipart/form-data
+// @Produce json
+// @Param image formData file true "Image file to hash"
+// @Param features query boolean false "Also return the HOG feature vector"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]string
+// @Router /hash [post]
+func (h *Handler) HashHandler(c *gin.Context) {
+	file, header, err := c.Request.FormFile("image")
+	if err != nil {
+		middleware.Error(c, http.StatusBadRequest, i18n.ImageMissing)
+		return
+	}
+	defer file.Close()
+
+	if header.Size > maxUploadSize {
+		middleware.Error(c, http.StatusBadRequest, i18n.FileTooLarge)
+		return
+	}
+
+	img, err := im.DecodeImage(file)
+	if err != nil {
+		middleware.Error(c, http.StatusBadRequest, decodeErrorCode(err))
+		return
+	}
+	if err := h.checkDimensions(img); err != nil {
+		middleware.ErrorDetail(c, http.StatusBadRequest, i18n.InvalidDimensions, err.Error())
+		return
+	}
+
+	cfg := h.config()
+	// Validated by SetConfig
+	background, _ := im.ParseHexColor(cfg.BackgroundColor)
+	img = im.Flatten(img, background)
+	grayWeights, _ := im.ParseGrayWeights(cfg.GrayWeights)
+	hashFilter, _ := im.ParseResizeFilter(cfg.HashResizeFilter)
+	hashConfig := im.HashConfig{Size: cfg.HashSize, Grid: cfg.HashGrid, Gray: grayWeights, Filter: hashFilter}
+	hash := im.ComputeDCTHashWithConfig(img, hashConfig)
+	response := gin.H{
+		"dct_hash":     hash,
+		"length":       len(hash),
+		"hash_config":  hashConfig.ID(),
+		"wavelet_hash": im.ComputeWaveletHash(img),
+	}
+	if c.Query("features") == "true" {
+		response["features"] = im.ExtractImageFeatures(img)
+	}
+	c.JSON(http.StatusOK, response)
+}
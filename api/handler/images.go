@@ -0,0 +1,246 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"photot/api/middleware"
+	"photot/helper/database"
+	"photot/helper/i18n"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultPageSize and maxPageSize bound /admin/list pages, since every image
+// carries its thumbnail
+const (
+	defaultPageSize = 50
+	maxPageSize     = 500
+)
+
+// @Summary List images
+// @Description List one page of stored reference images with their stable IDs and thumbnails
+// @Tags Image Database Management
+// @Produce json
+// @Param page query integer false "1-based page number, default 1"
+// @Param page_size query integer false "Images per page, default 50, at most 500"
+// @Param sort query string false "added_at (default) or filename"
+// @Param order query string false "asc (default) or desc"
+// @Param X-Tenant header string false "Tenant whose isolated database is used"
+// @Success 200 {object} database.ImagePage
+// @Failure 400 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /admin/list [get]
+func (h *Handler) ListImagesHandler(c *gin.Context) {
+	db, _, ok := h.tenant(c)
+	if !ok {
+		return
+	}
+
+	opts := database.ListOptions{Page: 1, PageSize: defaultPageSize}
+	if pageStr := c.Query("page"); pageStr != "" {
+		page, err := strconv.Atoi(pageStr)
+		if err != nil || page < 1 {
+			middleware.ErrorDetail(c, http.StatusBadRequest, i18n.InvalidParameter, "page must be a positive integer")
+			return
+		}
+		opts.Page = page
+	}
+	if sizeStr := c.Query("page_size"); sizeStr != "" {
+		size, err := strconv.Atoi(sizeStr)
+		if err != nil || size < 1 || size > maxPageSize {
+			middleware.ErrorDetail(c, http.StatusBadRequest, i18n.InvalidParameter,
+				fmt.Sprintf("page_size must be between 1 and %d", maxPageSize))
+			return
+		}
+		opts.PageSize = size
+	}
+	switch opts.Sort = c.DefaultQuery("sort", database.SortAddedAt); opts.Sort {
+	case database.SortAddedAt, database.SortFilename:
+	default:
+		middleware.ErrorDetail(c, http.StatusBadRequest, i18n.InvalidParameter, "sort must be added_at or filename")
+		return
+	}
+	switch order := c.DefaultQuery("order", "asc"); order {
+	case "asc":
+	case "desc":
+		opts.Descending = true
+	default:
+		middleware.ErrorDetail(c, http.StatusBadRequest, i18n.InvalidParameter, "order must be asc or desc")
+		return
+	}
+
+	c.JSON(http.StatusOK, db.ListImagesPage(opts))
+}
+
+// @Summary Get image
+// @Description Return the full record of one reference image, found by its stable ID or its DCT hash
+// @Tags Image Database Management
+// @Produce json
+// @Param id path string true "Stable image ID or DCT hash"
+// @Param include_features query boolean false "Include the ML feature vector"
+// @Param X-Tenant header string false "Tenant whose isolated database is used"
+// @Success 200 {object} database.ImageInfo
+// @Failure 404 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /admin/image/{id} [get]
+func (h *Handler) GetImageHandler(c *gin.Context) {
+	db, _, ok := h.tenant(c)
+	if !ok {
+		return
+	}
+
+	info, ok := db.Lookup(c.Param("id"))
+	if !ok {
+		middleware.Error(c, http.StatusNotFound, i18n.ImageNotFound)
+		return
+	}
+	if c.Query("include_features") == "true" {
+		info.Features = info.FeatureVector()
+	} else {
+		info.Features = nil
+	}
+	info.Quantized = nil
+	c.JSON(http.StatusOK, info)
+}
+
+// @Summary Replace image
+// @Description Replace the pixels of a reference image, recomputing its hashes, features and thumbnail while keeping its stable ID, filename, tags and added_at
+// @Tags Image Database Management
+// @Accept multipart/form-data
+// @Produce json
+// @Param id path string true "Stable image ID"
+// @Param image formData file true "New image file"
+// @Param X-Tenant header string false "Tenant whose isolated database is used"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /admin/image/{id} [put]
+func (h *Handler) ReplaceImageHandler(c *gin.Context) {
+	db, imageDir, ok := h.tenant(c)
+	if !ok {
+		return
+	}
+	id := c.Param("id")
+	current, ok := db.Image(id)
+	if !ok {
+		middleware.Error(c, http.StatusNotFound, i18n.ImageNotFound)
+		return
+	}
+	img, _, ok := h.readImageUpload(c)
+	if !ok || !h.checkContrast(c, img) {
+		return
+	}
+
+	// The file is only swapped in once the database accepts the new pixels
+	path := filepath.Join(imageDir, current.Filename)
+	sync := h.config().SyncImageWrites
+	pending, ok := saveImage(c, img, path, sync)
+	if !ok {
+		return
+	}
+	info, err := db.ReplaceImage(id, img)
+	if err != nil {
+		os.Remove(pending)
+		if errors.Is(err, database.ErrImageNotFound) {
+			middleware.Error(c, http.StatusNotFound, i18n.ImageNotFound)
+		} else {
+			middleware.ErrorDetail(c, http.StatusBadRequest, i18n.ImageExists, err.Error())
+		}
+		return
+	}
+	if err := storeImage(db, imageDir, pending, current.Filename, sync); err != nil {
+		// The old file is still in place, so the record must describe it
+		if !db.RestoreImage(info, current) {
+			slog.Warn("replaced image changed again before its file could be restored", "id", id)
+		}
+		slog.Error("error replacing image file", "path", path, "error", err)
+		middleware.Error(c, http.StatusInternalServerError, i18n.SaveFailed)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "image replaced",
+		"id":       info.ID,
+		"filename": info.Filename,
+		"hash":     info.Hash,
+	})
+}
+
+// @Summary Clear database
+// @Description Remove every reference image from memory and flush cached results. With delete_files=true the files of the removed images are deleted from the image directory too; otherwise they are reloaded on the next restart.
+// @Tags Image Database Management
+// @Produce json
+// @Param delete_files query boolean false "Also delete the removed images' files"
+// @Param X-Tenant header string false "Tenant whose isolated database is used"
+// @Success 200 {object} map[string]interface{}
+// @Security ApiKeyAuth
+// @Router /admin/clear [post]
+func (h *Handler) ClearHandler(c *gin.Context) {
+	db, imageDir, ok := h.tenant(c)
+	if !ok {
+		return
+	}
+
+	removed := db.Clear()
+	filesDeleted := 0
+	if c.Query("delete_files") == "true" {
+		for _, info := range removed {
+			for _, filename := range info.Files() {
+				if err := db.RemoveFile(imageDir, filename); err != nil {
+					if !os.IsNotExist(err) {
+						slog.Error("error removing image file", "file", filename, "error", err)
+					}
+					continue
+				}
+				filesDeleted++
+			}
+		}
+	}
+	slog.Info("database cleared", "removed", len(removed), "files_deleted", filesDeleted)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":       "database cleared",
+		"removed":       len(removed),
+		"files_deleted": filesDeleted,
+	})
+}
+
+// @Summary Delete image
+// @Description Remove a reference image and its file, and the files of its aliases, by stable ID
+// @Tags Image Database Management
+// @Produce json
+// @Param id path string true "Stable image ID"
+// @Param X-Tenant header string false "Tenant whose isolated database is used"
+// @Success 200 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /admin/image/{id} [delete]
+func (h *Handler) DeleteImageHandler(c *gin.Context) {
+	db, imageDir, ok := h.tenant(c)
+	if !ok {
+		return
+	}
+
+	info, ok := db.DeleteImage(c.Param("id"))
+	if !ok {
+		middleware.Error(c, http.StatusNotFound, i18n.ImageNotFound)
+		return
+	}
+	for _, filename := range info.Files() {
+		if err := db.RemoveFile(imageDir, filename); err != nil && !os.IsNotExist(err) {
+			slog.Error("error removing image file", "file", filename, "error", err)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "image deleted",
+		"id":       info.ID,
+		"filename": info.Filename,
+	})
+}
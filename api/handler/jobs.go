@@ -0,0 +1,51 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"photot/api/middleware"
+	"photot/helper/i18n"
+	"photot/helper/jobs"
+
+	"github.com/gin-gonic/gin"
+)
+
+// submitJob starts fn as a background job and answers 202 with its ID
+func (h *Handler) submitJob(c *gin.Context, kind string, fn jobs.Func) {
+	if h.Jobs == nil {
+		middleware.Error(c, http.StatusBadRequest, i18n.JobsDisabled)
+		return
+	}
+
+	id, err := h.Jobs.Submit(kind, fn)
+	if errors.Is(err, jobs.ErrQueueFull) {
+		middleware.Error(c, http.StatusServiceUnavailable, i18n.JobQueueFull)
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{
+		"job_id":     id,
+		"status_url": "/admin/jobs/" + id,
+	})
+}
+
+// @Summary Get job status
+// @Description Report the status, progress and result of a background job
+// @Tags Image Database Management
+// @Produce json
+// @Param id path string true "Job ID"
+// @Success 200 {object} jobs.Job
+// @Failure 404 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /admin/jobs/{id} [get]
+func (h *Handler) JobHandler(c *gin.Context) {
+	if h.Jobs == nil {
+		middleware.Error(c, http.StatusNotFound, i18n.JobNotFound)
+		return
+	}
+	job, ok := h.Jobs.Get(c.Param("id"))
+	if !ok {
+		middleware.Error(c, http.StatusNotFound, i18n.JobNotFound)
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
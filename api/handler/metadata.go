@@ -0,0 +1,67 @@
+package handler
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"photot/api/middleware"
+	"photot/helper/i18n"
+	im "photot/helper/image"
+
+	"github.com/gin-gonic/gin"
+)
+
+// @Summary Read image metadata
+// @Description Return the dimensions, format and EXIF camera, capture time and GPS location of an uploaded image without storing or matching it. Images without EXIF get an empty exif object; GPS is omitted when PHOTOT_METADATA_STRIP_GPS is set.
+// @Tags Image Recognition
+// @Accept multipart/form-data
+// @Produce json
+// @Param image formData file true "Image file to inspect"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]string
+// @Router /metadata [post]
+func (h *Handler) MetadataHandler(c *gin.Context) {
+	file, header, err := c.Request.FormFile("image")
+	if err != nil {
+		middleware.Error(c, http.StatusBadRequest, i18n.ImageMissing)
+		return
+	}
+	defer file.Close()
+
+	if header.Size > maxUploadSize {
+		middleware.Error(c, http.StatusBadRequest, i18n.FileTooLarge)
+		return
+	}
+	// EXIF and pixels are read from the same bytes, so keep them in memory
+	data, err := io.ReadAll(io.LimitReader(file, maxUploadSize+1))
+	if err != nil {
+		middleware.Error(c, http.StatusBadRequest, i18n.InvalidImage)
+		return
+	}
+	if len(data) > maxUploadSize {
+		middleware.Error(c, http.StatusBadRequest, i18n.FileTooLarge)
+		return
+	}
+
+	img, err := im.DecodeImage(bytes.NewReader(data))
+	if err != nil {
+		middleware.Error(c, http.StatusBadRequest, decodeErrorCode(err))
+		return
+	}
+	if err := h.checkDimensions(img); err != nil {
+		middleware.ErrorDetail(c, http.StatusBadRequest, i18n.InvalidDimensions, err.Error())
+		return
+	}
+
+	exif := im.ReadEXIF(bytes.NewReader(data))
+	if h.config().MetadataStripGPS {
+		exif.GPS = nil
+	}
+	bounds := img.Bounds()
+	c.JSON(http.StatusOK, gin.H{
+		"width":  bounds.Dx(),
+		"height": bounds.Dy(),
+		"format": im.DetectFormat(data),
+		"exif":   exif,
+	})
+}
@@ -0,0 +1,109 @@
+package handler
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"net/http"
+	"photot/api/middleware"
+	"photot/helper/i18n"
+
+	"github.com/disintegration/imaging"
+	"github.com/gin-gonic/gin"
+)
+
+// selftestTransform is one of the edits /admin/selftest applies to an upload
+type selftestTransform struct {
+	name  string
+	apply func(image.Image) (image.Image, error)
+}
+
+// selftestTransforms are common ways a copy of a reference image differs from it
+var selftestTransforms = []selftestTransform{
+	{"resize_50", func(img image.Image) (image.Image, error) {
+		return imaging.Resize(img, max(img.Bounds().Dx()/2, 1), 0, imaging.Lanczos), nil
+	}},
+	{"jpeg_q30", func(img image.Image) (image.Image, error) {
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 30}); err != nil {
+			return nil, err
+		}
+		return jpeg.Decode(&buf)
+	}},
+	{"rotate_5", func(img image.Image) (image.Image, error) {
+		return imaging.Rotate(img, 5, color.White), nil
+	}},
+	{"crop_90", func(img image.Image) (image.Image, error) {
+		bounds := img.Bounds()
+		return imaging.CropCenter(img, bounds.Dx()*9/10, bounds.Dy()*9/10), nil
+	}},
+}
+
+// selftestResult is how one transformed copy matched the original
+type selftestResult struct {
+	Transform      string   `json:"transform"`
+	Match          bool     `json:"match"`
+	Similarity     float64  `json:"similarity"`
+	Method         string   `json:"method"`
+	MLSimilarity   *float64 `json:"ml_similarity,omitempty"`
+	HashSimilarity *float64 `json:"hash_similarity,omitempty"`
+}
+
+// @Summary Self-similarity check
+// @Description Match edited copies of the uploaded image (half size, JPEG quality 30, rotated 5 degrees, center 90% crop) against the original alone, with the current match method, metric, weights and thresholds, to pick a threshold for your own images. Nothing is stored.
+// @Tags Image Database Management
+// @Accept multipart/form-data
+// @Produce json
+// @Param image formData file true "Image to test"
+// @Param threshold formData number false "Similarity threshold (0-100), defaults to PHOTOT_DEFAULT_THRESHOLD"
+// @Param ml_threshold formData number false "Similarity threshold for the ML branch (0-100), defaults to threshold"
+// @Param hash_threshold formData number false "Similarity threshold for the hash fallback (0-100), defaults to threshold"
+// @Param X-Tenant header string false "Tenant whose match settings are used"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /admin/selftest [post]
+func (h *Handler) SelftestHandler(c *gin.Context) {
+	db, _, ok := h.tenant(c)
+	if !ok {
+		return
+	}
+	img, _, ok := h.readImageUpload(c)
+	if !ok {
+		return
+	}
+
+	// The original is the only reference, so the search is never scoped
+	opts := h.matchOptions(c)
+	opts.Tags, opts.FilenamePrefix = nil, ""
+	scratch := db.NewEmpty()
+	if _, err := scratch.AddImage(img, "original"); err != nil {
+		middleware.ErrorDetail(c, http.StatusInternalServerError, i18n.InternalError, err.Error())
+		return
+	}
+
+	results := make([]selftestResult, 0, len(selftestTransforms))
+	for _, transform := range selftestTransforms {
+		copied, err := transform.apply(img)
+		if err != nil {
+			middleware.ErrorDetail(c, http.StatusInternalServerError, i18n.InternalError, transform.name+": "+err.Error())
+			return
+		}
+		match := h.findMatch(c.Request.Context(), scratch, copied, opts)
+		results = append(results, selftestResult{
+			Transform:      transform.name,
+			Match:          match.IsMatch,
+			Similarity:     match.Similarity,
+			Method:         match.Method,
+			MLSimilarity:   match.MLSimilarity,
+			HashSimilarity: match.HashSimilarity,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"match_method": scratch.MatchMethod(),
+		"threshold":    opts.Threshold,
+		"results":      results,
+	})
+}
@@ -0,0 +1,68 @@
+package handler
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"photot/api/middleware"
+	"photot/helper/database"
+	"photot/helper/i18n"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TenantHeader selects an isolated tenant database; requests without it use the default one
+const TenantHeader = "X-Tenant"
+
+// tenant returns the database and image directory selected by the request,
+// writing an error response and returning false when it cannot be used.
+// Only existing tenants are selected; see createTenant.
+func (h *Handler) tenant(c *gin.Context) (*database.ImageDatabase, string, bool) {
+	return h.openTenant(c, false)
+}
+
+// createTenant is tenant for /admin/add, the one route that creates the
+// selected tenant when it does not exist yet
+func (h *Handler) createTenant(c *gin.Context) (*database.ImageDatabase, string, bool) {
+	return h.openTenant(c, true)
+}
+
+// openTenant implements tenant and createTenant
+func (h *Handler) openTenant(c *gin.Context, create bool) (*database.ImageDatabase, string, bool) {
+	name := c.GetHeader(TenantHeader)
+	if name == "" {
+		return h.DB, h.ImageDir, true
+	}
+	if h.Tenants == nil {
+		middleware.Error(c, http.StatusBadRequest, i18n.TenantsDisabled)
+		return nil, "", false
+	}
+
+	get := h.Tenants.Get
+	if create {
+		get = h.Tenants.Create
+	}
+	db, dir, err := get(name)
+	switch {
+	case err == nil:
+		return db, dir, true
+	case errors.Is(err, database.ErrInvalidTenant):
+		middleware.Error(c, http.StatusBadRequest, i18n.InvalidTenant)
+	case errors.Is(err, database.ErrTenantNotFound):
+		middleware.Error(c, http.StatusNotFound, i18n.TenantNotFound)
+	case errors.Is(err, database.ErrTenantLimit):
+		middleware.Error(c, http.StatusServiceUnavailable, i18n.TenantLimit)
+	default:
+		slog.Error("error opening tenant", "tenant", name, "error", err)
+		middleware.Error(c, http.StatusInternalServerError, i18n.TenantFailed)
+	}
+	return nil, "", false
+}
+
+// eachDB applies fn to the default database and every tenant database
+func (h *Handler) eachDB(fn func(*database.ImageDatabase)) {
+	fn(h.DB)
+	if h.Tenants != nil {
+		h.Tenants.Each(fn)
+	}
+}
@@ -0,0 +1,50 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"photot/api/middleware"
+	"photot/helper/i18n"
+
+	"github.com/gin-gonic/gin"
+)
+
+// @Summary Get image thumbnail
+// @Description Serve the stored thumbnail of a reference image
+// @Tags Image Database Management
+// @Produce image/jpeg,image/png,image/webp
+// @Param id path string true "Stored image filename"
+// @Param X-Tenant header string false "Tenant whose isolated database is used"
+// @Success 200 {file} binary
+// @Success 304 "Not modified"
+// @Failure 404 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /admin/image/{id}/thumbnail [get]
+func (h *Handler) ThumbnailHandler(c *gin.Context) {
+	db, _, ok := h.tenant(c)
+	if !ok {
+		return
+	}
+	// The segment is named id to share the route tree with /admin/image/:id
+	encoded, ok := db.Thumbnail(c.Param("id"))
+	if !ok {
+		middleware.Error(c, http.StatusNotFound, i18n.ImageNotFound)
+		return
+	}
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(data) == 0 {
+		middleware.Error(c, http.StatusNotFound, i18n.ThumbnailNotAvailable)
+		return
+	}
+
+	etag := fmt.Sprintf(`"%x"`, sha256.Sum256(data))
+	c.Header("Cache-Control", "private, max-age=86400")
+	c.Header("ETag", etag)
+	if c.GetHeader("If-None-Match") == etag {
+		c.Status(http.StatusNotModified)
+		return
+	}
+	c.Data(http.StatusOK, http.DetectContentType(data), data)
+}
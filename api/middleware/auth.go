@@ -0,0 +1,37 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"photot/helper/i18n"
+
+	"github.com/gin-gonic/gin"
+)
+
+// APIKey rejects requests whose X-API-Key header does not match the key
+// returned by settings. Without a key every request is refused with 503,
+// unless settings also reports the check disabled, which lets all through.
+func APIKey(settings func() (key string, disabled bool)) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key, disabled := settings()
+		if disabled {
+			c.Next()
+			return
+		}
+		if key == "" {
+			Error(c, http.StatusServiceUnavailable, i18n.AdminAuthNotConfigured)
+			return
+		}
+
+		provided := c.GetHeader("X-API-Key")
+		if provided == "" {
+			Error(c, http.StatusUnauthorized, i18n.APIKeyMissing)
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(provided), []byte(key)) != 1 {
+			Error(c, http.StatusUnauthorized, i18n.APIKeyInvalid)
+			return
+		}
+		c.Next()
+	}
+}
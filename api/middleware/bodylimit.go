@@ -0,0 +1,60 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"photot/helper/i18n"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// multipartMemory is how much of a multipart body ParseMultipart parses into
+// memory before spilling files to disk, gin's default
+const multipartMemory = 32 << 20
+
+// BodyLimit caps every request body at limit bytes; limit is read on every
+// request so changes apply immediately, and 0 or less disables the cap. A
+// body that declares a larger Content-Length is rejected with 413 before any
+// of it is read; others are only wrapped, and fail when read past the cap,
+// see ParseMultipart and BodyTooLarge. Nothing is read here, so requests
+// later refused by rate limiting or authentication cost no parse.
+func BodyLimit(limit func() int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		n := limit()
+		if n <= 0 || c.Request.Body == nil || c.Request.Body == http.NoBody {
+			c.Next()
+			return
+		}
+		if c.Request.ContentLength > n {
+			c.Header("Connection", "close")
+			Error(c, http.StatusRequestEntityTooLarge, i18n.RequestTooLarge)
+			return
+		}
+
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, n)
+		c.Next()
+	}
+}
+
+// ParseMultipart parses multipart form bodies, so a chunked upload that runs
+// past BodyLimit's cap gets 413 rather than a missing-file error from the
+// handler. Install it after the rate limit and authentication middleware.
+func ParseMultipart() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if strings.HasPrefix(c.ContentType(), "multipart/form-data") {
+			if err := c.Request.ParseMultipartForm(multipartMemory); BodyTooLarge(err) {
+				c.Header("Connection", "close")
+				Error(c, http.StatusRequestEntityTooLarge, i18n.RequestTooLarge)
+				return
+			}
+		}
+		c.Next()
+	}
+}
+
+// BodyTooLarge reports whether err came from reading past BodyLimit's cap
+func BodyTooLarge(err error) bool {
+	var maxBytes *http.MaxBytesError
+	return errors.As(err, &maxBytes)
+}
@@ -0,0 +1,144 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"photot/helper/i18n"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// errBusy is returned by acquire when every slot and queue place is taken
+var errBusy = errors.New("concurrency limit and queue are full")
+
+// ConcurrencyLimiter bounds how many slots of work run at once, holding a
+// bounded number of further requests in a FIFO queue and rejecting the rest.
+// A nil limiter lets everything through.
+type ConcurrencyLimiter struct {
+	mu       sync.Mutex
+	inFlight int // Slots taken
+	waiters  []waiter
+	limits   func() (int, int)
+}
+
+// waiter is a queued request and the number of slots it waits for
+type waiter struct {
+	ready chan struct{}
+	slots int
+}
+
+// NewConcurrencyLimiter creates a limiter; limits returns the number of slots
+// that may be taken at once and how many more requests may wait for them, and
+// is read on every request so changes apply immediately. A limit of 0 or less
+// disables limiting.
+func NewConcurrencyLimiter(limits func() (int, int)) *ConcurrencyLimiter {
+	return &ConcurrencyLimiter{limits: limits}
+}
+
+// Middleware takes one slot per request. It returns 503 with a Retry-After
+// header when the queue is full, and gives up on a queued request once its
+// client goes away.
+func (l *ConcurrencyLimiter) Middleware() gin.HandlerFunc {
+	return l.Weighted(func() int { return 1 })
+}
+
+// Weighted is Middleware for routes that spread one request over several
+// goroutines, taking weight() slots per request. The weight is capped at the
+// limit, so such a request still runs once every other slot is free.
+func (l *ConcurrencyLimiter) Weighted(weight func() int) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if l == nil {
+			c.Next()
+			return
+		}
+		slots, err := l.acquire(c.Request.Context(), weight())
+		if err != nil {
+			c.Header("Retry-After", "1")
+			Error(c, http.StatusServiceUnavailable, i18n.ServerBusy)
+			return
+		}
+		defer l.release(slots)
+		c.Next()
+	}
+}
+
+// Stats returns the number of slots taken and requests waiting
+func (l *ConcurrencyLimiter) Stats() (inFlight, queued int) {
+	if l == nil {
+		return 0, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.inFlight, len(l.waiters)
+}
+
+// acquire takes up to want slots, at least one and at most the limit,
+// waiting in the queue if there is room, and returns how many it took
+func (l *ConcurrencyLimiter) acquire(ctx context.Context, want int) (int, error) {
+	l.mu.Lock()
+	limit, depth := l.limits()
+	slots := max(want, 1)
+	if limit > 0 {
+		slots = min(slots, limit)
+	}
+	if limit <= 0 || l.inFlight+slots <= limit && len(l.waiters) == 0 {
+		l.inFlight += slots
+		l.mu.Unlock()
+		return slots, nil
+	}
+	if len(l.waiters) >= depth {
+		l.mu.Unlock()
+		return 0, errBusy
+	}
+	ready := make(chan struct{})
+	l.waiters = append(l.waiters, waiter{ready: ready, slots: slots})
+	l.mu.Unlock()
+
+	select {
+	case <-ready:
+		return slots, nil
+	case <-ctx.Done():
+		l.mu.Lock()
+		for i, w := range l.waiters {
+			if w.ready == ready {
+				l.waiters = append(l.waiters[:i], l.waiters[i+1:]...)
+				// Requests queued behind this one may fit now
+				l.wake()
+				l.mu.Unlock()
+				return 0, ctx.Err()
+			}
+		}
+		l.mu.Unlock()
+		// The slots were handed over as the context ended, so pass them on
+		l.release(slots)
+		return 0, ctx.Err()
+	}
+}
+
+// release frees slots and hands them to the waiting requests
+func (l *ConcurrencyLimiter) release(slots int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inFlight -= slots
+	l.wake()
+}
+
+// wake hands free slots to waiting requests in order, stopping at the first
+// that does not fit, which also holds everyone back while the limit is lowered
+// below the slots taken. A request always fits once nothing else runs, in case
+// the limit dropped below its weight. The caller must hold mu.
+func (l *ConcurrencyLimiter) wake() {
+	limit, _ := l.limits()
+	for len(l.waiters) > 0 {
+		w := l.waiters[0]
+		// A limit of 0 or less means limiting was switched off
+		if limit > 0 && l.inFlight > 0 && l.inFlight+w.slots > limit {
+			return
+		}
+		l.inFlight += w.slots
+		close(w.ready)
+		l.waiters = l.waiters[1:]
+	}
+}
@@ -0,0 +1,91 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// corsMaxAge is how long, in seconds, browsers may cache a preflight answer
+const corsMaxAge = "600"
+
+// corsExposedHeaders are the response headers scripts of an allowed origin
+// may read
+const corsExposedHeaders = "ETag, X-Request-ID, X-Schema-Version, Retry-After"
+
+// CORSPolicy is which cross-origin browser requests are allowed
+type CORSPolicy struct {
+	AllowedOrigins   []string // Origins as scheme://host[:port], or "*" for any
+	AllowedMethods   []string
+	AllowedHeaders   []string
+	AllowCredentials bool // Let browsers send cookies and HTTP auth
+}
+
+// Validate checks that every origin is "*" or a bare scheme://host[:port]
+// and that credentials are not allowed for any origin, which browsers reject
+// and which would let every site act with a user's credentials
+func (p CORSPolicy) Validate() error {
+	for _, origin := range p.AllowedOrigins {
+		if origin == "*" {
+			if p.AllowCredentials {
+				return fmt.Errorf("cors credentials cannot be allowed with the wildcard origin")
+			}
+			continue
+		}
+		u, err := url.Parse(origin)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || (u.Path != "" && u.Path != "/") || u.RawQuery != "" {
+			return fmt.Errorf("cors origin must be * or scheme://host[:port], got %q", origin)
+		}
+	}
+	return nil
+}
+
+// allows reports whether requests from origin are allowed
+func (p CORSPolicy) allows(origin string) bool {
+	return slices.ContainsFunc(p.AllowedOrigins, func(allowed string) bool {
+		return allowed == "*" || strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin)
+	})
+}
+
+// CORS answers preflight requests and adds the CORS headers to requests from
+// origins the policy allows. policy is read on every request so changes apply
+// immediately. Requests from other origins, and every request when no origin
+// is allowed, pass through without CORS headers, so browsers refuse to hand
+// the responses to cross-origin scripts.
+func CORS(policy func() CORSPolicy) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		origin := c.GetHeader("Origin")
+		if origin == "" {
+			c.Next()
+			return
+		}
+		c.Writer.Header().Add("Vary", "Origin")
+		p := policy()
+		if !p.allows(origin) {
+			c.Next()
+			return
+		}
+
+		if slices.Contains(p.AllowedOrigins, "*") && !p.AllowCredentials {
+			c.Header("Access-Control-Allow-Origin", "*")
+		} else {
+			c.Header("Access-Control-Allow-Origin", origin)
+		}
+		if p.AllowCredentials {
+			c.Header("Access-Control-Allow-Credentials", "true")
+		}
+		if c.Request.Method == http.MethodOptions && c.GetHeader("Access-Control-Request-Method") != "" {
+			c.Header("Access-Control-Allow-Methods", strings.Join(p.AllowedMethods, ", "))
+			c.Header("Access-Control-Allow-Headers", strings.Join(p.AllowedHeaders, ", "))
+			c.Header("Access-Control-Max-Age", corsMaxAge)
+			c.AbortWithStatus(http.StatusNoContent)
+			return
+		}
+		c.Header("Access-Control-Expose-Headers", corsExposedHeaders)
+		c.Next()
+	}
+}
@@ -0,0 +1,37 @@
+package middleware
+
+import (
+	"photot/helper/i18n"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Error aborts with a JSON error body holding code and its message in the
+// language the request's Accept-Language header prefers
+func Error(c *gin.Context, status int, code i18n.Code) {
+	ErrorDetail(c, status, code, "")
+}
+
+// ErrorDetail is Error with an untranslated detail, such as the offending
+// value, added to the body when not empty
+func ErrorDetail(c *gin.Context, status int, code i18n.Code, detail string) {
+	c.AbortWithStatusJSON(status, ErrorBody(c, code, detail))
+}
+
+// ErrorBody returns the body ErrorDetail sends, for errors reported inside an
+// otherwise successful response, such as one item of a batch
+func ErrorBody(c *gin.Context, code i18n.Code, detail string) gin.H {
+	body := errorBody(c, code)
+	if detail != "" {
+		body["detail"] = detail
+	}
+	return body
+}
+
+// errorBody builds the JSON error body for code
+func errorBody(c *gin.Context, code i18n.Code) gin.H {
+	return gin.H{
+		"error_code": code,
+		"message":    i18n.Message(i18n.Language(c.GetHeader("Accept-Language")), code),
+	}
+}
@@ -0,0 +1,60 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// gzipWriters reuses compressors across responses
+var gzipWriters = sync.Pool{
+	New: func() any { return gzip.NewWriter(nil) },
+}
+
+// gzipWriter compresses the body and sets Content-Encoding on the first write,
+// so empty responses such as 304s go out untouched
+type gzipWriter struct {
+	gin.ResponseWriter
+	gz      *gzip.Writer
+	started bool
+}
+
+func (w *gzipWriter) Write(data []byte) (int, error) {
+	if !w.started {
+		w.started = true
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Header().Del("Content-Length")
+		w.gz.Reset(w.ResponseWriter)
+	}
+	return w.gz.Write(data)
+}
+
+func (w *gzipWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// Gzip compresses responses for clients that send Accept-Encoding: gzip. Use
+// it on routes with large JSON bodies, not on already-compressed images.
+func Gzip() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Vary", "Accept-Encoding")
+		if !strings.Contains(c.GetHeader("Accept-Encoding"), "gzip") {
+			c.Next()
+			return
+		}
+
+		gz := gzipWriters.Get().(*gzip.Writer)
+		writer := &gzipWriter{ResponseWriter: c.Writer, gz: gz}
+		c.Writer = writer
+		defer func() {
+			if writer.started {
+				gz.Close()
+			}
+			gz.Reset(nil)
+			gzipWriters.Put(gz)
+		}()
+		c.Next()
+	}
+}
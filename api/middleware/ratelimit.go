@@ -0,0 +1,97 @@
+package middleware
+
+import (
+	"math"
+	"net/http"
+	"photot/helper/i18n"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// sweepInterval is how often idle client buckets are garbage-collected
+const sweepInterval = time.Minute
+
+// bucket is the token bucket of a single client
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// RateLimiter is a per-client-IP token bucket limiter
+type RateLimiter struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	limits    func() (float64, int)
+	lastSweep time.Time
+}
+
+// NewRateLimiter creates a limiter; limits returns the refill rate in requests
+// per second and the burst size, and is read on every request so changes apply
+// immediately. A rate of 0 disables limiting.
+func NewRateLimiter(limits func() (float64, int)) *RateLimiter {
+	return &RateLimiter{
+		buckets:   make(map[string]*bucket),
+		limits:    limits,
+		lastSweep: time.Now(),
+	}
+}
+
+// Middleware returns 429 with a Retry-After header once a client runs out of tokens
+func (l *RateLimiter) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		rps, burst := l.limits()
+		if rps <= 0 || burst <= 0 {
+			c.Next()
+			return
+		}
+
+		allowed, retryAfter := l.take(c.ClientIP(), rps, burst, time.Now())
+		if !allowed {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			Error(c, http.StatusTooManyRequests, i18n.RateLimited)
+			return
+		}
+		c.Next()
+	}
+}
+
+// take consumes a token for ip, returning how long to wait when none is left
+func (l *RateLimiter) take(ip string, rps float64, burst int, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep(rps, burst, now)
+
+	b, ok := l.buckets[ip]
+	if !ok {
+		b = &bucket{tokens: float64(burst), last: now}
+		l.buckets[ip] = b
+	}
+	b.tokens = math.Min(float64(burst), b.tokens+now.Sub(b.last).Seconds()*rps)
+	b.last = now
+
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / rps * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// sweep drops buckets that have been idle long enough to refill completely,
+// since a full bucket behaves exactly like a missing one
+func (l *RateLimiter) sweep(rps float64, burst int, now time.Time) {
+	if now.Sub(l.lastSweep) < sweepInterval {
+		return
+	}
+	l.lastSweep = now
+
+	refill := time.Duration(float64(burst) / rps * float64(time.Second))
+	for ip, b := range l.buckets {
+		if now.Sub(b.last) > refill {
+			delete(l.buckets, ip)
+		}
+	}
+}
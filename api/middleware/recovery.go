@@ -0,0 +1,40 @@
+package middleware
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"photot/helper/i18n"
+	"runtime/debug"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Recovery turns a panic in a later handler into a logged stack trace and a
+// JSON 500 carrying the request ID, so the client can quote it when reporting
+// the failure. It must run after RequestID.
+func Recovery() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer func() {
+			r := recover()
+			if r == nil {
+				return
+			}
+			if err, ok := r.(error); ok && errors.Is(err, http.ErrAbortHandler) {
+				panic(r) // Deliberate connection abort, let net/http handle it
+			}
+
+			requestID := c.GetString(RequestIDKey)
+			slog.Error("panic serving request", "method", c.Request.Method, "path", c.Request.URL.Path,
+				"request_id", requestID, "panic", r, "stack", string(debug.Stack()))
+			if c.Writer.Written() {
+				c.Abort() // Too late to change the status
+				return
+			}
+			body := errorBody(c, i18n.InternalError)
+			body["request_id"] = requestID
+			c.AbortWithStatusJSON(http.StatusInternalServerError, body)
+		}()
+		c.Next()
+	}
+}
@@ -0,0 +1,16 @@
+package middleware
+
+import (
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SchemaVersion sets the X-Schema-Version header on every response
+func SchemaVersion(version int) gin.HandlerFunc {
+	value := strconv.Itoa(version)
+	return func(c *gin.Context) {
+		c.Header("X-Schema-Version", value)
+		c.Next()
+	}
+}
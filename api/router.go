@@ -3,6 +3,8 @@ package api
 import (
 	_ "photot/api/docs"
 	"photot/api/handler"
+	"photot/api/middleware"
+	"photot/helper/database"
 
 	"github.com/gin-gonic/gin"
 	swaggerFiles "github.com/swaggo/files"
@@ -13,16 +15,58 @@ import (
 // @version 1.1
 // @description API for image recognition using ML and perceptual hashing
 // @BasePath /
+// @securityDefinitions.apikey ApiKeyAuth
+// @in header
+// @name X-API-Key
 func Router(hand *handler.Handler) *gin.Engine {
 	r := gin.New()
+	// Validated by SetConfig. Without trusted proxies ClientIP is the peer
+	// address, so a forged X-Forwarded-For cannot dodge the rate limit.
+	r.SetTrustedProxies(hand.TrustedProxies())
+	r.Use(middleware.RequestID(), middleware.Recovery(), middleware.CORS(hand.CORSPolicy), middleware.SchemaVersion(database.SchemaVersion), middleware.BodyLimit(hand.MaxBodyBytes))
 	r.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
-	r.POST("/recognize", hand.RecognizeHandler)
+	r.GET("/health", hand.HealthHandler)
+	r.GET("/version", hand.VersionHandler)
+	limiter := middleware.NewRateLimiter(hand.RateLimit)
+	// Bodies are read before a concurrency slot is taken, so slow uploads
+	// cannot hold slots that only decoding and matching should count against
+	public := r.Group("/", limiter.Middleware(), middleware.ParseMultipart(), hand.Recognitions.Middleware())
+	{
+		public.POST("/recognize", hand.RecognizeHandler)
+		public.POST("/hash", hand.HashHandler)
+		public.POST("/metadata", hand.MetadataHandler)
+		public.POST("/compare", hand.CompareHandler)
+		public.POST("/compare-to/:id", hand.CompareToHandler)
+	}
+	// Batches run BatchWorkers images at once, so they take as many slots
+	batch := r.Group("/", limiter.Middleware(), middleware.ParseMultipart(), hand.Recognitions.Weighted(hand.BatchWorkers))
+	{
+		batch.POST("/recognize/batch", hand.RecognizeBatchHandler)
+		batch.POST("/recognize/batch/stream", hand.RecognizeBatchStreamHandler)
+		batch.POST("/compare/matrix", hand.CompareMatrixHandler)
+	}
 
-	admin := r.Group("/admin")
+	admin := r.Group("/admin", middleware.APIKey(hand.AdminAuth), middleware.ParseMultipart())
 	{
 		admin.POST("/add", hand.AddImageHandler)
 		admin.GET("/hello", hand.Hello)
 		admin.POST("/toggle-ml", hand.ToggleMLHandler)
+		admin.POST("/metric", hand.MetricHandler)
+		admin.POST("/method", hand.MatchMethodHandler)
+		admin.POST("/selftest", hand.SelftestHandler)
+		admin.GET("/config", hand.ConfigHandler)
+		admin.POST("/config/reload", hand.ReloadConfigHandler)
+		admin.GET("/duplicates", middleware.Gzip(), hand.DuplicatesHandler)
+		admin.GET("/image/:id", hand.GetImageHandler)
+		admin.GET("/image/:id/thumbnail", hand.ThumbnailHandler)
+		admin.GET("/list", middleware.Gzip(), hand.ListImagesHandler)
+		admin.PUT("/image/:id", hand.ReplaceImageHandler)
+		admin.DELETE("/image/:id", hand.DeleteImageHandler)
+		admin.POST("/clear", hand.ClearHandler)
+		admin.GET("/features/export", middleware.Gzip(), hand.ExportFeaturesHandler)
+		admin.POST("/features/import", hand.ImportFeaturesHandler)
+		admin.POST("/reindex", hand.ReindexHandler)
+		admin.GET("/jobs/:id", middleware.Gzip(), hand.JobHandler)
 	}
 	return r
 }
@@ -0,0 +1,181 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"photot/helper/database"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Client calls a running photot server
+type Client struct {
+	BaseURL    string // e.g. http://localhost:8080
+	APIKey     string // Sent as X-API-Key, required for Add when the server sets PHOTOT_ADMIN_API_KEY
+	HTTPClient *http.Client
+}
+
+// AddResponse is the body returned by /admin/add
+type AddResponse struct {
+	Message  string `json:"message"`
+	ID       string `json:"id"`
+	Filename string `json:"filename"`
+	Hash     string `json:"hash"`
+}
+
+// APIError is returned when the server answers with a non-2xx status
+type APIError struct {
+	StatusCode int
+	Code       string // Stable error code such as IMAGE_MISSING, empty if the server sent none
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	if e.Code != "" {
+		return fmt.Sprintf("photot: %d %s: %s", e.StatusCode, e.Code, e.Message)
+	}
+	return fmt.Sprintf("photot: %d %s", e.StatusCode, e.Message)
+}
+
+// New creates a client for the server at baseURL; a zero timeout means no timeout
+func New(baseURL, apiKey string, timeout time.Duration) *Client {
+	return &Client{
+		BaseURL:    strings.TrimRight(baseURL, "/"),
+		APIKey:     apiKey,
+		HTTPClient: &http.Client{Timeout: timeout},
+	}
+}
+
+// Recognize uploads an image to /recognize. A threshold of 0 or less leaves the
+// server's default in place.
+func (c *Client) Recognize(ctx context.Context, r io.Reader, threshold float64) (*database.RecognizeResponse, error) {
+	fields := map[string]string{}
+	if threshold > 0 {
+		fields["threshold"] = strconv.FormatFloat(threshold, 'f', -1, 64)
+	}
+
+	var response database.RecognizeResponse
+	if err := c.postImage(ctx, "/recognize", "image", r, fields, &response); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// Add uploads a reference image to /admin/add. filename must carry a supported
+// extension; name, when not empty, replaces the stored base name.
+func (c *Client) Add(ctx context.Context, r io.Reader, filename, name string) (*AddResponse, error) {
+	fields := map[string]string{}
+	if name != "" {
+		fields["name"] = name
+	}
+
+	var response AddResponse
+	if err := c.postImage(ctx, "/admin/add", filename, r, fields, &response); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// Compare uploads two images to /compare and returns their similarity. An
+// empty method leaves the choice between ml, hash and ssim to the server.
+func (c *Client) Compare(ctx context.Context, a, b io.Reader, method string) (*database.CompareResponse, error) {
+	files := []upload{{field: "image1", filename: "image1", r: a}, {field: "image2", filename: "image2", r: b}}
+	var response database.CompareResponse
+	if err := c.post(ctx, "/compare", files, methodField(method), &response); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// CompareTo uploads an image to /compare-to and returns its similarity to the
+// stored image with the stable ID, hash or filename id. An empty method leaves
+// the choice to the server.
+func (c *Client) CompareTo(ctx context.Context, id string, r io.Reader, method string) (*database.CompareResponse, error) {
+	var response database.CompareResponse
+	if err := c.postImage(ctx, "/compare-to/"+url.PathEscape(id), "image", r, methodField(method), &response); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// methodField returns the form fields selecting a comparison method
+func methodField(method string) map[string]string {
+	if method == "" {
+		return nil
+	}
+	return map[string]string{"method": method}
+}
+
+// upload is one file part of a multipart request
+type upload struct {
+	field, filename string
+	r               io.Reader
+}
+
+// postImage sends r as the multipart "image" field alongside fields and decodes
+// the JSON response into out
+func (c *Client) postImage(ctx context.Context, path, filename string, r io.Reader, fields map[string]string, out any) error {
+	return c.post(ctx, path, []upload{{field: "image", filename: filename, r: r}}, fields, out)
+}
+
+// post sends files and fields as a multipart form and decodes the JSON
+// response into out
+func (c *Client) post(ctx context.Context, path string, files []upload, fields map[string]string, out any) error {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	for _, file := range files {
+		part, err := writer.CreateFormFile(file.field, file.filename)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(part, file.r); err != nil {
+			return fmt.Errorf("reading %s: %w", file.field, err)
+		}
+	}
+	for key, value := range fields {
+		if err := writer.WriteField(key, value); err != nil {
+			return err
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.BaseURL+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	if c.APIKey != "" {
+		req.Header.Set("X-API-Key", c.APIKey)
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var failure struct {
+			Code    string `json:"error_code"`
+			Message string `json:"message"`
+		}
+		if json.NewDecoder(resp.Body).Decode(&failure) != nil || failure.Message == "" {
+			failure.Message = http.StatusText(resp.StatusCode)
+		}
+		return &APIError{StatusCode: resp.StatusCode, Code: failure.Code, Message: failure.Message}
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
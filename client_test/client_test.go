@@ -0,0 +1,86 @@
+package client_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"photot/api"
+	"photot/api/handler"
+	"photot/client"
+	"photot/helper/config"
+	"photot/helper/database"
+
+	"github.com/disintegration/imaging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient(t *testing.T) {
+	h := &handler.Handler{DB: database.NewImageDatabase(), ImageDir: t.TempDir()}
+	cfg := config.Default()
+	cfg.AdminAPIKey = "secret"
+	require.NoError(t, h.SetConfig(cfg))
+	server := httptest.NewServer(api.Router(h))
+	defer server.Close()
+
+	ctx := context.Background()
+	c := client.New(server.URL+"/", "secret", 5*time.Second)
+
+	added, err := c.Add(ctx, bytes.NewReader(pngBytes()), "reference.png", "")
+	require.NoError(t, err)
+	assert.Equal(t, "image added successfully", added.Message)
+	assert.Contains(t, added.Filename, "reference.png")
+
+	result, err := c.Recognize(ctx, bytes.NewReader(pngBytes()), 90)
+	require.NoError(t, err)
+	assert.Equal(t, "OK", result.Result)
+	assert.Equal(t, added.Filename, result.MatchedImage)
+	assert.Equal(t, database.SchemaVersion, result.SchemaVersion)
+
+	compared, err := c.Compare(ctx, bytes.NewReader(pngBytes()), bytes.NewReader(pngBytes()), "hash")
+	require.NoError(t, err)
+	assert.Equal(t, "hash", compared.Method)
+	assert.InDelta(t, 100, compared.Similarity, 0.01)
+	assert.InDelta(t, 1, compared.SimilarityNormalized, 1e-4)
+
+	compared, err = c.CompareTo(ctx, added.ID, bytes.NewReader(pngBytes()), "")
+	require.NoError(t, err)
+	assert.Equal(t, added.ID, compared.ID)
+	assert.Equal(t, added.Filename, compared.Filename)
+	assert.InDelta(t, 100, compared.Similarity, 0.5)
+
+	_, err = c.CompareTo(ctx, "missing", bytes.NewReader(pngBytes()), "hash")
+	var apiErr *client.APIError
+	require.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, http.StatusNotFound, apiErr.StatusCode)
+	assert.Equal(t, "IMAGE_NOT_FOUND", apiErr.Code)
+
+	_, err = c.Compare(ctx, bytes.NewReader(pngBytes()), bytes.NewReader(pngBytes()), "sift")
+	require.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, "INVALID_PARAMETER", apiErr.Code)
+
+	unauthorized := client.New(server.URL, "wrong", 5*time.Second)
+	_, err = unauthorized.Add(ctx, bytes.NewReader(pngBytes()), "other.png", "")
+	require.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, http.StatusUnauthorized, apiErr.StatusCode)
+	assert.Equal(t, "API_KEY_INVALID", apiErr.Code)
+}
+
+func pngBytes() []byte {
+	img := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	for y := 0; y < 100; y++ {
+		for x := 0; x < 100; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(x * 2), G: uint8(y * 2), B: 128, A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	imaging.Encode(&buf, img, imaging.PNG)
+	return buf.Bytes()
+}
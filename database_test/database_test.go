@@ -0,0 +1,1344 @@
+package database_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"photot/helper/database"
+	im "photot/helper/image"
+
+	"github.com/disintegration/imaging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func TestFindMatches(t *testing.T) {
+	db := database.NewImageDatabase()
+	_, err := db.AddImage(gradientImage(), "gradient.png")
+	require.NoError(t, err)
+	_, err = db.AddImage(checkerImage(), "checker.png")
+	require.NoError(t, err)
+	_, err = db.AddImage(stripesImage(), "stripes.png")
+	require.NoError(t, err)
+
+	t.Run("ReturnsAtMostN", func(t *testing.T) {
+		candidates, _ := db.FindMatches(gradientImage(), 2, 0, database.MatchOptions{})
+		assert.Len(t, candidates, 2)
+		assert.Equal(t, "gradient.png", candidates[0].Filename)
+		assert.GreaterOrEqual(t, candidates[0].Similarity, candidates[1].Similarity)
+	})
+
+	t.Run("FewerThanNClearTheFloor", func(t *testing.T) {
+		candidates, _ := db.FindMatches(gradientImage(), 3, 99, database.MatchOptions{})
+		assert.Len(t, candidates, 1)
+		assert.Equal(t, "gradient.png", candidates[0].Filename)
+	})
+
+	t.Run("NoneClearTheFloor", func(t *testing.T) {
+		db.SetMatchMethod(database.MethodHash)
+		defer db.SetMatchMethod(database.MethodAuto)
+
+		candidates, method := db.FindMatches(noiseImage(), 3, 100, database.MatchOptions{})
+		assert.Empty(t, candidates)
+		assert.Equal(t, "hash", method)
+	})
+}
+
+func TestFindMatchSeparateThresholds(t *testing.T) {
+	db := database.NewImageDatabase()
+	_, err := db.AddImage(gradientImage(), "gradient.png")
+	require.NoError(t, err)
+
+	// An unreachable ML threshold forces the hash fallback to decide
+	result := db.FindMatch(context.Background(), gradientImage(), database.MatchOptions{
+		MLThreshold:   101,
+		HashThreshold: 90,
+	})
+	assert.True(t, result.IsMatch)
+	assert.Equal(t, "gradient.png", result.MatchedImage)
+	assert.Equal(t, "hash", result.Method)
+	require.NotNil(t, result.MLSimilarity)
+	require.NotNil(t, result.HashSimilarity)
+	assert.Equal(t, result.Similarity, *result.HashSimilarity)
+}
+
+func TestFindMatchCancelledFallsBackToHash(t *testing.T) {
+	db := database.NewImageDatabase()
+	_, err := db.AddImage(gradientImage(), "gradient.png")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	result := db.FindMatch(ctx, gradientImage(), database.MatchOptions{MLThreshold: 85, HashThreshold: 85})
+	assert.True(t, result.IsMatch)
+	assert.Equal(t, "hash", result.Method)
+	assert.Contains(t, result.Degraded, "context canceled")
+}
+
+func TestAddImageDuplicates(t *testing.T) {
+	db := database.NewImageDatabase()
+	_, err := db.AddImage(gradientImage(), "gradient.png")
+	require.NoError(t, err)
+
+	_, err = db.AddImage(gradientImage(), "copy.png")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "exact duplicate: gradient.png")
+
+	// One changed pixel keeps the perceptual hash but not the content hash
+	nearCopy := gradientImage().(*image.RGBA)
+	nearCopy.Set(50, 50, color.RGBA{R: 1, G: 2, B: 3, A: 255})
+	_, err = db.AddImage(nearCopy, "near.png")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "already exists")
+	assert.NotContains(t, err.Error(), "exact duplicate")
+}
+
+func TestFindDuplicates(t *testing.T) {
+	db := database.NewImageDatabase()
+	_, err := db.AddImage(gradientImage(), "original.png")
+	require.NoError(t, err)
+	_, err = db.AddImage(checkerImage(), "checker.png")
+	require.NoError(t, err)
+
+	// A small white corner flips a single hash bit
+	marked := gradientImage().(*image.RGBA)
+	for y := 0; y < 10; y++ {
+		for x := 0; x < 10; x++ {
+			marked.Set(x, y, color.RGBA{R: 255, G: 255, B: 255, A: 255})
+		}
+	}
+	_, err = db.AddImage(marked, "marked.png")
+	require.NoError(t, err)
+
+	clusters := db.FindDuplicates(95)
+	require.Len(t, clusters, 1)
+	assert.Equal(t, "original.png", clusters[0].Representative)
+	assert.ElementsMatch(t, []string{"original.png", "marked.png"}, clusters[0].Filenames)
+
+	assert.Empty(t, db.FindDuplicates(100))
+}
+
+func TestFindMatchMethodMatchesScoreSource(t *testing.T) {
+	db := database.NewImageDatabase()
+	_, err := db.AddImage(gradientImage(), "gradient.png")
+	require.NoError(t, err)
+	_, err = db.AddImage(checkerImage(), "checker.png")
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	t.Run("ML", func(t *testing.T) {
+		result := db.FindMatch(ctx, gradientImage(), database.MatchOptions{MLThreshold: 85, HashThreshold: 85})
+		assert.Equal(t, "ml", result.Method)
+		candidates, _ := db.FindMatches(gradientImage(), 1, 0, database.MatchOptions{})
+		assert.Equal(t, candidates[0].Similarity, result.Similarity)
+	})
+
+	t.Run("Hash", func(t *testing.T) {
+		result := db.FindMatch(ctx, stripesImage(), database.MatchOptions{MLThreshold: 101, HashThreshold: 0})
+		assert.Equal(t, "hash", result.Method)
+		require.NotNil(t, result.HashSimilarity)
+		assert.Equal(t, *result.HashSimilarity, result.Similarity)
+	})
+
+	t.Run("Combined", func(t *testing.T) {
+		result := db.FindMatch(ctx, stripesImage(), database.MatchOptions{Threshold: 0, MLWeight: 3, HashWeight: 1})
+		assert.Equal(t, "combined", result.Method)
+		require.NotNil(t, result.MLSimilarity)
+		require.NotNil(t, result.HashSimilarity)
+		expected := (3**result.MLSimilarity + *result.HashSimilarity) / 4
+		assert.InDelta(t, expected, result.Similarity, 1e-9)
+		assert.True(t, result.IsMatch)
+	})
+
+	t.Run("EmptyDatabase", func(t *testing.T) {
+		empty := database.NewImageDatabase()
+		result := empty.FindMatch(ctx, gradientImage(), database.MatchOptions{})
+		assert.False(t, result.IsMatch)
+		assert.True(t, result.NoData)
+		assert.Equal(t, "none", result.Method)
+	})
+}
+
+func TestFindMatchMatchMethods(t *testing.T) {
+	db := database.NewImageDatabase()
+	_, err := db.AddImage(gradientImage(), "gradient.png")
+	require.NoError(t, err)
+	_, err = db.AddImage(checkerImage(), "checker.png")
+	require.NoError(t, err)
+	ctx := context.Background()
+	opts := database.MatchOptions{Threshold: 101, MLThreshold: 101, HashThreshold: 0}
+
+	db.SetMatchMethod(database.MethodML)
+	result := db.FindMatch(ctx, stripesImage(), opts)
+	assert.Equal(t, "ml", result.Method, "ml alone never falls back to hashing")
+	assert.False(t, result.IsMatch)
+	assert.Nil(t, result.HashSimilarity)
+	assert.Nil(t, result.HashDistance)
+
+	db.SetMatchMethod(database.MethodHash)
+	result = db.FindMatch(ctx, stripesImage(), opts)
+	assert.Equal(t, "hash", result.Method)
+	assert.True(t, result.IsMatch)
+	assert.Nil(t, result.MLSimilarity, "hash alone never extracts features")
+
+	db.SetMatchMethod(database.MethodCombined)
+	result = db.FindMatch(ctx, stripesImage(), database.MatchOptions{Threshold: 0})
+	assert.Equal(t, "combined", result.Method)
+	require.NotNil(t, result.MLSimilarity)
+	require.NotNil(t, result.HashSimilarity)
+	assert.InDelta(t, (*result.MLSimilarity+*result.HashSimilarity)/2, result.Similarity, 1e-9,
+		"without weights both branches count equally")
+
+	db.SetMatchMethod(database.MethodAuto)
+	result = db.FindMatch(ctx, stripesImage(), opts)
+	assert.Equal(t, "hash", result.Method)
+	assert.NotNil(t, result.MLSimilarity)
+
+	_, err = database.ParseMatchMethod("fuzzy")
+	assert.Error(t, err)
+	method, err := database.ParseMatchMethod("Combined")
+	assert.NoError(t, err)
+	assert.Equal(t, database.MethodCombined, method)
+}
+
+func TestFindMatchStrict(t *testing.T) {
+	db := database.NewImageDatabase()
+	_, err := db.AddImage(gradientImage(), "gradient.png")
+	require.NoError(t, err)
+	_, err = db.AddImage(checkerImage(), "checker.png")
+	require.NoError(t, err)
+	db.SetMatchMethod(database.MethodStrict)
+	ctx := context.Background()
+
+	both := db.FindMatch(ctx, stripesImage(), database.MatchOptions{})
+	assert.Equal(t, "strict", both.Method)
+	assert.True(t, both.IsMatch)
+	require.NotNil(t, both.MLSimilarity)
+	require.NotNil(t, both.HashSimilarity)
+	require.NotNil(t, both.HashDistance)
+	ml, hash := *both.MLSimilarity, *both.HashSimilarity
+	require.NotEqual(t, ml, hash, "the test needs branches that disagree")
+	assert.Equal(t, min(ml, hash), both.Similarity)
+
+	// Each branch passing alone is not enough
+	mlOnly := database.MatchOptions{MLThreshold: ml, HashThreshold: hash + 0.1}
+	result := db.FindMatch(ctx, stripesImage(), mlOnly)
+	assert.False(t, result.IsMatch)
+	assert.Equal(t, both.MatchedImage, result.MatchedImage)
+
+	hashOnly := database.MatchOptions{MLThreshold: ml + 0.1, HashThreshold: hash}
+	result = db.FindMatch(ctx, stripesImage(), hashOnly)
+	assert.False(t, result.IsMatch)
+
+	tooFar := *both.HashDistance - 1
+	result = db.FindMatch(ctx, stripesImage(), database.MatchOptions{MLThreshold: ml, MaxDistance: &tooFar})
+	assert.False(t, result.IsMatch)
+
+	// The auto method would have accepted the hash branch alone
+	db.SetMatchMethod(database.MethodAuto)
+	assert.True(t, db.FindMatch(ctx, stripesImage(), hashOnly).IsMatch)
+}
+
+// meanColorExtractor stands in for a model-backed extractor, describing an
+// image by its mean color
+type meanColorExtractor struct {
+	calls *int
+}
+
+func (e meanColorExtractor) ExtractFeatures(ctx context.Context, img image.Image) ([]float64, error) {
+	*e.calls++
+	small := imaging.Resize(img, 1, 1, imaging.Box)
+	return []float64{float64(small.Pix[0]) + 1, float64(small.Pix[1]) + 1, float64(small.Pix[2]) + 1}, nil
+}
+
+func TestCustomExtractor(t *testing.T) {
+	calls := 0
+	db := database.NewImageDatabase()
+	db.SetExtractor(meanColorExtractor{calls: &calls})
+	db.SetMatchMethod(database.MethodCombined)
+	_, err := db.AddImage(gradientImage(), "gradient.png")
+	require.NoError(t, err)
+	assert.Equal(t, 1, calls)
+
+	result := db.FindMatch(context.Background(), gradientImage(), database.MatchOptions{})
+	assert.Equal(t, 2, calls)
+	assert.Equal(t, "combined", result.Method)
+	assert.True(t, result.IsMatch)
+	require.NotNil(t, result.MLSimilarity)
+	assert.InDelta(t, 100, *result.MLSimilarity, 1e-9)
+
+	// A scratch copy keeps the extractor
+	db.NewEmpty().AddImage(checkerImage(), "checker.png")
+	assert.Equal(t, 3, calls)
+
+	db.SetExtractor(nil)
+	db.FindMatch(context.Background(), gradientImage(), database.MatchOptions{})
+	assert.Equal(t, 3, calls)
+}
+
+// constantExtractor describes every image by the same n ones
+type constantExtractor struct {
+	n int
+}
+
+func (e constantExtractor) ExtractFeatures(ctx context.Context, img image.Image) ([]float64, error) {
+	features := make([]float64, e.n)
+	for i := range features {
+		features[i] = 1
+	}
+	return features, nil
+}
+
+func TestFeatureTypesCompareLikeWithLike(t *testing.T) {
+	db := database.NewImageDatabase()
+	db.SetMatchMethod(database.MethodML)
+	db.SetExtractor(im.EdgeExtractor{})
+	info, err := db.AddImageWithTags(stripesImage(), "stripes.png", nil)
+	require.NoError(t, err)
+	assert.Equal(t, im.FeatureTypeEdge, info.FeatureType)
+	ctx := context.Background()
+	assert.True(t, db.FindMatch(ctx, stripesImage(), database.MatchOptions{MLThreshold: 90}).IsMatch)
+
+	// Vectors of the same length from another extractor are never compared
+	db.SetExtractor(constantExtractor{n: im.EdgeGrid * im.EdgeGrid})
+	assert.Equal(t, im.FeatureTypeCustom, db.FeatureType())
+	result := db.FindMatch(ctx, stripesImage(), database.MatchOptions{})
+	assert.False(t, result.IsMatch)
+	assert.Empty(t, result.MatchedImage)
+
+	db.SetExtractor(im.EdgeExtractor{})
+	records := db.ExportFeatures()
+	require.Len(t, records, 1)
+	assert.Equal(t, im.FeatureTypeEdge, records[0].FeatureType)
+	require.NoError(t, db.ImportFeatures(records[0]))
+	records[0].FeatureType = im.FeatureTypeHOG
+	assert.ErrorIs(t, db.ImportFeatures(records[0]), database.ErrFeatureType)
+}
+
+func TestTransparentBackgroundMatchesWhite(t *testing.T) {
+	// The same logo, once on white and once on a transparent background
+	logo := func(bg color.NRGBA) image.Image {
+		img := image.NewNRGBA(image.Rect(0, 0, 100, 100))
+		for y := 0; y < 100; y++ {
+			for x := 0; x < 100; x++ {
+				c := bg
+				if (x-50)*(x-50)+(y-50)*(y-50) < 30*30 || x > 60 && y < 30 {
+					c = color.NRGBA{R: 20, G: 60, B: 160, A: 255}
+				}
+				img.Set(x, y, c)
+			}
+		}
+		return img
+	}
+	white := logo(color.NRGBA{R: 255, G: 255, B: 255, A: 255})
+	transparent := logo(color.NRGBA{})
+
+	db := database.NewImageDatabase()
+	_, err := db.AddImage(white, "logo.png")
+	require.NoError(t, err)
+	for _, method := range []database.MatchMethod{database.MethodHash, database.MethodML} {
+		db.SetMatchMethod(method)
+		result := db.FindMatch(context.Background(), transparent, database.MatchOptions{MLThreshold: 99, HashThreshold: 99})
+		assert.True(t, result.IsMatch, method)
+		assert.InDelta(t, 100, result.Similarity, 0.01, method)
+	}
+
+	// On a black background the transparent logo looks like the black one instead
+	dark := database.NewImageDatabase()
+	dark.SetBackground(color.NRGBA{A: 255})
+	_, err = dark.AddImage(logo(color.NRGBA{A: 255}), "dark.png")
+	require.NoError(t, err)
+	_, err = dark.AddImage(transparent, "transparent.png")
+	assert.Error(t, err, "flattened onto black, the transparent logo duplicates the black one")
+}
+
+func TestFindMatchRotations(t *testing.T) {
+	db := database.NewImageDatabase()
+	_, err := db.AddImage(gradientImage(), "gradient.png")
+	require.NoError(t, err)
+	_, err = db.AddImage(checkerImage(), "checker.png")
+	require.NoError(t, err)
+
+	sideways := imaging.Rotate270(gradientImage())
+	opts := database.MatchOptions{MLThreshold: 99, HashThreshold: 99}
+
+	result := db.FindMatch(context.Background(), sideways, opts)
+	assert.Nil(t, result.Rotation)
+
+	opts.TryRotations = true
+	result = db.FindMatch(context.Background(), sideways, opts)
+	assert.True(t, result.IsMatch)
+	assert.Equal(t, "gradient.png", result.MatchedImage)
+	require.NotNil(t, result.Rotation)
+	assert.Equal(t, 90, *result.Rotation)
+}
+
+func TestFindMatchTags(t *testing.T) {
+	db := database.NewImageDatabase()
+	_, err := db.AddImageWithTags(gradientImage(), "gradient.png", []string{"Shoes", "red"})
+	require.NoError(t, err)
+	_, err = db.AddImageWithTags(checkerImage(), "checker.png", []string{"shoes"})
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	result := db.FindMatch(ctx, gradientImage(), database.MatchOptions{MLThreshold: 90, HashThreshold: 90, Tags: []string{"shoes", "red"}})
+	assert.True(t, result.IsMatch)
+	assert.Equal(t, "gradient.png", result.MatchedImage)
+
+	// Tags are case-insensitive, and a tag nothing carries leaves nothing to compare
+	candidates, _ := db.FindMatches(gradientImage(), 5, 0, database.MatchOptions{Tags: []string{"shoes"}})
+	assert.Len(t, candidates, 2)
+	result = db.FindMatch(ctx, gradientImage(), database.MatchOptions{MLThreshold: 90, HashThreshold: 90, Tags: []string{"hats"}})
+	assert.False(t, result.IsMatch)
+	assert.True(t, result.NoData)
+	assert.Empty(t, result.MatchedImage)
+}
+
+func TestFindMatchFilenamePrefix(t *testing.T) {
+	db := database.NewImageDatabase()
+	_, err := db.AddImage(gradientImage(), "1700000000_SKU-100_front.png")
+	require.NoError(t, err)
+	_, err = db.AddImage(checkerImage(), "SKU-200_front.png")
+	require.NoError(t, err)
+	ctx := context.Background()
+	opts := database.MatchOptions{MLThreshold: 101, HashThreshold: 0}
+
+	// The upload timestamp is ignored, so only the SKU-100 image is compared
+	opts.FilenamePrefix = "SKU-100"
+	result := db.FindMatch(ctx, checkerImage(), opts)
+	assert.Equal(t, "1700000000_SKU-100_front.png", result.MatchedImage)
+	candidates, _ := db.FindMatches(checkerImage(), 5, 0, opts)
+	assert.Len(t, candidates, 1)
+
+	opts.FilenamePrefix = "SKU-2"
+	assert.Equal(t, "SKU-200_front.png", db.FindMatch(ctx, gradientImage(), opts).MatchedImage)
+
+	opts.FilenamePrefix = "SKU-300"
+	result = db.FindMatch(ctx, gradientImage(), opts)
+	assert.True(t, result.NoData)
+	assert.Empty(t, result.MatchedImage)
+}
+
+func TestFindMatchTiled(t *testing.T) {
+	db := database.NewImageDatabase()
+	db.SetMatchMethod(database.MethodHash)
+	_, err := db.AddImage(gradientImage(), "logo.png")
+	require.NoError(t, err)
+
+	// The logo covers a sixth of a noisy scene, so the whole scene hashes
+	// nothing like it
+	scene := imaging.Resize(noiseImage(), 300, 200, imaging.NearestNeighbor)
+	scene = imaging.Paste(scene, gradientImage(), image.Pt(150, 50))
+	opts := database.MatchOptions{HashThreshold: 95}
+	assert.False(t, db.FindMatch(context.Background(), scene, opts).IsMatch)
+
+	opts.TileSize, opts.TileStride = 100, 50
+	result := db.FindMatch(context.Background(), scene, opts)
+	assert.True(t, result.IsMatch)
+	assert.Equal(t, "logo.png", result.MatchedImage)
+	assert.Equal(t, &database.Tile{X: 150, Y: 50, Size: 100}, result.Tile)
+}
+
+func TestGrayscaleQueryMatchesColorReference(t *testing.T) {
+	ctx := context.Background()
+	featureOpts := im.FeatureOptions{ColorHistogram: true}
+	db := database.NewImageDatabase()
+	db.SetFeatureOptions(featureOpts)
+	_, err := db.AddImage(gradientImage(), "color.png")
+	require.NoError(t, err)
+	_, err = db.AddImage(checkerImage(), "checker.png")
+	require.NoError(t, err)
+
+	// Compared in full, the color histograms pull the two vectors apart
+	gray := imaging.Grayscale(gradientImage())
+	colorFeatures, err := im.ExtractImageFeaturesWithOptions(ctx, gradientImage(), featureOpts)
+	require.NoError(t, err)
+	grayFeatures, err := im.ExtractImageFeaturesWithOptions(ctx, gray, featureOpts)
+	require.NoError(t, err)
+	fullSimilarity := im.CosineSimilarity(colorFeatures, grayFeatures)
+	assert.Less(t, fullSimilarity, 95.0)
+
+	result := db.FindMatch(ctx, gray, database.MatchOptions{MLThreshold: 95, HashThreshold: 101})
+	assert.True(t, result.IsMatch)
+	assert.Equal(t, "ml", result.Method)
+	assert.Equal(t, "color.png", result.MatchedImage)
+	assert.Greater(t, result.Similarity, fullSimilarity)
+}
+
+func TestFindMatchRecencyBoost(t *testing.T) {
+	db := database.NewImageDatabase()
+	db.SetMatchMethod(database.MethodHash)
+	_, _, err := db.AddImageWithOptions(gradientImage(), "older.png", database.AddOptions{AddedAt: time.Now().Add(-24 * time.Hour)})
+	require.NoError(t, err)
+
+	// A small white corner flips a single hash bit
+	marked := gradientImage().(*image.RGBA)
+	for y := 0; y < 10; y++ {
+		for x := 0; x < 10; x++ {
+			marked.Set(x, y, color.RGBA{R: 255, G: 255, B: 255, A: 255})
+		}
+	}
+	_, err = db.AddImage(marked, "newer.png")
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	opts := database.MatchOptions{HashThreshold: 90}
+	assert.Equal(t, "older.png", db.FindMatch(ctx, gradientImage(), opts).MatchedImage)
+
+	opts.RecencyBoost = 5
+	opts.RecencyHalfLife = time.Hour
+	result := db.FindMatch(ctx, gradientImage(), opts)
+	assert.True(t, result.IsMatch)
+	assert.Equal(t, "newer.png", result.MatchedImage)
+	assert.Less(t, result.Similarity, 100.0, "the reported similarity is not boosted")
+
+	// The newer image no longer clears the threshold, so it gets no bonus
+	opts.HashThreshold = 99
+	result = db.FindMatch(ctx, gradientImage(), opts)
+	assert.True(t, result.IsMatch)
+	assert.Equal(t, "older.png", result.MatchedImage)
+}
+
+func TestLoadImagesRestoresAddedAt(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+
+	// older.png has no upload stamp, so its modification time is used
+	older := filepath.Join(dir, "older.png")
+	require.NoError(t, imaging.Save(gradientImage(), older))
+	require.NoError(t, os.Chtimes(older, now, now.Add(-24*time.Hour)))
+
+	// The stamp /admin/add gave newer.png wins over a file time reset by a copy
+	marked := gradientImage().(*image.RGBA)
+	for y := 0; y < 10; y++ {
+		for x := 0; x < 10; x++ {
+			marked.Set(x, y, color.RGBA{R: 255, G: 255, B: 255, A: 255})
+		}
+	}
+	newer := filepath.Join(dir, strconv.FormatInt(now.Add(-time.Hour).UnixNano(), 10)+"_newer.png")
+	require.NoError(t, imaging.Save(marked, newer))
+	require.NoError(t, os.Chtimes(newer, now, now.Add(-48*time.Hour)))
+
+	db := database.NewImageDatabase()
+	db.SetMatchMethod(database.MethodHash)
+	require.NoError(t, db.LoadImages(dir))
+	opts := database.MatchOptions{HashThreshold: 90, RecencyBoost: 5, RecencyHalfLife: time.Hour}
+	result := db.FindMatch(context.Background(), gradientImage(), opts)
+	assert.True(t, result.IsMatch)
+	assert.Equal(t, filepath.Base(newer), result.MatchedImage)
+}
+
+func TestFindMatchTies(t *testing.T) {
+	// A white square on either top corner flips the same number of hash bits
+	marked := func(x0 int) image.Image {
+		img := gradientImage().(*image.RGBA)
+		for y := 0; y < 10; y++ {
+			for x := x0; x < x0+10; x++ {
+				img.Set(x, y, color.RGBA{R: 255, G: 255, B: 255, A: 255})
+			}
+		}
+		return img
+	}
+	now := time.Now()
+	for _, tc := range []struct {
+		leftAdded, rightAdded time.Time
+		want                  string
+	}{
+		{now, now.Add(-time.Hour), "right.png"},
+		{now, now, "left.png"},
+	} {
+		db := database.NewImageDatabase()
+		db.SetMatchMethod(database.MethodHash)
+		_, _, err := db.AddImageWithOptions(marked(0), "left.png", database.AddOptions{AddedAt: tc.leftAdded})
+		require.NoError(t, err)
+		_, _, err = db.AddImageWithOptions(marked(90), "right.png", database.AddOptions{AddedAt: tc.rightAdded})
+		require.NoError(t, err)
+
+		// Images are scanned in map order, which changes from call to call
+		for i := 0; i < 20; i++ {
+			result := db.FindMatch(context.Background(), gradientImage(), database.MatchOptions{HashThreshold: 90})
+			require.Equal(t, tc.want, result.MatchedImage)
+			assert.Zero(t, *result.Margin)
+		}
+	}
+}
+
+func TestFindMatchWaveletWeight(t *testing.T) {
+	db := database.NewImageDatabase()
+	db.SetMatchMethod(database.MethodHash)
+	_, err := db.AddImage(gradientImage(), "gradient.png")
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	// An unrelated query scores differently under each hash, and the weight
+	// blends the two linearly
+	dct := db.FindMatch(ctx, stripesImage(), database.MatchOptions{HashThreshold: 90})
+	wavelet := db.FindMatch(ctx, stripesImage(), database.MatchOptions{HashThreshold: 90, WaveletWeight: 1})
+	blended := db.FindMatch(ctx, stripesImage(), database.MatchOptions{HashThreshold: 90, WaveletWeight: 0.25})
+	assert.NotEqual(t, dct.Similarity, wavelet.Similarity)
+	assert.InDelta(t, 0.75*dct.Similarity+0.25*wavelet.Similarity, blended.Similarity, 1e-9)
+	assert.Equal(t, "hash", wavelet.Method)
+
+	var buf bytes.Buffer
+	require.NoError(t, jpeg.Encode(&buf, gradientImage(), &jpeg.Options{Quality: 5}))
+	compressed, err := jpeg.Decode(&buf)
+	require.NoError(t, err)
+	result := db.FindMatch(ctx, compressed, database.MatchOptions{HashThreshold: 90, WaveletWeight: 1})
+	assert.True(t, result.IsMatch)
+}
+
+func TestHashConfigMismatch(t *testing.T) {
+	db := database.NewImageDatabase()
+	db.SetMatchMethod(database.MethodHash)
+	info, err := db.AddImageWithTags(gradientImage(), "gradient.png", nil)
+	require.NoError(t, err)
+	assert.Equal(t, im.DefaultHashConfig.ID(), info.HashConfig)
+
+	// The same 4x4 grid over a 64px image gives hashes of the same length
+	// that mean something else, so they must not be compared
+	db.SetHashConfig(im.HashConfig{Size: 64, Grid: 4})
+	result := db.FindMatch(context.Background(), gradientImage(), database.MatchOptions{HashThreshold: 50})
+	assert.False(t, result.IsMatch)
+	matches, _ := db.FindMatches(gradientImage(), 5, 0, database.MatchOptions{})
+	assert.Empty(t, matches)
+
+	db.SetHashConfig(im.DefaultHashConfig)
+	result = db.FindMatch(context.Background(), gradientImage(), database.MatchOptions{HashThreshold: 50})
+	assert.True(t, result.IsMatch)
+}
+
+func TestStableImageIDs(t *testing.T) {
+	db := database.NewImageDatabase()
+	info, err := db.AddImageWithTags(gradientImage(), "gradient.png", []string{"logo"})
+	require.NoError(t, err)
+	_, err = db.AddImage(checkerImage(), "checker.png")
+	require.NoError(t, err)
+
+	assert.Equal(t, database.ImageID("gradient.png"), info.ID)
+	assert.NotEqual(t, info.ID, database.ImageID("checker.png"))
+
+	found, ok := db.Image(info.ID)
+	require.True(t, ok)
+	assert.Equal(t, info.Hash, found.Hash)
+
+	listed := db.ListImages()
+	require.Len(t, listed, 2)
+	assert.Equal(t, "gradient.png", listed[0].Filename)
+	assert.Nil(t, listed[0].Features)
+	assert.Equal(t, 100, listed[0].Width)
+	assert.Equal(t, 100, listed[0].Height)
+	assert.Equal(t, "png", listed[0].Format)
+
+	deleted, ok := db.DeleteImage(info.ID)
+	require.True(t, ok)
+	assert.Equal(t, "gradient.png", deleted.Filename)
+	_, ok = db.Image(info.ID)
+	assert.False(t, ok)
+	candidates, _ := db.FindMatches(gradientImage(), 5, 0, database.MatchOptions{Tags: []string{"logo"}})
+	assert.Empty(t, candidates)
+
+	// The pixels can be added again once the original is gone
+	_, err = db.AddImage(gradientImage(), "gradient.png")
+	assert.NoError(t, err)
+}
+
+func TestReplaceImage(t *testing.T) {
+	db := database.NewImageDatabase()
+	original, err := db.AddImageWithTags(gradientImage(), "product.png", []string{"shoes"})
+	require.NoError(t, err)
+	_, err = db.AddImage(checkerImage(), "other.png")
+	require.NoError(t, err)
+
+	replaced, err := db.ReplaceImage(original.ID, stripesImage())
+	require.NoError(t, err)
+	assert.Equal(t, original.ID, replaced.ID)
+	assert.Equal(t, original.Filename, replaced.Filename)
+	assert.Equal(t, original.AddedAt, replaced.AddedAt)
+	assert.Equal(t, []string{"shoes"}, replaced.Tags)
+	assert.NotEqual(t, original.Hash, replaced.Hash)
+
+	// The tag index follows the new hash
+	candidates, _ := db.FindMatches(stripesImage(), 5, 99, database.MatchOptions{Tags: []string{"shoes"}})
+	require.Len(t, candidates, 1)
+	assert.Equal(t, "product.png", candidates[0].Filename)
+
+	// The old pixels are free again, but another entry's pixels are not
+	_, err = db.ReplaceImage(original.ID, checkerImage())
+	assert.Error(t, err)
+	_, err = db.ReplaceImage(original.ID, stripesImage())
+	assert.NoError(t, err, "replacing an image with itself is allowed")
+	_, err = db.AddImage(gradientImage(), "again.png")
+	assert.NoError(t, err)
+
+	_, err = db.ReplaceImage("missing", noiseImage())
+	assert.ErrorIs(t, err, database.ErrImageNotFound)
+
+	// A replacement whose file could not be written is rolled back, but only
+	// while it is still the current record
+	before, ok := db.Image(original.ID)
+	require.True(t, ok)
+	replaced, err = db.ReplaceImage(original.ID, noiseImage())
+	require.NoError(t, err)
+	assert.True(t, db.RestoreImage(replaced, before))
+	restored, ok := db.Image(original.ID)
+	require.True(t, ok)
+	assert.Equal(t, before.Hash, restored.Hash)
+	assert.Equal(t, []string{"shoes"}, restored.Tags)
+	_, err = db.AddImage(noiseImage(), "noise.png")
+	assert.NoError(t, err, "the rolled back pixels are free again")
+	assert.False(t, db.RestoreImage(replaced, before))
+}
+
+func TestListImagesPage(t *testing.T) {
+	db := database.NewImageDatabase()
+	for name, img := range map[string]image.Image{"b.png": gradientImage(), "c.png": checkerImage(), "a.png": stripesImage()} {
+		_, err := db.AddImage(img, name)
+		require.NoError(t, err)
+	}
+
+	page := db.ListImagesPage(database.ListOptions{Sort: database.SortFilename, Page: 1, PageSize: 2})
+	assert.Equal(t, 3, page.Total)
+	assert.Equal(t, 2, page.TotalPages)
+	require.Len(t, page.Images, 2)
+	assert.Equal(t, "a.png", page.Images[0].Filename)
+	assert.Equal(t, "b.png", page.Images[1].Filename)
+
+	page = db.ListImagesPage(database.ListOptions{Sort: database.SortFilename, Descending: true, Page: 2, PageSize: 2})
+	require.Len(t, page.Images, 1)
+	assert.Equal(t, "a.png", page.Images[0].Filename)
+
+	page = db.ListImagesPage(database.ListOptions{Page: 3, PageSize: 2})
+	assert.Empty(t, page.Images)
+	assert.Equal(t, 3, page.Total)
+
+	// A page number whose offset would overflow is just past the end
+	page = db.ListImagesPage(database.ListOptions{Page: math.MaxInt, PageSize: 2})
+	assert.Empty(t, page.Images)
+	assert.Equal(t, math.MaxInt, page.Page)
+}
+
+func TestQuantizedFeatures(t *testing.T) {
+	db := database.NewImageDatabase()
+	db.SetQuantization(im.QuantizeInt8)
+	info, err := db.AddImageWithTags(gradientImage(), "gradient.png", nil)
+	require.NoError(t, err)
+	assert.Nil(t, info.Features)
+	require.NotNil(t, info.Quantized)
+	assert.Len(t, info.Quantized.Int8, len(info.FeatureVector()))
+
+	result := db.FindMatch(context.Background(), gradientImage(), database.MatchOptions{MLThreshold: 99, HashThreshold: 99})
+	assert.True(t, result.IsMatch)
+	assert.Equal(t, "ml", result.Method)
+	assert.InDelta(t, 100, result.Similarity, 0.5)
+}
+
+func TestCheckAdd(t *testing.T) {
+	db := database.NewImageDatabase()
+	_, err := db.AddImage(noiseImage(), "noise.png")
+	require.NoError(t, err)
+	_, err = db.AddImage(gradientImage(), "gradient.png")
+	require.NoError(t, err)
+
+	check := db.CheckAdd(noiseImage(), 95)
+	assert.Equal(t, database.RejectExactDuplicate, check.Reason)
+	assert.Equal(t, &database.MatchCandidate{Filename: "noise.png", Similarity: 100}, check.Duplicate)
+
+	check = db.CheckAdd(imaging.AdjustBrightness(gradientImage(), 5), 95)
+	assert.False(t, check.Accepted)
+	assert.Equal(t, database.RejectHashDuplicate, check.Reason)
+	assert.Equal(t, "gradient.png", check.Duplicate.Filename)
+
+	// A small patch changes the hash, so it would be accepted as a near-duplicate
+	patched := imaging.Paste(noiseImage(), imaging.New(15, 15, color.White), image.Pt(40, 40))
+	check = db.CheckAdd(patched, 85)
+	assert.True(t, check.Accepted)
+	require.NotNil(t, check.Duplicate)
+	assert.Equal(t, "noise.png", check.Duplicate.Filename)
+	assert.Less(t, check.Duplicate.Similarity, 100.0)
+
+	assert.Nil(t, db.CheckAdd(patched, 99).Duplicate, "below the threshold nothing is reported")
+	assert.Len(t, db.Hashes, 2, "CheckAdd must not store anything")
+}
+
+func TestLoadImagesSkipsBadFiles(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "corrupt.jpg"), []byte("\xff\xd8\xff\xe0 truncated"), 0644))
+
+	db := database.NewImageDatabase()
+	assert.Error(t, db.LoadImages(dir), "a directory where every file fails should report it")
+
+	require.NoError(t, imaging.Save(imaging.Resize(gradientImage(), 120, 80, imaging.Box), filepath.Join(dir, "gradient.jpeg")))
+	db = database.NewImageDatabase()
+	require.NoError(t, db.LoadImages(dir))
+	require.Len(t, db.Hashes, 1)
+	loaded := db.ListImages()[0]
+	assert.Equal(t, []any{120, 80, "jpeg"}, []any{loaded.Width, loaded.Height, loaded.Format})
+}
+
+func TestLoadImagesProgress(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, imaging.Save(gradientImage(), filepath.Join(dir, "gradient.png")))
+	require.NoError(t, imaging.Save(checkerImage(), filepath.Join(dir, "checker.png")))
+	require.NoError(t, imaging.Save(stripesImage(), filepath.Join(dir, "stripes.png")))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "corrupt.jpg"), []byte("\xff\xd8\xff\xe0 truncated"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("not an image"), 0644))
+
+	var reports []database.LoadProgress
+	db := database.NewImageDatabase()
+	require.NoError(t, db.LoadImagesWithOptions(dir, database.LoadOptions{
+		Workers:  2,
+		Progress: func(p database.LoadProgress) { reports = append(reports, p) },
+	}))
+
+	require.Len(t, reports, 4)
+	for i, p := range reports {
+		assert.Equal(t, i+1, p.Loaded+p.Failed, "each report should count one more file")
+	}
+	assert.Equal(t, database.LoadProgress{Loaded: 3, Failed: 1, Total: 4}, reports[3])
+	assert.Len(t, db.Hashes, 3)
+}
+
+func TestContentStorage(t *testing.T) {
+	dir := t.TempDir()
+	var encoded bytes.Buffer
+	require.NoError(t, imaging.Encode(&encoded, gradientImage(), imaging.PNG))
+	sum := sha256.Sum256(encoded.Bytes())
+	blob := filepath.Join(dir, database.ContentPath(hex.EncodeToString(sum[:]), ".png"))
+
+	db := database.NewImageDatabase()
+	for _, name := range []string{"a.png", "b.png"} {
+		pending := filepath.Join(dir, ".pending.tmp")
+		require.NoError(t, os.WriteFile(pending, encoded.Bytes(), 0644))
+		require.NoError(t, db.StoreContent(dir, pending, name, false))
+		assert.NoFileExists(t, pending)
+	}
+	assert.FileExists(t, blob, "identical bytes are stored once")
+	assert.Equal(t, db.FilePath("a.png"), db.FilePath("b.png"))
+	require.NoError(t, imaging.Save(checkerImage(), filepath.Join(dir, "flat.png")))
+
+	// Both layouts load, and the filenames sharing a blob become aliases
+	loaded := database.NewImageDatabase()
+	require.NoError(t, loaded.LoadImages(dir))
+	require.Len(t, loaded.Hashes, 2)
+	var files []string
+	for _, info := range loaded.ListImages() {
+		files = append(files, info.Files()...)
+	}
+	assert.ElementsMatch(t, []string{"a.png", "b.png", "flat.png"}, files)
+
+	require.NoError(t, loaded.RemoveFile(dir, "a.png"))
+	assert.FileExists(t, blob, "b.png still uses the blob")
+	require.NoError(t, loaded.RemoveFile(dir, "b.png"))
+	assert.NoFileExists(t, blob)
+	assert.NoFileExists(t, blob+".names")
+	require.NoError(t, loaded.RemoveFile(dir, "flat.png"))
+	assert.True(t, os.IsNotExist(loaded.RemoveFile(dir, "flat.png")))
+
+	_, err := database.ParseStorageLayout("tree")
+	assert.Error(t, err)
+}
+
+func TestRegistry(t *testing.T) {
+	template := database.NewImageDatabase()
+	template.SetMatchMethod(database.MethodHash)
+	baseDir := t.TempDir()
+	registry := database.NewRegistry(baseDir, 2, template)
+
+	_, _, err := registry.Get("acme")
+	assert.ErrorIs(t, err, database.ErrTenantNotFound, "Get does not create tenants")
+	acme, acmeDir, err := registry.Create("acme")
+	require.NoError(t, err)
+	assert.DirExists(t, acmeDir)
+	assert.Equal(t, database.MethodHash, acme.MatchMethod(), "settings are copied from the template")
+	again, _, err := registry.Get("acme")
+	require.NoError(t, err)
+	assert.Same(t, acme, again)
+
+	globex, _, err := registry.Create("globex")
+	require.NoError(t, err)
+	_, err = acme.AddImage(gradientImage(), "gradient.png")
+	require.NoError(t, err)
+	assert.Empty(t, globex.Hashes)
+
+	_, _, err = registry.Create("initech")
+	assert.ErrorIs(t, err, database.ErrTenantLimit)
+	_, _, err = registry.Get("../escape")
+	assert.ErrorIs(t, err, database.ErrInvalidTenant)
+
+	// Tenants created before a restart are found by their directory
+	restarted := database.NewRegistry(baseDir, 2, template)
+	_, _, err = restarted.Get("globex")
+	assert.NoError(t, err)
+	_, _, err = restarted.Get("initech")
+	assert.ErrorIs(t, err, database.ErrTenantNotFound)
+}
+
+// Test images with clearly different structure
+func gradientImage() image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	for y := 0; y < 100; y++ {
+		for x := 0; x < 100; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(x * 2), G: uint8(y * 2), B: 128, A: 255})
+		}
+	}
+	return img
+}
+
+func checkerImage() image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	for y := 0; y < 100; y++ {
+		for x := 0; x < 100; x++ {
+			c := color.RGBA{A: 255}
+			if (x/25+y/25)%2 == 0 {
+				c = color.RGBA{R: 255, G: 255, B: 255, A: 255}
+			}
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+func stripesImage() image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	for y := 0; y < 100; y++ {
+		for x := 0; x < 100; x++ {
+			c := color.RGBA{R: 20, G: 40, B: 60, A: 255}
+			if (y/10)%2 == 0 {
+				c = color.RGBA{R: 240, G: 200, B: 10, A: 255}
+			}
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+func noiseImage() image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	seed := uint32(7)
+	for y := 0; y < 100; y++ {
+		for x := 0; x < 100; x++ {
+			seed = seed*1664525 + 1013904223
+			v := uint8(seed >> 24)
+			img.Set(x, y, color.RGBA{R: v, G: v, B: v, A: 255})
+		}
+	}
+	return img
+}
+
+func TestScansRunAlongsideWrites(t *testing.T) {
+	db := database.NewImageDatabase()
+	_, err := db.AddImage(gradientImage(), "gradient.png")
+	require.NoError(t, err)
+	_, err = db.AddImage(checkerImage(), "checker.png")
+	require.NoError(t, err)
+
+	// Scans work on snapshots while images come and go
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 20; i++ {
+			db.FindMatches(stripesImage(), 5, 0, database.MatchOptions{})
+			db.FindDuplicates(90)
+			db.ExportFeatures()
+		}
+	}()
+	rng := rand.New(rand.NewSource(5))
+	for i := 0; i < 20; i++ {
+		img := image.NewGray(image.Rect(0, 0, 32, 32))
+		rng.Read(img.Pix)
+		info, err := db.AddImageWithTags(img, "noise"+strconv.Itoa(i)+".png", nil)
+		require.NoError(t, err)
+		if i%2 == 0 {
+			db.DeleteImage(info.ID)
+		}
+	}
+	<-done
+
+	candidates, _ := db.FindMatches(gradientImage(), 20, 0, database.MatchOptions{})
+	assert.Len(t, candidates, 12, "once writes stop, scans see every image")
+}
+
+func TestResultCache(t *testing.T) {
+	c := database.NewResultCache(time.Minute, 2)
+	c.Add("a", 1)
+	c.Add("b", 2)
+	_, ok := c.Get("a")
+	assert.True(t, ok)
+
+	// b is now the least recently used, so it makes room for c
+	c.Add("c", 3)
+	_, ok = c.Get("b")
+	assert.False(t, ok)
+	value, ok := c.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, 1, value)
+
+	// Add keeps the first value
+	c.Add("a", 10)
+	value, _ = c.Get("a")
+	assert.Equal(t, 1, value)
+	assert.Equal(t, database.CacheStats{Entries: 2, MaxEntries: 2, Hits: 3, Misses: 1}, c.Stats())
+
+	c.SetMaxEntries(1)
+	assert.Equal(t, 1, c.Stats().Entries)
+	_, ok = c.Get("a")
+	assert.True(t, ok)
+
+	expiring := database.NewResultCache(time.Millisecond, 10)
+	expiring.Add("a", 1)
+	time.Sleep(5 * time.Millisecond)
+	_, ok = expiring.Get("a")
+	assert.False(t, ok)
+	assert.Zero(t, expiring.Stats().Entries)
+
+	disabled := database.NewResultCache(time.Minute, 0)
+	disabled.Add("a", 1)
+	_, ok = disabled.Get("a")
+	assert.False(t, ok)
+}
+
+func TestNormalizeSimilarity(t *testing.T) {
+	assert.Equal(t, 0.855, database.NormalizeSimilarity(85.5))
+	assert.Equal(t, 1.0, database.NormalizeSimilarity(100.4))
+	assert.Equal(t, 0.0, database.NormalizeSimilarity(-3))
+}
+
+func TestConfidence(t *testing.T) {
+	bounds := database.DefaultConfidenceBounds
+	assert.Equal(t, database.ConfidenceHigh, database.Confidence(95, true, bounds))
+	assert.Equal(t, database.ConfidenceMedium, database.Confidence(94.9, true, bounds))
+	assert.Equal(t, database.ConfidenceLow, database.Confidence(80, true, bounds))
+	assert.Equal(t, database.ConfidenceNone, database.Confidence(99, false, bounds))
+}
+
+func TestMarshalProto(t *testing.T) {
+	rotation, blocks, margin := 90, 0.75, 12.5
+	response := database.RecognizeResponse{
+		SchemaVersion: database.SchemaVersion,
+		Result:        "OK",
+		Similarity:    97.5,
+		MatchedImage:  "logo.png",
+		Method:        "hash",
+		Candidates:    []database.MatchCandidate{{Filename: "logo.png", Similarity: 97.5}},
+		Rotation:      &rotation,
+		BlockFraction: &blocks,
+		Margin:        &margin,
+		LowEntropy:    true,
+		Features:      []float64{0.5, -1},
+		FeatureType:   "hog",
+	}
+
+	fields := map[protowire.Number][]byte{}
+	b := response.MarshalProto()
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		require.GreaterOrEqual(t, n, 0)
+		b = b[n:]
+		n = protowire.ConsumeFieldValue(num, typ, b)
+		require.GreaterOrEqual(t, n, 0)
+		fields[num] = b[:n]
+		b = b[n:]
+	}
+
+	version, _ := protowire.ConsumeVarint(fields[1])
+	assert.Equal(t, uint64(database.SchemaVersion), version)
+	result, _ := protowire.ConsumeString(fields[2])
+	assert.Equal(t, "OK", result)
+	similarity, _ := protowire.ConsumeFixed64(fields[3])
+	assert.Equal(t, 97.5, math.Float64frombits(similarity))
+	degrees, _ := protowire.ConsumeVarint(fields[13])
+	assert.Equal(t, uint64(90), degrees)
+	fraction, _ := protowire.ConsumeFixed64(fields[17])
+	assert.Equal(t, 0.75, math.Float64frombits(fraction))
+	lead, _ := protowire.ConsumeFixed64(fields[18])
+	assert.Equal(t, 12.5, math.Float64frombits(lead))
+	lowEntropy, _ := protowire.ConsumeVarint(fields[19])
+	assert.Equal(t, uint64(1), lowEntropy)
+	packed, _ := protowire.ConsumeBytes(fields[20])
+	require.Len(t, packed, 16)
+	first, _ := protowire.ConsumeFixed64(packed)
+	assert.Equal(t, 0.5, math.Float64frombits(first))
+	featureType, _ := protowire.ConsumeString(fields[21])
+	assert.Equal(t, "hog", featureType)
+	assert.Contains(t, fields, protowire.Number(9))
+
+	// Zero values are omitted, as proto3 does
+	assert.NotContains(t, fields, protowire.Number(10))
+	assert.NotContains(t, fields, protowire.Number(14))
+}
+
+func TestExportImportFeatures(t *testing.T) {
+	db := database.NewImageDatabase()
+	_, err := db.AddImage(gradientImage(), "gradient.png")
+	require.NoError(t, err)
+	_, err = db.AddImage(stripesImage(), "stripes.png")
+	require.NoError(t, err)
+
+	records := db.ExportFeatures()
+	require.Len(t, records, 2)
+	assert.Equal(t, "gradient.png", records[0].Filename)
+	assert.Equal(t, database.ImageID("gradient.png"), records[0].ID)
+	assert.NotEmpty(t, records[0].Features)
+
+	require.NoError(t, db.SetFeatures("gradient.png", records[1].Features))
+	assert.Equal(t, records[1].Features, db.ExportFeatures()[0].Features)
+
+	assert.ErrorIs(t, db.SetFeatures("missing.png", records[1].Features), database.ErrImageNotFound)
+	assert.ErrorIs(t, db.SetFeatures("stripes.png", []float64{1, 2, 3}), database.ErrFeatureLength)
+}
+
+func TestLSHIndexRecall(t *testing.T) {
+	const n, dim, queries = 2000, 144, 200
+	rng := rand.New(rand.NewSource(3))
+	vectors := make([][]float64, n)
+	idx := database.NewLSHIndex(8)
+	for i := range vectors {
+		vectors[i] = make([]float64, dim)
+		for j := range vectors[i] {
+			vectors[i][j] = rng.Float64()
+		}
+		idx.Add(strconv.Itoa(i), vectors[i])
+	}
+	require.Equal(t, n, idx.Len())
+
+	// Each query is a noisy copy of a stored vector, which stays its exact
+	// nearest neighbour; recall is how often the index offers it
+	found, scanned := 0, 0
+	for q := 0; q < queries; q++ {
+		target := rng.Intn(n)
+		query := make([]float64, dim)
+		for j, v := range vectors[target] {
+			query[j] = v + rng.NormFloat64()*0.05
+		}
+		candidates := idx.Candidates(query)
+		if _, ok := candidates[strconv.Itoa(target)]; ok {
+			found++
+		}
+		scanned += len(candidates)
+	}
+	assert.GreaterOrEqual(t, float64(found)/queries, 0.95)
+	assert.Less(t, float64(scanned)/queries, n*0.05, "the index should prune most vectors")
+
+	idx.Remove("0")
+	assert.NotContains(t, idx.Candidates(vectors[0]), "0")
+}
+
+func TestFindMatchWithLSH(t *testing.T) {
+	db := database.NewImageDatabase()
+	db.SetLSHTables(8)
+	for name, img := range map[string]image.Image{"gradient.png": gradientImage(), "stripes.png": stripesImage(), "checker.png": checkerImage()} {
+		_, err := db.AddImage(img, name)
+		require.NoError(t, err)
+	}
+
+	opts := database.MatchOptions{Threshold: 90, MLThreshold: 90, HashThreshold: 90}
+	result := db.FindMatch(context.Background(), gradientImage(), opts)
+	assert.True(t, result.IsMatch)
+	assert.Equal(t, "ml", result.Method)
+	assert.Equal(t, "gradient.png", result.MatchedImage)
+
+	_, ok := db.DeleteImage(database.ImageID("gradient.png"))
+	require.True(t, ok)
+	result = db.FindMatch(context.Background(), gradientImage(), opts)
+	assert.NotEqual(t, "gradient.png", result.MatchedImage)
+}
+
+func TestFindMatchRobust(t *testing.T) {
+	db := database.NewImageDatabase()
+	_, err := db.AddImage(noiseImage(), "noise.png")
+	require.NoError(t, err)
+	_, err = db.AddImage(checkerImage(), "checker.png")
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	// A hand over the top-left quarter covers 4 of the 16 blocks
+	covered := imaging.Clone(noiseImage())
+	for y := 0; y < 50; y++ {
+		for x := 0; x < 50; x++ {
+			covered.Set(x, y, color.RGBA{R: 224, G: 172, B: 105, A: 255})
+		}
+	}
+
+	opts := database.MatchOptions{HashThreshold: 90, MinBlockFraction: 0.6}
+	result := db.FindMatch(ctx, covered, opts)
+	assert.True(t, result.IsMatch)
+	assert.Equal(t, "noise.png", result.MatchedImage)
+	assert.Equal(t, "hash", result.Method)
+	require.NotNil(t, result.BlockFraction)
+	assert.Equal(t, 0.75, *result.BlockFraction)
+	assert.Equal(t, 100.0, result.Similarity)
+
+	opts.MinBlockFraction = 0.9
+	assert.False(t, db.FindMatch(ctx, covered, opts).IsMatch)
+
+	// Without the mode the whole image is compared and no blocks are reported
+	result = db.FindMatch(ctx, covered, database.MatchOptions{HashThreshold: 90})
+	assert.Nil(t, result.BlockFraction)
+}
+
+func TestFindMatchMaxDistance(t *testing.T) {
+	db := database.NewImageDatabase()
+	db.SetMatchMethod(database.MethodHash)
+	_, err := db.AddImage(gradientImage(), "gradient.png")
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	query := checkerImage()
+	result := db.FindMatch(ctx, query, database.MatchOptions{HashThreshold: 100})
+	require.NotNil(t, result.HashDistance)
+	distance := *result.HashDistance
+	require.Positive(t, distance)
+	assert.False(t, result.IsMatch)
+
+	// The cutoff is inclusive and replaces HashThreshold
+	for maxDistance, want := range map[int]bool{distance - 1: false, distance: true} {
+		result = db.FindMatch(ctx, query, database.MatchOptions{HashThreshold: 100, MaxDistance: &maxDistance})
+		assert.Equal(t, want, result.IsMatch, maxDistance)
+	}
+}
+
+func TestAddImageOrAlias(t *testing.T) {
+	db := database.NewImageDatabase()
+	first, err := db.AddImageWithTags(gradientImage(), "2_gradient.png", nil)
+	require.NoError(t, err)
+
+	// The default still rejects the collision
+	_, err = db.AddImageWithTags(gradientImage(), "3_gradient.png", nil)
+	assert.Error(t, err)
+
+	info, aliased, err := db.AddImageOrAlias(gradientImage(), "3_gradient.png", nil)
+	require.NoError(t, err)
+	assert.True(t, aliased)
+	assert.Equal(t, first.ID, info.ID)
+	assert.Equal(t, []string{"3_gradient.png"}, info.Aliases)
+	assert.Len(t, db.Hashes, 1)
+
+	// The stored image stays the record even when the new name sorts first
+	info, aliased, err = db.AddImageOrAlias(gradientImage(), "1_gradient.png", nil)
+	require.NoError(t, err)
+	assert.True(t, aliased)
+	assert.Equal(t, first.ID, info.ID)
+	assert.Equal(t, "2_gradient.png", info.Filename)
+	assert.Equal(t, []string{"1_gradient.png", "3_gradient.png"}, info.Aliases)
+	assert.Equal(t, []string{"2_gradient.png", "1_gradient.png", "3_gradient.png"}, info.Files())
+
+	// Records handed out earlier are not changed by later aliases
+	info, _, err = db.AddImageOrAlias(gradientImage(), "4_gradient.png", nil)
+	require.NoError(t, err)
+	_, _, err = db.AddImageOrAlias(gradientImage(), "0_gradient.png", nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"1_gradient.png", "3_gradient.png", "4_gradient.png"}, info.Aliases)
+
+	assert.True(t, db.RemoveAlias(info.ID, "3_gradient.png"))
+	assert.False(t, db.RemoveAlias(info.ID, "3_gradient.png"))
+	stored, ok := db.Lookup(info.ID)
+	require.True(t, ok)
+	assert.Equal(t, []string{"0_gradient.png", "1_gradient.png", "4_gradient.png"}, stored.Aliases)
+
+	// A new hash is added as usual
+	info, aliased, err = db.AddImageOrAlias(checkerImage(), "checker.png", nil)
+	require.NoError(t, err)
+	assert.False(t, aliased)
+	assert.Len(t, db.Hashes, 2)
+
+	// Files sharing a hash are merged the same way when loaded
+	dir := t.TempDir()
+	for _, name := range []string{"b.png", "a.png", "c.png"} {
+		require.NoError(t, imaging.Save(gradientImage(), filepath.Join(dir, name)))
+	}
+	loaded := database.NewImageDatabase()
+	require.NoError(t, loaded.LoadImages(dir))
+	require.Len(t, loaded.Hashes, 1)
+	for _, info := range loaded.Hashes {
+		assert.Equal(t, "a.png", info.Filename)
+		assert.Equal(t, []string{"b.png", "c.png"}, info.Aliases)
+	}
+}
+
+func TestFindMatchMinMargin(t *testing.T) {
+	db := database.NewImageDatabase()
+	db.SetMatchMethod(database.MethodHash)
+	_, err := db.AddImage(noiseImage(), "noise.png")
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	// Alone, an image leads by its whole similarity
+	opts := database.MatchOptions{HashThreshold: 80, MinMargin: 50}
+	result := db.FindMatch(ctx, noiseImage(), opts)
+	assert.True(t, result.IsMatch)
+	require.NotNil(t, result.Margin)
+	assert.Equal(t, 100.0, *result.Margin)
+
+	// A lightly edited copy leaves the original only a narrow lead
+	patched := imaging.Clone(noiseImage())
+	for y := 0; y < 25; y++ {
+		for x := 0; x < 25; x++ {
+			patched.Set(x, y, color.RGBA{R: 128, G: 128, B: 128, A: 255})
+		}
+	}
+	_, err = db.AddImage(patched, "noise_patched.png")
+	require.NoError(t, err)
+	result = db.FindMatch(ctx, noiseImage(), opts)
+	assert.False(t, result.IsMatch)
+	assert.Equal(t, "noise.png", result.MatchedImage)
+	require.NotNil(t, result.Margin)
+	assert.Greater(t, *result.Margin, 0.0)
+	assert.Less(t, *result.Margin, 50.0)
+
+	opts.MinMargin = 0
+	assert.True(t, db.FindMatch(ctx, noiseImage(), opts).IsMatch)
+}
+
+func TestSkipFeatures(t *testing.T) {
+	dir := t.TempDir()
+	db := database.NewImageDatabase()
+	db.SetMatchMethod(database.MethodML)
+	_, err := db.AddImage(checkerImage(), "checker.png")
+	require.NoError(t, err)
+	info, _, err := db.AddImageWithOptions(gradientImage(), "gradient.png", database.AddOptions{SkipFeatures: true})
+	require.NoError(t, err)
+	assert.True(t, info.FeaturesPending)
+	assert.Empty(t, info.Features)
+	assert.Equal(t, 1, db.PendingFeatures())
+	require.NoError(t, imaging.Save(gradientImage(), filepath.Join(dir, "gradient.png")))
+
+	// The ML branch cannot see a pending image, so hashing finds it
+	ctx := context.Background()
+	opts := database.MatchOptions{Threshold: 90, MLThreshold: 90, HashThreshold: 90}
+	result := db.FindMatch(ctx, gradientImage(), opts)
+	assert.True(t, result.IsMatch)
+	assert.Equal(t, "gradient.png", result.MatchedImage)
+	assert.Equal(t, "hash", result.Method)
+
+	reindexed, err := db.ExtractPending(ctx, dir, nil)
+	require.NoError(t, err)
+	assert.Equal(t, database.ReindexResult{Extracted: 1}, reindexed)
+	stored, _ := db.Image(info.ID)
+	assert.False(t, stored.FeaturesPending)
+	assert.NotEmpty(t, stored.Features)
+
+	result = db.FindMatch(ctx, gradientImage(), opts)
+	assert.True(t, result.IsMatch)
+	assert.Equal(t, "ml", result.Method)
+
+	// Pending files that cannot be read are reported and stay pending
+	_, _, err = db.AddImageWithOptions(stripesImage(), "missing.png", database.AddOptions{SkipFeatures: true})
+	require.NoError(t, err)
+	reindexed, err = db.ExtractPending(ctx, dir, nil)
+	require.NoError(t, err)
+	assert.Equal(t, database.ReindexResult{Failed: []string{"missing.png"}, Pending: 1}, reindexed)
+}
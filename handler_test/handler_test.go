@@ -1,39 +1,69 @@
 package handler_test
 
 import (
+	"bufio"
 	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"encoding/json"
 	"image"
 	"image/color"
+	"image/jpeg"
+	"io"
+	"log/slog"
 	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 
+	"photot/api"
 	"photot/api/handler"
+	"photot/api/middleware"
+	"photot/helper/config"
 	"photot/helper/database"
+	im "photot/helper/image"
+	"photot/helper/jobs"
+	"photot/helper/logging"
+	"photot/helper/version"
+	"photot/helper/webhook"
 
 	"github.com/disintegration/imaging"
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestHandler(t *testing.T) {
 	// Test uchun vaqtni sozlash
 	now := time.Now().UnixNano()
-	testDir := "./test_images_" + strconv.FormatInt(now, 10) // 10 - decimal base
-	_ = os.Mkdir(testDir, 0755)
-	defer os.RemoveAll(testDir)
+	// Removed by the testing package even when a subtest fails or panics
+	testDir := t.TempDir()
+
+	// Admin routes are left open in tests; TestAdminAPIKey covers the check
+	testConfig := func() *config.Config {
+		cfg := config.Default()
+		cfg.AdminAuthDisabled = true
+		return cfg
+	}
 
 	// Har bir test uchun yangi handler yaratish
 	newHandler := func() *handler.Handler {
 		db := database.NewImageDatabase()
-		return &handler.Handler{
+		h := &handler.Handler{
 			DB:       db,
 			ImageDir: testDir,
 		}
+		if err := h.SetConfig(testConfig()); err != nil {
+			t.Fatal(err)
+		}
+		return h
 	}
 
 	t.Run("TestAddImageHandler", func(t *testing.T) {
@@ -64,6 +94,88 @@ func TestHandler(t *testing.T) {
 		assert.Contains(t, resp.Body.String(), "image added successfully")
 	})
 
+	t.Run("TestLowEntropy", func(t *testing.T) {
+		h := newHandler()
+		addImage(h, "textured.png", t)
+		router := api.Router(h)
+		solid := imageUpload("blank.png", imaging.New(100, 100, color.NRGBA{R: 255, G: 255, B: 255, A: 255}))
+
+		resp := postImage(t, router, "/admin/add", nil, solid)
+		assert.Equal(t, http.StatusBadRequest, resp.Code)
+		assert.Contains(t, resp.Body.String(), "LOW_ENTROPY")
+		assert.Len(t, h.DB.ListImages(), 1)
+
+		resp = postImage(t, router, "/recognize", nil, solid)
+		require.Equal(t, http.StatusOK, resp.Code)
+		assert.Contains(t, resp.Body.String(), `"low_entropy":true`)
+		resp = postImage(t, router, "/recognize", nil, imageUpload("blank.png", createTestImage()))
+		require.Equal(t, http.StatusOK, resp.Code)
+		assert.NotContains(t, resp.Body.String(), "low_entropy")
+	})
+
+	t.Run("TestAddImageSanitizesName", func(t *testing.T) {
+		router := api.Router(newHandler())
+		add := func(name string, img image.Image) *httptest.ResponseRecorder {
+			return postImage(t, router, "/admin/add", map[string]string{"name": name}, imageUpload("upload.png", img))
+		}
+
+		resp := add(`..\..\etc/.rasm 1`, createNoiseImage())
+		require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+		var added map[string]string
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &added))
+		assert.Regexp(t, `^\d+_rasm%201\.png$`, added["filename"])
+		assert.FileExists(t, filepath.Join(testDir, added["filename"]))
+
+		resp = add("../..", createTestImage())
+		assert.Equal(t, http.StatusBadRequest, resp.Code)
+		assert.Contains(t, resp.Body.String(), "INVALID_FILENAME")
+	})
+
+	t.Run("TestAddImageLeavesNoTempFiles", func(t *testing.T) {
+		h := newHandler()
+		addImage(h, "atomic_ref.png", t)
+		before, _ := os.ReadDir(testDir)
+
+		// A rejected duplicate must leave neither its file nor a temp file behind
+		resp := postImage(t, api.Router(h), "/admin/add", nil, imageUpload("atomic_again.png", createTestImage()))
+		assert.Equal(t, http.StatusBadRequest, resp.Code)
+
+		after, _ := os.ReadDir(testDir)
+		assert.Len(t, after, len(before))
+		for _, entry := range after {
+			assert.False(t, strings.HasPrefix(entry.Name(), "."), "temp file %s left behind", entry.Name())
+			if strings.HasSuffix(entry.Name(), "atomic_ref.png") {
+				_, err := imaging.Open(filepath.Join(testDir, entry.Name()))
+				assert.NoError(t, err)
+			}
+		}
+	})
+
+	t.Run("TestAddImageDryRun", func(t *testing.T) {
+		h := newHandler()
+		addImage(h, "dry_run_ref.png", t)
+		before, _ := os.ReadDir(testDir)
+
+		resp := postImage(t, api.Router(h), "/admin/add?dry_run=true", nil, imageUpload("again.png", createTestImage()))
+		require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+		var check struct {
+			DryRun    bool                     `json:"dry_run"`
+			Accepted  bool                     `json:"accepted"`
+			Reason    string                   `json:"reason"`
+			Duplicate *database.MatchCandidate `json:"duplicate"`
+		}
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &check))
+		assert.True(t, check.DryRun)
+		assert.False(t, check.Accepted)
+		assert.Equal(t, database.RejectExactDuplicate, check.Reason)
+		require.NotNil(t, check.Duplicate)
+		assert.Contains(t, check.Duplicate.Filename, "dry_run_ref.png")
+
+		after, _ := os.ReadDir(testDir)
+		assert.Len(t, after, len(before), "a dry run must not save a file")
+		assert.Len(t, h.DB.Hashes, 1)
+	})
+
 	t.Run("TestDuplicateImage", func(t *testing.T) {
 		h := newHandler()
 		filename := "duplicate_test.png"
@@ -91,6 +203,19 @@ func TestHandler(t *testing.T) {
 		assert.Contains(t, resp.Body.String(), "already exists")
 	})
 
+	t.Run("TestExtensionContentMismatch", func(t *testing.T) {
+		router := api.Router(newHandler())
+
+		for filename, content := range map[string][]byte{
+			"renamed.jpg": pngBytes(createTestImage()),
+			"report.png":  []byte("%PDF-1.7\n%fake document"),
+		} {
+			resp := postImage(t, router, "/admin/add", nil, upload{"image", filename, content})
+			assert.Equal(t, http.StatusBadRequest, resp.Code, filename)
+			assert.Contains(t, resp.Body.String(), `"error_code":"FORMAT_MISMATCH"`, filename)
+		}
+	})
+
 	t.Run("TestToggleMLHandler", func(t *testing.T) {
 		h := newHandler()
 
@@ -111,9 +236,1370 @@ func TestHandler(t *testing.T) {
 		assert.Equal(t, http.StatusOK, resp.Code)
 		assert.Contains(t, resp.Body.String(), "ML disabled")
 	})
+
+	t.Run("TestMatchMethodHandler", func(t *testing.T) {
+		h := newHandler()
+		router := api.Router(h)
+		setMethod := func(method string) *httptest.ResponseRecorder {
+			return postImage(t, router, "/admin/method", map[string]string{"method": method})
+		}
+
+		resp := setMethod("ml")
+		require.Equal(t, http.StatusOK, resp.Code)
+		assert.Equal(t, database.MethodML, h.DB.MatchMethod())
+		assert.Contains(t, setMethod("").Body.String(), `"method":"ml"`)
+
+		resp = setMethod("sift")
+		assert.Equal(t, http.StatusBadRequest, resp.Code)
+		assert.Equal(t, database.MethodML, h.DB.MatchMethod())
+	})
+
+	t.Run("TestSelftest", func(t *testing.T) {
+		h := newHandler()
+		resp := postImage(t, api.Router(h), "/admin/selftest", map[string]string{"threshold": "50"}, imageUpload("scene.png", createTestImage()))
+		require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+
+		var selftest struct {
+			Threshold float64 `json:"threshold"`
+			Results   []struct {
+				Transform  string  `json:"transform"`
+				Match      bool    `json:"match"`
+				Similarity float64 `json:"similarity"`
+			} `json:"results"`
+		}
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &selftest))
+		assert.Equal(t, 50.0, selftest.Threshold)
+		require.Len(t, selftest.Results, 4)
+		for _, result := range selftest.Results {
+			assert.NotEmpty(t, result.Transform)
+			assert.Greater(t, result.Similarity, 50.0, result.Transform)
+			assert.True(t, result.Match, result.Transform)
+		}
+		assert.Empty(t, h.DB.Hashes, "nothing is stored")
+	})
+
+	t.Run("TestRejectsTinyImage", func(t *testing.T) {
+		pixel := imageUpload("pixel.png", image.NewRGBA(image.Rect(0, 0, 1, 1)))
+		resp := postImage(t, api.Router(newHandler()), "/recognize", nil, pixel)
+		assert.Equal(t, http.StatusBadRequest, resp.Code)
+		assert.Contains(t, resp.Body.String(), "image is 1x1")
+	})
+
+	t.Run("TestRejectsTruncatedJPEG", func(t *testing.T) {
+		router := api.Router(newHandler())
+		var encoded bytes.Buffer
+		require.NoError(t, jpeg.Encode(&encoded, createNoiseImage(), nil))
+
+		for _, size := range []int{200, encoded.Len() / 2, encoded.Len() - 2} {
+			resp := postImage(t, router, "/recognize", nil, upload{"image", "truncated.jpg", encoded.Bytes()[:size]})
+			assert.Equal(t, http.StatusBadRequest, resp.Code, size)
+			assert.Contains(t, resp.Body.String(), "CORRUPT_IMAGE", size)
+		}
+	})
+
+	t.Run("TestReloadConfigThreshold", func(t *testing.T) {
+		h := newHandler()
+		addImage(h, "reload_ref.png", t)
+
+		router := api.Router(h)
+		recognize := func() string {
+			return postImage(t, router, "/recognize", nil, imageUpload("query.png", createNoiseImage())).Body.String()
+		}
+		assert.Contains(t, recognize(), `"result":"NOT OK"`)
+
+		t.Setenv("PHOTOT_DEFAULT_THRESHOLD", "0")
+		t.Setenv("PHOTOT_ADDR", ":9090")
+		t.Setenv("PHOTOT_ADMIN_AUTH_DISABLED", "true")
+		req, _ := http.NewRequest("POST", "/admin/config/reload", nil)
+		resp := httptest.NewRecorder()
+		ctx, _ := gin.CreateTestContext(resp)
+		ctx.Request = req
+		h.ReloadConfigHandler(ctx)
+
+		assert.Equal(t, http.StatusOK, resp.Code)
+		assert.Contains(t, resp.Body.String(), `"applied":["PHOTOT_DEFAULT_THRESHOLD"]`)
+		assert.Contains(t, resp.Body.String(), `"requires_restart":["PHOTOT_ADDR"]`)
+		assert.Contains(t, recognize(), `"result":"OK"`)
+	})
+
+	t.Run("TestLogLevel", func(t *testing.T) {
+		var logs bytes.Buffer
+		logging.Init(&logs)
+		defer logging.Init(os.Stderr)
+		defer logging.SetLevel(slog.LevelInfo)
+
+		h := newHandler()
+		cfg := testConfig()
+		cfg.LogLevel = "loud"
+		assert.Error(t, h.SetConfig(cfg))
+
+		cfg.LogLevel = "warn"
+		require.NoError(t, h.SetConfig(cfg))
+		router := api.Router(h)
+		clear := func() {
+			req, _ := http.NewRequest("POST", "/admin/clear", nil)
+			router.ServeHTTP(httptest.NewRecorder(), req)
+		}
+		clear()
+		assert.NotContains(t, logs.String(), "database cleared")
+
+		cfg.LogLevel = "DEBUG"
+		require.NoError(t, h.SetConfig(cfg))
+		clear()
+		assert.Contains(t, logs.String(), "level=INFO msg=\"database cleared\" removed=0")
+	})
+
+	t.Run("TestCORS", func(t *testing.T) {
+		h := newHandler()
+		router := api.Router(h)
+		request := func(method, origin string) *httptest.ResponseRecorder {
+			req, _ := http.NewRequest(method, "/health", nil)
+			req.Header.Set("Origin", origin)
+			if method == http.MethodOptions {
+				req.Header.Set("Access-Control-Request-Method", "GET")
+			}
+			resp := httptest.NewRecorder()
+			router.ServeHTTP(resp, req)
+			return resp
+		}
+
+		// No origin is allowed by default
+		resp := request("GET", "https://app.example.com")
+		assert.Equal(t, http.StatusOK, resp.Code)
+		assert.Empty(t, resp.Header().Get("Access-Control-Allow-Origin"))
+
+		cfg := testConfig()
+		cfg.CORSAllowedOrigins = []string{"*"}
+		cfg.CORSAllowCredentials = true
+		assert.Error(t, h.SetConfig(cfg))
+		cfg.CORSAllowedOrigins = []string{"https://app.example.com/path"}
+		assert.Error(t, h.SetConfig(cfg))
+
+		cfg.CORSAllowedOrigins = []string{"https://app.example.com"}
+		require.NoError(t, h.SetConfig(cfg))
+		resp = request("OPTIONS", "https://app.example.com")
+		assert.Equal(t, http.StatusNoContent, resp.Code)
+		assert.Equal(t, "https://app.example.com", resp.Header().Get("Access-Control-Allow-Origin"))
+		assert.Equal(t, "true", resp.Header().Get("Access-Control-Allow-Credentials"))
+		assert.Contains(t, resp.Header().Get("Access-Control-Allow-Methods"), "POST")
+		assert.Contains(t, resp.Header().Get("Access-Control-Allow-Headers"), "X-API-Key")
+		resp = request("GET", "https://app.example.com")
+		assert.Equal(t, "https://app.example.com", resp.Header().Get("Access-Control-Allow-Origin"))
+		assert.Contains(t, resp.Header().Get("Access-Control-Expose-Headers"), "X-Request-ID")
+		resp = request("GET", "https://evil.example.com")
+		assert.Empty(t, resp.Header().Get("Access-Control-Allow-Origin"))
+		assert.Equal(t, "Origin", resp.Header().Get("Vary"))
+
+		cfg.CORSAllowedOrigins = []string{"*"}
+		cfg.CORSAllowCredentials = false
+		require.NoError(t, h.SetConfig(cfg))
+		resp = request("GET", "https://evil.example.com")
+		assert.Equal(t, "*", resp.Header().Get("Access-Control-Allow-Origin"))
+		assert.Empty(t, resp.Header().Get("Access-Control-Allow-Credentials"))
+	})
+
+	t.Run("TestConfigHandler", func(t *testing.T) {
+		h := newHandler()
+		cfg := testConfig()
+		cfg.WebhookSecret = "hush"
+		require.NoError(t, h.SetConfig(cfg))
+		h.DB.SetMatchMethod(database.MethodStrict)
+
+		req, _ := http.NewRequest("GET", "/admin/config", nil)
+		resp := httptest.NewRecorder()
+		api.Router(h).ServeHTTP(resp, req)
+		require.Equal(t, http.StatusOK, resp.Code)
+		assert.NotContains(t, resp.Body.String(), "hush")
+
+		var body struct {
+			Settings map[string]config.Setting `json:"settings"`
+			Runtime  map[string]interface{}    `json:"runtime"`
+		}
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &body))
+		assert.Equal(t, config.Setting{Value: 85.0, Hot: true}, body.Settings["PHOTOT_DEFAULT_THRESHOLD"])
+		assert.Equal(t, config.Setting{Value: ":8080", Hot: false}, body.Settings["PHOTOT_ADDR"])
+		assert.Equal(t, config.Redacted, body.Settings["PHOTOT_WEBHOOK_SECRET"].Value)
+		assert.Equal(t, "", body.Settings["PHOTOT_ADMIN_API_KEY"].Value)
+		assert.Equal(t, "strict", body.Runtime["match_method"])
+		assert.Equal(t, true, body.Runtime["ml_enabled"])
+		assert.Equal(t, 300.0, body.Runtime["cache_ttl_seconds"])
+	})
+
+	t.Run("TestAdminAPIKey", func(t *testing.T) {
+		h := newHandler()
+		cfg := config.Default()
+		router := api.Router(h)
+		hello := func(key string) *httptest.ResponseRecorder {
+			req, _ := http.NewRequest("GET", "/admin/hello", nil)
+			if key != "" {
+				req.Header.Set("X-API-Key", key)
+			}
+			resp := httptest.NewRecorder()
+			router.ServeHTTP(resp, req)
+			return resp
+		}
+
+		// Without a key admin routes are closed unless explicitly opened
+		assert.NoError(t, h.SetConfig(cfg))
+		resp := hello("")
+		assert.Equal(t, http.StatusServiceUnavailable, resp.Code)
+		assert.Contains(t, resp.Body.String(), "ADMIN_AUTH_NOT_CONFIGURED")
+		cfg.AdminAuthDisabled = true
+		assert.NoError(t, h.SetConfig(cfg))
+		assert.Equal(t, http.StatusOK, hello("").Code)
+
+		cfg.AdminAuthDisabled = false
+		cfg.AdminAPIKey = "secret"
+		assert.NoError(t, h.SetConfig(cfg))
+		for key, code := range map[string]int{"": http.StatusUnauthorized, "wrong": http.StatusUnauthorized, "secret": http.StatusOK} {
+			assert.Equal(t, code, hello(key).Code, "key %q", key)
+		}
+	})
+
+	t.Run("TestLocalizedErrors", func(t *testing.T) {
+		router := api.Router(newHandler())
+		for language, message := range map[string]string{"": "Image file not found", "uz-UZ,en;q=0.5": "Rasm fayli topilmadi"} {
+			req, _ := http.NewRequest("POST", "/recognize", nil)
+			req.Header.Set("Accept-Language", language)
+			resp := httptest.NewRecorder()
+			router.ServeHTTP(resp, req)
+
+			assert.Equal(t, http.StatusBadRequest, resp.Code)
+			var body map[string]string
+			require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &body))
+			assert.Equal(t, "IMAGE_MISSING", body["error_code"], language)
+			assert.Equal(t, message, body["message"], language)
+		}
+	})
+
+	t.Run("TestRecognizeRateLimit", func(t *testing.T) {
+		h := newHandler()
+		cfg := testConfig()
+		cfg.RateLimitRPS = 0.01
+		cfg.RateLimitBurst = 2
+		assert.NoError(t, h.SetConfig(cfg))
+		router := api.Router(h)
+
+		codes := []int{}
+		var last *httptest.ResponseRecorder
+		for i := 0; i < 3; i++ {
+			req, _ := http.NewRequest("POST", "/recognize", nil)
+			// Proxies are not trusted by default, so forged addresses share a bucket
+			req.RemoteAddr = "192.0.2.1:1234"
+			req.Header.Set("X-Forwarded-For", "203.0.113."+strconv.Itoa(i))
+			last = httptest.NewRecorder()
+			router.ServeHTTP(last, req)
+			codes = append(codes, last.Code)
+		}
+
+		assert.Equal(t, []int{http.StatusBadRequest, http.StatusBadRequest, http.StatusTooManyRequests}, codes)
+		assert.NotEmpty(t, last.Header().Get("Retry-After"))
+
+		cfg.TrustedProxies = []string{"not-an-ip"}
+		assert.Error(t, h.SetConfig(cfg))
+	})
+
+	t.Run("TestBodyLimit", func(t *testing.T) {
+		h := newHandler()
+		cfg := testConfig()
+		cfg.MaxBodyMB = 1
+		assert.NoError(t, h.SetConfig(cfg))
+		router := api.Router(h)
+
+		big := upload{"image", "big.png", bytes.Repeat([]byte{0}, 2<<20)}
+
+		// Declared length, rejected before reading
+		resp := postImage(t, router, "/recognize", nil, big)
+		assert.Equal(t, http.StatusRequestEntityTooLarge, resp.Code)
+		assert.Contains(t, resp.Body.String(), "REQUEST_TOO_LARGE")
+
+		// Chunked, rejected once the cap is read past
+		req := multipartRequest(t, "POST", "/compare", nil, big)
+		req.ContentLength = -1
+		resp = httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		assert.Equal(t, http.StatusRequestEntityTooLarge, resp.Code)
+
+		// Refused clients are turned away before their body is read
+		cfg.AdminAPIKey = "secret"
+		cfg.AdminAuthDisabled = false
+		require.NoError(t, h.SetConfig(cfg))
+		req = multipartRequest(t, "POST", "/admin/add", nil, big)
+		req.ContentLength = -1
+		resp = httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		assert.Equal(t, http.StatusUnauthorized, resp.Code)
+
+		// Small bodies reach the handler
+		req, _ = http.NewRequest("POST", "/recognize", strings.NewReader("x"))
+		resp = httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		assert.Equal(t, http.StatusBadRequest, resp.Code)
+
+		// A body within the cap may still hold a file over the per-file limit
+		cfg.MaxBodyMB = 64
+		require.NoError(t, h.SetConfig(cfg))
+		resp = postImage(t, router, "/recognize", nil, upload{"image", "big.png", bytes.Repeat([]byte{0}, 10<<20+1)})
+		assert.Equal(t, http.StatusBadRequest, resp.Code)
+		assert.Contains(t, resp.Body.String(), "FILE_TOO_LARGE")
+	})
+
+	t.Run("TestDefaultThresholdValidation", func(t *testing.T) {
+		h := newHandler()
+		cfg := testConfig()
+		cfg.DefaultThreshold = 120
+		assert.Error(t, h.SetConfig(cfg))
+	})
+
+	t.Run("TestGetImageHandler", func(t *testing.T) {
+		h := newHandler()
+		router := api.Router(h)
+		hash, err := h.DB.AddImage(createTestImage(), "record.png")
+		assert.NoError(t, err)
+		id := database.ImageID("record.png")
+
+		for _, key := range []string{id, hash} {
+			req, _ := http.NewRequest("GET", "/admin/image/"+key, nil)
+			resp := httptest.NewRecorder()
+			router.ServeHTTP(resp, req)
+			assert.Equal(t, http.StatusOK, resp.Code)
+			var info database.ImageInfo
+			assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &info))
+			assert.Equal(t, id, info.ID)
+			assert.Equal(t, "record.png", info.Filename)
+			assert.Equal(t, hash, info.Hash)
+			assert.NotEmpty(t, info.Thumbnail)
+			assert.Empty(t, info.Features)
+		}
+
+		req, _ := http.NewRequest("GET", "/admin/image/"+id+"?include_features=true", nil)
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		var info database.ImageInfo
+		assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &info))
+		assert.NotEmpty(t, info.Features)
+
+		req, _ = http.NewRequest("GET", "/admin/image/missing", nil)
+		resp = httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		assert.Equal(t, http.StatusNotFound, resp.Code)
+	})
+
+	t.Run("TestThumbnailHandler", func(t *testing.T) {
+		h := newHandler()
+		router := api.Router(h)
+		hash, err := h.DB.AddImage(createTestImage(), "thumb.png")
+		assert.NoError(t, err)
+		assert.NotEmpty(t, hash)
+
+		req, _ := http.NewRequest("GET", "/admin/image/thumb.png/thumbnail", nil)
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		assert.Equal(t, http.StatusOK, resp.Code)
+		assert.Equal(t, "image/jpeg", resp.Header().Get("Content-Type"))
+
+		req, _ = http.NewRequest("GET", "/admin/image/thumb.png/thumbnail", nil)
+		req.Header.Set("If-None-Match", resp.Header().Get("ETag"))
+		resp = httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		assert.Equal(t, http.StatusNotModified, resp.Code)
+
+		req, _ = http.NewRequest("GET", "/admin/image/missing.png/thumbnail", nil)
+		resp = httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		assert.Equal(t, http.StatusNotFound, resp.Code)
+
+		cfg := testConfig()
+		cfg.ThumbnailFormat = "webp"
+		require.NoError(t, h.SetConfig(cfg))
+		_, err = h.DB.AddImage(createNoiseImage(), "thumb.webp.png")
+		require.NoError(t, err)
+		req, _ = http.NewRequest("GET", "/admin/image/thumb.webp.png/thumbnail", nil)
+		resp = httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		assert.Equal(t, http.StatusOK, resp.Code)
+		assert.Equal(t, "image/webp", resp.Header().Get("Content-Type"))
+	})
+
+	t.Run("TestRecognizeCrop", func(t *testing.T) {
+		router := api.Router(newHandler())
+
+		for crop, code := range map[[2]string]int{
+			{"10,10,50,50", "px"}:           http.StatusOK,
+			{"0.1,0.1,0.8,0.8", "fraction"}: http.StatusOK,
+			{"60,60,50,50", "px"}:           http.StatusBadRequest,
+			{"1,2,3", "px"}:                 http.StatusBadRequest,
+		} {
+			fields := map[string]string{"crop": crop[0], "crop_units": crop[1]}
+			resp := postImage(t, router, "/recognize", fields, imageUpload("query.png", createTestImage()))
+			assert.Equal(t, code, resp.Code, crop)
+		}
+	})
+
+	t.Run("TestRecognizeMatchedThumbnail", func(t *testing.T) {
+		h := newHandler()
+		addImage(h, "thumbnail_match.png", t)
+		router := api.Router(h)
+
+		for include, want := range map[string]bool{"true": true, "": false} {
+			fields := map[string]string{"include_matched_thumbnail": include}
+			resp := postImage(t, router, "/recognize", fields, imageUpload("query.png", createTestImage()))
+			var response database.RecognizeResponse
+			assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &response))
+			assert.Equal(t, "OK", response.Result)
+			assert.InDelta(t, response.Similarity/100, response.SimilarityNormalized, 1e-9)
+			assert.Equal(t, database.ConfidenceHigh, response.Confidence)
+			assert.Equal(t, want, response.MatchedThumbnail != "", include)
+		}
+	})
+
+	t.Run("TestRecognizeReturnFeatures", func(t *testing.T) {
+		h := newHandler()
+		addImage(h, "features_ref.png", t)
+		router := api.Router(h)
+		recognize := func(query string) database.RecognizeResponse {
+			resp := postImage(t, router, "/recognize"+query, nil, imageUpload("query.png", createTestImage()))
+			require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+			var response database.RecognizeResponse
+			require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &response))
+			return response
+		}
+
+		response := recognize("")
+		assert.Empty(t, response.Features)
+		assert.Empty(t, response.FeatureType)
+
+		response = recognize("?return_features=true")
+		assert.Equal(t, "OK", response.Result)
+		assert.Equal(t, im.FeatureTypeHOG, response.FeatureType)
+		assert.Equal(t, h.DB.ExportFeatures()[0].Features, response.Features)
+	})
+
+	t.Run("TestRecognizeTiled", func(t *testing.T) {
+		h := newHandler()
+		addImage(h, "tiled_ref.png", t)
+		router := api.Router(h)
+		scene := imageUpload("scene.png", imaging.Paste(imaging.New(300, 200, color.White), createTestImage(), image.Pt(100, 50)))
+		recognize := func(fields map[string]string) *httptest.ResponseRecorder {
+			return postImage(t, router, "/recognize", fields, scene)
+		}
+
+		resp := recognize(map[string]string{"mode": "tiled", "tile_size": "100", "tile_stride": "50"})
+		require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+		var response database.RecognizeResponse
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &response))
+		assert.Equal(t, "OK", response.Result)
+		assert.Equal(t, &database.Tile{X: 100, Y: 50, Size: 100}, response.Tile)
+
+		resp = recognize(map[string]string{"mode": "robust"})
+		require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+		response = database.RecognizeResponse{}
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &response))
+		assert.NotNil(t, response.BlockFraction)
+		assert.Nil(t, response.Tile)
+
+		for _, fields := range []map[string]string{
+			{"mode": "sliding"},
+			{"mode": "robust", "min_block_fraction": "0"},
+			{"mode": "robust", "min_block_fraction": "1.5"},
+			{"mode": "tiled", "tile_size": "250"},
+			{"mode": "tiled", "tile_size": "16", "tile_stride": "1"},
+			{"max_distance": "-1"},
+			{"min_margin": "-5"},
+			{"min_margin": "wide"},
+		} {
+			resp := recognize(fields)
+			assert.Equal(t, http.StatusBadRequest, resp.Code, fields)
+			assert.Contains(t, resp.Body.String(), "INVALID_PARAMETER")
+		}
+	})
+
+	t.Run("TestRecognizeETag", func(t *testing.T) {
+		h := newHandler()
+		addImage(h, "etag_ref.png", t)
+		router := api.Router(h)
+
+		recognize := func(threshold, ifNoneMatch string) *httptest.ResponseRecorder {
+			fields := map[string]string{"threshold": threshold}
+			req := multipartRequest(t, "POST", "/recognize", fields, imageUpload("query.png", createTestImage()))
+			if ifNoneMatch != "" {
+				req.Header.Set("If-None-Match", ifNoneMatch)
+			}
+			resp := httptest.NewRecorder()
+			router.ServeHTTP(resp, req)
+			return resp
+		}
+
+		first := recognize("90", "")
+		require.Equal(t, http.StatusOK, first.Code)
+		etag := first.Header().Get("ETag")
+		require.NotEmpty(t, etag)
+		assert.Equal(t, etag, recognize("90", "").Header().Get("ETag"))
+		assert.NotEqual(t, etag, recognize("80", "").Header().Get("ETag"))
+
+		replayed := recognize("90", etag)
+		assert.Equal(t, http.StatusOK, replayed.Code)
+		assert.Equal(t, first.Body.String(), replayed.Body.String())
+
+		// Changing the database invalidates the tag
+		_, err := h.DB.AddImage(createNoiseImage(), "etag_other.png")
+		require.NoError(t, err)
+		etag = recognize("90", "").Header().Get("ETag")
+
+		// So does switching the match method, which changes the result
+		require.Equal(t, http.StatusOK, postImage(t, router, "/admin/toggle-ml", map[string]string{"enable": "false"}).Code)
+		resp := recognize("90", etag)
+		assert.NotEqual(t, etag, resp.Header().Get("ETag"))
+
+		// And applying a config, whose confidence bounds label the result
+		etag = resp.Header().Get("ETag")
+		cfg := testConfig()
+		cfg.ConfidenceHigh = 100
+		require.NoError(t, h.SetConfig(cfg))
+		assert.NotEqual(t, etag, recognize("90", etag).Header().Get("ETag"))
+	})
+
+	t.Run("TestRecognizeProtobuf", func(t *testing.T) {
+		h := newHandler()
+		addImage(h, "proto_ref.png", t)
+		router := api.Router(h)
+
+		for accept, want := range map[string]string{
+			"":                                  "application/json; charset=utf-8",
+			"application/json":                  "application/json; charset=utf-8",
+			"application/x-protobuf":            "application/x-protobuf",
+			"application/x-protobuf, */*;q=0.1": "application/x-protobuf",
+		} {
+			req := multipartRequest(t, "POST", "/recognize", nil, imageUpload("query.png", createTestImage()))
+			req.Header.Set("Accept", accept)
+			resp := httptest.NewRecorder()
+			router.ServeHTTP(resp, req)
+
+			assert.Equal(t, http.StatusOK, resp.Code, accept)
+			assert.Equal(t, want, resp.Header().Get("Content-Type"), accept)
+			if want == "application/x-protobuf" {
+				assert.Contains(t, resp.Body.String(), "proto_ref.png")
+				assert.False(t, json.Valid(resp.Body.Bytes()))
+			}
+		}
+	})
+
+	t.Run("TestRecognizeBatch", func(t *testing.T) {
+		h := newHandler()
+		addImage(h, "batch_ref.png", t)
+		router := api.Router(h)
+
+		resp := postImage(t, router, "/recognize/batch", nil,
+			imageUpload("frame0.png", createTestImage()),
+			upload{"image", "frame1.png", []byte("not an image")},
+			imageUpload("frame2.png", createNoiseImage()))
+		require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+
+		var batch struct {
+			Results []struct {
+				Index    int                         `json:"index"`
+				Filename string                      `json:"filename"`
+				Response *database.RecognizeResponse `json:"response"`
+				Error    map[string]string           `json:"error"`
+			} `json:"results"`
+		}
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &batch))
+		require.Len(t, batch.Results, 3)
+		for i, result := range batch.Results {
+			assert.Equal(t, i, result.Index)
+			assert.Equal(t, "frame"+strconv.Itoa(i)+".png", result.Filename)
+		}
+		assert.Equal(t, "OK", batch.Results[0].Response.Result)
+		assert.Nil(t, batch.Results[1].Response)
+		assert.Equal(t, "INVALID_IMAGE", batch.Results[1].Error["error_code"])
+		assert.Equal(t, "NOT OK", batch.Results[2].Response.Result)
+	})
+
+	t.Run("TestCompareTo", func(t *testing.T) {
+		h := newHandler()
+		addImage(h, "compare_to.png", t)
+		stored := h.DB.ListImages()[0]
+		router := api.Router(h)
+		compareTo := func(id, method string, data []byte) *httptest.ResponseRecorder {
+			fields := map[string]string{"method": method}
+			return postImage(t, router, "/compare-to/"+url.PathEscape(id), fields, upload{"image", "query.png", data})
+		}
+
+		for _, method := range []string{"ml", "hash", "ssim"} {
+			resp := compareTo(stored.ID, method, pngBytes(createTestImage()))
+			require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+			var same database.CompareResponse
+			require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &same))
+			assert.Equal(t, method, same.Method)
+			assert.Equal(t, stored.ID, same.ID)
+			assert.Equal(t, stored.Filename, same.Filename)
+			assert.InDelta(t, 100, same.Similarity, 0.5, method)
+
+			resp = compareTo(stored.Filename, method, pngBytes(createNoiseImage()))
+			require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+			var different database.CompareResponse
+			require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &different))
+			assert.Less(t, different.Similarity, same.Similarity, method)
+			assert.Equal(t, database.NormalizeSimilarity(different.Similarity), different.SimilarityNormalized)
+		}
+
+		resp := compareTo("missing", "hash", pngBytes(createTestImage()))
+		assert.Equal(t, http.StatusNotFound, resp.Code)
+		assert.Contains(t, resp.Body.String(), "IMAGE_NOT_FOUND")
+
+		_, _, err := h.DB.AddImageWithOptions(createNoiseImage(), "pending.png", database.AddOptions{SkipFeatures: true})
+		require.NoError(t, err)
+		resp = compareTo("pending.png", "ml", pngBytes(createNoiseImage()))
+		assert.Equal(t, http.StatusConflict, resp.Code)
+		assert.Contains(t, resp.Body.String(), "NOT_COMPARABLE")
+		resp = compareTo("pending.png", "hash", pngBytes(createNoiseImage()))
+		require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+		assert.Contains(t, resp.Body.String(), `"similarity":100`)
+	})
+
+	t.Run("TestRecognizeBatchStream", func(t *testing.T) {
+		h := newHandler()
+		addImage(h, "stream_ref.png", t)
+		router := api.Router(h)
+
+		resp := postImage(t, router, "/recognize/batch/stream", nil,
+			imageUpload("frame0.png", createTestImage()),
+			upload{"image", "frame1.png", []byte("not an image")},
+			imageUpload("frame2.png", createNoiseImage()))
+		require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+		assert.Equal(t, "application/x-ndjson", resp.Header().Get("Content-Type"))
+		assert.True(t, resp.Flushed)
+
+		type line struct {
+			Index    int                         `json:"index"`
+			Filename string                      `json:"filename"`
+			Response *database.RecognizeResponse `json:"response"`
+			Error    map[string]string           `json:"error"`
+		}
+		lines := make(map[int]line)
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			var l line
+			require.NoError(t, json.Unmarshal(scanner.Bytes(), &l))
+			assert.Equal(t, "frame"+strconv.Itoa(l.Index)+".png", l.Filename)
+			lines[l.Index] = l
+		}
+		require.Len(t, lines, 3)
+		assert.Equal(t, "OK", lines[0].Response.Result)
+		assert.Equal(t, "INVALID_IMAGE", lines[1].Error["error_code"])
+		assert.Equal(t, "NOT OK", lines[2].Response.Result)
+
+		// Once the client has gone no further images are handed out
+		cfg := testConfig()
+		cfg.BatchWorkers = 1
+		require.NoError(t, h.SetConfig(cfg))
+		frames := make([]upload, 20)
+		for i := range frames {
+			frames[i] = imageUpload("frame"+strconv.Itoa(i)+".png", createTestImage())
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		req := multipartRequest(t, "POST", "/recognize/batch/stream", nil, frames...).WithContext(ctx)
+		resp = httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		assert.Less(t, strings.Count(resp.Body.String(), "\n"), len(frames))
+	})
+
+	t.Run("TestCompare", func(t *testing.T) {
+		router := api.Router(newHandler())
+		compare := func(method string, second []byte) *httptest.ResponseRecorder {
+			return postImage(t, router, "/compare", map[string]string{"method": method},
+				upload{"image1", "a.png", pngBytes(createTestImage())}, upload{"image2", "b.png", second})
+		}
+
+		for _, method := range []string{"ml", "hash", "ssim"} {
+			resp := compare(method, pngBytes(createTestImage()))
+			require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+			var same database.CompareResponse
+			require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &same))
+			assert.Equal(t, method, same.Method)
+			assert.InDelta(t, 100, same.Similarity, 0.01, method)
+
+			resp = compare(method, pngBytes(createNoiseImage()))
+			require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+			var different database.CompareResponse
+			require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &different))
+			assert.Less(t, different.Similarity, same.Similarity, method)
+			assert.Equal(t, database.NormalizeSimilarity(different.Similarity), different.SimilarityNormalized)
+		}
+
+		resp := compare("", pngBytes(createTestImage()))
+		require.Equal(t, http.StatusOK, resp.Code)
+		assert.Contains(t, resp.Body.String(), `"method":"ml"`)
+
+		resp = compare("sift", pngBytes(createTestImage()))
+		assert.Equal(t, http.StatusBadRequest, resp.Code)
+		assert.Contains(t, resp.Body.String(), "INVALID_PARAMETER")
+
+		resp = compare("ssim", []byte("not an image"))
+		assert.Equal(t, http.StatusBadRequest, resp.Code)
+		assert.Contains(t, resp.Body.String(), "image2")
+	})
+
+	t.Run("TestCompareMatrix", func(t *testing.T) {
+		router := api.Router(newHandler())
+		matrix := func(parts ...[]byte) *httptest.ResponseRecorder {
+			files := make([]upload, len(parts))
+			for i, data := range parts {
+				files[i] = upload{"image", strconv.Itoa(i) + ".png", data}
+			}
+			return postImage(t, router, "/compare/matrix", map[string]string{"method": "hash"}, files...)
+		}
+
+		resp := matrix(pngBytes(createTestImage()), pngBytes(createNoiseImage()), pngBytes(createTestImage()))
+		require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+		var response struct {
+			Method    string      `json:"method"`
+			Filenames []string    `json:"filenames"`
+			Matrix    [][]float64 `json:"matrix"`
+		}
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &response))
+		assert.Equal(t, "hash", response.Method)
+		assert.Equal(t, []string{"0.png", "1.png", "2.png"}, response.Filenames)
+		require.Len(t, response.Matrix, 3)
+		for i := range response.Matrix {
+			assert.Equal(t, 100.0, response.Matrix[i][i])
+			for j := range response.Matrix {
+				assert.Equal(t, response.Matrix[i][j], response.Matrix[j][i])
+			}
+		}
+		assert.Equal(t, 100.0, response.Matrix[0][2])
+		assert.Less(t, response.Matrix[0][1], 100.0)
+
+		resp = matrix(pngBytes(createTestImage()))
+		assert.Equal(t, http.StatusBadRequest, resp.Code)
+		assert.Contains(t, resp.Body.String(), "INVALID_PARAMETER")
+
+		resp = matrix(pngBytes(createTestImage()), []byte("not an image"))
+		assert.Equal(t, http.StatusBadRequest, resp.Code)
+		assert.Contains(t, resp.Body.String(), "image 1")
+	})
+
+	t.Run("TestTenantIsolation", func(t *testing.T) {
+		h := newHandler()
+		h.Tenants = database.NewRegistry(testDir+"/tenants", 1, h.DB)
+		router := api.Router(h)
+
+		post := func(path, tenant string) *httptest.ResponseRecorder {
+			req := multipartRequest(t, "POST", path, nil, imageUpload("tenant.png", createTestImage()))
+			if tenant != "" {
+				req.Header.Set(handler.TenantHeader, tenant)
+			}
+			resp := httptest.NewRecorder()
+			router.ServeHTTP(resp, req)
+			return resp
+		}
+
+		resp := post("/recognize", "acme")
+		assert.Equal(t, http.StatusNotFound, resp.Code, "public routes do not create tenants")
+		assert.Contains(t, resp.Body.String(), "TENANT_NOT_FOUND")
+		assert.Equal(t, http.StatusOK, post("/admin/add", "acme").Code)
+		assert.Contains(t, post("/recognize", "acme").Body.String(), `"result":"OK"`)
+		assert.Contains(t, post("/recognize", "").Body.String(), `"result":"NO_DATA"`)
+		assert.Equal(t, http.StatusNotFound, post("/recognize", "globex").Code)
+		assert.Equal(t, http.StatusServiceUnavailable, post("/admin/add", "globex").Code)
+		assert.Equal(t, http.StatusBadRequest, post("/recognize", "Bad/Name").Code)
+	})
+
+	t.Run("TestListAndDeleteImage", func(t *testing.T) {
+		h := newHandler()
+		addImage(h, "listed.png", t)
+		router := api.Router(h)
+
+		var listed database.ImagePage
+		req, _ := http.NewRequest("GET", "/admin/list?page_size=10&sort=filename&order=desc", nil)
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &listed))
+		assert.Equal(t, 1, listed.Total)
+		assert.Equal(t, 10, listed.PageSize)
+		id := listed.Images[0].ID
+		assert.NotEmpty(t, id)
+		assert.FileExists(t, testDir+"/"+listed.Images[0].Filename)
+
+		for _, code := range []int{http.StatusOK, http.StatusNotFound} {
+			req, _ = http.NewRequest("DELETE", "/admin/image/"+id, nil)
+			resp = httptest.NewRecorder()
+			router.ServeHTTP(resp, req)
+			assert.Equal(t, code, resp.Code)
+		}
+		assert.NoFileExists(t, testDir+"/"+listed.Images[0].Filename)
+
+		req, _ = http.NewRequest("GET", "/admin/list?page=9223372036854775807&page_size=500", nil)
+		resp = httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		require.Equal(t, http.StatusOK, resp.Code)
+		assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &listed))
+		assert.Empty(t, listed.Images)
+
+		for _, query := range []string{"page=0", "page=9223372036854775808", "page_size=501", "sort=size", "order=up"} {
+			req, _ = http.NewRequest("GET", "/admin/list?"+query, nil)
+			resp = httptest.NewRecorder()
+			router.ServeHTTP(resp, req)
+			assert.Equal(t, http.StatusBadRequest, resp.Code, query)
+		}
+	})
+
+	t.Run("TestListOrderSurvivesReload", func(t *testing.T) {
+		dir := t.TempDir()
+		h := &handler.Handler{DB: database.NewImageDatabase(), ImageDir: dir}
+		require.NoError(t, h.SetConfig(testConfig()))
+		router := api.Router(h)
+		for name, img := range map[string]image.Image{"c.png": createTestImage(), "a.png": createNoiseImage(), "b.png": imaging.Invert(createTestImage())} {
+			require.Equal(t, http.StatusOK, postImage(t, router, "/admin/add", nil, imageUpload(name, img)).Code)
+		}
+		list := func(h *handler.Handler) []database.ImageInfo {
+			req, _ := http.NewRequest("GET", "/admin/list?sort=added_at", nil)
+			resp := httptest.NewRecorder()
+			api.Router(h).ServeHTTP(resp, req)
+			require.Equal(t, http.StatusOK, resp.Code)
+			var page database.ImagePage
+			require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &page))
+			return page.Images
+		}
+		before := list(h)
+		require.Len(t, before, 3)
+
+		// Reloading restores each image's AddedAt, so the page is the same
+		for i := 0; i < 3; i++ {
+			reloaded := &handler.Handler{DB: database.NewImageDatabase(), ImageDir: dir}
+			require.NoError(t, reloaded.SetConfig(testConfig()))
+			require.NoError(t, reloaded.DB.LoadImages(dir))
+			after := list(reloaded)
+			require.Len(t, after, len(before))
+			for j := range before {
+				assert.Equal(t, before[j].Filename, after[j].Filename)
+				assert.True(t, before[j].AddedAt.Equal(after[j].AddedAt), before[j].Filename)
+			}
+		}
+	})
+
+	t.Run("TestAddImageAlias", func(t *testing.T) {
+		h := newHandler()
+		addImage(h, "alias_ref.png", t)
+		router := api.Router(h)
+		add := func(onDuplicate string) *httptest.ResponseRecorder {
+			fields := map[string]string{"on_duplicate": onDuplicate}
+			return postImage(t, router, "/admin/add", fields, imageUpload("alias_copy.png", createTestImage()))
+		}
+
+		assert.Equal(t, http.StatusBadRequest, add("reject").Code)
+		assert.Equal(t, http.StatusBadRequest, add("merge").Code)
+
+		resp := add("alias")
+		require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+		var added map[string]interface{}
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &added))
+		assert.Equal(t, true, added["aliased"])
+		stored := h.DB.ListImages()
+		require.Len(t, stored, 1)
+		assert.Equal(t, stored[0].ID, added["id"])
+		assert.Equal(t, []string{added["filename"].(string)}, stored[0].Aliases)
+		assert.FileExists(t, filepath.Join(testDir, added["filename"].(string)))
+
+		// Deleting the image removes the files of its aliases too
+		req, _ := http.NewRequest("DELETE", "/admin/image/"+stored[0].ID, nil)
+		resp = httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		assert.Equal(t, http.StatusOK, resp.Code)
+		for _, filename := range stored[0].Files() {
+			assert.NoFileExists(t, filepath.Join(testDir, filename))
+		}
+	})
+
+	t.Run("TestContentStorageLayout", func(t *testing.T) {
+		dir := t.TempDir()
+		h := &handler.Handler{DB: database.NewImageDatabase(), ImageDir: dir}
+		cfg := testConfig()
+		cfg.StorageLayout = "content"
+		require.NoError(t, h.SetConfig(cfg))
+		router := api.Router(h)
+		add := func() map[string]interface{} {
+			fields := map[string]string{"on_duplicate": "alias"}
+			resp := postImage(t, router, "/admin/add", fields, imageUpload("stored.png", createTestImage()))
+			require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+			var added map[string]interface{}
+			require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &added))
+			return added
+		}
+
+		first, second := add(), add()
+		assert.Equal(t, true, second["aliased"])
+		path := h.DB.FilePath(first["filename"].(string))
+		assert.NotEqual(t, first["filename"], path, "the file is stored under its content hash")
+		assert.Equal(t, path, h.DB.FilePath(second["filename"].(string)), "identical uploads share one file")
+		assert.FileExists(t, filepath.Join(dir, path))
+		assert.NoFileExists(t, filepath.Join(dir, first["filename"].(string)))
+
+		reloaded := database.NewImageDatabase()
+		require.NoError(t, reloaded.LoadImages(dir))
+		stored := reloaded.ListImages()
+		require.Len(t, stored, 1)
+		assert.ElementsMatch(t, []string{first["filename"].(string), second["filename"].(string)}, stored[0].Files())
+
+		req, _ := http.NewRequest("DELETE", "/admin/image/"+first["id"].(string), nil)
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		assert.Equal(t, http.StatusOK, resp.Code)
+		assert.NoFileExists(t, filepath.Join(dir, path))
+	})
+
+	t.Run("TestSkipFeatures", func(t *testing.T) {
+		h := newHandler()
+		router := api.Router(h)
+		fields := map[string]string{"skip_features": "true"}
+		resp := postImage(t, router, "/admin/add", fields, imageUpload("pending.png", createNoiseImage()))
+		require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+		assert.Equal(t, 1, h.DB.PendingFeatures())
+		assert.True(t, h.DB.ListImages()[0].FeaturesPending)
+
+		req, _ := http.NewRequest("POST", "/admin/reindex", nil)
+		resp = httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+		assert.JSONEq(t, `{"extracted":1,"pending":0}`, resp.Body.String())
+		assert.False(t, h.DB.ListImages()[0].FeaturesPending)
+		assert.Len(t, h.DB.ExportFeatures(), 1)
+	})
+
+	t.Run("TestClearDatabase", func(t *testing.T) {
+		h := newHandler()
+		addImage(h, "cleared.png", t)
+		stored := h.DB.ListImages()
+		require.Len(t, stored, 1)
+		router := api.Router(h)
+
+		req, _ := http.NewRequest("POST", "/admin/clear?delete_files=true", nil)
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		assert.Equal(t, http.StatusOK, resp.Code)
+		assert.Contains(t, resp.Body.String(), `"removed":1`)
+		assert.Contains(t, resp.Body.String(), `"files_deleted":1`)
+		assert.Empty(t, h.DB.ListImages())
+		assert.NoFileExists(t, testDir+"/"+stored[0].Filename)
+
+		// The same image can be added again once cleared
+		addImage(h, "cleared.png", t)
+	})
+
+	t.Run("TestFeatureExportImport", func(t *testing.T) {
+		h := newHandler()
+		addImage(h, "features.png", t)
+		router := api.Router(h)
+
+		req, _ := http.NewRequest("GET", "/admin/features/export", nil)
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		require.Equal(t, http.StatusOK, resp.Code)
+		assert.Equal(t, "application/x-ndjson", resp.Header().Get("Content-Type"))
+		var record database.FeatureRecord
+		require.NoError(t, json.Unmarshal(bytes.TrimSpace(resp.Body.Bytes()), &record))
+		assert.NotEmpty(t, record.Features)
+
+		record.Features[0] += 1
+		line, _ := json.Marshal(record)
+		body := string(line) + "\n" + `{"filename": "missing.png", "features": [1]}` + "\nnot json\n"
+		req, _ = http.NewRequest("POST", "/admin/features/import", strings.NewReader(body))
+		resp = httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		require.Equal(t, http.StatusOK, resp.Code)
+		assert.Contains(t, resp.Body.String(), `"imported":1`)
+		assert.Contains(t, resp.Body.String(), `"failed":2`)
+		assert.Equal(t, record.Features, h.DB.ExportFeatures()[0].Features)
+	})
+
+	t.Run("TestReplaceImage", func(t *testing.T) {
+		h := newHandler()
+		addImage(h, "replaced.png", t)
+		original := h.DB.ListImages()[0]
+		router := api.Router(h)
+
+		put := func(id string, img image.Image) *httptest.ResponseRecorder {
+			req := multipartRequest(t, "PUT", "/admin/image/"+id, nil, imageUpload("new.png", img))
+			resp := httptest.NewRecorder()
+			router.ServeHTTP(resp, req)
+			return resp
+		}
+
+		assert.Equal(t, http.StatusOK, put(original.ID, createNoiseImage()).Code)
+		replaced, ok := h.DB.Image(original.ID)
+		require.True(t, ok)
+		assert.Equal(t, original.Filename, replaced.Filename)
+		assert.Equal(t, original.AddedAt, replaced.AddedAt)
+		assert.NotEqual(t, original.Hash, replaced.Hash)
+		stored, err := imaging.Open(testDir + "/" + original.Filename)
+		require.NoError(t, err)
+		assert.Equal(t, replaced.ContentHash, im.ContentHash(stored))
+
+		// When the file cannot be written the record keeps describing the old one
+		path := testDir + "/" + original.Filename
+		require.NoError(t, os.Rename(path, path+".bak"))
+		require.NoError(t, os.MkdirAll(path+"/blocker", 0o755))
+		resp := put(original.ID, createTestImage())
+		assert.Equal(t, http.StatusInternalServerError, resp.Code)
+		assert.Contains(t, resp.Body.String(), "SAVE_FAILED")
+		kept, ok := h.DB.Image(original.ID)
+		require.True(t, ok)
+		assert.Equal(t, replaced.Hash, kept.Hash)
+		assert.Equal(t, replaced.ContentHash, kept.ContentHash)
+		require.NoError(t, os.RemoveAll(path))
+		require.NoError(t, os.Rename(path+".bak", path))
+
+		assert.Equal(t, http.StatusNotFound, put("missing", createNoiseImage()).Code)
+	})
+
+	t.Run("TestHashHandler", func(t *testing.T) {
+		h := newHandler()
+		router := api.Router(h)
+
+		for query, wantFeatures := range map[string]bool{"": false, "?features=true": true} {
+			resp := postImage(t, router, "/hash"+query, nil, imageUpload("query.png", createTestImage()))
+			var response struct {
+				DCTHash  string    `json:"dct_hash"`
+				Length   int       `json:"length"`
+				Features []float64 `json:"features"`
+			}
+			assert.Equal(t, http.StatusOK, resp.Code)
+			assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &response))
+			assert.Equal(t, len(response.DCTHash), response.Length)
+			assert.Equal(t, wantFeatures, len(response.Features) > 0, query)
+		}
+		assert.Empty(t, h.DB.Hashes)
+	})
+
+	t.Run("TestRecognizeWebhook", func(t *testing.T) {
+		events := make(chan webhook.Event, 1)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var event webhook.Event
+			json.NewDecoder(r.Body).Decode(&event)
+			events <- event
+		}))
+		defer server.Close()
+
+		h := newHandler()
+		cfg := testConfig()
+		cfg.WebhookURL = server.URL
+		assert.NoError(t, h.SetConfig(cfg))
+		h.Webhooks = webhook.NewNotifier(h.WebhookSettings)
+		addImage(h, "webhook.png", t)
+
+		req := multipartRequest(t, "POST", "/recognize", nil, imageUpload("query.png", createTestImage()))
+		req.Header.Set("X-Request-ID", "abc123")
+		resp := httptest.NewRecorder()
+		api.Router(h).ServeHTTP(resp, req)
+		assert.Equal(t, "abc123", resp.Header().Get("X-Request-ID"))
+
+		select {
+		case event := <-events:
+			assert.Equal(t, "abc123", event.RequestID)
+			assert.Contains(t, event.MatchedImage, "webhook.png")
+		case <-time.After(5 * time.Second):
+			t.Fatal("webhook was not called")
+		}
+	})
+
+	t.Run("TestAsyncDuplicatesJob", func(t *testing.T) {
+		h := newHandler()
+		h.Jobs = jobs.NewManager(1, time.Minute)
+		router := api.Router(h)
+
+		req, _ := http.NewRequest("GET", "/admin/duplicates?async=true", nil)
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		assert.Equal(t, http.StatusAccepted, resp.Code)
+		var accepted struct {
+			JobID     string `json:"job_id"`
+			StatusURL string `json:"status_url"`
+		}
+		assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &accepted))
+
+		var job jobs.Job
+		for i := 0; i < 100 && job.Status != jobs.StatusDone; i++ {
+			time.Sleep(5 * time.Millisecond)
+			req, _ = http.NewRequest("GET", accepted.StatusURL, nil)
+			resp = httptest.NewRecorder()
+			router.ServeHTTP(resp, req)
+			assert.Equal(t, http.StatusOK, resp.Code)
+			assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &job))
+		}
+		assert.Equal(t, jobs.StatusDone, job.Status)
+		assert.Equal(t, "duplicates", job.Kind)
+	})
+
+	t.Run("TestGzipListOnly", func(t *testing.T) {
+		h := newHandler()
+		addImage(h, "gzip.png", t)
+		router := api.Router(h)
+
+		req, _ := http.NewRequest("GET", "/admin/list", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		assert.Equal(t, "gzip", resp.Header().Get("Content-Encoding"))
+		reader, err := gzip.NewReader(resp.Body)
+		assert.NoError(t, err)
+		var listed map[string]any
+		assert.NoError(t, json.NewDecoder(reader).Decode(&listed))
+		assert.EqualValues(t, 1, listed["total"])
+
+		images := listed["images"].([]any)
+		filename := images[0].(map[string]any)["filename"].(string)
+		req, _ = http.NewRequest("GET", "/admin/image/"+filename+"/thumbnail", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		resp = httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		assert.Equal(t, http.StatusOK, resp.Code)
+		assert.Empty(t, resp.Header().Get("Content-Encoding"))
+	})
+
+	t.Run("TestSchemaVersionHeader", func(t *testing.T) {
+		resp := postImage(t, api.Router(newHandler()), "/recognize", nil, imageUpload("query.png", createTestImage()))
+		version := strconv.Itoa(database.SchemaVersion)
+		assert.Equal(t, http.StatusOK, resp.Code)
+		assert.Equal(t, version, resp.Header().Get("X-Schema-Version"))
+		assert.Contains(t, resp.Body.String(), `"schema_version":`+version)
+	})
+
+	t.Run("TestConcurrencyLimiter", func(t *testing.T) {
+		h := newHandler()
+		limiter := middleware.NewConcurrencyLimiter(func() (int, int) { return 1, 1 })
+		h.Recognitions = limiter
+		release := make(chan struct{})
+		router := gin.New()
+		router.GET("/slow", limiter.Middleware(), func(c *gin.Context) {
+			<-release
+			c.Status(http.StatusOK)
+		})
+		router.GET("/health", h.HealthHandler)
+
+		serve := func() *httptest.ResponseRecorder {
+			req, _ := http.NewRequest("GET", "/slow", nil)
+			resp := httptest.NewRecorder()
+			router.ServeHTTP(resp, req)
+			return resp
+		}
+		results := make(chan int, 2)
+		for i := 0; i < 2; i++ {
+			go func() { results <- serve().Code }()
+			assert.Eventually(t, func() bool {
+				inFlight, queued := limiter.Stats()
+				return inFlight+queued == i+1
+			}, time.Second, time.Millisecond)
+		}
+
+		// One request runs and one waits, so a third is turned away
+		resp := serve()
+		assert.Equal(t, http.StatusServiceUnavailable, resp.Code)
+		assert.Contains(t, resp.Body.String(), "SERVER_BUSY")
+		assert.Equal(t, "1", resp.Header().Get("Retry-After"))
+
+		req, _ := http.NewRequest("GET", "/health", nil)
+		resp = httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		assert.Contains(t, resp.Body.String(), `"recognize":{"in_flight":1,"queued":1}`)
+
+		close(release)
+		assert.Equal(t, http.StatusOK, <-results)
+		assert.Equal(t, http.StatusOK, <-results)
+		inFlight, queued := limiter.Stats()
+		assert.Equal(t, [2]int{0, 0}, [2]int{inFlight, queued})
+
+		// A weighted request takes its slots, capped at the limit, so a
+		// single request waits behind it
+		limiter = middleware.NewConcurrencyLimiter(func() (int, int) { return 2, 1 })
+		release = make(chan struct{})
+		router = gin.New()
+		router.GET("/batch", limiter.Weighted(func() int { return 4 }), func(c *gin.Context) {
+			<-release
+			c.Status(http.StatusOK)
+		})
+		router.GET("/slow", limiter.Middleware(), func(c *gin.Context) { c.Status(http.StatusOK) })
+		for queued, path := range []string{"/batch", "/slow"} {
+			go func() {
+				req, _ := http.NewRequest("GET", path, nil)
+				resp := httptest.NewRecorder()
+				router.ServeHTTP(resp, req)
+				results <- resp.Code
+			}()
+			assert.Eventually(t, func() bool {
+				inFlight, waiting := limiter.Stats()
+				return inFlight == 2 && waiting == queued
+			}, time.Second, time.Millisecond, path)
+		}
+		close(release)
+		assert.Equal(t, http.StatusOK, <-results)
+		assert.Equal(t, http.StatusOK, <-results)
+		inFlight, queued = limiter.Stats()
+		assert.Equal(t, [2]int{0, 0}, [2]int{inFlight, queued})
+	})
+
+	t.Run("TestConcurrencyLimiterSlowUpload", func(t *testing.T) {
+		h := newHandler()
+		addImage(h, "slow_upload_ref.png", t)
+		h.Recognitions = middleware.NewConcurrencyLimiter(func() (int, int) { return 1, 0 })
+		router := api.Router(h)
+
+		// An upload that stalls halfway holds no slot while its body is read
+		reader, writer := io.Pipe()
+		form := multipart.NewWriter(writer)
+		stalled := make(chan int)
+		go func() {
+			req, _ := http.NewRequest("POST", "/recognize", reader)
+			req.Header.Set("Content-Type", form.FormDataContentType())
+			resp := httptest.NewRecorder()
+			router.ServeHTTP(resp, req)
+			stalled <- resp.Code
+		}()
+		part, _ := form.CreateFormFile("image", "slow.png")
+		part.Write(pngBytes(createTestImage())[:100])
+
+		inFlight, _ := h.Recognitions.Stats()
+		assert.Zero(t, inFlight)
+		assert.Equal(t, http.StatusOK, postImage(t, router, "/recognize", nil, imageUpload("query.png", createTestImage())).Code)
+
+		writer.CloseWithError(io.ErrUnexpectedEOF)
+		assert.Equal(t, http.StatusBadRequest, <-stalled)
+	})
+
+	t.Run("TestMetadata", func(t *testing.T) {
+		h := newHandler()
+		router := api.Router(h)
+
+		post := func(filename string, data []byte) map[string]any {
+			resp := postImage(t, router, "/metadata", nil, upload{"image", filename, data})
+			require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+
+			var response map[string]any
+			require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &response))
+			return response
+		}
+
+		// No EXIF is an empty object, not an error
+		response := post("plain.png", pngBytes(createTestImage()))
+		assert.Equal(t, "png", response["format"])
+		assert.Equal(t, float64(100), response["width"])
+		assert.Equal(t, map[string]any{}, response["exif"])
+
+		response = post("photo.jpg", exifJPEG(t))
+		assert.Equal(t, "jpeg", response["format"])
+		exif := response["exif"].(map[string]any)
+		assert.Equal(t, "Canon", exif["make"])
+		assert.Equal(t, "EOS 5D", exif["model"])
+		assert.Contains(t, exif["taken_at"], "2024-03-01T10:20:30")
+		assert.Equal(t, map[string]any{"latitude": 41.5, "longitude": -69.25}, exif["gps"])
+
+		cfg := testConfig()
+		cfg.MetadataStripGPS = true
+		require.NoError(t, h.SetConfig(cfg))
+		exif = post("photo.jpg", exifJPEG(t))["exif"].(map[string]any)
+		assert.Equal(t, "Canon", exif["make"])
+		assert.NotContains(t, exif, "gps")
+	})
+
+	t.Run("TestVersion", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "/version", nil)
+		resp := httptest.NewRecorder()
+		api.Router(newHandler()).ServeHTTP(resp, req)
+
+		require.Equal(t, http.StatusOK, resp.Code)
+		var body map[string]any
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &body))
+		assert.Equal(t, version.Version, body["version"])
+		assert.NotEmpty(t, body["commit"])
+		assert.NotEmpty(t, body["build_time"])
+		assert.Equal(t, true, body["ml_loaded"])
+	})
+
+	t.Run("TestRecoveryMiddleware", func(t *testing.T) {
+		router := gin.New()
+		router.Use(middleware.RequestID(), middleware.Recovery())
+		router.GET("/panic", func(c *gin.Context) { panic("boom") })
+
+		req, _ := http.NewRequest("GET", "/panic", nil)
+		req.Header.Set("X-Request-ID", "req-123")
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusInternalServerError, resp.Code)
+		var body map[string]string
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &body))
+		assert.Equal(t, "INTERNAL_ERROR", body["error_code"])
+		assert.Equal(t, "req-123", body["request_id"])
+	})
 }
 
 // Yordamchi funksiyalar
+
+// exifEntry is one tag of a little-endian TIFF IFD
+type exifEntry struct {
+	tag, kind uint16
+	count     uint32
+	value     []byte
+}
+
+// exifIFD lays out an IFD starting at offset with its out-of-line values
+// right after it
+func exifIFD(offset int, entries []exifEntry) []byte {
+	var head, values bytes.Buffer
+	valuesAt := offset + 2 + 12*len(entries) + 4
+	binary.Write(&head, binary.LittleEndian, uint16(len(entries)))
+	for _, e := range entries {
+		binary.Write(&head, binary.LittleEndian, e.tag)
+		binary.Write(&head, binary.LittleEndian, e.kind)
+		binary.Write(&head, binary.LittleEndian, e.count)
+		if len(e.value) <= 4 {
+			head.Write(append(e.value, make([]byte, 4-len(e.value))...))
+			continue
+		}
+		binary.Write(&head, binary.LittleEndian, uint32(valuesAt+values.Len()))
+		values.Write(e.value)
+	}
+	binary.Write(&head, binary.LittleEndian, uint32(0))
+	return append(head.Bytes(), values.Bytes()...)
+}
+
+// exifJPEG returns a JPEG whose EXIF names a Canon EOS 5D, a capture time
+// and a GPS position of 41.5N 69.25W
+func exifJPEG(t *testing.T) []byte {
+	rationals := func(values ...uint32) []byte {
+		var b bytes.Buffer
+		for _, v := range values {
+			binary.Write(&b, binary.LittleEndian, [2]uint32{v, 100})
+		}
+		return b.Bytes()
+	}
+	ifd0 := func(gpsAt uint32) []byte {
+		pointer := binary.LittleEndian.AppendUint32(nil, gpsAt)
+		return exifIFD(8, []exifEntry{
+			{0x010f, 2, 6, []byte("Canon\x00")},
+			{0x0110, 2, 7, []byte("EOS 5D\x00")},
+			{0x0132, 2, 20, []byte("2024:03:01 10:20:30\x00")},
+			{0x8825, 4, 1, pointer},
+		})
+	}
+	gpsAt := 8 + len(ifd0(0))
+	gps := exifIFD(gpsAt, []exifEntry{
+		{0x0001, 2, 2, []byte("N\x00")},
+		{0x0002, 5, 3, rationals(4100, 3000, 0)},
+		{0x0003, 2, 2, []byte("W\x00")},
+		{0x0004, 5, 3, rationals(6900, 1500, 0)},
+	})
+	tiff := append([]byte("II*\x00\x08\x00\x00\x00"), ifd0(uint32(gpsAt))...)
+	tiff = append(tiff, gps...)
+
+	var encoded bytes.Buffer
+	require.NoError(t, imaging.Encode(&encoded, createTestImage(), imaging.JPEG))
+	segment := append([]byte("Exif\x00\x00"), tiff...)
+	app1 := binary.BigEndian.AppendUint16([]byte{0xff, 0xe1}, uint16(2+len(segment)))
+	jpeg := append([]byte{}, encoded.Bytes()[:2]...)
+	jpeg = append(append(jpeg, app1...), segment...)
+	return append(jpeg, encoded.Bytes()[2:]...)
+}
 func createTestImage() image.Image {
 	img := image.NewRGBA(image.Rect(0, 0, 100, 100))
 	for y := 0; y < 100; y++ {
@@ -129,6 +1615,66 @@ func createTestImage() image.Image {
 	return img
 }
 
+func pngBytes(img image.Image) []byte {
+	var buf bytes.Buffer
+	imaging.Encode(&buf, img, imaging.PNG)
+	return buf.Bytes()
+}
+
+// upload is one file part of a multipart form
+type upload struct {
+	field, filename string
+	data            []byte
+}
+
+// imageUpload is img as a PNG in the "image" field most endpoints read
+func imageUpload(filename string, img image.Image) upload {
+	return upload{"image", filename, pngBytes(img)}
+}
+
+// multipartRequest builds a request whose body is a multipart form holding
+// fields and files
+func multipartRequest(t *testing.T, method, path string, fields map[string]string, files ...upload) *http.Request {
+	t.Helper()
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	for _, file := range files {
+		part, err := writer.CreateFormFile(file.field, file.filename)
+		require.NoError(t, err)
+		part.Write(file.data)
+	}
+	for field, value := range fields {
+		require.NoError(t, writer.WriteField(field, value))
+	}
+	require.NoError(t, writer.Close())
+
+	req, err := http.NewRequest(method, path, body)
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	return req
+}
+
+// postImage serves a multipart POST through router
+func postImage(t *testing.T, router http.Handler, path string, fields map[string]string, files ...upload) *httptest.ResponseRecorder {
+	t.Helper()
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, multipartRequest(t, http.MethodPost, path, fields, files...))
+	return resp
+}
+
+func createNoiseImage() image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	seed := uint32(42)
+	for y := 0; y < 100; y++ {
+		for x := 0; x < 100; x++ {
+			seed = seed*1664525 + 1013904223
+			v := uint8(seed >> 24)
+			img.Set(x, y, color.RGBA{R: v, G: 255 - v, B: v / 2, A: 255})
+		}
+	}
+	return img
+}
+
 func addImage(h *handler.Handler, filename string, t *testing.T) {
 	img := createTestImage()
 
@@ -0,0 +1,285 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// Config holds runtime settings, each overridable by the environment
+// variable named in its env tag. Fields tagged reload:"hot" can be changed
+// on a running server; all others require a restart. Fields tagged
+// secret:"true" are redacted by Settings.
+type Config struct {
+	Addr     string `env:"PHOTOT_ADDR"`      // Listen address
+	ImageDir string `env:"PHOTOT_IMAGE_DIR"` // Directory holding reference images
+
+	LogLevel string `env:"PHOTOT_LOG_LEVEL" reload:"hot"` // Least severe messages logged: error, warn, info or debug
+
+	LoadWorkers int `env:"PHOTOT_LOAD_WORKERS"` // Images decoded at once while loading ImageDir at startup
+
+	SyncImageWrites bool `env:"PHOTOT_SYNC_IMAGE_WRITES" reload:"hot"` // fsync stored images before renaming them into ImageDir
+
+	DefaultThreshold  float64 `env:"PHOTOT_DEFAULT_THRESHOLD" reload:"hot"`   // Similarity threshold when the request has none
+	MLTimeoutMs       int     `env:"PHOTOT_ML_TIMEOUT_MS" reload:"hot"`       // Deadline for the ML branch before falling back to hashing, 0 disables
+	MLWeight          float64 `env:"PHOTOT_ML_WEIGHT" reload:"hot"`           // Weight of ML similarity in the combined score
+	HashWeight        float64 `env:"PHOTOT_HASH_WEIGHT" reload:"hot"`         // Weight of hash similarity in the combined score
+	EqualizeFeatures  bool    `env:"PHOTOT_EQUALIZE_FEATURES"`                // Histogram-equalize before HOG extraction
+	Quantization      string  `env:"PHOTOT_FEATURE_QUANTIZATION"`             // Stored feature precision: none, float16 or int8
+	MinImageDimension int     `env:"PHOTOT_MIN_IMAGE_DIMENSION" reload:"hot"` // Smallest accepted width/height in pixels
+	MaxImagePixels    int     `env:"PHOTOT_MAX_IMAGE_PIXELS" reload:"hot"`    // Largest accepted width*height, 0 disables the check
+
+	WaveletWeight float64 `env:"PHOTOT_WAVELET_WEIGHT" reload:"hot"` // Share (0-1) of hash similarity taken from the wavelet hash
+
+	ConfidenceHigh   float64 `env:"PHOTOT_CONFIDENCE_HIGH" reload:"hot"`   // Similarity at which a match is labelled high confidence
+	ConfidenceMedium float64 `env:"PHOTOT_CONFIDENCE_MEDIUM" reload:"hot"` // Similarity at which a match is labelled medium confidence
+
+	ColorFeatures bool `env:"PHOTOT_COLOR_FEATURES"` // Append a color histogram to ML features
+
+	FeatureExtractor string `env:"PHOTOT_FEATURE_EXTRACTOR"` // ML feature vectors: hog for photographs, edge for documents and line art
+
+	ExtraImageFormats []string `env:"PHOTOT_EXTRA_IMAGE_FORMATS"` // .ext=format pairs accepting formats registered with the image package
+
+	StorageLayout string `env:"PHOTOT_STORAGE_LAYOUT"` // Where new files go: flat by filename, or content by SHA-256 in shard directories
+
+	BackgroundColor string `env:"PHOTOT_BACKGROUND_COLOR"` // #rrggbb that transparent pixels are flattened onto before hashing
+
+	LSHTables int `env:"PHOTOT_LSH_TABLES"` // LSH tables narrowing the ML scan, 0 compares every vector
+
+	HashSize int `env:"PHOTOT_HASH_SIZE"` // Side in pixels of the grayscale copy DCT hashes are computed from
+	HashGrid int `env:"PHOTOT_HASH_GRID"` // Blocks per side of the DCT hash grid; larger grids give longer hashes
+
+	GrayWeights string `env:"PHOTOT_GRAY_WEIGHTS"` // r,g,b weights of the grayscale copy DCT hashes use, empty for standard luminance
+
+	MaxTiles int `env:"PHOTOT_MAX_TILES" reload:"hot"` // Windows a mode=tiled recognize may hash
+
+	MinBlockFraction float64 `env:"PHOTOT_MIN_BLOCK_FRACTION" reload:"hot"` // Share of blocks that must agree for a mode=robust match
+
+	MinContrast float64 `env:"PHOTOT_MIN_CONTRAST" reload:"hot"` // Luminance standard deviation below which images count as solid, 0 disables the check
+
+	ThumbnailWidth  int    `env:"PHOTOT_THUMBNAIL_WIDTH" reload:"hot"`  // Width of stored thumbnails in pixels
+	ThumbnailFormat string `env:"PHOTOT_THUMBNAIL_FORMAT" reload:"hot"` // Thumbnail encoding: jpeg, png or webp
+
+	ThumbnailQuality int `env:"PHOTOT_THUMBNAIL_QUALITY" reload:"hot"` // JPEG and WebP thumbnail quality, 1-100
+
+	// Resize filters: nearest, box, linear, catmullrom or lanczos
+	HashResizeFilter      string `env:"PHOTOT_HASH_RESIZE_FILTER"`                   // Downscale to the hash and feature grids
+	ThumbnailResizeFilter string `env:"PHOTOT_THUMBNAIL_RESIZE_FILTER" reload:"hot"` // Downscale to thumbnails
+
+	AdminAPIKey       string `env:"PHOTOT_ADMIN_API_KEY" reload:"hot" secret:"true"` // Required X-API-Key for /admin routes; unset refuses them
+	AdminAuthDisabled bool   `env:"PHOTOT_ADMIN_AUTH_DISABLED" reload:"hot"`         // Leave /admin routes open, for local development
+
+	MaxBodyMB int `env:"PHOTOT_MAX_BODY_MB" reload:"hot"` // Largest request body in MB, 0 disables the cap
+
+	// CORS policy for browser clients; no origin is allowed by default
+	CORSAllowedOrigins   []string `env:"PHOTOT_CORS_ALLOWED_ORIGINS" reload:"hot"`   // Origins as scheme://host[:port], or *
+	CORSAllowedMethods   []string `env:"PHOTOT_CORS_ALLOWED_METHODS" reload:"hot"`   // Methods allowed in preflight answers
+	CORSAllowedHeaders   []string `env:"PHOTOT_CORS_ALLOWED_HEADERS" reload:"hot"`   // Request headers allowed in preflight answers
+	CORSAllowCredentials bool     `env:"PHOTOT_CORS_ALLOW_CREDENTIALS" reload:"hot"` // Allow cookies and HTTP auth, never with *
+
+	CacheMaxEntries int `env:"PHOTOT_CACHE_MAX_ENTRIES" reload:"hot"` // Recognize results cached per database, 0 disables the cache
+
+	RateLimitRPS   float64 `env:"PHOTOT_RATE_LIMIT_RPS" reload:"hot"`   // Recognize requests per second per IP, 0 disables
+	RateLimitBurst int     `env:"PHOTOT_RATE_LIMIT_BURST" reload:"hot"` // Requests an IP may make at once
+
+	TrustedProxies []string `env:"PHOTOT_TRUSTED_PROXIES"` // IPs or CIDRs whose X-Forwarded-For names the client; none by default
+
+	MaxConcurrentRecognize int `env:"PHOTOT_MAX_CONCURRENT_RECOGNIZE" reload:"hot"` // Recognize/hash requests processed at once, 0 disables the limit
+	RecognizeQueueDepth    int `env:"PHOTOT_RECOGNIZE_QUEUE_DEPTH" reload:"hot"`    // Requests that may wait for a slot before 503s, 0 rejects at once
+
+	BatchWorkers int `env:"PHOTOT_BATCH_WORKERS" reload:"hot"`  // Images of a /recognize/batch, or pairs of a /compare/matrix, handled at once
+	MaxBatchSize int `env:"PHOTOT_MAX_BATCH_SIZE" reload:"hot"` // Most images a /recognize/batch request may carry
+
+	MaxStreamBatchSize int `env:"PHOTOT_MAX_STREAM_BATCH_SIZE" reload:"hot"` // Most images a /recognize/batch/stream request may carry
+
+	MaxMatrixSize int `env:"PHOTOT_MAX_MATRIX_SIZE" reload:"hot"` // Most images a /compare/matrix request may carry
+
+	MetadataStripGPS bool `env:"PHOTOT_METADATA_STRIP_GPS" reload:"hot"` // Omit EXIF GPS coordinates from /metadata responses
+
+	MaxTenants int `env:"PHOTOT_MAX_TENANTS"` // Tenant databases kept in memory, 0 disables the X-Tenant header
+
+	JobWorkers    int `env:"PHOTOT_JOB_WORKERS"`     // Background jobs run at once, 0 disables async jobs
+	JobTTLMinutes int `env:"PHOTOT_JOB_TTL_MINUTES"` // How long finished jobs stay queryable
+
+	RecencyBoost         float64 `env:"PHOTOT_RECENCY_BOOST" reload:"hot"`           // Ranking bonus for new images among matches, 0 disables
+	RecencyHalfLifeHours float64 `env:"PHOTOT_RECENCY_HALF_LIFE_HOURS" reload:"hot"` // Age at which the recency bonus halves
+
+	WebhookURL           string  `env:"PHOTOT_WEBHOOK_URL" reload:"hot"`                  // Receives a POST for each confident match, empty disables
+	WebhookSecret        string  `env:"PHOTOT_WEBHOOK_SECRET" reload:"hot" secret:"true"` // HMAC-SHA256 key for the X-Photot-Signature header
+	WebhookMinSimilarity float64 `env:"PHOTOT_WEBHOOK_MIN_SIMILARITY" reload:"hot"`       // Similarity a match needs to trigger the webhook
+}
+
+// Default returns the built-in configuration
+func Default() *Config {
+	return &Config{
+		Addr:              ":8080",
+		LogLevel:          "info",
+		ImageDir:          "./images",
+		DefaultThreshold:  85.0,
+		MLTimeoutMs:       2000,
+		Quantization:      "none",
+		FeatureExtractor:  "hog",
+		StorageLayout:     "flat",
+		MinImageDimension: 16,
+		MaxImagePixels:    40_000_000,
+		ThumbnailWidth:    100,
+		ThumbnailFormat:   "jpeg",
+		RateLimitRPS:      5,
+		RateLimitBurst:    10,
+		MaxTenants:        16,
+		JobWorkers:        2,
+		JobTTLMinutes:     60,
+
+		RecencyHalfLifeHours: 24,
+		WebhookMinSimilarity: 95,
+
+		MaxConcurrentRecognize: runtime.NumCPU(),
+		RecognizeQueueDepth:    32,
+
+		LoadWorkers: 4,
+		MaxTiles:    256,
+
+		ThumbnailQuality: 95,
+
+		HashResizeFilter:      "linear",
+		ThumbnailResizeFilter: "lanczos",
+
+		HashSize: 32,
+		HashGrid: 4,
+
+		ConfidenceHigh:   95,
+		ConfidenceMedium: 85,
+
+		BatchWorkers: 4,
+		MaxBatchSize: 32,
+
+		MaxStreamBatchSize: 4096,
+
+		SyncImageWrites: true,
+		BackgroundColor: "#ffffff",
+
+		MaxBodyMB: 64,
+
+		CORSAllowedMethods: []string{"GET", "POST", "PUT", "DELETE"},
+		CORSAllowedHeaders: []string{"Content-Type", "Accept", "Accept-Language", "If-None-Match", "X-API-Key", "X-Tenant", "X-Request-ID"},
+
+		CacheMaxEntries: 1000,
+
+		MinBlockFraction: 0.6,
+		MinContrast:      2,
+
+		MaxMatrixSize: 32,
+	}
+}
+
+// Load returns the default configuration overridden by environment variables
+func Load() (*Config, error) {
+	cfg := Default()
+	v := reflect.ValueOf(cfg).Elem()
+	t := v.Type()
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := field.Tag.Get("env")
+		raw, ok := os.LookupEnv(name)
+		if name == "" || !ok {
+			continue
+		}
+		if err := setField(v.Field(i), strings.TrimSpace(raw)); err != nil {
+			return nil, fmt.Errorf("invalid %s: %v", name, err)
+		}
+	}
+	return cfg, nil
+}
+
+// Merge returns a copy of current with the hot-reloadable settings taken from
+// next, along with the env names of changed settings split into those applied
+// and those that need a restart to take effect
+func Merge(current, next *Config) (*Config, []string, []string) {
+	merged := *current
+	mv := reflect.ValueOf(&merged).Elem()
+	nv := reflect.ValueOf(next).Elem()
+	t := mv.Type()
+
+	applied := []string{}
+	restart := []string{}
+	for i := 0; i < t.NumField(); i++ {
+		if reflect.DeepEqual(mv.Field(i).Interface(), nv.Field(i).Interface()) {
+			continue
+		}
+		field := t.Field(i)
+		if field.Tag.Get("reload") != "hot" {
+			restart = append(restart, field.Tag.Get("env"))
+			continue
+		}
+		mv.Field(i).Set(nv.Field(i))
+		applied = append(applied, field.Tag.Get("env"))
+	}
+	return &merged, applied, restart
+}
+
+// Redacted stands in for the value of a secret setting that is set
+const Redacted = "[redacted]"
+
+// Setting is the value of one setting and whether it is hot-reloadable
+type Setting struct {
+	Value any  `json:"value"`
+	Hot   bool `json:"hot"`
+}
+
+// Settings returns every setting of cfg keyed by its env name. Secret
+// settings that are set read Redacted, so the result is safe to serve.
+func Settings(cfg *Config) map[string]Setting {
+	v := reflect.ValueOf(cfg).Elem()
+	t := v.Type()
+	settings := make(map[string]Setting, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		value := v.Field(i).Interface()
+		if field.Tag.Get("secret") == "true" && !v.Field(i).IsZero() {
+			value = Redacted
+		}
+		settings[field.Tag.Get("env")] = Setting{Value: value, Hot: field.Tag.Get("reload") == "hot"}
+	}
+	return settings
+}
+
+// setField parses raw into the field according to its kind
+func setField(field reflect.Value, raw string) error {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(raw)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return err
+		}
+		field.SetInt(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return err
+		}
+		field.SetFloat(f)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case reflect.Slice:
+		var items []string
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		field.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported config type %s", field.Kind())
+	}
+	return nil
+}
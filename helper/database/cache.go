@@ -0,0 +1,131 @@
+package database
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// Defaults of the result cache every database starts with
+const (
+	DefaultCacheTTL        = 5 * time.Minute
+	DefaultCacheMaxEntries = 1000
+)
+
+// ResultCache is a least-recently-used cache whose entries also expire after
+// a fixed TTL. It holds at most its max entries, evicting the least recently
+// read or added one to make room, so diverse traffic cannot grow it without
+// bound. It is safe for concurrent use.
+type ResultCache struct {
+	mu         sync.Mutex
+	ttl        time.Duration
+	maxEntries int
+	order      *list.List // Front is the most recently used
+	entries    map[string]*list.Element
+	hits       uint64
+	misses     uint64
+}
+
+// cacheEntry is one cached value
+type cacheEntry struct {
+	key     string
+	value   any
+	expires time.Time
+}
+
+// CacheStats counts a cache's entries and lookups
+type CacheStats struct {
+	Entries    int    `json:"entries"`
+	MaxEntries int    `json:"max_entries"`
+	Hits       uint64 `json:"hits"`
+	Misses     uint64 `json:"misses"`
+}
+
+// NewResultCache creates a cache; a maxEntries of 0 or less disables caching
+func NewResultCache(ttl time.Duration, maxEntries int) *ResultCache {
+	return &ResultCache{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		order:      list.New(),
+		entries:    make(map[string]*list.Element),
+	}
+}
+
+// Get returns the value cached under key, marking it recently used
+func (c *ResultCache) Get(key string) (any, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[key]
+	if ok && time.Now().After(elem.Value.(*cacheEntry).expires) {
+		c.remove(elem)
+		ok = false
+	}
+	if !ok {
+		c.misses++
+		return nil, false
+	}
+	c.hits++
+	c.order.MoveToFront(elem)
+	return elem.Value.(*cacheEntry).value, true
+}
+
+// Add caches value under key unless an unexpired value is already there,
+// evicting the least recently used entry when the cache is full
+func (c *ResultCache) Add(key string, value any) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.maxEntries <= 0 {
+		return
+	}
+	now := time.Now()
+	if elem, ok := c.entries[key]; ok {
+		if now.Before(elem.Value.(*cacheEntry).expires) {
+			return
+		}
+		c.remove(elem)
+	}
+	c.entries[key] = c.order.PushFront(&cacheEntry{key: key, value: value, expires: now.Add(c.ttl)})
+	c.trim()
+}
+
+// SetMaxEntries changes how many entries the cache holds, evicting the least
+// recently used ones that no longer fit
+func (c *ResultCache) SetMaxEntries(maxEntries int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.maxEntries = maxEntries
+	c.trim()
+}
+
+// Flush removes every entry; the hit and miss counts are kept
+func (c *ResultCache) Flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.order.Init()
+	c.entries = make(map[string]*list.Element)
+}
+
+// TTL returns how long entries are kept
+func (c *ResultCache) TTL() time.Duration {
+	return c.ttl
+}
+
+// Stats returns the current entry count and the lookups since creation
+func (c *ResultCache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return CacheStats{Entries: len(c.entries), MaxEntries: c.maxEntries, Hits: c.hits, Misses: c.misses}
+}
+
+// trim evicts from the back until the cache fits. The caller must hold mu.
+func (c *ResultCache) trim() {
+	for len(c.entries) > max(c.maxEntries, 0) {
+		c.remove(c.order.Back())
+	}
+}
+
+// remove drops elem. The caller must hold mu.
+func (c *ResultCache) remove(elem *list.Element) {
+	c.order.Remove(elem)
+	delete(c.entries, elem.Value.(*cacheEntry).key)
+}
@@ -0,0 +1,212 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"path/filepath"
+	"sync"
+
+	im "photot/helper/image"
+)
+
+// Methods Compare scores two images by
+const (
+	CompareML   = "ml"   // HOG feature vectors under the configured metric
+	CompareHash = "hash" // DCT hashes
+	CompareSSIM = "ssim" // Structural similarity of grayscale copies
+)
+
+// CompareResponse is the body returned by /compare and /compare-to
+type CompareResponse struct {
+	SchemaVersion        int     `json:"schema_version"`
+	Similarity           float64 `json:"similarity"`
+	SimilarityNormalized float64 `json:"similarity_normalized"`
+	Method               string  `json:"method"`
+	ProcessingTimeMs     int64   `json:"processing_time_ms"`
+
+	// The stored image compared against, set by /compare-to only
+	ID       string `json:"id,omitempty"`
+	Filename string `json:"filename,omitempty"`
+}
+
+// ErrNotComparable is returned by CompareStored when the stored image lacks
+// what the method compares
+var ErrNotComparable = errors.New("stored image cannot be compared by this method")
+
+// ParseCompareMethod validates a method name, defaulting to the one FindMatch
+// leads with: ML when it is on, hashing otherwise
+func (db *ImageDatabase) ParseCompareMethod(method string) (string, error) {
+	switch method {
+	case "":
+		if db.UsesML() {
+			return CompareML, nil
+		}
+		return CompareHash, nil
+	case CompareML, CompareHash, CompareSSIM:
+		return method, nil
+	}
+	return "", fmt.Errorf("method must be ml, hash or ssim, got %q", method)
+}
+
+// Compare returns the 0-100 similarity of two images by method, using the
+// database's background, feature options, metric and hash config. Negative
+// SSIM, from inverted structure, is reported as 0.
+func (db *ImageDatabase) Compare(ctx context.Context, img1, img2 image.Image, method string) (float64, error) {
+	a, err := db.prepareCompare(ctx, img1, method)
+	if err != nil {
+		return 0, err
+	}
+	b, err := db.prepareCompare(ctx, img2, method)
+	if err != nil {
+		return 0, err
+	}
+	return db.comparePrepared(a, b, method)
+}
+
+// CompareMatrix returns the pairwise similarities of imgs by method, as
+// Compare scores them: entry [i][j] compares imgs[i] with imgs[j], and the
+// diagonal is 100. Each image is prepared once and only the upper triangle
+// is computed, workers pairs at a time, then mirrored.
+func (db *ImageDatabase) CompareMatrix(ctx context.Context, imgs []image.Image, method string, workers int) ([][]float64, error) {
+	prepared := make([]compareInput, len(imgs))
+	for i, img := range imgs {
+		var err error
+		if prepared[i], err = db.prepareCompare(ctx, img, method); err != nil {
+			return nil, fmt.Errorf("image %d: %w", i, err)
+		}
+	}
+
+	matrix := make([][]float64, len(imgs))
+	for i := range matrix {
+		matrix[i] = make([]float64, len(imgs))
+		matrix[i][i] = 100
+	}
+	pairs := make(chan [2]int)
+	errs := make(chan error, 1)
+	var wg sync.WaitGroup
+	for range max(workers, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for pair := range pairs {
+				i, j := pair[0], pair[1]
+				similarity, err := db.comparePrepared(prepared[i], prepared[j], method)
+				if err != nil {
+					select {
+					case errs <- fmt.Errorf("images %d and %d: %w", i, j, err):
+					default:
+					}
+					continue
+				}
+				// Each pair is written by one worker only
+				matrix[i][j], matrix[j][i] = similarity, similarity
+			}
+		}()
+	}
+	for i := range imgs {
+		for j := i + 1; j < len(imgs); j++ {
+			pairs <- [2]int{i, j}
+		}
+	}
+	close(pairs)
+	wg.Wait()
+
+	select {
+	case err := <-errs:
+		return nil, err
+	default:
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return matrix, nil
+}
+
+// CompareStored returns the 0-100 similarity of img to the stored image
+// info by method, as Compare scores two uploads. The ml and hash methods
+// compare against the stored vector and hash without reading the file; ssim
+// decodes the file from imageDir. It fails with ErrNotComparable when the
+// stored vector is pending or from another extractor, or the stored hash was
+// computed with another config.
+func (db *ImageDatabase) CompareStored(ctx context.Context, img image.Image, info ImageInfo, method, imageDir string) (float64, error) {
+	var stored compareInput
+	switch method {
+	case CompareML:
+		if info.featureLen() == 0 {
+			return 0, fmt.Errorf("%w: it has no feature vector yet", ErrNotComparable)
+		}
+		if info.featureType() != db.FeatureType() {
+			return 0, fmt.Errorf("%w: its feature vector is from the %s extractor", ErrNotComparable, info.featureType())
+		}
+		stored.features = info.FeatureVector()
+	case CompareHash:
+		db.Mutex.RLock()
+		config := db.hashConfig.ID()
+		db.Mutex.RUnlock()
+		if info.HashConfig != config {
+			return 0, fmt.Errorf("%w: its hash was computed with another hash config", ErrNotComparable)
+		}
+		stored.hash = info.Hash
+	case CompareSSIM:
+		ref, err := im.OpenImage(filepath.Join(imageDir, db.FilePath(info.Filename)))
+		if err != nil {
+			return 0, err
+		}
+		if stored, err = db.prepareCompare(ctx, ref, method); err != nil {
+			return 0, err
+		}
+	default:
+		return 0, fmt.Errorf("unknown compare method %q", method)
+	}
+
+	query, err := db.prepareCompare(ctx, img, method)
+	if err != nil {
+		return 0, err
+	}
+	if method == CompareML && len(query.features) != len(stored.features) {
+		return 0, fmt.Errorf("%w: %v", ErrNotComparable, errIncompatibleFeatures)
+	}
+	return db.comparePrepared(query, stored, method)
+}
+
+// compareInput is what an image is reduced to before comparing it by a method
+type compareInput struct {
+	features []float64
+	hash     string
+	plane    im.SSIMPlane
+}
+
+// prepareCompare flattens img and reduces it to what method compares
+func (db *ImageDatabase) prepareCompare(ctx context.Context, img image.Image, method string) (compareInput, error) {
+	img = db.flatten(img)
+	switch method {
+	case CompareML:
+		features, err := db.extractFeatures(ctx, img)
+		return compareInput{features: features}, err
+	case CompareHash:
+		hash, _ := db.dctHash(img)
+		return compareInput{hash: hash}, nil
+	case CompareSSIM:
+		return compareInput{plane: im.NewSSIMPlane(img)}, nil
+	}
+	return compareInput{}, fmt.Errorf("unknown compare method %q", method)
+}
+
+// comparePrepared scores two prepared images by method
+func (db *ImageDatabase) comparePrepared(a, b compareInput, method string) (float64, error) {
+	switch method {
+	case CompareML:
+		return im.FeatureSimilarity(a.features, b.features, db.Metric()), nil
+	case CompareHash:
+		distance, err := im.HammingDistance(a.hash, b.hash)
+		if err != nil {
+			return 0, err
+		}
+		return hashSimilarity(distance, len(a.hash)), nil
+	case CompareSSIM:
+		return max(a.plane.SSIM(b.plane), 0) * 100, nil
+	}
+	return 0, fmt.Errorf("unknown compare method %q", method)
+}
@@ -1,58 +1,502 @@
 package database
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"image"
-	"log"
+	"image/color"
+	"log/slog"
+	"math"
 	"os"
 	"path/filepath"
 	im "photot/helper/image"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/disintegration/imaging"
-	"github.com/patrickmn/go-cache"
 )
 
 // ImageDatabase stores image hashes and features for recognition
 type ImageDatabase struct {
-	Hashes map[string]imageInfo
+	Hashes map[string]ImageInfo // Keyed by perceptual hash
 	Mutex  sync.RWMutex
-	Cache  *cache.Cache
-	UseML  bool // Switch between ML or hash-based comparison
+	Cache  *ResultCache
+
+	contentHashes map[string]string              // SHA-256 of pixels -> filename, for exact duplicates
+	tags          map[string]map[string]struct{} // tag -> hashes of images carrying it
+	ids           map[string]string              // stable ID -> hash
+	thumbnail     im.ThumbnailOptions
+	metric        im.DistanceMetric
+	method        MatchMethod
+	features      im.FeatureOptions
+	quantization  im.QuantizationMode
+	hashConfig    im.HashConfig
+	version       uint64 // Bumped on every index change, see Version
+
+	// background is the color transparent pixels are flattened onto
+	background color.NRGBA
+
+	// extractor computes ML feature vectors; nil extracts HOG features with
+	// the options in features
+	extractor im.FeatureExtractor
+
+	// lsh narrows the ML branch to vectors sharing a bucket with the query;
+	// nil scans every vector
+	lsh *LSHIndex
+
+	// featureDim is the length of the first feature vector stored. Queries
+	// whose vectors differ skip the ML branch, logged once by dimMismatch.
+	featureDim  int
+	dimMismatch sync.Once
+
+	// pending counts the stored images whose features are pending
+	pending int
+
+	// layout is where StoreContent puts new files, see StorageLayout
+	layout StorageLayout
+
+	// files maps the logical filenames of content-addressed files to their
+	// paths relative to the image directory; flat files are not listed.
+	// storeMu guards it and serializes sidecar updates.
+	files   map[string]string
+	storeMu sync.Mutex
+}
+
+// ImageInfo contains metadata for stored images
+type ImageInfo struct {
+	ID          string    `json:"id"` // Stable ID, unaffected by the hash algorithm
+	Filename    string    `json:"filename"`
+	Hash        string    `json:"hash"`
+	HashConfig  string    `json:"hash_config"` // im.HashConfig.ID of the config Hash was computed with
+	ContentHash string    `json:"content_hash"`
+	Features    []float64 `json:"features,omitempty"` // ML feature vector, nil when stored quantized
+	AddedAt     time.Time `json:"added_at"`
+	Thumbnail   string    `json:"thumbnail,omitempty"`
+	Tags        []string  `json:"tags,omitempty"`
+
+	// Quantized replaces Features when vectors are stored at reduced precision
+	Quantized *im.QuantizedVector `json:"quantized_features,omitempty"`
+
+	// FeatureType names the extractor that produced the vector, as returned
+	// by im.FeatureTypeOf; empty in records from before it was kept, which
+	// hold HOG vectors
+	FeatureType string `json:"feature_type,omitempty"`
+
+	// WaveletHash is the Haar wavelet hash, blended into hash similarity by
+	// MatchOptions.WaveletWeight
+	WaveletHash string `json:"wavelet_hash"`
+
+	// Dimensions of the original image and its format, as named by
+	// im.SupportedImageFormats; unset in records from before they were kept
+	Width  int    `json:"width,omitempty"`
+	Height int    `json:"height,omitempty"`
+	Format string `json:"format,omitempty"`
+
+	// Aliases are the files of later uploads that collided with this image's
+	// hash and were recorded against it instead of being rejected, oldest
+	// first
+	Aliases []string `json:"aliases,omitempty"`
+
+	// FeaturesPending is set on images added with AddOptions.SkipFeatures
+	// until ExtractPending fills their vector in. Until then they are
+	// matched by hash alone.
+	FeaturesPending bool `json:"features_pending,omitempty"`
+
+	// BlockHashes are the DCT hashes of an im.BlockGrid grid over the image,
+	// compared one by one in occlusion-tolerant matching. They are left out
+	// of responses, which would grow by a kilobyte per image.
+	BlockHashes []string `json:"-"`
+}
+
+// imageFormat returns the format of a stored file from its extension, which
+// /admin/add has checked against the sniffed content
+func imageFormat(filename string) string {
+	return im.SupportedImageFormats[strings.ToLower(filepath.Ext(filename))]
+}
+
+// Files returns the image's filename followed by its aliases
+func (info ImageInfo) Files() []string {
+	return append([]string{info.Filename}, info.Aliases...)
+}
+
+// featureLen returns the length of the stored feature vector, 0 when there is none
+func (info ImageInfo) featureLen() int {
+	if info.Quantized != nil {
+		return info.Quantized.Len()
+	}
+	return len(info.Features)
+}
+
+// featureType returns the type of the stored feature vector
+func (info ImageInfo) featureType() string {
+	if info.FeatureType == "" {
+		return im.FeatureTypeHOG
+	}
+	return info.FeatureType
 }
 
-// imageInfo contains metadata for stored images
-type imageInfo struct {
-	Filename  string    `json:"filename"`
-	Hash      string    `json:"hash"`
-	Features  []float64 `json:"features,omitempty"` // ML feature vector
-	AddedAt   time.Time `json:"added_at"`
-	Thumbnail string    `json:"thumbnail,omitempty"`
+// FeatureVector returns the ML feature vector, dequantizing it if needed
+func (info ImageInfo) FeatureVector() []float64 {
+	if info.Quantized != nil {
+		return info.Quantized.Dequantize()
+	}
+	return info.Features
 }
 
-// RecognizeResponse structure for API responses
+// SchemaVersion identifies the response shape; increment it whenever
+// RecognizeResponse or other response bodies change
+const SchemaVersion = 26
+
+// RecognizeResponse structure for API responses. Similarity and
+// SimilarityNormalized are the same score on a 0-100 and a 0-1 scale.
 type RecognizeResponse struct {
-	Result           string  `json:"result"`
-	Similarity       float64 `json:"similarity"`
-	MatchedImage     string  `json:"matched_image,omitempty"`
-	ProcessingTimeMs int64   `json:"processing_time_ms"`
-	Method           string  `json:"method"` // "ml" or "hash"
+	SchemaVersion        int              `json:"schema_version"`
+	Result               string           `json:"result"`
+	Similarity           float64          `json:"similarity"`
+	SimilarityNormalized float64          `json:"similarity_normalized"`
+	Confidence           string           `json:"confidence"` // ConfidenceHigh, ConfidenceMedium, ConfidenceLow or ConfidenceNone
+	MatchedImage         string           `json:"matched_image,omitempty"`
+	ProcessingTimeMs     int64            `json:"processing_time_ms"`
+	Method               string           `json:"method"` // "ml", "hash", "combined", "strict" or "none"
+	Candidates           []MatchCandidate `json:"candidates,omitempty"`
+	Degraded             string           `json:"degraded,omitempty"`          // Set when ML timed out and hashing decided
+	MLSimilarity         *float64         `json:"ml_similarity,omitempty"`     // Best ML score, when both branches ran
+	HashSimilarity       *float64         `json:"hash_similarity,omitempty"`   // Best hash score, when both branches ran
+	Rotation             *int             `json:"rotation,omitempty"`          // Winning counter-clockwise query rotation in degrees
+	Tile                 *Tile            `json:"tile,omitempty"`              // Best-matching query tile in tiled mode
+	BlockFraction        *float64         `json:"block_fraction,omitempty"`    // Share of blocks that agreed in robust mode
+	HashDistance         *int             `json:"hash_distance,omitempty"`     // DCT hamming distance to matched_image, when hashing decided
+	Margin               *float64         `json:"margin,omitempty"`            // Lead of matched_image over the second-best image
+	MatchedThumbnail     string           `json:"matched_thumbnail,omitempty"` // Base64 thumbnail of matched_image, when requested
+	LowEntropy           bool             `json:"low_entropy,omitempty"`       // Query is nearly a solid color, see PHOTOT_MIN_CONTRAST
+	Features             []float64        `json:"features,omitempty"`          // Query feature vector, when requested
+	FeatureType          string           `json:"feature_type,omitempty"`      // Extractor of features: hog, edge or custom
+}
+
+// MatchCandidate is a single ranked result returned by FindMatches
+type MatchCandidate struct {
+	Filename   string  `json:"filename"`
+	Similarity float64 `json:"similarity"`
+}
+
+// NormalizeSimilarity converts a 0-100 similarity, the scale every matcher
+// reports on, to 0-1, clamping scores that drift out of range
+func NormalizeSimilarity(similarity float64) float64 {
+	return min(max(similarity/100, 0), 1)
+}
+
+// Confidence labels, from most to least certain
+const (
+	ConfidenceHigh   = "high"
+	ConfidenceMedium = "medium"
+	ConfidenceLow    = "low"
+	ConfidenceNone   = "none"
+)
+
+// ConfidenceBounds are the 0-100 similarities at which a match is labelled
+// high or medium confidence; matches below Medium are low
+type ConfidenceBounds struct {
+	High   float64
+	Medium float64
+}
+
+// DefaultConfidenceBounds labels 95 and up high and 85 and up medium
+var DefaultConfidenceBounds = ConfidenceBounds{High: 95, Medium: 85}
+
+// Confidence buckets the similarity of a match into a label clients can route
+// on without depending on the numeric scoring. Anything that did not match
+// is ConfidenceNone, whichever branch's threshold it missed.
+func Confidence(similarity float64, matched bool, bounds ConfidenceBounds) string {
+	switch {
+	case !matched:
+		return ConfidenceNone
+	case similarity >= bounds.High:
+		return ConfidenceHigh
+	case similarity >= bounds.Medium:
+		return ConfidenceMedium
+	default:
+		return ConfidenceLow
+	}
 }
 
 // NewImageDatabase creates a new image database instance
 func NewImageDatabase() *ImageDatabase {
 	db := &ImageDatabase{
-		Hashes: make(map[string]imageInfo),
-		Cache:  cache.New(5*time.Minute, 10*time.Minute),
-		UseML:  true,
+		Hashes:        make(map[string]ImageInfo),
+		Cache:         NewResultCache(DefaultCacheTTL, DefaultCacheMaxEntries),
+		contentHashes: make(map[string]string),
+		tags:          make(map[string]map[string]struct{}),
+		ids:           make(map[string]string),
+		thumbnail:     im.DefaultThumbnailOptions,
+		metric:        im.MetricCosine,
+		method:        MethodAuto,
+		hashConfig:    im.DefaultHashConfig,
+		background:    im.DefaultBackground,
 	}
 	return db
 }
 
+// NewEmpty returns a database without images that matches, hashes and
+// extracts features with the same settings as db
+func (db *ImageDatabase) NewEmpty() *ImageDatabase {
+	empty := NewImageDatabase()
+	db.Mutex.RLock()
+	defer db.Mutex.RUnlock()
+	empty.method = db.method
+	empty.metric = db.metric
+	empty.thumbnail = db.thumbnail
+	empty.features = db.features
+	empty.extractor = db.extractor
+	empty.Cache.SetMaxEntries(db.Cache.Stats().MaxEntries)
+	empty.quantization = db.quantization
+	empty.hashConfig = db.hashConfig
+	empty.background = db.background
+	empty.layout = db.layout
+	if db.lsh != nil {
+		empty.lsh = NewLSHIndex(db.lsh.tables)
+	}
+	return empty
+}
+
+// SetThumbnailOptions changes the size and format of thumbnails generated from now on
+func (db *ImageDatabase) SetThumbnailOptions(opts im.ThumbnailOptions) {
+	db.Mutex.Lock()
+	defer db.Mutex.Unlock()
+	db.thumbnail = opts
+}
+
+// SetMetric changes the distance metric used to compare feature vectors
+func (db *ImageDatabase) SetMetric(metric im.DistanceMetric) {
+	db.Mutex.Lock()
+	defer db.Mutex.Unlock()
+	if db.metric != metric {
+		db.metric = metric
+		db.version++
+	}
+}
+
+// MatchMethod selects which branches FindMatch runs
+type MatchMethod string
+
+const (
+	// MethodAuto tries ML first and falls back to hashing when it finds no
+	// match, or blends both when MLWeight and HashWeight are both positive
+	MethodAuto MatchMethod = "auto"
+	// MethodML matches by feature vectors alone, hashing only when the ML
+	// branch cannot run
+	MethodML MatchMethod = "ml"
+	// MethodHash matches by perceptual hashes alone
+	MethodHash MatchMethod = "hash"
+	// MethodCombined always blends ML and hash similarities by MLWeight and
+	// HashWeight, weighing them equally when both are zero
+	MethodCombined MatchMethod = "combined"
+	// MethodStrict matches only when an image clears both the ML and the hash
+	// threshold, reporting the lower of its two similarities
+	MethodStrict MatchMethod = "strict"
+)
+
+// ParseMatchMethod validates a match method name
+func ParseMatchMethod(name string) (MatchMethod, error) {
+	switch method := MatchMethod(strings.ToLower(name)); method {
+	case MethodAuto, MethodML, MethodHash, MethodCombined, MethodStrict:
+		return method, nil
+	}
+	return "", fmt.Errorf("unknown match method: %s", name)
+}
+
+// SetMatchMethod changes the branches FindMatch runs
+func (db *ImageDatabase) SetMatchMethod(method MatchMethod) {
+	db.Mutex.Lock()
+	defer db.Mutex.Unlock()
+	if db.method != method {
+		db.method = method
+		db.version++
+	}
+}
+
+// MatchMethod returns the branches FindMatch runs
+func (db *ImageDatabase) MatchMethod() MatchMethod {
+	db.Mutex.RLock()
+	defer db.Mutex.RUnlock()
+	return db.method
+}
+
+// UsesML reports whether matching compares feature vectors at all
+func (db *ImageDatabase) UsesML() bool {
+	return db.MatchMethod() != MethodHash
+}
+
+// Metric returns the distance metric used to compare feature vectors
+func (db *ImageDatabase) Metric() im.DistanceMetric {
+	db.Mutex.RLock()
+	defer db.Mutex.RUnlock()
+	return db.metric
+}
+
+// SetFeatureOptions changes the preprocessing used to extract feature vectors.
+// Call it before images are loaded, since existing vectors are not recomputed.
+func (db *ImageDatabase) SetFeatureOptions(opts im.FeatureOptions) {
+	db.Mutex.Lock()
+	defer db.Mutex.Unlock()
+	db.features = opts
+}
+
+// SetExtractor replaces the built-in HOG extraction behind the ML branch,
+// for instance with a neural network; nil restores it. As with
+// SetFeatureOptions, call it before images are loaded, since vectors from
+// different extractors cannot be compared.
+func (db *ImageDatabase) SetExtractor(extractor im.FeatureExtractor) {
+	db.Mutex.Lock()
+	defer db.Mutex.Unlock()
+	db.extractor = extractor
+}
+
+// FeatureType returns the type of the vectors the ML branch extracts, see
+// im.FeatureTypeOf. Only stored vectors of this type are compared.
+func (db *ImageDatabase) FeatureType() string {
+	db.Mutex.RLock()
+	defer db.Mutex.RUnlock()
+	return im.FeatureTypeOf(db.extractor)
+}
+
+// SetQuantization changes the precision feature vectors are stored at from now on
+func (db *ImageDatabase) SetQuantization(mode im.QuantizationMode) {
+	db.Mutex.Lock()
+	defer db.Mutex.Unlock()
+	db.quantization = mode
+}
+
+// SetHashConfig changes the geometry of DCT hashes. Call it before images are
+// loaded, since stored hashes are not recomputed and are never compared with
+// hashes of another config.
+func (db *ImageDatabase) SetHashConfig(cfg im.HashConfig) {
+	db.Mutex.Lock()
+	defer db.Mutex.Unlock()
+	db.hashConfig = cfg
+}
+
+// SetLSHTables indexes feature vectors in an LSH index with the given number
+// of tables, so the ML branch of FindMatch compares the query only with
+// vectors sharing one of its buckets. More tables raise recall at the cost of
+// speed; 0 disables the index and every vector is scanned.
+func (db *ImageDatabase) SetLSHTables(tables int) {
+	db.Mutex.Lock()
+	defer db.Mutex.Unlock()
+	if tables <= 0 {
+		db.lsh = nil
+		return
+	}
+	if db.lsh != nil && db.lsh.tables == tables {
+		return
+	}
+	db.lsh = NewLSHIndex(tables)
+	for hash, info := range db.Hashes {
+		db.lsh.Add(hash, info.FeatureVector())
+	}
+}
+
+// SetBackground changes the color transparent pixels are composited onto
+// before hashing, feature extraction and thumbnailing. Call it before images
+// are loaded, since stored hashes and features are not recomputed.
+func (db *ImageDatabase) SetBackground(bg color.NRGBA) {
+	db.Mutex.Lock()
+	defer db.Mutex.Unlock()
+	if db.background != bg {
+		db.background = bg
+		db.version++
+	}
+}
+
+// flatten composites img onto the configured background. Every entry point
+// taking an image flattens it first, so stored and query images agree.
+func (db *ImageDatabase) flatten(img image.Image) image.Image {
+	db.Mutex.RLock()
+	bg := db.background
+	db.Mutex.RUnlock()
+	return im.Flatten(img, bg)
+}
+
+// dctHash computes the DCT hash of img with the configured geometry and
+// returns it along with the config's ID
+func (db *ImageDatabase) dctHash(img image.Image) (string, string) {
+	db.Mutex.RLock()
+	cfg := db.hashConfig
+	db.Mutex.RUnlock()
+	return im.ComputeDCTHashWithConfig(img, cfg), cfg.ID()
+}
+
+// blockHashes computes the block hashes of img with the configured geometry
+// and returns them along with the config's ID
+func (db *ImageDatabase) blockHashes(img image.Image) ([]string, string) {
+	db.Mutex.RLock()
+	cfg := db.hashConfig
+	db.Mutex.RUnlock()
+	return im.ComputeBlockHashes(img, cfg), cfg.ID()
+}
+
+// storeFeatures returns features as they should be stored: either the full
+// vector or, when quantization is on, its quantized form
+func (db *ImageDatabase) storeFeatures(features []float64) ([]float64, *im.QuantizedVector) {
+	db.Mutex.RLock()
+	mode := db.quantization
+	db.Mutex.RUnlock()
+	if features == nil || mode == "" || mode == im.QuantizeNone {
+		return features, nil
+	}
+	return nil, im.Quantize(features, mode)
+}
+
+// extractFeatures extracts a feature vector with the configured extractor
+func (db *ImageDatabase) extractFeatures(ctx context.Context, img image.Image) ([]float64, error) {
+	db.Mutex.RLock()
+	extractor := db.extractor
+	if extractor == nil {
+		extractor = im.HOGExtractor{Options: db.features}
+	}
+	db.Mutex.RUnlock()
+	return extractor.ExtractFeatures(ctx, img)
+}
+
+// generateThumbnail creates a thumbnail using the configured options
+func (db *ImageDatabase) generateThumbnail(img image.Image) string {
+	db.Mutex.RLock()
+	opts := db.thumbnail
+	db.Mutex.RUnlock()
+	return im.GenerateThumbnailWithOptions(img, opts)
+}
+
+// DefaultLoadWorkers is how many files LoadImages decodes at once
+const DefaultLoadWorkers = 4
+
+// LoadProgress counts the image files of a directory load
+type LoadProgress struct {
+	Loaded int // Files indexed so far
+	Failed int // Files that could not be loaded
+	Total  int // Image files in the directory
+}
+
+// LoadOptions tunes LoadImagesWithOptions
+type LoadOptions struct {
+	Workers int // Files decoded at once, DefaultLoadWorkers when zero or less
+
+	// Progress, when set, is called after every file with the running
+	// counts. Calls are never concurrent, so it need not lock.
+	Progress func(LoadProgress)
+}
+
 // LoadImages loads images from directory and extracts features
 func (db *ImageDatabase) LoadImages(imageDir string) error {
+	return db.LoadImagesWithOptions(imageDir, LoadOptions{})
+}
+
+// LoadImagesWithOptions loads images from directory with opts.Workers
+// decoders, reporting each finished file to opts.Progress
+func (db *ImageDatabase) LoadImagesWithOptions(imageDir string, opts LoadOptions) error {
 	if _, err := os.Stat(imageDir); os.IsNotExist(err) {
 		return fmt.Errorf("image directory not found: %s", imageDir)
 	}
@@ -62,168 +506,1092 @@ func (db *ImageDatabase) LoadImages(imageDir string) error {
 		return fmt.Errorf("failed to read directory: %s", err)
 	}
 
-	var wg sync.WaitGroup
-	threadLimit := make(chan struct{}, 4)
-
+	var names []contentFile
 	for _, file := range files {
-		if file.IsDir() {
+		// Hidden files include replacements interrupted before their rename
+		if file.IsDir() || strings.HasPrefix(file.Name(), ".") {
 			continue
 		}
 		ext := strings.ToLower(filepath.Ext(file.Name()))
-		if !isImageFile(ext) {
-			continue
+		if im.IsImageFile(ext) {
+			names = append(names, contentFile{path: file.Name(), filename: file.Name()})
 		}
+	}
+	// Files stored by content live in shard directories, and both layouts
+	// load side by side so switching layout needs no migration
+	content, err := listContentFiles(imageDir)
+	if err != nil {
+		return fmt.Errorf("failed to read content directories: %s", err)
+	}
+	names = append(names, content...)
 
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = DefaultLoadWorkers
+	}
+	var wg sync.WaitGroup
+	var progressMu sync.Mutex
+	progress := LoadProgress{Total: len(names)}
+	threadLimit := make(chan struct{}, workers)
+
+	for _, name := range names {
 		wg.Add(1)
 		threadLimit <- struct{}{}
 
-		go func(fileName string) {
+		go func(file contentFile) {
 			defer wg.Done()
 			defer func() { <-threadLimit }()
 
-			path := filepath.Join(imageDir, fileName)
-			img, err := imaging.Open(path)
+			err := db.loadImage(imageDir, file)
 			if err != nil {
-				log.Printf("Failed to open file %s: %v", path, err)
-				return
+				slog.Warn("failed to load image", "path", filepath.Join(imageDir, file.path), "error", err)
+			} else {
+				slog.Debug("loaded image", "file", file.filename)
 			}
 
-			hash := im.ComputeDCTHash(img)
-			thumbnail := im.GenerateThumbnail(img)
-
-			info := imageInfo{
-				Filename:  fileName,
-				Hash:      hash,
-				AddedAt:   time.Now(),
-				Thumbnail: thumbnail,
+			progressMu.Lock()
+			defer progressMu.Unlock()
+			if err != nil {
+				progress.Failed++
+			} else {
+				progress.Loaded++
+			}
+			if opts.Progress != nil {
+				opts.Progress(progress)
 			}
+		}(name)
+	}
 
-			// Extract ML features
-			features := im.ExtractImageFeatures(img)
-			info.Features = features
+	wg.Wait()
+	slog.Info("loaded images into database", "images", len(db.Hashes), "failed", progress.Failed)
+	if progress.Total > 0 && progress.Failed == progress.Total {
+		return fmt.Errorf("all %d image files failed to load", progress.Failed)
+	}
+	return nil
+}
+
+// loadImage decodes and indexes a single file, turning a panic from a
+// malformed image into an error so one bad file cannot abort the whole load
+func (db *ImageDatabase) loadImage(imageDir string, file contentFile) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic while processing image: %v", r)
+		}
+	}()
 
-			db.Mutex.Lock()
-			db.Hashes[hash] = info
-			db.Mutex.Unlock()
+	path := filepath.Join(imageDir, file.path)
+	img, err := im.OpenImage(path)
+	if err != nil {
+		return err
+	}
+	fileName := file.filename
+	if file.path != fileName {
+		db.storeMu.Lock()
+		if db.files == nil {
+			db.files = make(map[string]string)
+		}
+		db.files[fileName] = file.path
+		db.storeMu.Unlock()
+	}
+	if img.Bounds().Empty() {
+		return fmt.Errorf("image has no pixels")
+	}
 
-			log.Printf("Loaded image: %s", fileName)
-		}(file.Name())
+	contentHash := im.ContentHash(img)
+	img = db.flatten(img)
+	hash, hashConfig := db.dctHash(img)
+	blocks, _ := db.blockHashes(img)
+	features, _ := db.extractFeatures(context.Background(), img)
+	features, quantized := db.storeFeatures(features)
+	info := ImageInfo{
+		ID:          ImageID(fileName),
+		Filename:    fileName,
+		Hash:        hash,
+		HashConfig:  hashConfig,
+		ContentHash: contentHash,
+		AddedAt:     loadedAddedAt(path, fileName),
+		Thumbnail:   db.generateThumbnail(img),
+		Features:    features, // ML features
+		Quantized:   quantized,
+		FeatureType: db.FeatureType(),
+		WaveletHash: im.ComputeWaveletHash(img),
+		BlockHashes: blocks,
+		Width:       img.Bounds().Dx(),
+		Height:      img.Bounds().Dy(),
+		Format:      imageFormat(fileName),
 	}
 
-	wg.Wait()
-	log.Printf("Loaded %d images into database", len(db.Hashes))
+	db.Mutex.Lock()
+	defer db.Mutex.Unlock()
+	if existing, ok := db.Hashes[hash]; ok {
+		// Files sharing a hash are the aliases of the one whose name sorts
+		// first, which for /admin/add's timestamped names is the one stored
+		// first, so the record and its ID do not depend on the order the
+		// concurrent loads finish in
+		if info.Filename < existing.Filename {
+			db.unindex(existing)
+			info.Aliases = existing.Files()
+			info.Tags = existing.Tags
+			sort.Strings(info.Aliases)
+			db.index(info)
+			return nil
+		}
+		db.alias(info)
+		return nil
+	}
+	db.index(info)
 	return nil
 }
 
-// isImageFile checks if extension is supported
-func isImageFile(ext string) bool {
-	supportedExts := map[string]bool{
-		".jpg":  true,
-		".jpeg": true,
-		".png":  true,
-		".gif":  true,
-		".bmp":  true,
-		".tiff": true,
-		".webp": true,
+// loadedAddedAt recovers when a loaded file was added: from the "<unix
+// nanos>_" stamp /admin/add gives uploads, else from the file's modification
+// time, so the recency boost and list order survive a restart
+func loadedAddedAt(path, filename string) time.Time {
+	stamp, _, ok := strings.Cut(filename, "_")
+	if ok && stamp != "" && strings.Trim(stamp, "0123456789") == "" {
+		if nanos, err := strconv.ParseInt(stamp, 10, 64); err == nil {
+			return time.Unix(0, nanos)
+		}
+	}
+	if stat, err := os.Stat(path); err == nil {
+		return stat.ModTime()
 	}
-	return supportedExts[ext]
+	return time.Now()
 }
 
-// FindMatch searches for similar images using combined ML and hash methods
-func (db *ImageDatabase) FindMatch(img image.Image, similarityThreshold float64) (bool, string, float64, string) {
-	method := "hash"
+// MatchOptions controls the thresholds FindMatch applies to each branch
+type MatchOptions struct {
+	Threshold     float64 // Similarity (0-100) required by the combined score
+	MLThreshold   float64 // Similarity (0-100) required by the ML branch
+	HashThreshold float64 // Similarity (0-100) required by the hash fallback
 
-	// First try ML-based matching
-	if db.UseML {
-		method = "ml"
-		features := im.ExtractImageFeatures(img)
-		isMatch, matchedImage, similarity := db.findMatchByFeatures(features, similarityThreshold)
+	// When both weights are positive, ML and hash similarities are blended per
+	// image instead of trying ML first and falling back to hashing
+	MLWeight   float64
+	HashWeight float64
+
+	// TryRotations also matches the query rotated by 90, 180 and 270 degrees,
+	// quadrupling the work
+	TryRotations bool
+
+	// Tags restricts the search to images carrying every listed tag
+	Tags []string
+
+	// FilenamePrefix restricts the search to images whose filename, with or
+	// without the upload timestamp /admin/add prepends, starts with it
+	FilenamePrefix string
+
+	// RecencyBoost adds up to this many similarity points to newer images when
+	// ranking, halving every RecencyHalfLife of age. Only images that already
+	// clear the threshold are boosted, and the reported similarity is never
+	// boosted, so it reorders matches without turning a non-match into one.
+	RecencyBoost    float64
+	RecencyHalfLife time.Duration
+
+	// WaveletWeight (0-1) is the share of hash similarity taken from the
+	// wavelet hash instead of the DCT hash; 1 uses the wavelet hash alone
+	WaveletWeight float64
+
+	// TileSize, when positive, hashes every TileSize x TileSize window of
+	// the query, TileStride pixels apart, instead of the whole image, to find
+	// a reference shown small within a larger scene. The caller bounds the
+	// tile count with im.Tiles. ML matching and TryRotations are skipped.
+	TileSize   int
+	TileStride int
+
+	// MaxDistance, when set, replaces HashThreshold with a cutoff on the
+	// hamming distance between DCT hashes: the hash branch matches when at
+	// most this many bits differ
+	MaxDistance *int
+
+	// MinBlockFraction, when positive, matches block by block to tolerate
+	// occlusion, such as a hand over part of a product: query and reference
+	// are split into an im.BlockGrid grid, and an image matches when at least
+	// this fraction (0-1) of its blocks clear HashThreshold. MaxDistance, ML
+	// matching, TryRotations and tiles are ignored.
+	MinBlockFraction float64
+
+	// MinMargin, when positive, also requires the best image to lead the
+	// second best by at least this many similarity points to match, so that
+	// a query close to two references is not matched to either. It is
+	// ignored in tiled and robust modes, which report no margin.
+	MinMargin float64
+
+	// MinContrast, when positive, flags queries whose im.Contrast is below
+	// it as LowEntropy: near-solid images, whose hashes are degenerate
+	MinContrast float64
+
+	// pendingOnly restricts the scope to images whose features are pending
+	pendingOnly bool
+}
+
+// hashMatches reports whether a hash match with the given similarity and DCT
+// hamming distance clears MaxDistance, or HashThreshold when it is unset
+func (opts MatchOptions) hashMatches(similarity float64, distance int) bool {
+	if opts.MaxDistance != nil {
+		return distance <= *opts.MaxDistance
+	}
+	return similarity >= opts.HashThreshold
+}
+
+// recencyBonus returns the ranking bonus for an image added at addedAt whose
+// similarity was scored against threshold
+func (opts MatchOptions) recencyBonus(addedAt time.Time, similarity, threshold float64) float64 {
+	if opts.RecencyBoost <= 0 || opts.RecencyHalfLife <= 0 || similarity < threshold {
+		return 0
+	}
+	age := time.Since(addedAt)
+	if age < 0 {
+		age = 0
+	}
+	return opts.RecencyBoost * math.Pow(0.5, float64(age)/float64(opts.RecencyHalfLife))
+}
+
+// MatchResult is the outcome of FindMatch
+type MatchResult struct {
+	IsMatch      bool
+	MatchedImage string
+	Similarity   float64
+	Method       string // Source of Similarity: "ml", "hash", "combined" or "strict"
+	Degraded     string // Why the ML branch was abandoned, empty when it completed
+
+	// Per-branch best similarities, both set only when both branches ran
+	MLSimilarity   *float64
+	HashSimilarity *float64
+
+	// Counter-clockwise rotation applied to the query, set when TryRotations is on
+	Rotation *int
+
+	// Query region that matched best, set in tiled mode
+	Tile *Tile
+
+	// Share of MatchedImage's blocks that agreed with the query, set in
+	// robust mode
+	BlockFraction *float64
+
+	// DCT hamming distance to MatchedImage, set when hashing decided
+	HashDistance *int
+
+	// How many similarity points MatchedImage leads the second-best image by
+	// in the branch that decided, or its whole similarity when it was the
+	// only image compared. A recency boost can rank an image first with a
+	// lower similarity, making it negative. Not set in tiled and robust modes.
+	Margin *float64
+
+	// NoData is set when there were no reference images in scope to compare
+	// against, as opposed to none of them being similar enough
+	NoData bool
+
+	// LowEntropy is set when the query is nearly a solid color, per
+	// MatchOptions.MinContrast. Its hash says little, so any similarity
+	// reported for it, even a match, deserves suspicion.
+	LowEntropy bool
+}
+
+// FindMatch searches for similar images by the database's MatchMethod.
+// If ctx ends while the ML branch runs, the result falls back to hashing.
+// Method always names the comparison that produced Similarity.
+func (db *ImageDatabase) FindMatch(ctx context.Context, img image.Image, opts MatchOptions) MatchResult {
+	result := db.findMatch(ctx, img, opts)
+	if result.IsMatch && opts.MinMargin > 0 && result.Margin != nil && *result.Margin < opts.MinMargin {
+		result.IsMatch = false
+	}
+	result.LowEntropy = opts.MinContrast > 0 && im.Contrast(img) < opts.MinContrast
+	return result
+}
+
+// findMatch is FindMatch without the MinMargin check
+func (db *ImageDatabase) findMatch(ctx context.Context, img image.Image, opts MatchOptions) MatchResult {
+	if !db.hasImages(opts) {
+		return MatchResult{Method: "none", NoData: true}
+	}
+	img = db.flatten(img)
+	if opts.MinBlockFraction > 0 {
+		return db.findMatchRobust(img, opts)
+	}
+	if opts.TileSize > 0 {
+		return db.findMatchTiled(img, opts)
+	}
+	if opts.TryRotations {
+		return db.findMatchRotated(ctx, img, opts)
+	}
+
+	var result MatchResult
 
-		if isMatch {
-			return isMatch, matchedImage, similarity, method
+	method := db.MatchMethod()
+	switch {
+	case method == MethodStrict:
+		strict, err := db.findMatchStrict(ctx, img, opts)
+		if err == nil {
+			return db.matchPending(img, opts, strict)
+		}
+		// Hashing still reports the closest image, but alone it cannot match
+		result.Degraded = fmt.Sprintf("strict matching needs the ml branch: %v", err)
+	case method == MethodCombined, method == MethodAuto && opts.MLWeight > 0 && opts.HashWeight > 0:
+		if opts.MLWeight == 0 && opts.HashWeight == 0 {
+			opts.MLWeight, opts.HashWeight = 1, 1
+		}
+		combined, err := db.findMatchCombined(ctx, img, opts)
+		if err == nil {
+			return db.matchPending(img, opts, combined)
+		}
+		if !errors.Is(err, errIncompatibleFeatures) {
+			result.Degraded = fmt.Sprintf("ml matching abandoned: %v", err)
+		}
+	case method == MethodAuto, method == MethodML:
+		// First try ML-based matching
+		ml, err := db.findMatchByFeatures(ctx, img, opts)
+		if errors.Is(err, errIncompatibleFeatures) {
+			// Hashing alone decides, as if ML were off
+		} else if err != nil {
+			result.Degraded = fmt.Sprintf("ml matching abandoned: %v", err)
+		} else if ml.IsMatch {
+			return ml
+		} else if method == MethodML {
+			return db.matchPending(img, opts, ml)
+		} else {
+			result.MLSimilarity = &ml.Similarity
 		}
 	}
 
 	// Fallback to hash-based matching
-	uploadedHash := im.ComputeDCTHash(img)
+	hash := db.findMatchByHash(db.newHashQuery(img, opts), opts)
+
+	result.IsMatch = hash.MatchedImage != "" && opts.hashMatches(hash.Similarity, *hash.HashDistance) && method != MethodStrict
+	result.MatchedImage = hash.MatchedImage
+	result.Similarity = hash.Similarity
+	result.HashDistance = hash.HashDistance
+	result.Margin = hash.Margin
+	result.Method = "hash"
+	if result.MLSimilarity != nil {
+		result.HashSimilarity = &hash.Similarity
+	}
+	return result
+}
 
+// matchPending returns result unless it is not a match and images without
+// features, which the ML branch cannot see, are stored. Those are then
+// matched by hash, which decides if one clears HashThreshold or MaxDistance.
+func (db *ImageDatabase) matchPending(img image.Image, opts MatchOptions, result MatchResult) MatchResult {
+	db.Mutex.RLock()
+	pending := db.pending
+	db.Mutex.RUnlock()
+	if result.IsMatch || pending == 0 {
+		return result
+	}
+	opts.pendingOnly = true
+	hash := db.findMatchByHash(db.newHashQuery(img, opts), opts)
+	if hash.MatchedImage == "" || !opts.hashMatches(hash.Similarity, *hash.HashDistance) {
+		return result
+	}
+	hash.IsMatch = true
+	hash.Degraded = result.Degraded
+	return hash
+}
+
+// hasImages reports whether any stored image is within the scope of opts
+func (db *ImageDatabase) hasImages(opts MatchOptions) bool {
 	db.Mutex.RLock()
 	defer db.Mutex.RUnlock()
+	return len(db.scope(opts)) > 0
+}
 
-	if len(db.Hashes) == 0 {
-		return false, "", 0.0, method
+// findMatchRotated runs FindMatch on each right-angle rotation of img and keeps
+// the best result, preferring matches over higher raw similarity
+func (db *ImageDatabase) findMatchRotated(ctx context.Context, img image.Image, opts MatchOptions) MatchResult {
+	opts.TryRotations = false
+	rotations := []func(image.Image) *image.NRGBA{imaging.Clone, imaging.Rotate90, imaging.Rotate180, imaging.Rotate270}
+
+	var best MatchResult
+	for i, rotate := range rotations {
+		result := db.FindMatch(ctx, rotate(img), opts)
+		better := result.IsMatch && !best.IsMatch ||
+			result.IsMatch == best.IsMatch && result.Similarity > best.Similarity
+		if i == 0 || better {
+			degrees := i * 90
+			result.Rotation = &degrees
+			best = result
+		}
 	}
+	return best
+}
 
-	bestMatch := ""
-	minDistance := len(uploadedHash)
+// Tile is a square region of a query image, in pixels from its top-left corner
+type Tile struct {
+	X    int `json:"x"`
+	Y    int `json:"y"`
+	Size int `json:"size"`
+}
 
-	for hash, info := range db.Hashes {
-		distance, err := im.HammingDistance(uploadedHash, hash)
-		if err != nil {
+// findMatchTiled hashes each tile of img and keeps the tile that comes
+// closest to any stored image
+func (db *ImageDatabase) findMatchTiled(img image.Image, opts MatchOptions) MatchResult {
+	result := MatchResult{Method: "hash"}
+	origin := img.Bounds().Min
+	for _, rect := range im.Tiles(img.Bounds(), opts.TileSize, opts.TileStride) {
+		match := db.findMatchByHash(db.newHashQuery(imaging.Crop(img, rect), opts), opts)
+		if match.MatchedImage != "" && (result.MatchedImage == "" || match.Similarity > result.Similarity) {
+			result.MatchedImage = match.MatchedImage
+			result.Similarity = match.Similarity
+			result.HashDistance = match.HashDistance
+			result.Tile = &Tile{X: rect.Min.X - origin.X, Y: rect.Min.Y - origin.Y, Size: opts.TileSize}
+		}
+	}
+	result.IsMatch = result.MatchedImage != "" && opts.hashMatches(result.Similarity, *result.HashDistance)
+	return result
+}
+
+// findMatchRobust compares the block hashes of img with those of every stored
+// image and keeps the one with the largest share of agreeing blocks, breaking
+// ties by their mean similarity, which is reported as Similarity
+func (db *ImageDatabase) findMatchRobust(img image.Image, opts MatchOptions) MatchResult {
+	blocks, config := db.blockHashes(img)
+	result := MatchResult{Method: "hash"}
+	if len(blocks) == 0 {
+		return result
+	}
+
+	db.Mutex.RLock()
+	defer db.Mutex.RUnlock()
+	bestFraction := 0.0
+	for _, info := range db.scope(opts) {
+		if info.HashConfig != config || len(info.BlockHashes) != len(blocks) {
+			continue
+		}
+		agreed, total := 0, 0.0
+		for i, block := range blocks {
+			distance, err := im.HammingDistance(block, info.BlockHashes[i])
+			if err != nil {
+				continue
+			}
+			if similarity := hashSimilarity(distance, len(block)); similarity >= opts.HashThreshold {
+				agreed++
+				total += similarity
+			}
+		}
+		fraction, similarity := float64(agreed)/float64(len(blocks)), 0.0
+		if agreed > 0 {
+			similarity = total / float64(agreed)
+		}
+		if result.MatchedImage == "" || fraction > bestFraction ||
+			fraction == bestFraction && similarity > result.Similarity {
+			result.MatchedImage = info.Filename
+			result.Similarity = similarity
+			bestFraction = fraction
+		}
+	}
+	if result.MatchedImage != "" {
+		result.BlockFraction = &bestFraction
+		result.IsMatch = bestFraction >= opts.MinBlockFraction
+	}
+	return result
+}
+
+// findMatchByHash returns the stored image closest to query with its
+// similarity, margin and the hamming distance between their DCT hashes. The
+// result is left unmatched for the caller to decide.
+func (db *ImageDatabase) findMatchByHash(query hashQuery, opts MatchOptions) MatchResult {
+	db.Mutex.RLock()
+	defer db.Mutex.RUnlock()
+
+	result := MatchResult{Method: "hash"}
+	bestHash := ""
+	var rank ranking
+	for _, info := range db.scope(opts) {
+		similarity, ok := query.similarity(info, opts.WaveletWeight)
+		if !ok {
+			continue
+		}
+
+		score := similarity + opts.recencyBonus(info.AddedAt, similarity, opts.HashThreshold)
+		if rank.add(info, similarity, score) {
+			result.MatchedImage, result.Similarity, bestHash = info.Filename, similarity, info.Hash
+		}
+	}
+
+	if result.MatchedImage != "" {
+		distance, _ := im.HammingDistance(query.dct, bestHash)
+		result.HashDistance = &distance
+		result.Margin = rank.margin()
+	}
+	return result
+}
+
+// ranking keeps the two highest scores of a scan and the similarities behind
+// them, to tell how far ahead of the rest the best image is
+type ranking struct {
+	n                      int
+	bestScore, secondScore float64
+	best, second           float64
+
+	// Identify the best image for breaking ties
+	bestAddedAt  time.Time
+	bestFilename string
+}
+
+// add ranks an image by score, reporting whether it is the new best. Ties go
+// to the image added first, then to the filename sorting first, so the result
+// does not depend on the order the images are scanned in.
+func (r *ranking) add(info ImageInfo, similarity, score float64) bool {
+	r.n++
+	switch {
+	case r.n == 1 || score > r.bestScore || score == r.bestScore && r.precedes(info):
+		r.secondScore, r.second = r.bestScore, r.best
+		r.bestScore, r.best = score, similarity
+		r.bestAddedAt, r.bestFilename = info.AddedAt, info.Filename
+		return true
+	case r.n == 2 || score > r.secondScore:
+		r.secondScore, r.second = score, similarity
+	}
+	return false
+}
+
+// precedes reports whether info wins a tie with the best image
+func (r *ranking) precedes(info ImageInfo) bool {
+	if !info.AddedAt.Equal(r.bestAddedAt) {
+		return info.AddedAt.Before(r.bestAddedAt)
+	}
+	return info.Filename < r.bestFilename
+}
+
+// margin returns how many similarity points the best image leads the second
+// by, all of its similarity when it is the only one, and nil when there is none
+func (r ranking) margin() *float64 {
+	if r.n == 0 {
+		return nil
+	}
+	margin := r.best - r.second
+	return &margin
+}
+
+// hashQuery holds the perceptual hashes of a query image
+type hashQuery struct {
+	dct     string
+	config  string // im.HashConfig.ID of dct
+	wavelet string // Only computed when MatchOptions.WaveletWeight is positive
+}
+
+func (db *ImageDatabase) newHashQuery(img image.Image, opts MatchOptions) hashQuery {
+	var query hashQuery
+	query.dct, query.config = db.dctHash(img)
+	if opts.WaveletWeight > 0 {
+		query.wavelet = im.ComputeWaveletHash(img)
+	}
+	return query
+}
+
+// similarity returns the 0-100 hash similarity of info to the query, blending
+// in the wavelet hash by waveletWeight when info has one. ok is false when the
+// hashes cannot be compared, including when they were computed with different
+// configs.
+func (q hashQuery) similarity(info ImageInfo, waveletWeight float64) (float64, bool) {
+	if info.HashConfig != q.config {
+		return 0, false
+	}
+	distance, err := im.HammingDistance(q.dct, info.Hash)
+	if err != nil {
+		return 0, false
+	}
+	similarity := hashSimilarity(distance, len(q.dct))
+	if q.wavelet == "" || info.WaveletHash == "" {
+		return similarity, true
+	}
+
+	distance, err = im.HammingDistance(q.wavelet, info.WaveletHash)
+	if err != nil {
+		return similarity, true
+	}
+	wavelet := hashSimilarity(distance, len(q.wavelet))
+	return (1-waveletWeight)*similarity + waveletWeight*wavelet, true
+}
+
+// hashSimilarity converts a hamming distance between hashes of length bits to 0-100
+func hashSimilarity(distance, length int) float64 {
+	if length == 0 {
+		return 0
+	}
+	return 100.0 - (float64(distance)/float64(length))*100.0
+}
+
+// findMatchCombined scores every image by the weighted mean of its ML and hash
+// similarities and returns the best one
+func (db *ImageDatabase) findMatchCombined(ctx context.Context, img image.Image, opts MatchOptions) (MatchResult, error) {
+	features, err := db.queryFeatures(ctx, img)
+	if err != nil {
+		return MatchResult{}, err
+	}
+	query := db.newHashQuery(img, opts)
+	totalWeight := opts.MLWeight + opts.HashWeight
+
+	db.Mutex.RLock()
+	defer db.Mutex.RUnlock()
+	if !db.featuresCompatible(features.vector) {
+		return MatchResult{}, errIncompatibleFeatures
+	}
+
+	result := MatchResult{Method: "combined"}
+	var rank ranking
+	for _, info := range db.scope(opts) {
+		hashSim, ok := query.similarity(info, opts.WaveletWeight)
+		if !ok || !features.compatible(info) {
 			continue
 		}
+		mlSimilarity := features.similarity(info.FeatureVector(), db.metric)
+		similarity := (opts.MLWeight*mlSimilarity + opts.HashWeight*hashSim) / totalWeight
+		score := similarity + opts.recencyBonus(info.AddedAt, similarity, opts.Threshold)
+		if rank.add(info, similarity, score) {
+			result.MatchedImage = info.Filename
+			result.Similarity = similarity
+			result.MLSimilarity = &mlSimilarity
+			result.HashSimilarity = &hashSim
+		}
+	}
+
+	result.IsMatch = result.MatchedImage != "" && result.Similarity >= opts.Threshold
+	result.Margin = rank.margin()
+	return result, nil
+}
+
+// findMatchStrict scores every image by both branches and returns the one
+// whose lower similarity is highest. It matches only when that image clears
+// MLThreshold and, by hash, HashThreshold or MaxDistance; Similarity is the
+// lower of the two.
+func (db *ImageDatabase) findMatchStrict(ctx context.Context, img image.Image, opts MatchOptions) (MatchResult, error) {
+	features, err := db.queryFeatures(ctx, img)
+	if err != nil {
+		return MatchResult{}, err
+	}
+	query := db.newHashQuery(img, opts)
 
-		if distance < minDistance {
-			minDistance = distance
-			bestMatch = info.Filename
+	db.Mutex.RLock()
+	defer db.Mutex.RUnlock()
+	if !db.featuresCompatible(features.vector) {
+		return MatchResult{}, errIncompatibleFeatures
+	}
+
+	result := MatchResult{Method: "strict"}
+	var rank ranking
+	var mlBest, hashBest float64
+	var distanceBest int
+	for _, info := range db.scope(opts) {
+		hashSim, ok := query.similarity(info, opts.WaveletWeight)
+		if !ok || !features.compatible(info) {
+			continue
 		}
+		mlSimilarity := features.similarity(info.FeatureVector(), db.metric)
+		similarity := min(mlSimilarity, hashSim)
+		score := similarity + opts.recencyBonus(info.AddedAt, similarity, min(opts.MLThreshold, opts.HashThreshold))
+		if rank.add(info, similarity, score) {
+			result.MatchedImage = info.Filename
+			result.Similarity = similarity
+			mlBest, hashBest = mlSimilarity, hashSim
+			distanceBest, _ = im.HammingDistance(query.dct, info.Hash)
+		}
+	}
+
+	if result.MatchedImage != "" {
+		result.MLSimilarity, result.HashSimilarity, result.HashDistance = &mlBest, &hashBest, &distanceBest
+		result.Margin = rank.margin()
+		result.IsMatch = mlBest >= opts.MLThreshold && opts.hashMatches(hashBest, distanceBest)
+	}
+	return result, nil
+}
+
+// featureQuery holds the feature vector of a query image
+type featureQuery struct {
+	vector   []float64
+	kind     string // Feature type of vector, see im.FeatureTypeOf
+	grayOnly bool   // Leave out the color histogram, which a grayscale query cannot match
+}
+
+// compatible reports whether the stored vector of info can be compared with
+// the query's: it has the same type and length
+func (q featureQuery) compatible(info ImageInfo) bool {
+	return info.featureLen() == len(q.vector) && info.featureType() == q.kind
+}
+
+// queryFeatures extracts the features of a query image, noting when it is
+// grayscale while stored HOG vectors carry a color histogram
+func (db *ImageDatabase) queryFeatures(ctx context.Context, img image.Image) (featureQuery, error) {
+	vector, err := db.extractFeatures(ctx, img)
+	if err != nil {
+		return featureQuery{}, err
 	}
+	db.Mutex.RLock()
+	withColor := db.extractor == nil && db.features.ColorHistogram
+	kind := im.FeatureTypeOf(db.extractor)
+	db.Mutex.RUnlock()
+	return featureQuery{vector: vector, kind: kind, grayOnly: withColor && im.IsGrayscale(img)}, nil
+}
 
-	maxDistance := len(uploadedHash)
-	similarity := 100.0 - (float64(minDistance)/float64(maxDistance))*100.0
+// similarity returns the 0-100 similarity of the query to a stored vector of
+// the same length, comparing only the HOG values of a grayscale query
+func (q featureQuery) similarity(stored []float64, metric im.DistanceMetric) float64 {
+	query := q.vector
+	if q.grayOnly && len(query) > im.HOGLength {
+		query, stored = query[:im.HOGLength], stored[:im.HOGLength]
+	}
+	return im.FeatureSimilarity(query, stored, metric)
+}
 
-	isMatch := similarity >= similarityThreshold
-	method = "hash"
+// errIncompatibleFeatures is returned by the ML branch when query vectors
+// cannot be compared with the stored ones
+var errIncompatibleFeatures = errors.New("query feature dimension does not match stored vectors")
 
-	return isMatch, bestMatch, similarity, method
+// featuresCompatible reports whether features can be compared with the stored
+// vectors, logging the first mismatch. The caller must hold db.Mutex.
+func (db *ImageDatabase) featuresCompatible(features []float64) bool {
+	if db.featureDim == 0 || len(features) == db.featureDim {
+		return true
+	}
+	db.dimMismatch.Do(func() {
+		slog.Warn("ML matching skipped, matching by hash only: query and stored feature dimensions differ",
+			"query", len(features), "stored", db.featureDim)
+	})
+	return false
 }
 
 // findMatchByFeatures performs ML-based similarity search
-func (db *ImageDatabase) findMatchByFeatures(features []float64, similarityThreshold float64) (bool, string, float64) {
+func (db *ImageDatabase) findMatchByFeatures(ctx context.Context, img image.Image, opts MatchOptions) (MatchResult, error) {
+	features, err := db.queryFeatures(ctx, img)
+	if err != nil {
+		return MatchResult{}, err
+	}
+
 	db.Mutex.RLock()
 	defer db.Mutex.RUnlock()
+	if !db.featuresCompatible(features.vector) {
+		return MatchResult{}, errIncompatibleFeatures
+	}
 
-	bestMatch := ""
-	maxSimilarity := 0.0
+	result := MatchResult{Method: "ml"}
+	var rank ranking
 
-	for _, info := range db.Hashes {
-		if info.Features == nil {
+	pool := db.scope(opts)
+	if db.lsh != nil && !features.grayOnly {
+		pool = db.lshCandidates(pool, features.vector)
+	}
+	scanned := 0
+	for _, info := range pool {
+		if !features.compatible(info) {
 			continue
 		}
+		if scanned++; scanned%256 == 0 {
+			if err := ctx.Err(); err != nil {
+				return MatchResult{}, err
+			}
+		}
+
+		similarity := features.similarity(info.FeatureVector(), db.metric)
+		score := similarity + opts.recencyBonus(info.AddedAt, similarity, opts.MLThreshold)
+		if rank.add(info, similarity, score) && score > 0 {
+			result.MatchedImage, result.Similarity = info.Filename, similarity
+		}
+	}
+
+	result.IsMatch = result.MatchedImage != "" && result.Similarity >= opts.MLThreshold
+	if result.MatchedImage != "" {
+		result.Margin = rank.margin()
+	}
+	return result, nil
+}
+
+// lshCandidates narrows pool to the images sharing an LSH bucket with vector.
+// The caller must hold db.Mutex.
+func (db *ImageDatabase) lshCandidates(pool map[string]ImageInfo, vector []float64) map[string]ImageInfo {
+	candidates := make(map[string]ImageInfo)
+	for hash := range db.lsh.Candidates(vector) {
+		if info, ok := pool[hash]; ok {
+			candidates[hash] = info
+		}
+	}
+	return candidates
+}
+
+// snapshot copies the images within the Tags and FilenamePrefix scope of opts
+// under a brief read lock, so a long scan can iterate the copy without
+// blocking AddImage and other writers for its whole duration. The copy is
+// shallow: feature vectors and thumbnails are shared, which is safe because
+// stored records are only ever replaced, never modified in place.
+//
+// The trade-off is staleness: a scan over a snapshot misses images added after
+// it was taken and may report images deleted or replaced since. Callers that
+// must see a consistent, current database should hold db.Mutex instead.
+func (db *ImageDatabase) snapshot(opts MatchOptions) map[string]ImageInfo {
+	db.Mutex.RLock()
+	defer db.Mutex.RUnlock()
+	scoped := db.scope(opts)
+	images := make(map[string]ImageInfo, len(scoped))
+	for hash, info := range scoped {
+		images[hash] = info
+	}
+	return images
+}
+
+// FindMatches returns up to n stored images within the Tags and FilenamePrefix
+// scope of opts, ranked by similarity. Candidates below minSimilarity are
+// dropped, so fewer than n may be returned. Thresholds in opts are ignored.
+// The scan runs over a snapshot, so concurrent adds are not blocked.
+func (db *ImageDatabase) FindMatches(img image.Image, n int, minSimilarity float64, opts MatchOptions) ([]MatchCandidate, string) {
+	img = db.flatten(img)
+	var features featureQuery
+	useML := db.UsesML()
+	if useML {
+		var err error
+		features, err = db.queryFeatures(context.Background(), img)
+		db.Mutex.RLock()
+		useML = err == nil && db.featuresCompatible(features.vector)
+		db.Mutex.RUnlock()
+	}
+	method := "hash"
+	var query hashQuery
+	if useML {
+		method = "ml"
+	} else {
+		query = db.newHashQuery(img, MatchOptions{})
+	}
+	metric := db.Metric()
+
+	candidates := make([]MatchCandidate, 0, n)
+	for _, info := range db.snapshot(opts) {
+		var similarity float64
+		if useML {
+			if !features.compatible(info) {
+				continue
+			}
+			similarity = features.similarity(info.FeatureVector(), metric)
+		} else {
+			var ok bool
+			if similarity, ok = query.similarity(info, 0); !ok {
+				continue
+			}
+		}
 
-		similarity := im.CosineSimilarity(features, info.Features)
-		if similarity > maxSimilarity {
-			maxSimilarity = similarity
-			bestMatch = info.Filename
+		if similarity < minSimilarity {
+			continue
 		}
+		candidates = append(candidates, MatchCandidate{Filename: info.Filename, Similarity: similarity})
 	}
 
-	isMatch := maxSimilarity >= similarityThreshold
-	return isMatch, bestMatch, maxSimilarity
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].Similarity != candidates[j].Similarity {
+			return candidates[i].Similarity > candidates[j].Similarity
+		}
+		return candidates[i].Filename < candidates[j].Filename
+	})
+	if len(candidates) > n {
+		candidates = candidates[:n]
+	}
+	return candidates, method
 }
 
-// AddImage adds new image to the database
+// AddImage adds new image to the database. Byte-identical images are rejected
+// before any hashing or feature extraction is done.
 func (db *ImageDatabase) AddImage(img image.Image, filename string) (string, error) {
-	hash := im.ComputeDCTHash(img)
-	thumbnail := im.GenerateThumbnail(img)
+	info, err := db.AddImageWithTags(img, filename, nil)
+	return info.Hash, err
+}
+
+// AddImageWithTags adds a new image labelled with tags, which searches can
+// then be scoped to through MatchOptions.Tags, and returns the stored record
+func (db *ImageDatabase) AddImageWithTags(img image.Image, filename string, tags []string) (ImageInfo, error) {
+	info, _, err := db.AddImageWithOptions(img, filename, AddOptions{Tags: tags})
+	return info, err
+}
+
+// AddImageOrAlias is AddImageWithTags, except that an image whose hash is
+// already stored is recorded as an alias of the stored one, whose updated
+// record is returned with aliased set. The tags of an alias are ignored.
+func (db *ImageDatabase) AddImageOrAlias(img image.Image, filename string, tags []string) (info ImageInfo, aliased bool, err error) {
+	return db.AddImageWithOptions(img, filename, AddOptions{Tags: tags, AliasDuplicates: true})
+}
+
+// AddOptions controls how AddImageWithOptions stores an image
+type AddOptions struct {
+	Tags []string // Labels searches can be scoped to through MatchOptions.Tags
+
+	// AliasDuplicates records an image whose hash is already stored as an
+	// alias of the stored one instead of rejecting it
+	AliasDuplicates bool
 
-	info := imageInfo{
-		Filename:  filename,
-		Hash:      hash,
-		AddedAt:   time.Now(),
-		Thumbnail: thumbnail,
-		Features:  im.ExtractImageFeatures(img),
+	// SkipFeatures stores only the hashes and thumbnail, leaving the image
+	// with FeaturesPending set for ExtractPending, to speed up bulk imports
+	SkipFeatures bool
+
+	// AddedAt records when the image was added, now when zero. Imports set it
+	// to keep the age that RecencyBoost and sorting by added_at go by.
+	AddedAt time.Time
+}
+
+// AddImageWithOptions adds img as opts directs, reporting whether it was
+// recorded as an alias
+func (db *ImageDatabase) AddImageWithOptions(img image.Image, filename string, opts AddOptions) (ImageInfo, bool, error) {
+	contentHash := im.ContentHash(img)
+	if existing, ok := db.exactDuplicate(contentHash); ok && !opts.AliasDuplicates {
+		return ImageInfo{}, false, fmt.Errorf("image already exists as an exact duplicate: %s", existing)
+	}
+
+	img = db.flatten(img)
+	hash, hashConfig := db.dctHash(img)
+	blocks, _ := db.blockHashes(img)
+	thumbnail := db.generateThumbnail(img)
+	var features []float64
+	if !opts.SkipFeatures {
+		features, _ = db.extractFeatures(context.Background(), img)
+	}
+	features, quantized := db.storeFeatures(features)
+	addedAt := opts.AddedAt
+	if addedAt.IsZero() {
+		addedAt = time.Now()
+	}
+
+	info := ImageInfo{
+		ID:          ImageID(filename),
+		Filename:    filename,
+		Hash:        hash,
+		HashConfig:  hashConfig,
+		ContentHash: contentHash,
+		AddedAt:     addedAt,
+		Thumbnail:   thumbnail,
+		Features:    features,
+		Tags:        NormalizeTags(opts.Tags),
+		Quantized:   quantized,
+		FeatureType: db.FeatureType(),
+		WaveletHash: im.ComputeWaveletHash(img),
+		BlockHashes: blocks,
+		Width:       img.Bounds().Dx(),
+		Height:      img.Bounds().Dy(),
+		Format:      imageFormat(filename),
+
+		FeaturesPending: opts.SkipFeatures,
 	}
 
 	db.Mutex.Lock()
 	defer db.Mutex.Unlock()
 
-	for _, existingInfo := range db.Hashes {
-		if existingInfo.Hash == hash {
-			return "", fmt.Errorf("image already exists: %s", existingInfo.Filename)
+	if _, ok := db.Hashes[hash]; ok && opts.AliasDuplicates {
+		return db.alias(info), true, nil
+	}
+	if existing, ok := db.contentHashes[contentHash]; ok {
+		return ImageInfo{}, false, fmt.Errorf("image already exists as an exact duplicate: %s", existing)
+	}
+	if existing, ok := db.Hashes[hash]; ok {
+		return ImageInfo{}, false, fmt.Errorf("image already exists: %s", existing.Filename)
+	}
+
+	db.index(info)
+	return info, false, nil
+}
+
+// alias records the file of info as an alias of the stored image with the
+// same hash and returns that image's updated record. The stored image stays
+// the record, so its ID does not change. The caller must hold the write lock.
+func (db *ImageDatabase) alias(info ImageInfo) ImageInfo {
+	existing := db.Hashes[info.Hash]
+	// Records handed out earlier share the old slice, so build a new one
+	existing.Aliases = append(slices.Clone(existing.Aliases), info.Filename)
+	sort.Strings(existing.Aliases)
+	db.Hashes[existing.Hash] = existing
+	return existing
+}
+
+// NormalizeTags lowercases and trims tags, dropping empty and repeated ones
+func NormalizeTags(tags []string) []string {
+	var normalized []string
+	seen := make(map[string]bool, len(tags))
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		normalized = append(normalized, tag)
+	}
+	return normalized
+}
+
+// scope returns the stored images within the Tags and FilenamePrefix of opts.
+// The caller must hold db.Mutex.
+func (db *ImageDatabase) scope(opts MatchOptions) map[string]ImageInfo {
+	tagged := db.tagged(opts.Tags)
+	if opts.FilenamePrefix == "" && !opts.pendingOnly {
+		return tagged
+	}
+
+	scoped := make(map[string]ImageInfo)
+	for hash, info := range tagged {
+		if opts.FilenamePrefix != "" && !hasFilenamePrefix(info.Filename, opts.FilenamePrefix) {
+			continue
+		}
+		if opts.pendingOnly && !info.FeaturesPending {
+			continue
+		}
+		scoped[hash] = info
+	}
+	return scoped
+}
+
+// hasFilenamePrefix reports whether filename, or what follows the
+// "<unix nanos>_" stamp /admin/add gives uploads, starts with prefix
+func hasFilenamePrefix(filename, prefix string) bool {
+	if strings.HasPrefix(filename, prefix) {
+		return true
+	}
+	stamp, name, ok := strings.Cut(filename, "_")
+	if !ok || stamp == "" || strings.Trim(stamp, "0123456789") != "" {
+		return false
+	}
+	return strings.HasPrefix(name, prefix)
+}
+
+// tagged returns the stored images carrying every tag in tags, or all images
+// when tags is empty. Only the most selective tag's index is walked, so a rare
+// tag keeps the search small. The caller must hold db.Mutex.
+func (db *ImageDatabase) tagged(tags []string) map[string]ImageInfo {
+	tags = NormalizeTags(tags)
+	if len(tags) == 0 {
+		return db.Hashes
+	}
+
+	smallest := db.tags[tags[0]]
+	for _, tag := range tags[1:] {
+		if len(db.tags[tag]) < len(smallest) {
+			smallest = db.tags[tag]
+		}
+	}
+
+	scoped := make(map[string]ImageInfo, len(smallest))
+	for hash := range smallest {
+		carriesAll := true
+		for _, tag := range tags {
+			if _, ok := db.tags[tag][hash]; !ok {
+				carriesAll = false
+				break
+			}
+		}
+		if carriesAll {
+			scoped[hash] = db.Hashes[hash]
 		}
 	}
+	return scoped
+}
 
-	db.Hashes[hash] = info
-	return hash, nil
+// Thumbnail returns the stored base64 thumbnail of the image with filename
+func (db *ImageDatabase) Thumbnail(filename string) (string, bool) {
+	db.Mutex.RLock()
+	defer db.Mutex.RUnlock()
+	for _, info := range db.Hashes {
+		if info.Filename == filename {
+			return info.Thumbnail, true
+		}
+	}
+	return "", false
+}
+
+// exactDuplicate returns the filename of a stored image with the same pixels
+func (db *ImageDatabase) exactDuplicate(contentHash string) (string, bool) {
+	db.Mutex.RLock()
+	defer db.Mutex.RUnlock()
+	filename, ok := db.contentHashes[contentHash]
+	return filename, ok
 }
@@ -0,0 +1,185 @@
+package database
+
+import (
+	"image"
+	"math"
+	"sort"
+
+	im "photot/helper/image"
+)
+
+// DuplicateCluster groups stored images whose hashes are near-identical
+type DuplicateCluster struct {
+	Representative string   `json:"representative"` // Oldest image in the cluster
+	Filenames      []string `json:"filenames"`
+}
+
+// bkNode is a node in a BK-tree keyed by hamming distance
+type bkNode struct {
+	hash     string
+	children map[int]*bkNode
+}
+
+// insert adds hash to the subtree rooted at n
+func (n *bkNode) insert(hash string) {
+	for {
+		distance, err := im.HammingDistance(n.hash, hash)
+		if err != nil || distance == 0 {
+			return
+		}
+		child, ok := n.children[distance]
+		if !ok {
+			n.children[distance] = &bkNode{hash: hash, children: make(map[int]*bkNode)}
+			return
+		}
+		n = child
+	}
+}
+
+// search collects every hash within radius of hash
+func (n *bkNode) search(hash string, radius int, found []string) []string {
+	distance, err := im.HammingDistance(n.hash, hash)
+	if err != nil {
+		return found
+	}
+	if distance <= radius {
+		found = append(found, n.hash)
+	}
+	for d := distance - radius; d <= distance+radius; d++ {
+		if child, ok := n.children[d]; ok {
+			found = child.search(hash, radius, found)
+		}
+	}
+	return found
+}
+
+// unionFind tracks cluster membership by hash
+type unionFind map[string]string
+
+// find returns the root of hash, compressing the path on the way
+func (u unionFind) find(hash string) string {
+	for u[hash] != hash {
+		u[hash] = u[u[hash]]
+		hash = u[hash]
+	}
+	return hash
+}
+
+// union merges the clusters of a and b
+func (u unionFind) union(a, b string) {
+	rootA, rootB := u.find(a), u.find(b)
+	if rootA != rootB {
+		u[rootB] = rootA
+	}
+}
+
+// FindDuplicates clusters stored images whose hash similarity is at least
+// threshold (0-100). Clusters are transitive: two images end up together if a
+// chain of near-identical images links them. Clustering runs over a snapshot,
+// so images added meanwhile are not considered.
+func (db *ImageDatabase) FindDuplicates(threshold float64) []DuplicateCluster {
+	images := db.snapshot(MatchOptions{})
+	db.Mutex.RLock()
+	config := db.hashConfig.ID()
+	db.Mutex.RUnlock()
+
+	var root *bkNode
+	uf := make(unionFind, len(images))
+	radius := 0
+	for hash, info := range images {
+		uf[hash] = hash
+		if info.HashConfig != config {
+			continue
+		}
+		if root == nil {
+			root = &bkNode{hash: hash, children: make(map[int]*bkNode)}
+			radius = int(math.Floor(float64(len(hash)) * (100 - threshold) / 100))
+			continue
+		}
+		root.insert(hash)
+	}
+	if root == nil {
+		return []DuplicateCluster{}
+	}
+
+	for hash, info := range images {
+		if info.HashConfig != config {
+			continue
+		}
+		for _, neighbour := range root.search(hash, radius, nil) {
+			uf.union(hash, neighbour)
+		}
+	}
+
+	groups := make(map[string][]ImageInfo)
+	for hash, info := range images {
+		groupRoot := uf.find(hash)
+		groups[groupRoot] = append(groups[groupRoot], info)
+	}
+
+	clusters := []DuplicateCluster{}
+	for _, members := range groups {
+		if len(members) < 2 {
+			continue
+		}
+		sort.Slice(members, func(i, j int) bool {
+			if !members[i].AddedAt.Equal(members[j].AddedAt) {
+				return members[i].AddedAt.Before(members[j].AddedAt)
+			}
+			return members[i].Filename < members[j].Filename
+		})
+		cluster := DuplicateCluster{Representative: members[0].Filename}
+		for _, member := range members {
+			cluster.Filenames = append(cluster.Filenames, member.Filename)
+		}
+		clusters = append(clusters, cluster)
+	}
+	sort.Slice(clusters, func(i, j int) bool {
+		return clusters[i].Representative < clusters[j].Representative
+	})
+	return clusters
+}
+
+// Reasons AddCheck gives for an image AddImage would refuse
+const (
+	RejectExactDuplicate = "exact_duplicate" // Same pixels as a stored image
+	RejectHashDuplicate  = "hash_duplicate"  // Same perceptual hash as a stored image
+)
+
+// AddCheck is the outcome CheckAdd predicts for adding an image
+type AddCheck struct {
+	Accepted  bool            `json:"accepted"`
+	Reason    string          `json:"reason,omitempty"`    // RejectExactDuplicate or RejectHashDuplicate when not accepted
+	Duplicate *MatchCandidate `json:"duplicate,omitempty"` // Closest stored image at or above the threshold
+}
+
+// CheckAdd runs the duplicate checks of AddImage on img without storing
+// anything, and also reports the stored image whose hash is closest to img's
+// when their similarity is at least threshold (0-100)
+func (db *ImageDatabase) CheckAdd(img image.Image, threshold float64) AddCheck {
+	contentHash := im.ContentHash(img)
+	query := db.newHashQuery(db.flatten(img), MatchOptions{})
+
+	db.Mutex.RLock()
+	defer db.Mutex.RUnlock()
+
+	if existing, ok := db.contentHashes[contentHash]; ok {
+		return AddCheck{Reason: RejectExactDuplicate, Duplicate: &MatchCandidate{Filename: existing, Similarity: 100}}
+	}
+	if existing, ok := db.Hashes[query.dct]; ok && existing.HashConfig == query.config {
+		return AddCheck{Reason: RejectHashDuplicate, Duplicate: &MatchCandidate{Filename: existing.Filename, Similarity: 100}}
+	}
+
+	check := AddCheck{Accepted: true}
+	for _, info := range db.Hashes {
+		similarity, ok := query.similarity(info, 0)
+		if !ok || similarity < threshold {
+			continue
+		}
+		if check.Duplicate == nil || similarity > check.Duplicate.Similarity ||
+			similarity == check.Duplicate.Similarity && info.Filename < check.Duplicate.Filename {
+			check.Duplicate = &MatchCandidate{Filename: info.Filename, Similarity: similarity}
+		}
+	}
+	return check
+}
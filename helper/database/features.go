@@ -0,0 +1,113 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	im "photot/helper/image"
+	"sort"
+)
+
+// FeatureRecord pairs a stored image with its ML feature vector, the unit of
+// feature export and import
+type FeatureRecord struct {
+	ID       string    `json:"id,omitempty"`
+	Filename string    `json:"filename"`
+	Features []float64 `json:"features"`
+
+	// FeatureType names the extractor of Features; empty in exports from
+	// before it was kept, which are taken to match the database
+	FeatureType string `json:"feature_type,omitempty"`
+}
+
+// ErrFeatureLength is returned when an imported vector's length differs from
+// that of the vectors already stored
+var ErrFeatureLength = errors.New("feature vector length does not match the stored vectors")
+
+// ErrFeatureType is returned when an imported vector was produced by another
+// feature extractor than the database's
+var ErrFeatureType = errors.New("feature type does not match the database's extractor")
+
+// QueryFeatures returns the feature vector the ML branch compares img by,
+// extracted by the configured extractor after flattening as FindMatch does,
+// along with the extractor's feature type
+func (db *ImageDatabase) QueryFeatures(ctx context.Context, img image.Image) ([]float64, string, error) {
+	features, err := db.extractFeatures(ctx, db.flatten(img))
+	return features, db.FeatureType(), err
+}
+
+// ExportFeatures returns the feature vector of every image that has one,
+// dequantized and ordered by filename
+func (db *ImageDatabase) ExportFeatures() []FeatureRecord {
+	images := db.snapshot(MatchOptions{})
+	records := make([]FeatureRecord, 0, len(images))
+	for _, info := range images {
+		if info.featureLen() == 0 {
+			continue
+		}
+		records = append(records, FeatureRecord{
+			ID:          info.ID,
+			Filename:    info.Filename,
+			Features:    info.FeatureVector(),
+			FeatureType: info.featureType(),
+		})
+	}
+
+	sort.Slice(records, func(i, j int) bool { return records[i].Filename < records[j].Filename })
+	return records
+}
+
+// ImportFeatures stores the vector of an exported record with SetFeatures. It
+// fails with ErrFeatureType when the record names another feature type than
+// the database extracts.
+func (db *ImageDatabase) ImportFeatures(record FeatureRecord) error {
+	if featureType := db.FeatureType(); record.FeatureType != "" && record.FeatureType != featureType {
+		return fmt.Errorf("%w: got %s, the database extracts %s", ErrFeatureType, record.FeatureType, featureType)
+	}
+	return db.SetFeatures(record.Filename, record.Features)
+}
+
+// SetFeatures replaces the feature vector of the stored image filename with
+// one computed elsewhere by the database's extractor, quantizing it like
+// extracted vectors. It fails with
+// ErrImageNotFound for unknown files and ErrFeatureLength when the vector
+// could not be compared with the others.
+func (db *ImageDatabase) SetFeatures(filename string, features []float64) error {
+	if len(features) == 0 {
+		return fmt.Errorf("%w: empty vector", ErrFeatureLength)
+	}
+	stored, quantized := db.storeFeatures(features)
+
+	db.Mutex.Lock()
+	defer db.Mutex.Unlock()
+	hash, ok := db.ids[ImageID(filename)]
+	if !ok {
+		return ErrImageNotFound
+	}
+	return db.setFeatures(hash, features, stored, quantized)
+}
+
+// setFeatures does the work of SetFeatures for the image stored under hash,
+// given features and their stored form. The caller must hold the write lock.
+func (db *ImageDatabase) setFeatures(hash string, features, stored []float64, quantized *im.QuantizedVector) error {
+	if db.featureDim != 0 && db.featureDim != len(features) {
+		return fmt.Errorf("%w: got %d, stored vectors have %d", ErrFeatureLength, len(features), db.featureDim)
+	}
+	db.featureDim = len(features)
+
+	info := db.Hashes[hash]
+	info.Features = stored
+	info.Quantized = quantized
+	info.FeatureType = im.FeatureTypeOf(db.extractor)
+	if info.FeaturesPending {
+		info.FeaturesPending = false
+		db.pending--
+	}
+	db.Hashes[hash] = info
+	if db.lsh != nil {
+		db.lsh.Add(hash, features)
+	}
+	db.version++
+	return nil
+}
@@ -0,0 +1,295 @@
+package database
+
+import (
+	"context"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"image"
+	im "photot/helper/image"
+	"sort"
+)
+
+// ErrImageNotFound is returned for an ID no stored image has
+var ErrImageNotFound = errors.New("image not found")
+
+// imageIDNamespace seeds ImageID so its UUIDs don't collide with other name-based UUIDs
+var imageIDNamespace = []byte("photot/image/")
+
+// ImageID returns the stable ID of a stored file: a name-based (version 5 style)
+// UUID of its filename, so an image keeps its ID across restarts and changes
+// to the hash algorithm
+func ImageID(filename string) string {
+	sum := sha1.Sum(append(append([]byte{}, imageIDNamespace...), filename...))
+	sum[6] = sum[6]&0x0f | 0x50 // version 5
+	sum[8] = sum[8]&0x3f | 0x80 // RFC 4122 variant
+	return fmt.Sprintf("%x-%x-%x-%x-%x", sum[0:4], sum[4:6], sum[6:8], sum[8:10], sum[10:16])
+}
+
+// index records info in every lookup map. The caller must hold the write lock.
+func (db *ImageDatabase) index(info ImageInfo) {
+	if db.featureDim == 0 {
+		db.featureDim = info.featureLen()
+	}
+	db.version++
+	db.Hashes[info.Hash] = info
+	if info.FeaturesPending {
+		db.pending++
+	}
+	if db.lsh != nil {
+		db.lsh.Add(info.Hash, info.FeatureVector())
+	}
+	db.contentHashes[info.ContentHash] = info.Filename
+	db.ids[info.ID] = info.Hash
+	for _, tag := range info.Tags {
+		if db.tags[tag] == nil {
+			db.tags[tag] = make(map[string]struct{})
+		}
+		db.tags[tag][info.Hash] = struct{}{}
+	}
+}
+
+// unindex removes info from every lookup map. The caller must hold the write lock.
+func (db *ImageDatabase) unindex(info ImageInfo) {
+	db.version++
+	delete(db.Hashes, info.Hash)
+	if info.FeaturesPending {
+		db.pending--
+	}
+	if db.lsh != nil {
+		db.lsh.Remove(info.Hash)
+	}
+	delete(db.contentHashes, info.ContentHash)
+	delete(db.ids, info.ID)
+	for _, tag := range info.Tags {
+		delete(db.tags[tag], info.Hash)
+		if len(db.tags[tag]) == 0 {
+			delete(db.tags, tag)
+		}
+	}
+}
+
+// Version returns a counter that changes whenever an image is added, removed
+// or replaced, or the match method, metric or background changes, so results
+// computed at one version can be reused until it moves
+func (db *ImageDatabase) Version() uint64 {
+	db.Mutex.RLock()
+	defer db.Mutex.RUnlock()
+	return db.version
+}
+
+// Image returns the stored image with the stable ID id
+func (db *ImageDatabase) Image(id string) (ImageInfo, bool) {
+	db.Mutex.RLock()
+	defer db.Mutex.RUnlock()
+	hash, ok := db.ids[id]
+	if !ok {
+		return ImageInfo{}, false
+	}
+	return db.Hashes[hash], true
+}
+
+// Lookup returns the stored image whose stable ID or DCT hash is key
+func (db *ImageDatabase) Lookup(key string) (ImageInfo, bool) {
+	db.Mutex.RLock()
+	defer db.Mutex.RUnlock()
+	if hash, ok := db.ids[key]; ok {
+		return db.Hashes[hash], true
+	}
+	info, ok := db.Hashes[key]
+	return info, ok
+}
+
+// RemoveAlias forgets the alias filename of the image with the stable ID id,
+// reporting whether it had one. The file is left for the caller to remove.
+func (db *ImageDatabase) RemoveAlias(id, filename string) bool {
+	db.Mutex.Lock()
+	defer db.Mutex.Unlock()
+	hash, ok := db.ids[id]
+	if !ok {
+		return false
+	}
+	info := db.Hashes[hash]
+	for i, alias := range info.Aliases {
+		if alias == filename {
+			info.Aliases = append(info.Aliases[:i:i], info.Aliases[i+1:]...)
+			db.Hashes[hash] = info
+			return true
+		}
+	}
+	return false
+}
+
+// DeleteImage removes the image with the stable ID id and returns its record.
+// The file itself is left for the caller to remove.
+func (db *ImageDatabase) DeleteImage(id string) (ImageInfo, bool) {
+	db.Mutex.Lock()
+	defer db.Mutex.Unlock()
+	hash, ok := db.ids[id]
+	if !ok {
+		return ImageInfo{}, false
+	}
+	info := db.Hashes[hash]
+	db.unindex(info)
+	return info, true
+}
+
+// Clear removes every image, forgets the feature dimension so the next image
+// sets it afresh, and flushes the cache. It returns the removed records; their
+// files are left for the caller to remove.
+func (db *ImageDatabase) Clear() []ImageInfo {
+	db.Mutex.Lock()
+	defer db.Mutex.Unlock()
+	removed := make([]ImageInfo, 0, len(db.Hashes))
+	for _, info := range db.Hashes {
+		removed = append(removed, info)
+	}
+	db.Hashes = make(map[string]ImageInfo)
+	db.contentHashes = make(map[string]string)
+	db.tags = make(map[string]map[string]struct{})
+	db.ids = make(map[string]string)
+	db.featureDim = 0
+	db.pending = 0
+	if db.lsh != nil {
+		db.lsh = NewLSHIndex(db.lsh.tables)
+	}
+	db.version++
+	db.Cache.Flush()
+	return removed
+}
+
+// ReplaceImage swaps the pixels of the image with the stable ID id for img,
+// recomputing its hashes, features, thumbnail and dimensions while keeping
+// its ID, filename, format, tags and AddedAt. Writing the file is left to the
+// caller.
+func (db *ImageDatabase) ReplaceImage(id string, img image.Image) (ImageInfo, error) {
+	contentHash := im.ContentHash(img)
+	img = db.flatten(img)
+	hash, hashConfig := db.dctHash(img)
+	blocks, _ := db.blockHashes(img)
+	thumbnail := db.generateThumbnail(img)
+	features, _ := db.extractFeatures(context.Background(), img)
+	features, quantized := db.storeFeatures(features)
+	wavelet := im.ComputeWaveletHash(img)
+
+	db.Mutex.Lock()
+	defer db.Mutex.Unlock()
+
+	oldHash, ok := db.ids[id]
+	if !ok {
+		return ImageInfo{}, ErrImageNotFound
+	}
+	old := db.Hashes[oldHash]
+	if existing, ok := db.contentHashes[contentHash]; ok && existing != old.Filename {
+		return ImageInfo{}, fmt.Errorf("image already exists as an exact duplicate: %s", existing)
+	}
+	if existing, ok := db.Hashes[hash]; ok && existing.ID != id {
+		return ImageInfo{}, fmt.Errorf("image already exists: %s", existing.Filename)
+	}
+
+	info := old
+	info.Hash = hash
+	info.HashConfig = hashConfig
+	info.ContentHash = contentHash
+	info.Thumbnail = thumbnail
+	info.Features = features
+	info.Quantized = quantized
+	info.FeatureType = im.FeatureTypeOf(db.extractor)
+	info.FeaturesPending = false
+	info.WaveletHash = wavelet
+	info.BlockHashes = blocks
+	info.Width, info.Height = img.Bounds().Dx(), img.Bounds().Dy()
+
+	db.unindex(old)
+	db.index(info)
+	return info, nil
+}
+
+// RestoreImage puts back old, the record ReplaceImage swapped for replaced,
+// when the caller could not write the new file. It does nothing and reports
+// false once the image has changed again or old's hash has been taken.
+func (db *ImageDatabase) RestoreImage(replaced, old ImageInfo) bool {
+	db.Mutex.Lock()
+	defer db.Mutex.Unlock()
+	if hash, ok := db.ids[replaced.ID]; !ok || hash != replaced.Hash || old.ID != replaced.ID {
+		return false
+	}
+	if existing, ok := db.Hashes[old.Hash]; ok && existing.ID != old.ID {
+		return false
+	}
+	db.unindex(db.Hashes[replaced.Hash])
+	db.index(old)
+	return true
+}
+
+// Sort orders accepted by ListOptions
+const (
+	SortAddedAt  = "added_at"
+	SortFilename = "filename"
+)
+
+// ListOptions selects one page of stored images
+type ListOptions struct {
+	Sort       string // SortAddedAt (default) or SortFilename
+	Descending bool
+	Page       int // 1-based
+	PageSize   int // Zero or less returns every image on one page
+}
+
+// ImagePage is one page of ListImagesPage
+type ImagePage struct {
+	Images     []ImageInfo `json:"images"`
+	Total      int         `json:"total"` // Images across all pages
+	Page       int         `json:"page"`
+	PageSize   int         `json:"page_size"`
+	TotalPages int         `json:"total_pages"`
+}
+
+// ListImages returns every stored image, oldest first, without feature vectors
+func (db *ImageDatabase) ListImages() []ImageInfo {
+	return db.ListImagesPage(ListOptions{}).Images
+}
+
+// ListImagesPage returns the images on one page, without feature vectors.
+// Ties on the sort key are broken by filename and then ID, so pages are
+// stable while the database is unchanged.
+func (db *ImageDatabase) ListImagesPage(opts ListOptions) ImagePage {
+	db.Mutex.RLock()
+	images := make([]ImageInfo, 0, len(db.Hashes))
+	for _, info := range db.Hashes {
+		info.Features = nil
+		info.Quantized = nil
+		images = append(images, info)
+	}
+	db.Mutex.RUnlock()
+
+	sort.Slice(images, func(i, j int) bool {
+		a, b := images[i], images[j]
+		if opts.Descending {
+			a, b = b, a
+		}
+		if opts.Sort != SortFilename && !a.AddedAt.Equal(b.AddedAt) {
+			return a.AddedAt.Before(b.AddedAt)
+		}
+		if a.Filename != b.Filename {
+			return a.Filename < b.Filename
+		}
+		return a.ID < b.ID
+	})
+
+	page := ImagePage{Total: len(images), Page: max(opts.Page, 1), PageSize: opts.PageSize}
+	if page.PageSize <= 0 {
+		page.PageSize = max(len(images), 1)
+	}
+	page.TotalPages = (page.Total + page.PageSize - 1) / page.PageSize
+
+	// Pages past the end are empty; checking before multiplying keeps a huge
+	// page number from overflowing into a valid offset
+	start := len(images)
+	if page.Page-1 <= len(images)/page.PageSize {
+		start = min((page.Page-1)*page.PageSize, len(images))
+	}
+	end := start + min(page.PageSize, len(images)-start)
+	page.Images = images[start:end]
+	return page
+}
@@ -0,0 +1,140 @@
+package database
+
+import (
+	"math/rand"
+)
+
+// LSHBits is the number of random hyperplanes hashed per LSH table. More bits
+// make buckets smaller, so fewer candidates are verified but near neighbours
+// are split more often; more tables win that recall back.
+const LSHBits = 10
+
+// lshSeed fixes the hyperplanes, so the same vectors always land in the same
+// buckets and results are reproducible across restarts
+const lshSeed = 1
+
+// LSHIndex is a random-hyperplane locality-sensitive hash over feature
+// vectors. Vectors at a small angle share a bucket in at least one table with
+// high probability, so a query only needs exact comparison against the keys
+// in its buckets rather than against every stored vector. It is not safe for
+// concurrent use; ImageDatabase guards it with its mutex.
+type LSHIndex struct {
+	tables  int
+	planes  [][][]float64 // table -> bit -> hyperplane normal, created on first Add
+	buckets []map[uint64]map[string]struct{}
+	keys    map[string][]uint64 // key -> its bucket in each table, for Remove
+}
+
+// NewLSHIndex creates an index with the given number of tables
+func NewLSHIndex(tables int) *LSHIndex {
+	idx := &LSHIndex{tables: tables, keys: make(map[string][]uint64)}
+	idx.buckets = make([]map[uint64]map[string]struct{}, tables)
+	for t := range idx.buckets {
+		idx.buckets[t] = make(map[uint64]map[string]struct{})
+	}
+	return idx
+}
+
+// Add indexes vector under key, replacing any vector key had. Vectors whose
+// length differs from the first one added are ignored.
+func (idx *LSHIndex) Add(key string, vector []float64) {
+	if len(vector) == 0 {
+		return
+	}
+	if idx.planes == nil {
+		idx.initPlanes(len(vector))
+	}
+	if len(vector) != len(idx.planes[0][0]) {
+		return
+	}
+	idx.Remove(key)
+
+	signatures := idx.signatures(vector)
+	for t, signature := range signatures {
+		bucket := idx.buckets[t][signature]
+		if bucket == nil {
+			bucket = make(map[string]struct{})
+			idx.buckets[t][signature] = bucket
+		}
+		bucket[key] = struct{}{}
+	}
+	idx.keys[key] = signatures
+}
+
+// Remove drops key from the index
+func (idx *LSHIndex) Remove(key string) {
+	signatures, ok := idx.keys[key]
+	if !ok {
+		return
+	}
+	for t, signature := range signatures {
+		delete(idx.buckets[t][signature], key)
+		if len(idx.buckets[t][signature]) == 0 {
+			delete(idx.buckets[t], signature)
+		}
+	}
+	delete(idx.keys, key)
+}
+
+// Len returns the number of indexed keys
+func (idx *LSHIndex) Len() int {
+	return len(idx.keys)
+}
+
+// Candidates returns the keys sharing a bucket with vector in any table
+func (idx *LSHIndex) Candidates(vector []float64) map[string]struct{} {
+	candidates := make(map[string]struct{})
+	if idx.planes == nil || len(vector) != len(idx.planes[0][0]) {
+		return candidates
+	}
+	for t, signature := range idx.signatures(vector) {
+		for key := range idx.buckets[t][signature] {
+			candidates[key] = struct{}{}
+		}
+	}
+	return candidates
+}
+
+// initPlanes draws LSHBits Gaussian hyperplane normals per table
+func (idx *LSHIndex) initPlanes(dim int) {
+	rng := rand.New(rand.NewSource(lshSeed))
+	idx.planes = make([][][]float64, idx.tables)
+	for t := range idx.planes {
+		idx.planes[t] = make([][]float64, LSHBits)
+		for b := range idx.planes[t] {
+			plane := make([]float64, dim)
+			for i := range plane {
+				plane[i] = rng.NormFloat64()
+			}
+			idx.planes[t][b] = plane
+		}
+	}
+}
+
+// signatures returns the bucket of vector in each table: one bit per
+// hyperplane, set when the vector lies on its positive side. The vector is
+// centred first; HOG values are all positive, so uncentred vectors would
+// crowd into the few buckets of the positive orthant.
+func (idx *LSHIndex) signatures(vector []float64) []uint64 {
+	var mean float64
+	for _, v := range vector {
+		mean += v
+	}
+	mean /= float64(len(vector))
+
+	signatures := make([]uint64, idx.tables)
+	for t, planes := range idx.planes {
+		var signature uint64
+		for b, plane := range planes {
+			var dot float64
+			for i, v := range vector {
+				dot += (v - mean) * plane[i]
+			}
+			if dot >= 0 {
+				signature |= 1 << b
+			}
+		}
+		signatures[t] = signature
+	}
+	return signatures
+}
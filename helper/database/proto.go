@@ -0,0 +1,109 @@
+package database
+
+import (
+	"math"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// MarshalProto encodes the response as the RecognizeResponse message in
+// api/proto/recognize.proto. As in proto3, zero values are left out, except
+// the optional scores, rotation, hash distance, block fraction and margin,
+// which are written whenever they are set.
+func (r RecognizeResponse) MarshalProto() []byte {
+	var b []byte
+	b = appendInt(b, 1, int64(r.SchemaVersion))
+	b = appendString(b, 2, r.Result)
+	b = appendDouble(b, 3, r.Similarity)
+	b = appendDouble(b, 4, r.SimilarityNormalized)
+	b = appendString(b, 5, r.Confidence)
+	b = appendString(b, 6, r.MatchedImage)
+	b = appendInt(b, 7, r.ProcessingTimeMs)
+	b = appendString(b, 8, r.Method)
+	for _, candidate := range r.Candidates {
+		var m []byte
+		m = appendString(m, 1, candidate.Filename)
+		m = appendDouble(m, 2, candidate.Similarity)
+		b = appendMessage(b, 9, m)
+	}
+	b = appendString(b, 10, r.Degraded)
+	if r.MLSimilarity != nil {
+		b = protowire.AppendTag(b, 11, protowire.Fixed64Type)
+		b = protowire.AppendFixed64(b, math.Float64bits(*r.MLSimilarity))
+	}
+	if r.HashSimilarity != nil {
+		b = protowire.AppendTag(b, 12, protowire.Fixed64Type)
+		b = protowire.AppendFixed64(b, math.Float64bits(*r.HashSimilarity))
+	}
+	if r.Rotation != nil {
+		b = protowire.AppendTag(b, 13, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(int64(*r.Rotation)))
+	}
+	if r.Tile != nil {
+		var m []byte
+		m = appendInt(m, 1, int64(r.Tile.X))
+		m = appendInt(m, 2, int64(r.Tile.Y))
+		m = appendInt(m, 3, int64(r.Tile.Size))
+		b = appendMessage(b, 14, m)
+	}
+	b = appendString(b, 15, r.MatchedThumbnail)
+	if r.HashDistance != nil {
+		b = protowire.AppendTag(b, 16, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(int64(*r.HashDistance)))
+	}
+	if r.BlockFraction != nil {
+		b = protowire.AppendTag(b, 17, protowire.Fixed64Type)
+		b = protowire.AppendFixed64(b, math.Float64bits(*r.BlockFraction))
+	}
+	if r.Margin != nil {
+		b = protowire.AppendTag(b, 18, protowire.Fixed64Type)
+		b = protowire.AppendFixed64(b, math.Float64bits(*r.Margin))
+	}
+	if r.LowEntropy {
+		b = protowire.AppendTag(b, 19, protowire.VarintType)
+		b = protowire.AppendVarint(b, 1)
+	}
+	if len(r.Features) > 0 {
+		var packed []byte
+		for _, v := range r.Features {
+			packed = protowire.AppendFixed64(packed, math.Float64bits(v))
+		}
+		b = protowire.AppendTag(b, 20, protowire.BytesType)
+		b = protowire.AppendBytes(b, packed)
+	}
+	b = appendString(b, 21, r.FeatureType)
+	return b
+}
+
+// appendInt writes a non-zero int32 or int64 field
+func appendInt(b []byte, num protowire.Number, v int64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, uint64(v))
+}
+
+// appendDouble writes a non-zero double field
+func appendDouble(b []byte, num protowire.Number, v float64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.Fixed64Type)
+	return protowire.AppendFixed64(b, math.Float64bits(v))
+}
+
+// appendString writes a non-empty string field
+func appendString(b []byte, num protowire.Number, v string) []byte {
+	if v == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, v)
+}
+
+// appendMessage writes an embedded message field, even when it is empty
+func appendMessage(b []byte, num protowire.Number, m []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, m)
+}
@@ -0,0 +1,139 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+)
+
+// ErrInvalidTenant is returned for tenant names that are not safe directory names
+var ErrInvalidTenant = errors.New("tenant must be 1-64 lowercase letters, digits, '-' or '_'")
+
+// ErrTenantLimit is returned when creating a tenant would exceed the registry cap
+var ErrTenantLimit = errors.New("tenant limit reached")
+
+// ErrTenantNotFound is returned by Get for a tenant that was never created
+var ErrTenantNotFound = errors.New("tenant does not exist")
+
+// tenantName restricts tenants to names usable as a single path segment
+var tenantName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+// Registry holds an isolated ImageDatabase per tenant, each backed by its
+// own subdirectory of baseDir. Tenants are created explicitly with Create;
+// Get only opens those whose directory already exists.
+type Registry struct {
+	baseDir    string
+	maxTenants int
+	template   *ImageDatabase // ML, metric and thumbnail settings are copied from it
+
+	mu      sync.Mutex
+	tenants map[string]*tenantEntry
+}
+
+// tenantEntry is a tenant database, possibly still loading. ready is closed
+// once db or err is set.
+type tenantEntry struct {
+	ready chan struct{}
+	db    *ImageDatabase
+	err   error
+}
+
+// NewRegistry creates a registry holding at most maxTenants databases whose
+// settings start out as those of template
+func NewRegistry(baseDir string, maxTenants int, template *ImageDatabase) *Registry {
+	return &Registry{
+		baseDir:    baseDir,
+		maxTenants: maxTenants,
+		template:   template,
+		tenants:    make(map[string]*tenantEntry),
+	}
+}
+
+// Get returns the database and image directory of an existing tenant,
+// loading the images in its directory on first use. It fails with
+// ErrTenantNotFound when the tenant was never created, so unauthenticated
+// callers cannot use up the tenant cap.
+func (r *Registry) Get(tenant string) (*ImageDatabase, string, error) {
+	return r.open(tenant, false)
+}
+
+// Create returns the database and image directory of tenant as Get does,
+// creating the tenant first when it does not exist
+func (r *Registry) Create(tenant string) (*ImageDatabase, string, error) {
+	return r.open(tenant, true)
+}
+
+// open returns the database of tenant, creating its directory when create
+// is set. Images are loaded outside the registry lock, so a slow load only
+// holds up requests for the same tenant.
+func (r *Registry) open(tenant string, create bool) (*ImageDatabase, string, error) {
+	if !tenantName.MatchString(tenant) {
+		return nil, "", ErrInvalidTenant
+	}
+	dir := filepath.Join(r.baseDir, tenant)
+
+	r.mu.Lock()
+	if entry, ok := r.tenants[tenant]; ok {
+		r.mu.Unlock()
+		<-entry.ready
+		if entry.err != nil {
+			return nil, "", entry.err
+		}
+		return entry.db, dir, nil
+	}
+	if _, err := os.Stat(dir); os.IsNotExist(err) && !create {
+		r.mu.Unlock()
+		return nil, "", ErrTenantNotFound
+	}
+	if len(r.tenants) >= r.maxTenants {
+		r.mu.Unlock()
+		return nil, "", ErrTenantLimit
+	}
+	entry := &tenantEntry{ready: make(chan struct{})}
+	r.tenants[tenant] = entry
+	r.mu.Unlock()
+
+	entry.db, entry.err = r.load(dir)
+	if entry.err != nil {
+		// Let a later request retry
+		r.mu.Lock()
+		delete(r.tenants, tenant)
+		r.mu.Unlock()
+	}
+	close(entry.ready)
+	if entry.err != nil {
+		return nil, "", entry.err
+	}
+	return entry.db, dir, nil
+}
+
+// load creates dir if needed and loads a database from it
+func (r *Registry) load(dir string) (*ImageDatabase, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("creating tenant directory: %w", err)
+	}
+	db := r.template.NewEmpty()
+	if err := db.LoadImages(dir); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// Each calls fn for every tenant database loaded so far
+func (r *Registry) Each(fn func(*ImageDatabase)) {
+	r.mu.Lock()
+	entries := make([]*tenantEntry, 0, len(r.tenants))
+	for _, entry := range r.tenants {
+		entries = append(entries, entry)
+	}
+	r.mu.Unlock()
+	for _, entry := range entries {
+		<-entry.ready
+		if entry.db != nil {
+			fn(entry.db)
+		}
+	}
+}
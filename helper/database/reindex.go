@@ -0,0 +1,83 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+
+	im "photot/helper/image"
+)
+
+// ReindexResult reports what ExtractPending did
+type ReindexResult struct {
+	Extracted int      `json:"extracted"`        // Images whose features were filled in
+	Failed    []string `json:"failed,omitempty"` // Files that could not be read or extracted, still pending
+	Pending   int      `json:"pending"`          // Images still pending afterwards
+}
+
+// PendingFeatures returns how many stored images await ExtractPending
+func (db *ImageDatabase) PendingFeatures() int {
+	db.Mutex.RLock()
+	defer db.Mutex.RUnlock()
+	return db.pending
+}
+
+// ExtractPending extracts the features of every image added with
+// AddOptions.SkipFeatures, reading its file from imageDir, and clears
+// FeaturesPending on each. Files that fail are reported and stay pending.
+// progress, when set, is called with the share (0-1) of images done. It stops
+// early with ctx's error once ctx ends.
+func (db *ImageDatabase) ExtractPending(ctx context.Context, imageDir string, progress func(float64)) (ReindexResult, error) {
+	var pending []ImageInfo
+	db.Mutex.RLock()
+	for _, info := range db.Hashes {
+		if info.FeaturesPending {
+			pending = append(pending, info)
+		}
+	}
+	db.Mutex.RUnlock()
+	sort.Slice(pending, func(i, j int) bool { return pending[i].Filename < pending[j].Filename })
+
+	var result ReindexResult
+	for i, info := range pending {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		img, err := im.OpenImage(filepath.Join(imageDir, db.FilePath(info.Filename)))
+		var features []float64
+		if err == nil {
+			features, err = db.extractFeatures(ctx, db.flatten(img))
+		}
+		if err == nil {
+			err = db.fillPending(info, features)
+		}
+		if err != nil {
+			result.Failed = append(result.Failed, info.Filename)
+		} else {
+			result.Extracted++
+		}
+		if progress != nil {
+			progress(float64(i+1) / float64(len(pending)))
+		}
+	}
+	result.Pending = db.PendingFeatures()
+	return result, nil
+}
+
+// fillPending stores features for info as SetFeatures does, unless the image
+// was replaced, removed or filled in since info was read
+func (db *ImageDatabase) fillPending(info ImageInfo, features []float64) error {
+	if len(features) == 0 {
+		return fmt.Errorf("%w: empty vector", ErrFeatureLength)
+	}
+	stored, quantized := db.storeFeatures(features)
+
+	db.Mutex.Lock()
+	defer db.Mutex.Unlock()
+	current, ok := db.Hashes[info.Hash]
+	if !ok || current.ID != info.ID || !current.FeaturesPending {
+		return nil
+	}
+	return db.setFeatures(info.Hash, features, stored, quantized)
+}
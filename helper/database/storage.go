@@ -0,0 +1,297 @@
+package database
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// StorageLayout is how image files are laid out in the image directory
+type StorageLayout string
+
+// Storage layouts
+const (
+	// LayoutFlat stores each file directly in the image directory under its
+	// logical filename
+	LayoutFlat StorageLayout = "flat"
+
+	// LayoutContent stores each distinct file once, under the SHA-256 of its
+	// bytes in two levels of shard directories, as ab/cd/abcd...<ext>. The
+	// logical filenames sharing it are listed one per line in a .names file
+	// beside it.
+	LayoutContent StorageLayout = "content"
+)
+
+// namesSuffix ends the sidecar listing the logical filenames of a content blob
+const namesSuffix = ".names"
+
+// ParseStorageLayout validates a layout name, empty meaning flat
+func ParseStorageLayout(name string) (StorageLayout, error) {
+	switch layout := StorageLayout(strings.ToLower(name)); layout {
+	case "", LayoutFlat:
+		return LayoutFlat, nil
+	case LayoutContent:
+		return layout, nil
+	}
+	return "", fmt.Errorf("storage layout must be flat or content, got %q", name)
+}
+
+// SetStorageLayout sets the layout new files are stored in. Files already
+// stored in either layout keep loading.
+func (db *ImageDatabase) SetStorageLayout(layout StorageLayout) {
+	db.Mutex.Lock()
+	defer db.Mutex.Unlock()
+	db.layout = layout
+}
+
+// StorageLayout returns the layout new files are stored in
+func (db *ImageDatabase) StorageLayout() StorageLayout {
+	db.Mutex.RLock()
+	defer db.Mutex.RUnlock()
+	if db.layout == "" {
+		return LayoutFlat
+	}
+	return db.layout
+}
+
+// ContentPath returns the path, relative to the image directory, that the
+// content layout stores a file with the given hex SHA-256 and extension at
+func ContentPath(sum, ext string) string {
+	return filepath.Join(sum[0:2], sum[2:4], sum+strings.ToLower(ext))
+}
+
+// FilePath returns the path, relative to imageDir, of the file stored under
+// the logical filename: its content blob, or the filename itself when it is
+// stored flat
+func (db *ImageDatabase) FilePath(filename string) string {
+	db.storeMu.Lock()
+	defer db.storeMu.Unlock()
+	if path, ok := db.files[filename]; ok {
+		return path
+	}
+	return filename
+}
+
+// StoreContent moves pending, a complete file in imageDir, to its content
+// path and lists filename in the blob's sidecar. When a file with the same
+// bytes is already stored pending is dropped instead, so identical uploads
+// share one file on disk. A previous file of filename, content addressed or
+// flat, is released. With sync the data and directories are flushed.
+func (db *ImageDatabase) StoreContent(imageDir, pending, filename string, sync bool) error {
+	sum, err := fileSHA256(pending)
+	if err != nil {
+		os.Remove(pending)
+		return err
+	}
+	path := ContentPath(sum, filepath.Ext(filename))
+	full := filepath.Join(imageDir, path)
+
+	db.storeMu.Lock()
+	defer db.storeMu.Unlock()
+	if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+		os.Remove(pending)
+		return err
+	}
+	if _, err := os.Stat(full); err == nil {
+		os.Remove(pending)
+	} else {
+		if err := os.Rename(pending, full); err != nil {
+			os.Remove(pending)
+			return err
+		}
+		if sync {
+			syncDir(filepath.Dir(full))
+		}
+	}
+	names, err := readNames(full)
+	if err != nil {
+		return err
+	}
+	if !slices.Contains(names, filename) {
+		if err := writeNames(full, append(names, filename), sync); err != nil {
+			return err
+		}
+	}
+
+	if previous, ok := db.files[filename]; ok && previous != path {
+		if err := db.releaseContent(imageDir, previous, filename, sync); err != nil {
+			return err
+		}
+	} else if !ok {
+		if err := os.Remove(filepath.Join(imageDir, filename)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if db.files == nil {
+		db.files = make(map[string]string)
+	}
+	db.files[filename] = path
+	return nil
+}
+
+// RemoveFile deletes the file stored under the logical filename. A content
+// blob is only deleted with the last filename listed for it. It returns an
+// error satisfying os.IsNotExist when nothing was stored under filename.
+func (db *ImageDatabase) RemoveFile(imageDir, filename string) error {
+	db.storeMu.Lock()
+	defer db.storeMu.Unlock()
+	path, ok := db.files[filename]
+	if !ok {
+		return os.Remove(filepath.Join(imageDir, filename))
+	}
+	delete(db.files, filename)
+	return db.releaseContent(imageDir, path, filename, false)
+}
+
+// releaseContent drops filename from the sidecar of the blob at path,
+// deleting both once no filename is left. The caller holds storeMu.
+func (db *ImageDatabase) releaseContent(imageDir, path, filename string, sync bool) error {
+	full := filepath.Join(imageDir, path)
+	names, err := readNames(full)
+	if err != nil {
+		return err
+	}
+	names = slices.DeleteFunc(names, func(name string) bool { return name == filename })
+	if len(names) > 0 {
+		return writeNames(full, names, sync)
+	}
+	if err := os.Remove(full); err != nil {
+		return err
+	}
+	if err := os.Remove(full + namesSuffix); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// contentFile is a file to load: where it is stored and its logical filename
+type contentFile struct {
+	path     string // Relative to the image directory
+	filename string
+}
+
+// listContentFiles returns the logical filenames stored in the shard
+// directories of imageDir. Blobs without a sidecar, left by a crash before
+// their first filename was listed, are skipped.
+func listContentFiles(imageDir string) ([]contentFile, error) {
+	var files []contentFile
+	shards, err := os.ReadDir(imageDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, shard := range shards {
+		if !shard.IsDir() || !isShard(shard.Name()) {
+			continue
+		}
+		subshards, err := os.ReadDir(filepath.Join(imageDir, shard.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, subshard := range subshards {
+			if !subshard.IsDir() || !isShard(subshard.Name()) {
+				continue
+			}
+			dir := filepath.Join(shard.Name(), subshard.Name())
+			blobs, err := os.ReadDir(filepath.Join(imageDir, dir))
+			if err != nil {
+				return nil, err
+			}
+			for _, blob := range blobs {
+				if blob.IsDir() || strings.HasPrefix(blob.Name(), ".") || strings.HasSuffix(blob.Name(), namesSuffix) {
+					continue
+				}
+				path := filepath.Join(dir, blob.Name())
+				names, err := readNames(filepath.Join(imageDir, path))
+				if err != nil {
+					return nil, err
+				}
+				for _, name := range names {
+					files = append(files, contentFile{path: path, filename: name})
+				}
+			}
+		}
+	}
+	return files, nil
+}
+
+// isShard reports whether name is a shard directory of the content layout
+func isShard(name string) bool {
+	if len(name) != 2 {
+		return false
+	}
+	_, err := hex.DecodeString(name)
+	return err == nil && strings.ToLower(name) == name
+}
+
+// readNames returns the logical filenames listed for the blob at full,
+// none when it has no sidecar
+func readNames(full string) ([]string, error) {
+	data, err := os.ReadFile(full + namesSuffix)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			names = append(names, line)
+		}
+	}
+	return names, nil
+}
+
+// writeNames atomically replaces the sidecar of the blob at full
+func writeNames(full string, names []string, sync bool) error {
+	file, err := os.CreateTemp(filepath.Dir(full), "."+filepath.Base(full)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(file, strings.Join(names, "\n")+"\n")
+	if err == nil && sync {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), full+namesSuffix)
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+	if sync {
+		syncDir(filepath.Dir(full))
+	}
+	return nil
+}
+
+// fileSHA256 returns the hex SHA-256 of the file at path
+func fileSHA256(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	sum := sha256.New()
+	if _, err := io.Copy(sum, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(sum.Sum(nil)), nil
+}
+
+// syncDir flushes a directory so renames into it survive a crash. Not every
+// platform can sync a directory, so failures are ignored.
+func syncDir(dir string) {
+	if file, err := os.Open(dir); err == nil {
+		file.Sync()
+		file.Close()
+	}
+}
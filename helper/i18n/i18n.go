@@ -0,0 +1,166 @@
+package i18n
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Code identifies an API error. Codes are stable across releases and
+// languages, so clients should switch on them rather than on messages.
+type Code string
+
+const (
+	ImageMissing           Code = "IMAGE_MISSING"
+	FileTooLarge           Code = "FILE_TOO_LARGE"
+	RequestTooLarge        Code = "REQUEST_TOO_LARGE"
+	UnsupportedFormat      Code = "UNSUPPORTED_FORMAT"
+	FormatMismatch         Code = "FORMAT_MISMATCH"
+	InvalidImage           Code = "INVALID_IMAGE"
+	HEICNotEnabled         Code = "HEIC_NOT_ENABLED"
+	InvalidDimensions      Code = "INVALID_DIMENSIONS"
+	InvalidParameter       Code = "INVALID_PARAMETER"
+	InvalidCrop            Code = "INVALID_CROP"
+	ImageExists            Code = "IMAGE_EXISTS"
+	SavePermissionDenied   Code = "SAVE_PERMISSION_DENIED"
+	SaveFailed             Code = "SAVE_FAILED"
+	ImageNotFound          Code = "IMAGE_NOT_FOUND"
+	ThumbnailNotAvailable  Code = "THUMBNAIL_NOT_AVAILABLE"
+	TenantsDisabled        Code = "TENANTS_DISABLED"
+	InvalidTenant          Code = "INVALID_TENANT"
+	TenantNotFound         Code = "TENANT_NOT_FOUND"
+	TenantLimit            Code = "TENANT_LIMIT"
+	TenantFailed           Code = "TENANT_FAILED"
+	JobsDisabled           Code = "JOBS_DISABLED"
+	JobQueueFull           Code = "JOB_QUEUE_FULL"
+	JobNotFound            Code = "JOB_NOT_FOUND"
+	InvalidConfig          Code = "INVALID_CONFIG"
+	RateLimited            Code = "RATE_LIMITED"
+	APIKeyMissing          Code = "API_KEY_MISSING"
+	APIKeyInvalid          Code = "API_KEY_INVALID"
+	AdminAuthNotConfigured Code = "ADMIN_AUTH_NOT_CONFIGURED"
+	InternalError          Code = "INTERNAL_ERROR"
+	ServerBusy             Code = "SERVER_BUSY"
+	InvalidFilename        Code = "INVALID_FILENAME"
+	CorruptImage           Code = "CORRUPT_IMAGE"
+	LowEntropy             Code = "LOW_ENTROPY"
+	NotComparable          Code = "NOT_COMPARABLE"
+)
+
+// DefaultLanguage is used when Accept-Language names no supported language
+const DefaultLanguage = "en"
+
+// catalog maps a language to the message of every code. Each language must
+// cover every code.
+var catalog = map[string]map[Code]string{
+	"en": {
+		ImageMissing:           "Image file not found",
+		FileTooLarge:           "File size exceeds 10MB",
+		RequestTooLarge:        "Request body is too large",
+		UnsupportedFormat:      "Unsupported file format. Please upload a valid image.",
+		FormatMismatch:         "File extension does not match its content",
+		InvalidImage:           "Invalid image format",
+		HEICNotEnabled:         "HEIC support is not enabled on this server",
+		InvalidDimensions:      "Image dimensions are outside the allowed range",
+		InvalidParameter:       "Invalid request parameter",
+		InvalidCrop:            "Invalid crop region",
+		ImageExists:            "Image already exists",
+		SavePermissionDenied:   "Permission denied to save image",
+		SaveFailed:             "Error saving image",
+		ImageNotFound:          "Image not found",
+		ThumbnailNotAvailable:  "Thumbnail not available",
+		TenantsDisabled:        "Tenants are not enabled",
+		InvalidTenant:          "Tenant must be 1-64 lowercase letters, digits, '-' or '_'",
+		TenantNotFound:         "Tenant does not exist; add an image to it through /admin/add first",
+		TenantLimit:            "Tenant limit reached",
+		TenantFailed:           "Error opening tenant",
+		JobsDisabled:           "Async jobs are not enabled",
+		JobQueueFull:           "Job queue is full, try again later",
+		JobNotFound:            "Job not found",
+		InvalidConfig:          "Invalid configuration",
+		RateLimited:            "Rate limit exceeded",
+		APIKeyMissing:          "Missing X-API-Key header",
+		APIKeyInvalid:          "Invalid API key",
+		AdminAuthNotConfigured: "Admin routes are disabled until PHOTOT_ADMIN_API_KEY is set",
+		InternalError:          "Internal error",
+		ServerBusy:             "Server is busy, try again later",
+		InvalidFilename:        "Filename has no usable characters",
+		CorruptImage:           "Image is too small or corrupt",
+		LowEntropy:             "Image is nearly a solid color and cannot be used as a reference",
+		NotComparable:          "Stored image cannot be compared by this method",
+	},
+	"uz": {
+		ImageMissing:           "Rasm fayli topilmadi",
+		FileTooLarge:           "Fayl hajmi 10MB dan oshib ketdi",
+		RequestTooLarge:        "So'rov hajmi juda katta",
+		UnsupportedFormat:      "Fayl formati qo'llab-quvvatlanmaydi. Iltimos, to'g'ri rasm yuklang.",
+		FormatMismatch:         "Fayl kengaytmasi uning mazmuniga mos kelmaydi",
+		InvalidImage:           "Rasm formati noto'g'ri",
+		HEICNotEnabled:         "Ushbu serverda HEIC qo'llab-quvvatlanmaydi",
+		InvalidDimensions:      "Rasm o'lchamlari ruxsat etilgan chegaradan tashqarida",
+		InvalidParameter:       "So'rov parametri noto'g'ri",
+		InvalidCrop:            "Kesish sohasi noto'g'ri",
+		ImageExists:            "Rasm allaqachon mavjud",
+		SavePermissionDenied:   "Rasmni saqlashga ruxsat yo'q",
+		SaveFailed:             "Rasmni saqlashda xatolik",
+		ImageNotFound:          "Rasm topilmadi",
+		ThumbnailNotAvailable:  "Kichik rasm mavjud emas",
+		TenantsDisabled:        "Ijarachilar yoqilmagan",
+		InvalidTenant:          "Ijarachi nomi 1-64 ta kichik harf, raqam, '-' yoki '_' dan iborat bo'lishi kerak",
+		TenantNotFound:         "Ijarachi mavjud emas; avval /admin/add orqali unga rasm qo'shing",
+		TenantLimit:            "Ijarachilar soni chegarasiga yetildi",
+		TenantFailed:           "Ijarachini ochishda xatolik",
+		JobsDisabled:           "Asinxron vazifalar yoqilmagan",
+		JobQueueFull:           "Vazifalar navbati to'lgan, keyinroq urinib ko'ring",
+		JobNotFound:            "Vazifa topilmadi",
+		InvalidConfig:          "Sozlamalar noto'g'ri",
+		RateLimited:            "So'rovlar chegarasidan oshib ketdi",
+		APIKeyMissing:          "X-API-Key sarlavhasi yo'q",
+		APIKeyInvalid:          "API kaliti noto'g'ri",
+		AdminAuthNotConfigured: "PHOTOT_ADMIN_API_KEY o'rnatilmaguncha admin yo'llari o'chirilgan",
+		InternalError:          "Ichki xatolik",
+		ServerBusy:             "Server band, keyinroq urinib ko'ring",
+		InvalidFilename:        "Fayl nomida yaroqli belgilar yo'q",
+		CorruptImage:           "Rasm juda kichik yoki buzilgan",
+		LowEntropy:             "Rasm deyarli bir xil rangda, uni namuna sifatida ishlatib bo'lmaydi",
+		NotComparable:          "Saqlangan rasmni bu usul bilan solishtirib bo'lmaydi",
+	},
+}
+
+// Message returns the message for code in lang, falling back to
+// DefaultLanguage and then to the code itself
+func Message(lang string, code Code) string {
+	if message, ok := catalog[lang][code]; ok {
+		return message
+	}
+	if message, ok := catalog[DefaultLanguage][code]; ok {
+		return message
+	}
+	return string(code)
+}
+
+// Language picks the supported language the client prefers most from an
+// Accept-Language header such as "uz-UZ,uz;q=0.9,en;q=0.5". Only the primary
+// subtag is considered; ties keep header order.
+func Language(acceptLanguage string) string {
+	best, bestQ := DefaultLanguage, 0.0
+	for _, entry := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(entry), ";")
+		primary, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(tag)), "-")
+		if _, ok := catalog[primary]; !ok {
+			continue
+		}
+
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		if q > bestQ {
+			best, bestQ = primary, q
+		}
+	}
+	return best
+}
@@ -0,0 +1,30 @@
+package image
+
+import (
+	"image"
+	"math"
+
+	"github.com/disintegration/imaging"
+)
+
+// contrastSize is the side of the grayscale copy Contrast measures
+const contrastSize = 64
+
+// Contrast returns the standard deviation of the luminance (0-255) of a
+// contrastSize x contrastSize grayscale copy of img. Solid and near-solid
+// images, whose hashes are degenerate and alike, score close to 0;
+// downscaling first averages away sensor and JPEG noise.
+func Contrast(img image.Image) float64 {
+	gray := imaging.Grayscale(imaging.Resize(ToRGB(img), contrastSize, contrastSize, imaging.Linear))
+	var sum, squares float64
+	for y := 0; y < contrastSize; y++ {
+		for x := 0; x < contrastSize; x++ {
+			v := float64(gray.Pix[y*gray.Stride+x*4])
+			sum += v
+			squares += v * v
+		}
+	}
+	const n = contrastSize * contrastSize
+	mean := sum / n
+	return math.Sqrt(max(squares/n-mean*mean, 0))
+}
@@ -0,0 +1,60 @@
+package image
+
+import (
+	"io"
+	"strings"
+	"time"
+
+	"github.com/rwcarlsen/goexif/exif"
+)
+
+// EXIF holds the camera, capture time and location recorded in a photo.
+// Fields the file does not carry are left empty.
+type EXIF struct {
+	Make     string     `json:"make,omitempty"`
+	Model    string     `json:"model,omitempty"`
+	Software string     `json:"software,omitempty"`
+	TakenAt  *time.Time `json:"taken_at,omitempty"`
+	GPS      *GPS       `json:"gps,omitempty"`
+}
+
+// GPS is a location in decimal degrees, negative south and west
+type GPS struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+// ReadEXIF parses the EXIF block of a JPEG or TIFF. Images without EXIF,
+// including every other format, yield an empty EXIF rather than an error.
+func ReadEXIF(r io.Reader) EXIF {
+	var meta EXIF
+	x, err := exif.Decode(r)
+	if x == nil || err != nil && exif.IsCriticalError(err) {
+		return meta
+	}
+
+	meta.Make = exifString(x, exif.Make)
+	meta.Model = exifString(x, exif.Model)
+	meta.Software = exifString(x, exif.Software)
+	if taken, err := x.DateTime(); err == nil {
+		meta.TakenAt = &taken
+	}
+	if lat, long, err := x.LatLong(); err == nil {
+		meta.GPS = &GPS{Latitude: lat, Longitude: long}
+	}
+	return meta
+}
+
+// exifString returns a string tag with the NUL padding some cameras write
+// trimmed, or an empty string when the tag is missing
+func exifString(x *exif.Exif, name exif.FieldName) string {
+	tag, err := x.Get(name)
+	if err != nil {
+		return ""
+	}
+	value, err := tag.StringVal()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimRight(value, "\x00"))
+}
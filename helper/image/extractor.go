@@ -0,0 +1,68 @@
+package image
+
+import (
+	"context"
+	"fmt"
+	"image"
+)
+
+// FeatureExtractor computes the vector the ML branch compares. HOGExtractor
+// is the built-in, pure Go implementation; one backed by a neural network can
+// take its place where it is available. Vectors from different extractors are
+// not comparable.
+type FeatureExtractor interface {
+	ExtractFeatures(ctx context.Context, img image.Image) ([]float64, error)
+}
+
+// Feature types name the kind of vector an extractor produces. They are
+// stored with each vector, and vectors of different types are never compared.
+const (
+	FeatureTypeHOG    = "hog"
+	FeatureTypeEdge   = "edge"
+	FeatureTypeCustom = "custom" // Extractors that do not implement FeatureTyper
+)
+
+// FeatureTyper is implemented by extractors that name their feature type
+type FeatureTyper interface {
+	FeatureType() string
+}
+
+// FeatureTypeOf returns the feature type of extractor's vectors, FeatureTypeHOG
+// for nil, which stands for the built-in HOG extraction
+func FeatureTypeOf(extractor FeatureExtractor) string {
+	if extractor == nil {
+		return FeatureTypeHOG
+	}
+	if typer, ok := extractor.(FeatureTyper); ok {
+		return typer.FeatureType()
+	}
+	return FeatureTypeCustom
+}
+
+// ParseFeatureExtractor returns the extractor for a feature type name, hog
+// or edge. hog gives nil, which stands for the built-in HOG extraction with
+// the database's FeatureOptions.
+func ParseFeatureExtractor(name string) (FeatureExtractor, error) {
+	switch name {
+	case "", FeatureTypeHOG:
+		return nil, nil
+	case FeatureTypeEdge:
+		return EdgeExtractor{}, nil
+	}
+	return nil, fmt.Errorf("feature extractor must be hog or edge, got %q", name)
+}
+
+// HOGExtractor extracts HOG features after the preprocessing in Options
+type HOGExtractor struct {
+	Options FeatureOptions
+}
+
+// ExtractFeatures implements FeatureExtractor
+func (e HOGExtractor) ExtractFeatures(ctx context.Context, img image.Image) ([]float64, error) {
+	return ExtractImageFeaturesWithOptions(ctx, img, e.Options)
+}
+
+// FeatureType implements FeatureTyper
+func (e HOGExtractor) FeatureType() string {
+	return FeatureTypeHOG
+}
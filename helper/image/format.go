@@ -0,0 +1,213 @@
+package image
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/disintegration/imaging"
+)
+
+// ErrHEICNotEnabled is returned when HEIC/HEIF content is decoded by a binary
+// built without the heic build tag
+var ErrHEICNotEnabled = errors.New("HEIC support not enabled")
+
+// ErrCorruptImage is returned for content in a recognized format that fails to
+// decode or decodes to no pixels, typically a truncated upload
+var ErrCorruptImage = errors.New("image too small or corrupt")
+
+// SupportedImageFormats maps the file extensions accepted by the service to
+// the format DetectFormat reports for their content. RegisterExtension adds
+// the extensions of formats registered with the image package.
+var SupportedImageFormats = map[string]string{
+	".jpg":  "jpeg",
+	".jpeg": "jpeg",
+	".png":  "png",
+	".gif":  "gif",
+	".bmp":  "bmp",
+	".tiff": "tiff",
+	".webp": "webp",
+	".heic": "heic",
+	".heif": "heic",
+	".svg":  "svg",
+}
+
+// SniffLen is the number of leading bytes DetectFormat needs
+const SniffLen = 12
+
+// heicDecoder is registered by heic.go when built with -tags heic
+var heicDecoder func(io.Reader) (image.Image, error)
+
+// heicBrands are the ISO BMFF major brands used by HEIC/HEIF files
+var heicBrands = map[string]bool{
+	"heic": true,
+	"heix": true,
+	"hevc": true,
+	"hevx": true,
+	"heim": true,
+	"heis": true,
+	"mif1": true,
+	"msf1": true,
+}
+
+// IsImageFile checks if extension is supported
+func IsImageFile(ext string) bool {
+	_, ok := SupportedImageFormats[ext]
+	return ok
+}
+
+// RegisterExtension accepts files with extension ext as the format a
+// decoder was registered under with image.RegisterFormat, e.g. by importing
+// a format plugin for its side effects. Their content is recognized and
+// decoded through the image package's registry. Registering an extension
+// again under the same format does nothing; it is not safe to change
+// SupportedImageFormats while files are being checked, so register at
+// startup.
+func RegisterExtension(ext, format string) error {
+	ext = strings.ToLower(ext)
+	if !strings.HasPrefix(ext, ".") || len(ext) < 2 || strings.ContainsAny(ext[1:], "./\\") {
+		return fmt.Errorf("extension must look like .ext, got %q", ext)
+	}
+	if format == "" {
+		return fmt.Errorf("extension %s needs a format name", ext)
+	}
+	if current, ok := SupportedImageFormats[ext]; ok {
+		if current != format {
+			return fmt.Errorf("extension %s is already read as %s", ext, current)
+		}
+		return nil
+	}
+	SupportedImageFormats[ext] = format
+	return nil
+}
+
+// DetectFormat identifies an image format from its leading bytes, returning
+// an empty string when the content is not a supported image
+func DetectFormat(header []byte) string {
+	switch {
+	case bytes.HasPrefix(header, []byte("\xff\xd8\xff")):
+		return "jpeg"
+	case bytes.HasPrefix(header, []byte("\x89PNG\r\n\x1a\n")):
+		return "png"
+	case bytes.HasPrefix(header, []byte("GIF87a")), bytes.HasPrefix(header, []byte("GIF89a")):
+		return "gif"
+	case bytes.HasPrefix(header, []byte("BM")):
+		return "bmp"
+	case bytes.HasPrefix(header, []byte("II*\x00")), bytes.HasPrefix(header, []byte("MM\x00*")):
+		return "tiff"
+	case len(header) >= 12 && bytes.HasPrefix(header, []byte("RIFF")) && bytes.Equal(header[8:12], []byte("WEBP")):
+		return "webp"
+	case IsHEIC(header):
+		return "heic"
+	case isSVG(header):
+		return "svg"
+	}
+	return registeredFormat(header)
+}
+
+// registeredFormat returns the name of the format registered with the image
+// package whose magic prefix header starts with, empty when none is. Its
+// config decoder is expected to fail on the truncated header; only the
+// sniffed name is used.
+func registeredFormat(header []byte) string {
+	_, format, _ := image.DecodeConfig(bytes.NewReader(header))
+	return format
+}
+
+// IsHEIC reports whether the leading bytes of a file look like HEIC/HEIF
+func IsHEIC(header []byte) bool {
+	if len(header) < 12 || !bytes.Equal(header[4:8], []byte("ftyp")) {
+		return false
+	}
+	return heicBrands[string(header[8:12])]
+}
+
+// DecodeImage decodes an image, routing HEIC/HEIF content through the HEIC
+// decoder and rasterizing SVG at SVGRasterSize. Everything else goes through
+// the decoders registered with the image package: those of the standard
+// library and imaging's imports, and any format plugin imported for its side
+// effects.
+func DecodeImage(r io.Reader) (image.Image, error) {
+	br := bufio.NewReader(r)
+	header, _ := br.Peek(SniffLen)
+	if IsHEIC(header) {
+		if heicDecoder == nil {
+			return nil, ErrHEICNotEnabled
+		}
+		return heicDecoder(br)
+	}
+	if isSVG(header) {
+		return rasterizeSVG(br)
+	}
+	img, _, err := image.Decode(br)
+	if err != nil {
+		if errors.Is(err, image.ErrFormat) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: %v", ErrCorruptImage, err)
+	}
+	if img.Bounds().Empty() {
+		return nil, ErrCorruptImage
+	}
+	return ToRGB(img), nil
+}
+
+// ToRGB converts CMYK images, as decoded from print-workflow JPEGs, to NRGBA
+// once so hashing, features and thumbnails all see the same colors as the RGB
+// original. image/jpeg already undoes the Adobe APP14 channel inversion.
+// Other images are returned unchanged.
+func ToRGB(img image.Image) image.Image {
+	if cmyk, ok := img.(*image.CMYK); ok {
+		return imaging.Clone(cmyk)
+	}
+	return img
+}
+
+// DefaultBackground is the color transparent pixels are flattened onto
+var DefaultBackground = color.NRGBA{R: 255, G: 255, B: 255, A: 255}
+
+// Flatten composites img onto an opaque background of color bg, so a logo on
+// a transparent background hashes and compares like the same logo on bg.
+// Fully transparent pixels would otherwise count as black. Images without
+// transparency are returned unchanged.
+func Flatten(img image.Image, bg color.Color) image.Image {
+	if opaque, ok := img.(interface{ Opaque() bool }); ok && opaque.Opaque() {
+		return img
+	}
+	bounds := img.Bounds()
+	flat := image.NewNRGBA(bounds)
+	draw.Draw(flat, bounds, image.NewUniform(bg), image.Point{}, draw.Src)
+	draw.Draw(flat, bounds, img, bounds.Min, draw.Over)
+	return flat
+}
+
+// ParseHexColor parses an opaque color written as #rrggbb
+func ParseHexColor(s string) (color.NRGBA, error) {
+	hex, ok := strings.CutPrefix(s, "#")
+	if !ok || len(hex) != 6 {
+		return color.NRGBA{}, fmt.Errorf("color must be #rrggbb, got %q", s)
+	}
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return color.NRGBA{}, fmt.Errorf("color must be #rrggbb, got %q", s)
+	}
+	return color.NRGBA{R: uint8(v >> 16), G: uint8(v >> 8), B: uint8(v), A: 255}, nil
+}
+
+// OpenImage opens and decodes an image file using DecodeImage
+func OpenImage(path string) (image.Image, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return DecodeImage(file)
+}
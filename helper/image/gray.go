@@ -0,0 +1,71 @@
+package image
+
+import (
+	"fmt"
+	"image"
+	"strconv"
+	"strings"
+
+	"github.com/disintegration/imaging"
+)
+
+// GrayWeights are the shares of red, green and blue in a grayscale
+// conversion. The zero value means Luminance.
+type GrayWeights struct {
+	R, G, B float64
+}
+
+// Luminance is the Rec. 601 weighting imaging.Grayscale applies
+var Luminance = GrayWeights{R: 0.299, G: 0.587, B: 0.114}
+
+// ParseGrayWeights parses "r,g,b" weights, such as "0.6,0.3,0.1", scaling
+// them to sum to 1 so the result stays in 0-255. An empty string is Luminance.
+func ParseGrayWeights(s string) (GrayWeights, error) {
+	if strings.TrimSpace(s) == "" {
+		return Luminance, nil
+	}
+	parts := strings.Split(s, ",")
+	if len(parts) != 3 {
+		return GrayWeights{}, fmt.Errorf("gray weights must be r,g,b, got %q", s)
+	}
+	var w [3]float64
+	for i, part := range parts {
+		v, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil || v < 0 {
+			return GrayWeights{}, fmt.Errorf("gray weight %q must be a non-negative number", part)
+		}
+		w[i] = v
+	}
+	sum := w[0] + w[1] + w[2]
+	if sum == 0 {
+		return GrayWeights{}, fmt.Errorf("gray weights must not all be 0")
+	}
+	return GrayWeights{R: w[0] / sum, G: w[1] / sum, B: w[2] / sum}, nil
+}
+
+// IsLuminance reports whether w is the standard weighting
+func (w GrayWeights) IsLuminance() bool {
+	return w == GrayWeights{} || w == Luminance
+}
+
+// String formats w as ParseGrayWeights accepts it
+func (w GrayWeights) String() string {
+	if w == (GrayWeights{}) {
+		w = Luminance
+	}
+	return fmt.Sprintf("%g,%g,%g", w.R, w.G, w.B)
+}
+
+// Grayscale converts img to grayscale with the given weights, pixel for pixel
+// as imaging.Grayscale does for Luminance
+func Grayscale(img image.Image, w GrayWeights) *image.NRGBA {
+	if w.IsLuminance() {
+		return imaging.Grayscale(img)
+	}
+	dst := imaging.Clone(img)
+	for i := 0; i+3 < len(dst.Pix); i += 4 {
+		y := uint8(w.R*float64(dst.Pix[i]) + w.G*float64(dst.Pix[i+1]) + w.B*float64(dst.Pix[i+2]) + 0.5)
+		dst.Pix[i], dst.Pix[i+1], dst.Pix[i+2] = y, y, y
+	}
+	return dst
+}
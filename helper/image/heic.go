@@ -0,0 +1,10 @@
+//go:build heic
+
+package image
+
+import "github.com/jdeng/goheif"
+
+// HEIC decoding needs cgo (libde265), so it is only compiled in with -tags heic
+func init() {
+	heicDecoder = goheif.Decode
+}
@@ -2,7 +2,10 @@ package image
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"fmt"
 	"image"
 	"image/color"
@@ -10,23 +13,74 @@ import (
 	"strings"
 
 	"github.com/disintegration/imaging"
+	"github.com/gen2brain/webp"
 )
 
+// HashConfig sets the geometry of the DCT hash. The image is resized to
+// Size x Size, split into Grid x Grid blocks whose means give the first
+// Grid*Grid bits, and sampled on a 2*Grid row lattice for the horizontal
+// gradient bits. Gray weights the channels of the grayscale copy, the zero
+// value meaning Luminance. Hashes are only comparable between identical
+// configs, except for Filter: the resize filter, DefaultHashFilter when
+// empty, shifts a few bits at most and is not part of the ID.
+type HashConfig struct {
+	Size   int
+	Grid   int
+	Gray   GrayWeights
+	Filter string
+}
+
+// DefaultHashConfig is the 32x32, 4x4 grid hash every stored image used
+// before the grid became configurable; it yields 72 bits
+var DefaultHashConfig = HashConfig{Size: 32, Grid: 4}
+
+// Validate reports whether the grid divides the image evenly
+func (c HashConfig) Validate() error {
+	if c.Grid < 2 {
+		return fmt.Errorf("hash grid must be at least 2, got %d", c.Grid)
+	}
+	if c.Size < 2*c.Grid || c.Size%(2*c.Grid) != 0 {
+		return fmt.Errorf("hash size %d must be a positive multiple of twice the grid (%d)", c.Size, 2*c.Grid)
+	}
+	return nil
+}
+
+// ID identifies the config a hash was computed with, e.g. "dct-32x4", or
+// "dct-32x4-gray0.6,0.3,0.1" with custom gray weights
+func (c HashConfig) ID() string {
+	if !c.Gray.IsLuminance() {
+		return fmt.Sprintf("dct-%dx%d-gray%s", c.Size, c.Grid, c.Gray)
+	}
+	return fmt.Sprintf("dct-%dx%d", c.Size, c.Grid)
+}
+
+// Length is the number of bits a hash with this config has
+func (c HashConfig) Length() int {
+	samples := 2 * c.Grid
+	return c.Grid*c.Grid + samples*(samples-1)
+}
+
 // computeDCTHash calculates perceptual hash using Discrete Cosine Transform
 func ComputeDCTHash(img image.Image) string {
-	resized := imaging.Resize(img, 32, 32, imaging.Lanczos)
-	gray := imaging.Grayscale(resized)
-	const blockSize = 8
-	const numBlocks = 16
-	blockValues := make([]float64, numBlocks)
+	return ComputeDCTHashWithConfig(img, DefaultHashConfig)
+}
+
+// ComputeDCTHashWithConfig calculates the hash with the given geometry. The
+// config must be valid.
+func ComputeDCTHashWithConfig(img image.Image, cfg HashConfig) string {
+	size, grid := cfg.Size, cfg.Grid
+	resized := imaging.Resize(ToRGB(img), size, size, resizeFilter(cfg.Filter, DefaultHashFilter))
+	gray := Grayscale(resized, cfg.Gray)
+	blockSize := size / grid
+	blockValues := make([]float64, grid*grid)
 
 	blockIndex := 0
-	for by := 0; by < 4; by++ {
-		for bx := 0; bx < 4; bx++ {
+	for by := 0; by < grid; by++ {
+		for bx := 0; bx < grid; bx++ {
 			var sum float64
 			var count int
-			for y := by * blockSize; y < (by+1)*blockSize && y < 32; y++ {
-				for x := bx * blockSize; x < (bx+1)*blockSize && x < 32; x++ {
+			for y := by * blockSize; y < (by+1)*blockSize && y < size; y++ {
+				for x := bx * blockSize; x < (bx+1)*blockSize && x < size; x++ {
 					c := color.GrayModel.Convert(gray.At(x, y)).(color.Gray)
 					sum += float64(c.Y)
 					count++
@@ -51,10 +105,12 @@ func ComputeDCTHash(img image.Image) string {
 			hash.WriteString("0")
 		}
 	}
-	for y := 0; y < 8; y++ {
-		for x := 0; x < 7; x++ {
-			c1 := color.GrayModel.Convert(gray.At(x*4, y*4)).(color.Gray)
-			c2 := color.GrayModel.Convert(gray.At((x+1)*4, y*4)).(color.Gray)
+	samples := 2 * grid
+	step := size / samples
+	for y := 0; y < samples; y++ {
+		for x := 0; x < samples-1; x++ {
+			c1 := color.GrayModel.Convert(gray.At(x*step, y*step)).(color.Gray)
+			c2 := color.GrayModel.Convert(gray.At((x+1)*step, y*step)).(color.Gray)
 
 			if c1.Y > c2.Y {
 				hash.WriteString("1")
@@ -69,16 +125,65 @@ func ComputeDCTHash(img image.Image) string {
 
 // extractImageFeatures extracts HOG (Histogram of Oriented Gradients) features
 func ExtractImageFeatures(img image.Image) []float64 {
+	features, _ := ExtractImageFeaturesContext(context.Background(), img)
+	return features
+}
+
+// ExtractImageFeaturesContext extracts HOG features, stopping early with the
+// context's error if it is cancelled or its deadline passes
+func ExtractImageFeaturesContext(ctx context.Context, img image.Image) ([]float64, error) {
+	return ExtractImageFeaturesWithOptions(ctx, img, FeatureOptions{})
+}
+
+// FeatureOptions controls the preprocessing applied before HOG extraction.
+// Stored and query features must use the same options to be comparable.
+type FeatureOptions struct {
+	// Equalize spreads the grayscale histogram over the full range, so the
+	// same scene under a different exposure produces similar gradients
+	Equalize bool
+
+	// ColorHistogram appends an 8-bin histogram of each RGB channel after
+	// the HOG features, so the same shapes in different colors score lower.
+	// Grayscale queries should be compared on the first HOGLength values only.
+	ColorHistogram bool
+
+	// Filter resizes the image to 64x64, DefaultHashFilter when empty
+	Filter string
+}
+
+// HOGLength is the number of HOG values at the start of every feature vector:
+// 3x3 blocks with 16 orientations
+const HOGLength = 144
+
+// colorBins is the number of histogram bins per channel with ColorHistogram
+const colorBins = 8
+
+// grayTolerance is how far apart the channels of a pixel may be for
+// IsGrayscale to still count it as gray, absorbing JPEG chroma noise
+const grayTolerance = 8
+
+// ExtractImageFeaturesWithOptions extracts HOG features after the preprocessing in opts
+func ExtractImageFeaturesWithOptions(ctx context.Context, img image.Image, opts FeatureOptions) ([]float64, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	// Resize image to 64x64
-	resized := imaging.Resize(img, 64, 64, imaging.Lanczos)
+	resized := imaging.Resize(img, 64, 64, resizeFilter(opts.Filter, DefaultHashFilter))
 	gray := imaging.Grayscale(resized)
+	if opts.Equalize {
+		gray = equalizeHistogram(gray)
+	}
 
 	// Simple HOG implementation
-	features := make([]float64, 0, 144) // 3x3 blocks with 16 orientations
+	features := make([]float64, 0, HOGLength+3*colorBins)
 
 	// Calculate HOG features
 	for by := 0; by < 3; by++ {
 		for bx := 0; bx < 3; bx++ {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
 			histogram := make([]float64, 16) // 16 orientation bins
 
 			for y := by*20 + 2; y < (by+1)*20-2 && y < 64; y++ {
@@ -120,7 +225,75 @@ func ExtractImageFeatures(img image.Image) []float64 {
 		}
 	}
 
-	return features
+	if opts.ColorHistogram {
+		features = append(features, colorHistogram(resized)...)
+	}
+	return features, nil
+}
+
+// colorHistogram returns a colorBins histogram of the red, green and blue
+// channels of img, each normalized to unit length like a HOG block
+func colorHistogram(img *image.NRGBA) []float64 {
+	histogram := make([]float64, 3*colorBins)
+	for i := 0; i < len(img.Pix); i += 4 {
+		for channel := 0; channel < 3; channel++ {
+			histogram[channel*colorBins+int(img.Pix[i+channel])*colorBins/256]++
+		}
+	}
+	for channel := 0; channel < 3; channel++ {
+		bins := histogram[channel*colorBins : (channel+1)*colorBins]
+		var sum float64
+		for _, count := range bins {
+			sum += count * count
+		}
+		norm := math.Sqrt(sum + 1e-6)
+		for i := range bins {
+			bins[i] /= norm
+		}
+	}
+	return histogram
+}
+
+// IsGrayscale reports whether every pixel of img has (nearly) equal red,
+// green and blue values, as in a desaturated copy of a color image
+func IsGrayscale(img image.Image) bool {
+	small := imaging.Resize(img, 64, 64, imaging.Box)
+	for i := 0; i < len(small.Pix); i += 4 {
+		r, g, b := int(small.Pix[i]), int(small.Pix[i+1]), int(small.Pix[i+2])
+		if max(r, g, b)-min(r, g, b) > grayTolerance {
+			return false
+		}
+	}
+	return true
+}
+
+// equalizeHistogram remaps the levels of a grayscale image through its
+// cumulative histogram so they are spread evenly over 0-255
+func equalizeHistogram(gray *image.NRGBA) *image.NRGBA {
+	var histogram [256]int
+	for i := 0; i < len(gray.Pix); i += 4 {
+		histogram[gray.Pix[i]]++
+	}
+
+	var cdf [256]int
+	total, cdfMin := 0, 0
+	for level, count := range histogram {
+		total += count
+		cdf[level] = total
+		if cdfMin == 0 {
+			cdfMin = total
+		}
+	}
+	if total == cdfMin {
+		return gray // A single level has nothing to spread
+	}
+
+	equalized := imaging.Clone(gray)
+	for i := 0; i < len(equalized.Pix); i += 4 {
+		level := uint8((cdf[equalized.Pix[i]] - cdfMin) * 255 / (total - cdfMin))
+		equalized.Pix[i], equalized.Pix[i+1], equalized.Pix[i+2] = level, level, level
+	}
+	return equalized
 }
 
 // cosineSimilarity calculates similarity between two feature vectors
@@ -143,17 +316,170 @@ func CosineSimilarity(a, b []float64) float64 {
 	return dotProduct / (math.Sqrt(normA) * math.Sqrt(normB)) * 100.0
 }
 
+// ThumbnailOptions controls the size and encoding of generated thumbnails
+type ThumbnailOptions struct {
+	Width   int
+	Format  ThumbnailFormat
+	Quality int    // JPEG and WebP quality (1-100); zero keeps the encoder default
+	Filter  string // Resize filter, DefaultThumbnailFilter when empty
+}
+
+// ThumbnailFormat is the encoding of generated thumbnails
+type ThumbnailFormat string
+
+const (
+	ThumbnailJPEG ThumbnailFormat = "jpeg"
+	ThumbnailPNG  ThumbnailFormat = "png"
+	ThumbnailWebP ThumbnailFormat = "webp" // Lossy, at the same quality setting as JPEG
+)
+
+// DefaultThumbnailOptions produces 100px wide JPEG thumbnails
+var DefaultThumbnailOptions = ThumbnailOptions{Width: 100, Format: ThumbnailJPEG}
+
+// ParseThumbnailFormat validates a thumbnail format name, accepting "jpg"
+// for "jpeg"
+func ParseThumbnailFormat(name string) (ThumbnailFormat, error) {
+	switch format := ThumbnailFormat(strings.ToLower(name)); format {
+	case "jpg":
+		return ThumbnailJPEG, nil
+	case ThumbnailJPEG, ThumbnailPNG, ThumbnailWebP:
+		return format, nil
+	}
+	return "", fmt.Errorf("unsupported thumbnail format: %s", name)
+}
+
+// DistanceMetric selects how two feature vectors are compared
+type DistanceMetric string
+
+const (
+	MetricCosine    DistanceMetric = "cosine"
+	MetricEuclidean DistanceMetric = "euclidean"
+	MetricManhattan DistanceMetric = "manhattan"
+)
+
+// ParseDistanceMetric validates a metric name
+func ParseDistanceMetric(name string) (DistanceMetric, error) {
+	switch metric := DistanceMetric(strings.ToLower(name)); metric {
+	case MetricCosine, MetricEuclidean, MetricManhattan:
+		return metric, nil
+	}
+	return "", fmt.Errorf("unknown distance metric: %s", name)
+}
+
+// FeatureSimilarity compares two feature vectors with metric, returning 0-100
+// where 100 means identical. For the non-negative HOG vectors every metric
+// scores orthogonal vectors as 0.
+func FeatureSimilarity(a, b []float64, metric DistanceMetric) float64 {
+	switch metric {
+	case MetricEuclidean:
+		return EuclideanSimilarity(a, b)
+	case MetricManhattan:
+		return ManhattanSimilarity(a, b)
+	default:
+		return CosineSimilarity(a, b)
+	}
+}
+
+// EuclideanSimilarity converts L2 distance to 0-100, scaled by the distance
+// between the vectors if they were orthogonal
+func EuclideanSimilarity(a, b []float64) float64 {
+	if len(a) != len(b) {
+		return 0
+	}
+
+	var distance, normA, normB float64
+	for i := range a {
+		diff := a[i] - b[i]
+		distance += diff * diff
+		normA += a[i] * a[i]
+		normB += b[i] * b[i]
+	}
+
+	if normA+normB <= 0 {
+		return 0
+	}
+	similarity := (1 - math.Sqrt(distance)/math.Sqrt(normA+normB)) * 100.0
+	return math.Max(0, similarity)
+}
+
+// ManhattanSimilarity converts L1 distance to 0-100, scaled by the sum of both L1 norms
+func ManhattanSimilarity(a, b []float64) float64 {
+	if len(a) != len(b) {
+		return 0
+	}
+
+	var distance, total float64
+	for i := range a {
+		distance += math.Abs(a[i] - b[i])
+		total += math.Abs(a[i]) + math.Abs(b[i])
+	}
+
+	if total <= 0 {
+		return 0
+	}
+	return (1 - distance/total) * 100.0
+}
+
 // generateThumbnail creates base64 encoded thumbnail
 func GenerateThumbnail(img image.Image) string {
-	thumbnail := imaging.Resize(img, 100, 0, imaging.Lanczos)
+	return GenerateThumbnailWithOptions(img, DefaultThumbnailOptions)
+}
+
+// GenerateThumbnailWithOptions creates a base64 encoded thumbnail of the
+// width, format and quality in opts
+func GenerateThumbnailWithOptions(img image.Image, opts ThumbnailOptions) string {
+	thumbnail := imaging.Resize(img, opts.Width, 0, resizeFilter(opts.Filter, DefaultThumbnailFilter))
 	var buf bytes.Buffer
-	err := imaging.Encode(&buf, thumbnail, imaging.JPEG)
+	var err error
+	switch opts.Format {
+	case ThumbnailWebP:
+		// imaging has no WebP encoder
+		quality := webp.DefaultQuality
+		if opts.Quality > 0 {
+			quality = opts.Quality
+		}
+		err = webp.Encode(&buf, thumbnail, webp.Options{Quality: quality, Method: webp.DefaultMethod})
+	case ThumbnailPNG:
+		err = imaging.Encode(&buf, thumbnail, imaging.PNG)
+	default:
+		var encodeOpts []imaging.EncodeOption
+		if opts.Quality > 0 {
+			encodeOpts = append(encodeOpts, imaging.JPEGQuality(opts.Quality))
+		}
+		err = imaging.Encode(&buf, thumbnail, imaging.JPEG, encodeOpts...)
+	}
 	if err != nil {
 		return ""
 	}
 	return base64.StdEncoding.EncodeToString(buf.Bytes())
 }
 
+// ContentHash returns a SHA-256 of the decoded pixels as non-premultiplied
+// RGBA, identical for byte-identical images. Rows are hashed as they are read
+// rather than copying the whole image first.
+func ContentHash(img image.Image) string {
+	b := img.Bounds()
+	sum := sha256.New()
+	fmt.Fprintf(sum, "%dx%d:", b.Dx(), b.Dy())
+	if nrgba, ok := img.(*image.NRGBA); ok {
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			i := nrgba.PixOffset(b.Min.X, y)
+			sum.Write(nrgba.Pix[i : i+b.Dx()*4])
+		}
+		return hex.EncodeToString(sum.Sum(nil))
+	}
+	row := make([]byte, b.Dx()*4)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			i := (x - b.Min.X) * 4
+			row[i], row[i+1], row[i+2], row[i+3] = c.R, c.G, c.B, c.A
+		}
+		sum.Write(row)
+	}
+	return hex.EncodeToString(sum.Sum(nil))
+}
+
 // hammingDistance calculates difference between two hashes
 func HammingDistance(hash1, hash2 string) (int, error) {
 	if len(hash1) != len(hash2) {
@@ -0,0 +1,118 @@
+package image
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// QuantizationMode selects the precision feature vectors are stored at
+type QuantizationMode string
+
+const (
+	QuantizeNone    QuantizationMode = "none"    // float64, 8 bytes per value
+	QuantizeFloat16 QuantizationMode = "float16" // IEEE half precision, 2 bytes per value
+	QuantizeInt8    QuantizationMode = "int8"    // Linear steps of the largest magnitude, 1 byte per value
+)
+
+// ParseQuantizationMode validates a quantization mode name
+func ParseQuantizationMode(name string) (QuantizationMode, error) {
+	switch mode := QuantizationMode(strings.ToLower(name)); mode {
+	case QuantizeNone, QuantizeFloat16, QuantizeInt8:
+		return mode, nil
+	}
+	return "", fmt.Errorf("unknown quantization mode: %s", name)
+}
+
+// QuantizedVector is a feature vector stored at reduced precision
+type QuantizedVector struct {
+	Int8    []int8   `json:"int8,omitempty"`
+	Scale   float64  `json:"scale,omitempty"` // Value of one Int8 step
+	Float16 []uint16 `json:"float16,omitempty"`
+}
+
+// Quantize reduces v to the precision of mode. QuantizeNone returns nil.
+func Quantize(v []float64, mode QuantizationMode) *QuantizedVector {
+	switch mode {
+	case QuantizeInt8:
+		var maxAbs float64
+		for _, value := range v {
+			maxAbs = math.Max(maxAbs, math.Abs(value))
+		}
+		q := &QuantizedVector{Int8: make([]int8, len(v)), Scale: maxAbs / 127}
+		if q.Scale == 0 {
+			return q
+		}
+		for i, value := range v {
+			q.Int8[i] = int8(math.Round(value / q.Scale))
+		}
+		return q
+	case QuantizeFloat16:
+		q := &QuantizedVector{Float16: make([]uint16, len(v))}
+		for i, value := range v {
+			q.Float16[i] = toFloat16(float32(value))
+		}
+		return q
+	}
+	return nil
+}
+
+// Len returns the number of values in q
+func (q *QuantizedVector) Len() int {
+	return len(q.Float16) + len(q.Int8)
+}
+
+// Dequantize expands q back to float64 values
+func (q *QuantizedVector) Dequantize() []float64 {
+	if q.Float16 != nil {
+		v := make([]float64, len(q.Float16))
+		for i, half := range q.Float16 {
+			v[i] = float64(fromFloat16(half))
+		}
+		return v
+	}
+	v := make([]float64, len(q.Int8))
+	for i, step := range q.Int8 {
+		v[i] = float64(step) * q.Scale
+	}
+	return v
+}
+
+// toFloat16 rounds f to the nearest IEEE 754 half, saturating to infinity and
+// flushing values below the smallest normal half to zero
+func toFloat16(f float32) uint16 {
+	bits := math.Float32bits(f)
+	sign := uint16(bits>>16) & 0x8000
+	exponent := int(bits>>23&0xff) - 127 + 15
+	mantissa := bits & 0x7fffff
+
+	switch {
+	case exponent >= 0x1f:
+		return sign | 0x7c00
+	case exponent <= 0:
+		return sign
+	}
+	half := uint32(exponent)<<10 | mantissa>>13
+	if mantissa&0x1000 != 0 { // Round half up on the dropped bits
+		half++
+	}
+	if half >= 0x7c00 {
+		return sign | 0x7c00
+	}
+	return sign | uint16(half)
+}
+
+// fromFloat16 expands an IEEE 754 half produced by toFloat16
+func fromFloat16(h uint16) float32 {
+	sign := uint32(h&0x8000) << 16
+	exponent := uint32(h >> 10 & 0x1f)
+	mantissa := uint32(h & 0x3ff)
+
+	switch exponent {
+	case 0:
+		return math.Float32frombits(sign)
+	case 0x1f:
+		return math.Float32frombits(sign | 0x7f800000)
+	}
+	return math.Float32frombits(sign | (exponent-15+127)<<23 | mantissa<<13)
+}
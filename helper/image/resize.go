@@ -0,0 +1,55 @@
+package image
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/disintegration/imaging"
+)
+
+// Resize filters, from fastest to sharpest
+const (
+	FilterNearest    = "nearest"
+	FilterBox        = "box"
+	FilterLinear     = "linear"
+	FilterCatmullRom = "catmullrom"
+	FilterLanczos    = "lanczos"
+)
+
+// DefaultHashFilter is the filter hashes and HOG features downscale with. At
+// their 32x32 and 64x64 targets bilinear filtering loses nothing Lanczos
+// keeps, at a fraction of the cost. Box is faster still, but flips hash bits
+// on smooth gradients where neighbouring samples are nearly equal.
+const DefaultHashFilter = FilterLinear
+
+// DefaultThumbnailFilter is the filter thumbnails are resized with, where
+// sharpness shows
+const DefaultThumbnailFilter = FilterLanczos
+
+// resizeFilters maps filter names to imaging's resampling filters
+var resizeFilters = map[string]imaging.ResampleFilter{
+	FilterNearest:    imaging.NearestNeighbor,
+	FilterBox:        imaging.Box,
+	FilterLinear:     imaging.Linear,
+	FilterCatmullRom: imaging.CatmullRom,
+	FilterLanczos:    imaging.Lanczos,
+}
+
+// ParseResizeFilter validates a filter name, case-insensitively, and returns
+// it in the form the options structs take
+func ParseResizeFilter(name string) (string, error) {
+	name = strings.ToLower(name)
+	if _, ok := resizeFilters[name]; !ok {
+		return "", fmt.Errorf("resize filter must be nearest, box, linear, catmullrom or lanczos, got %q", name)
+	}
+	return name, nil
+}
+
+// resizeFilter returns the named filter, or fallback's when name is empty or
+// unknown
+func resizeFilter(name, fallback string) imaging.ResampleFilter {
+	if filter, ok := resizeFilters[name]; ok {
+		return filter
+	}
+	return resizeFilters[fallback]
+}
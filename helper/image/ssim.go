@@ -0,0 +1,90 @@
+package image
+
+import (
+	"image"
+
+	"github.com/disintegration/imaging"
+)
+
+// ssimSize is the side of the grayscale copies SSIM compares
+const ssimSize = 128
+
+// ssimWindow and ssimStride set the local windows whose statistics SSIM
+// averages; windows overlap by half
+const (
+	ssimWindow = 8
+	ssimStride = 4
+)
+
+// Stabilizing constants of SSIM for 8-bit luminance: (0.01*255)^2 and (0.03*255)^2
+const (
+	ssimC1 = 6.5025
+	ssimC2 = 58.5225
+)
+
+// SSIM returns the mean structural similarity of two images, compared as
+// ssimSize x ssimSize grayscale copies over overlapping 8x8 windows. It is 1
+// for identical images and falls towards 0, or below for inverted structure,
+// as luminance, contrast and structure diverge. Unlike hashes and HOG
+// features it scores how alike two specific images look, not whether one is
+// a copy of the other.
+func SSIM(img1, img2 image.Image) float64 {
+	return NewSSIMPlane(img1).SSIM(NewSSIMPlane(img2))
+}
+
+// SSIMPlane is the grayscale copy SSIM compares, for scoring one image
+// against many without resizing it each time
+type SSIMPlane []float64
+
+// NewSSIMPlane returns the luminance of img resized to ssimSize x ssimSize
+func NewSSIMPlane(img image.Image) SSIMPlane {
+	gray := imaging.Grayscale(imaging.Resize(ToRGB(img), ssimSize, ssimSize, imaging.Lanczos))
+	plane := make(SSIMPlane, ssimSize*ssimSize)
+	for y := 0; y < ssimSize; y++ {
+		for x := 0; x < ssimSize; x++ {
+			plane[y*ssimSize+x] = float64(gray.Pix[y*gray.Stride+x*4])
+		}
+	}
+	return plane
+}
+
+// SSIM returns the mean structural similarity of two planes, as SSIM does
+// for the images they were made from
+func (a SSIMPlane) SSIM(b SSIMPlane) float64 {
+	var total float64
+	windows := 0
+	for y := 0; y+ssimWindow <= ssimSize; y += ssimStride {
+		for x := 0; x+ssimWindow <= ssimSize; x += ssimStride {
+			total += ssimWindowScore(a, b, x, y)
+			windows++
+		}
+	}
+	return total / float64(windows)
+}
+
+// ssimWindowScore computes SSIM over the window at (x0, y0)
+func ssimWindowScore(a, b []float64, x0, y0 int) float64 {
+	const n = ssimWindow * ssimWindow
+	var sumA, sumB float64
+	for y := y0; y < y0+ssimWindow; y++ {
+		for x := x0; x < x0+ssimWindow; x++ {
+			sumA += a[y*ssimSize+x]
+			sumB += b[y*ssimSize+x]
+		}
+	}
+	meanA, meanB := sumA/n, sumB/n
+
+	var varA, varB, cov float64
+	for y := y0; y < y0+ssimWindow; y++ {
+		for x := x0; x < x0+ssimWindow; x++ {
+			da, db := a[y*ssimSize+x]-meanA, b[y*ssimSize+x]-meanB
+			varA += da * da
+			varB += db * db
+			cov += da * db
+		}
+	}
+	varA, varB, cov = varA/(n-1), varB/(n-1), cov/(n-1)
+
+	return (2*meanA*meanB + ssimC1) * (2*cov + ssimC2) /
+		((meanA*meanA + meanB*meanB + ssimC1) * (varA + varB + ssimC2))
+}
@@ -0,0 +1,50 @@
+package image
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"math"
+
+	"github.com/srwiley/oksvg"
+	"github.com/srwiley/rasterx"
+)
+
+// SVGRasterSize is the length in pixels of the longer side of a rasterized SVG.
+// Hashes and features depend on it, so changing it requires re-adding SVG
+// reference images.
+const SVGRasterSize = 512
+
+// isSVG reports whether the leading bytes of a file look like SVG or XML text
+func isSVG(header []byte) bool {
+	header = bytes.TrimPrefix(header, []byte("\xef\xbb\xbf"))
+	header = bytes.TrimLeft(header, " \t\r\n")
+	return bytes.HasPrefix(header, []byte("<svg")) || bytes.HasPrefix(header, []byte("<?xml"))
+}
+
+// rasterizeSVG renders an SVG onto a white background with its longer side
+// scaled to SVGRasterSize, so transparent logos hash the same way every time
+func rasterizeSVG(r io.Reader) (image.Image, error) {
+	icon, err := oksvg.ReadIconStream(r)
+	if err != nil {
+		return nil, err
+	}
+	viewW, viewH := icon.ViewBox.W, icon.ViewBox.H
+	if viewW <= 0 || viewH <= 0 {
+		return nil, errors.New("svg has no usable viewBox or size")
+	}
+
+	scale := SVGRasterSize / math.Max(viewW, viewH)
+	width := max(1, int(math.Round(viewW*scale)))
+	height := max(1, int(math.Round(viewH*scale)))
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(img, img.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	icon.SetTarget(0, 0, float64(width), float64(height))
+	scanner := rasterx.NewScannerGV(width, height, img, img.Bounds())
+	icon.Draw(rasterx.NewDasher(width, height, scanner), 1)
+	return img, nil
+}
@@ -0,0 +1,90 @@
+package image
+
+import (
+	"image"
+	"math"
+
+	"github.com/disintegration/imaging"
+)
+
+// BlockGrid is the number of blocks per side ComputeBlockHashes splits an
+// image into
+const BlockGrid = 4
+
+// Tiles returns the size x size windows that slide across bounds in steps of
+// stride, row by row. The last window of each row and column is moved back to
+// end on the edge, so no strip of bounds is left uncovered. Nothing is
+// returned when the window is larger than bounds or stride is not positive.
+func Tiles(bounds image.Rectangle, size, stride int) []image.Rectangle {
+	if size <= 0 || stride <= 0 || size > bounds.Dx() || size > bounds.Dy() {
+		return nil
+	}
+	xs := tileOffsets(bounds.Dx(), size, stride)
+	ys := tileOffsets(bounds.Dy(), size, stride)
+
+	tiles := make([]image.Rectangle, 0, len(xs)*len(ys))
+	for _, y := range ys {
+		for _, x := range xs {
+			tiles = append(tiles, image.Rect(x, y, x+size, y+size).Add(bounds.Min))
+		}
+	}
+	return tiles
+}
+
+// TileCount returns len(Tiles(bounds, size, stride)) without building the
+// windows, to check the count before committing to the allocation. Counts
+// that do not fit an int are reported as math.MaxInt.
+func TileCount(bounds image.Rectangle, size, stride int) int {
+	if size <= 0 || stride <= 0 || size > bounds.Dx() || size > bounds.Dy() {
+		return 0
+	}
+	xs := tileOffsetCount(bounds.Dx(), size, stride)
+	ys := tileOffsetCount(bounds.Dy(), size, stride)
+	if xs > math.MaxInt/ys {
+		return math.MaxInt
+	}
+	return xs * ys
+}
+
+// tileOffsetCount returns len(tileOffsets(length, size, stride))
+func tileOffsetCount(length, size, stride int) int {
+	n := (length-size)/stride + 1
+	if (length-size)%stride != 0 {
+		n++
+	}
+	return n
+}
+
+// tileOffsets returns the window starts along one side of the given length
+func tileOffsets(length, size, stride int) []int {
+	var offsets []int
+	for offset := 0; offset+size <= length; offset += stride {
+		offsets = append(offsets, offset)
+	}
+	if last := length - size; offsets[len(offsets)-1] != last {
+		offsets = append(offsets, last)
+	}
+	return offsets
+}
+
+// ComputeBlockHashes splits img into a BlockGrid x BlockGrid grid and returns
+// the DCT hash of each block with cfg, row by row. Comparing images block by
+// block lets a match tolerate part of one being covered. Nothing is returned
+// for images narrower or shorter than BlockGrid pixels.
+func ComputeBlockHashes(img image.Image, cfg HashConfig) []string {
+	b := img.Bounds()
+	if b.Dx() < BlockGrid || b.Dy() < BlockGrid {
+		return nil
+	}
+	hashes := make([]string, 0, BlockGrid*BlockGrid)
+	for row := 0; row < BlockGrid; row++ {
+		for col := 0; col < BlockGrid; col++ {
+			block := image.Rect(
+				b.Min.X+col*b.Dx()/BlockGrid, b.Min.Y+row*b.Dy()/BlockGrid,
+				b.Min.X+(col+1)*b.Dx()/BlockGrid, b.Min.Y+(row+1)*b.Dy()/BlockGrid,
+			)
+			hashes = append(hashes, ComputeDCTHashWithConfig(imaging.Crop(img, block), cfg))
+		}
+	}
+	return hashes
+}
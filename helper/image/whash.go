@@ -0,0 +1,68 @@
+package image
+
+import (
+	"image"
+	"sort"
+	"strings"
+
+	"github.com/disintegration/imaging"
+)
+
+// waveletSize is the side of the grayscale image ComputeWaveletHash transforms
+const waveletSize = 32
+
+// waveletLevels Haar steps reduce waveletSize to the 8x8 approximation band
+// the 64 hash bits come from
+const waveletLevels = 2
+
+// ComputeWaveletHash calculates a 64-bit wavelet hash: a 32x32 grayscale copy
+// is decomposed with a two-level Haar transform and each coefficient of the
+// remaining 8x8 low-frequency band is compared to the band's median. JPEG
+// artifacts live mostly in the discarded high-frequency bands, so it holds up
+// better under heavy compression than ComputeDCTHash.
+func ComputeWaveletHash(img image.Image) string {
+	gray := imaging.Grayscale(imaging.Resize(ToRGB(img), waveletSize, waveletSize, imaging.Lanczos))
+
+	band := make([][]float64, waveletSize)
+	for y := range band {
+		band[y] = make([]float64, waveletSize)
+		for x := range band[y] {
+			band[y][x] = float64(gray.Pix[y*gray.Stride+x*4]) / 255
+		}
+	}
+	for level := 0; level < waveletLevels; level++ {
+		band = haarApproximation(band)
+	}
+
+	values := make([]float64, 0, len(band)*len(band))
+	for _, row := range band {
+		values = append(values, row...)
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	median := (sorted[len(sorted)/2-1] + sorted[len(sorted)/2]) / 2
+
+	var hash strings.Builder
+	for _, value := range values {
+		if value > median {
+			hash.WriteString("1")
+		} else {
+			hash.WriteString("0")
+		}
+	}
+	return hash.String()
+}
+
+// haarApproximation applies one level of the 2D Haar transform and returns
+// only its low-low band, half the size of band in each direction
+func haarApproximation(band [][]float64) [][]float64 {
+	size := len(band) / 2
+	low := make([][]float64, size)
+	for y := range low {
+		low[y] = make([]float64, size)
+		for x := range low[y] {
+			low[y][x] = (band[2*y][2*x] + band[2*y][2*x+1] + band[2*y+1][2*x] + band[2*y+1][2*x+1]) / 2
+		}
+	}
+	return low
+}
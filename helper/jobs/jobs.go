@@ -0,0 +1,156 @@
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// ErrQueueFull is returned by Submit when too many jobs are waiting to run
+var ErrQueueFull = errors.New("job queue is full")
+
+// QueueSize is the number of submitted jobs that may wait for a worker
+const QueueSize = 64
+
+// Status is the lifecycle state of a job
+type Status string
+
+const (
+	StatusPending Status = "pending"
+	StatusRunning Status = "running"
+	StatusDone    Status = "done"
+	StatusFailed  Status = "failed"
+)
+
+// Func is the work of a job. It reports progress (0-1) through progress and
+// should return early once ctx is cancelled.
+type Func func(ctx context.Context, progress func(float64)) (any, error)
+
+// Job is a snapshot of a submitted job
+type Job struct {
+	ID         string     `json:"id"`
+	Kind       string     `json:"kind"`
+	Status     Status     `json:"status"`
+	Progress   float64    `json:"progress"`
+	Result     any        `json:"result,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// task pairs a queued job with its work
+type task struct {
+	id string
+	fn Func
+}
+
+// Manager runs jobs on a fixed pool of workers and forgets finished jobs
+// once they are older than its TTL
+type Manager struct {
+	mu    sync.Mutex
+	jobs  map[string]*Job
+	queue chan task
+	ttl   time.Duration
+}
+
+// NewManager starts workers goroutines; finished jobs are kept for ttl
+func NewManager(workers int, ttl time.Duration) *Manager {
+	m := &Manager{
+		jobs:  make(map[string]*Job),
+		queue: make(chan task, QueueSize),
+		ttl:   ttl,
+	}
+	for i := 0; i < workers; i++ {
+		go m.work()
+	}
+	return m
+}
+
+// Submit queues fn as a job of the given kind and returns its ID
+func (m *Manager) Submit(kind string, fn Func) (string, error) {
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	id := hex.EncodeToString(buf)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.expire(time.Now())
+
+	select {
+	case m.queue <- task{id: id, fn: fn}:
+	default:
+		return "", ErrQueueFull
+	}
+	m.jobs[id] = &Job{ID: id, Kind: kind, Status: StatusPending, CreatedAt: time.Now()}
+	return id, nil
+}
+
+// Get returns a snapshot of the job with id
+func (m *Manager) Get(id string) (Job, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.expire(time.Now())
+	job, ok := m.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return *job, true
+}
+
+// work runs queued jobs until the process exits
+func (m *Manager) work() {
+	for t := range m.queue {
+		m.update(t.id, func(job *Job) { job.Status = StatusRunning })
+		result, err := m.run(t)
+
+		m.update(t.id, func(job *Job) {
+			now := time.Now()
+			job.FinishedAt = &now
+			if err != nil {
+				job.Status = StatusFailed
+				job.Error = err.Error()
+				return
+			}
+			job.Status = StatusDone
+			job.Progress = 1
+			job.Result = result
+		})
+	}
+}
+
+// run calls the job's function, turning a panic into an error
+func (m *Manager) run(t task) (result any, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			slog.Error("job panicked", "job", t.id, "panic", r)
+			err = fmt.Errorf("job panicked: %v", r)
+		}
+	}()
+	progress := func(p float64) {
+		m.update(t.id, func(job *Job) { job.Progress = min(max(p, 0), 1) })
+	}
+	return t.fn(context.Background(), progress)
+}
+
+// update applies fn to the job with id under the lock
+func (m *Manager) update(id string, fn func(*Job)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if job, ok := m.jobs[id]; ok {
+		fn(job)
+	}
+}
+
+// expire drops finished jobs older than the TTL. The caller must hold m.mu.
+func (m *Manager) expire(now time.Time) {
+	for id, job := range m.jobs {
+		if job.FinishedAt != nil && now.Sub(*job.FinishedAt) > m.ttl {
+			delete(m.jobs, id)
+		}
+	}
+}
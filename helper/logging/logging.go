@@ -0,0 +1,39 @@
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// level is the least severe level logged, shared by every logger Init makes
+var level = new(slog.LevelVar)
+
+// Init makes a logger writing key=value lines to w the default of both slog
+// and the log package, logging at the level last set by SetLevel, info until
+// then
+func Init(w io.Writer) {
+	slog.SetDefault(slog.New(slog.NewTextHandler(w, &slog.HandlerOptions{Level: level})))
+}
+
+// ParseLevel parses error, warn, info or debug, case-insensitively
+func ParseLevel(name string) (slog.Level, error) {
+	switch strings.ToLower(name) {
+	case "error":
+		return slog.LevelError, nil
+	case "warn":
+		return slog.LevelWarn, nil
+	case "info":
+		return slog.LevelInfo, nil
+	case "debug":
+		return slog.LevelDebug, nil
+	}
+	return 0, fmt.Errorf("log level must be error, warn, info or debug, got %q", name)
+}
+
+// SetLevel changes the level of loggers made by Init, including ones made
+// before the call
+func SetLevel(l slog.Level) {
+	level.Set(l)
+}
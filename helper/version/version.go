@@ -0,0 +1,43 @@
+package version
+
+import "runtime/debug"
+
+// Build identity, set at link time, e.g.
+//
+//	go build -ldflags "-X photot/helper/version.Commit=$(git rev-parse HEAD) -X photot/helper/version.BuildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+//
+// Version follows the @version swagger annotation in api/router.go.
+var (
+	Version   = "1.1"
+	Commit    = ""
+	BuildTime = ""
+)
+
+// Info identifies the running binary
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildTime string `json:"build_time"`
+	GoVersion string `json:"go_version"`
+}
+
+// Get returns the build identity. A commit not set through -ldflags is taken
+// from the VCS stamp go build embeds; anything still missing is "unknown".
+func Get() Info {
+	info := Info{Version: Version, Commit: Commit, BuildTime: BuildTime, GoVersion: "unknown"}
+	if build, ok := debug.ReadBuildInfo(); ok {
+		info.GoVersion = build.GoVersion
+		for _, setting := range build.Settings {
+			if setting.Key == "vcs.revision" && info.Commit == "" {
+				info.Commit = setting.Value
+			}
+		}
+	}
+	if info.Commit == "" {
+		info.Commit = "unknown"
+	}
+	if info.BuildTime == "" {
+		info.BuildTime = "unknown"
+	}
+	return info
+}
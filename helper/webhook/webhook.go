@@ -0,0 +1,137 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// QueueSize is the number of events that may wait for delivery before new
+// ones are dropped
+const QueueSize = 100
+
+// maxAttempts bounds delivery attempts per event
+const maxAttempts = 3
+
+// Event is the JSON body posted to the webhook
+type Event struct {
+	Event        string    `json:"event"` // Always "match"
+	RequestID    string    `json:"request_id"`
+	MatchedImage string    `json:"matched_image"`
+	Similarity   float64   `json:"similarity"`
+	Method       string    `json:"method"`
+	Timestamp    time.Time `json:"timestamp"`
+}
+
+// Notifier delivers events from a bounded queue on a background goroutine,
+// so a slow webhook never blocks the request that triggered it
+type Notifier struct {
+	queue    chan Event
+	settings func() (string, string)
+	client   *http.Client
+
+	// Backoff is the delay before the first retry, doubling on each one
+	Backoff time.Duration
+}
+
+// NewNotifier starts a notifier; settings returns the webhook URL and HMAC
+// secret and is read for every delivery, so changes apply immediately
+func NewNotifier(settings func() (string, string)) *Notifier {
+	n := &Notifier{
+		queue:    make(chan Event, QueueSize),
+		settings: settings,
+		client:   &http.Client{Timeout: 5 * time.Second},
+		Backoff:  time.Second,
+	}
+	go n.run()
+	return n
+}
+
+// Notify queues event for delivery, dropping it with a log line when the
+// queue is full. It is a no-op on a nil notifier or when no URL is set.
+func (n *Notifier) Notify(event Event) {
+	if n == nil {
+		return
+	}
+	if url, _ := n.settings(); url == "" {
+		return
+	}
+	if event.Event == "" {
+		event.Event = "match"
+	}
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now().UTC()
+	}
+
+	select {
+	case n.queue <- event:
+	default:
+		slog.Warn("webhook queue full, dropping event", "request_id", event.RequestID)
+	}
+}
+
+// run delivers queued events one at a time
+func (n *Notifier) run() {
+	for event := range n.queue {
+		body, err := json.Marshal(event)
+		if err != nil {
+			slog.Error("webhook event not encodable", "request_id", event.RequestID, "error", err)
+			continue
+		}
+
+		backoff := n.Backoff
+		for attempt := 1; ; attempt++ {
+			err = n.deliver(body)
+			if err == nil {
+				break
+			}
+			if attempt == maxAttempts {
+				slog.Error("webhook delivery failed", "request_id", event.RequestID, "attempts", attempt, "error", err)
+				break
+			}
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+}
+
+// deliver posts body once, signing it when a secret is configured
+func (n *Notifier) deliver(body []byte) error {
+	url, secret := n.settings()
+	if url == "" {
+		return nil
+	}
+
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if secret != "" {
+		req.Header.Set("X-Photot-Signature", "sha256="+Sign(body, secret))
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return nil
+}
+
+// Sign returns the hex HMAC-SHA256 of body keyed by secret, as sent in the
+// X-Photot-Signature header
+func Sign(body []byte, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,30 @@
+package i18n_test
+
+import (
+	"photot/helper/i18n"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLanguage(t *testing.T) {
+	cases := map[string]string{
+		"":                        "en",
+		"uz":                      "uz",
+		"uz-UZ,en;q=0.8":          "uz",
+		"ru-RU,en;q=0.5,uz;q=0.9": "uz",
+		"en;q=0.9,UZ-Latn;q=0.9":  "en",
+		"fr-FR,de;q=0.8":          "en",
+		"uz;q=bogus,en;q=0.1":     "en",
+	}
+	for header, want := range cases {
+		assert.Equal(t, want, i18n.Language(header), header)
+	}
+}
+
+func TestMessage(t *testing.T) {
+	assert.Equal(t, "Image file not found", i18n.Message("en", i18n.ImageMissing))
+	assert.Equal(t, "Rasm fayli topilmadi", i18n.Message("uz", i18n.ImageMissing))
+	assert.Equal(t, "Image file not found", i18n.Message("fr", i18n.ImageMissing))
+	assert.Equal(t, "SOMETHING_NEW", i18n.Message("uz", "SOMETHING_NEW"))
+}
@@ -0,0 +1,517 @@
+package image_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"io"
+	"math/rand"
+	"os"
+	"strings"
+	"testing"
+
+	im "photot/helper/image"
+
+	"github.com/disintegration/imaging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFeatureSimilarityMetrics(t *testing.T) {
+	a := []float64{1, 0, 2, 0}
+	b := []float64{0, 3, 0, 1}
+
+	for _, metric := range []im.DistanceMetric{im.MetricCosine, im.MetricEuclidean, im.MetricManhattan} {
+		assert.InDelta(t, 100.0, im.FeatureSimilarity(a, a, metric), 1e-9, metric)
+		assert.InDelta(t, 0.0, im.FeatureSimilarity(a, b, metric), 1e-9, metric)
+		assert.Equal(t, 0.0, im.FeatureSimilarity(a, []float64{1}, metric), metric)
+	}
+
+	_, err := im.ParseDistanceMetric("chebyshev")
+	assert.Error(t, err)
+}
+
+func TestCMYKHashMatchesRGBTwin(t *testing.T) {
+	// testdata/cmyk.jpg is a print-workflow JPEG: CMYK with an Adobe APP14
+	// marker and inverted ink values. testdata/rgb.jpg is its RGB export.
+	cmykData, err := os.ReadFile("testdata/cmyk.jpg")
+	require.NoError(t, err)
+	rgbData, err := os.ReadFile("testdata/rgb.jpg")
+	require.NoError(t, err)
+	assert.True(t, bytes.HasPrefix(cmykData, []byte("\xff\xd8\xff\xee\x00\x0eAdobe")))
+	raw, err := jpeg.Decode(bytes.NewReader(cmykData))
+	require.NoError(t, err)
+	require.IsType(t, &image.CMYK{}, raw)
+
+	cmyk, err := im.DecodeImage(bytes.NewReader(cmykData))
+	require.NoError(t, err)
+	assert.IsType(t, &image.NRGBA{}, cmyk)
+	rgb, err := im.DecodeImage(bytes.NewReader(rgbData))
+	require.NoError(t, err)
+
+	// The inversion is undone, so the red disc is red rather than cyan
+	r, g, b, _ := cmyk.At(40, 40).RGBA()
+	assert.InDelta(t, 220, r>>8, 12)
+	assert.InDelta(t, 40, g>>8, 12)
+	assert.InDelta(t, 30, b>>8, 12)
+
+	distance, err := im.HammingDistance(im.ComputeDCTHash(rgb), im.ComputeDCTHash(cmyk))
+	assert.NoError(t, err)
+	assert.LessOrEqual(t, distance, 2)
+	assert.Greater(t, im.CosineSimilarity(im.ExtractImageFeatures(rgb), im.ExtractImageFeatures(cmyk)), 99.0)
+}
+
+func TestDecodeSVG(t *testing.T) {
+	logo := `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 100 50">
+	<rect x="25" y="10" width="50" height="30" fill="#ff0000"/>
+</svg>`
+	assert.Equal(t, "svg", im.DetectFormat([]byte(logo[:im.SniffLen])))
+
+	img, err := im.DecodeImage(strings.NewReader(logo))
+	assert.NoError(t, err)
+	assert.Equal(t, im.SVGRasterSize, img.Bounds().Dx())
+	assert.Equal(t, im.SVGRasterSize/2, img.Bounds().Dy())
+
+	r, g, b, _ := img.At(5, 5).RGBA()
+	assert.Equal(t, [3]uint32{0xffff, 0xffff, 0xffff}, [3]uint32{r, g, b}, "transparent areas are white")
+	r, g, b, _ = img.At(256, 128).RGBA()
+	assert.Equal(t, [3]uint32{0xffff, 0, 0}, [3]uint32{r, g, b})
+}
+
+func TestEqualizedFeaturesTolerateDarkening(t *testing.T) {
+	scene := image.NewRGBA(image.Rect(0, 0, 120, 120))
+	for y := 0; y < 120; y++ {
+		for x := 0; x < 120; x++ {
+			v := 60 + x
+			switch {
+			case (x-40)*(x-40)+(y-50)*(y-50) < 400:
+				v = 200 - y/2
+			case x > 70 && y > 60 && x < 110 && y < 100:
+				v = 90 + y/3
+			case y > 100:
+				v = 170
+			}
+			scene.Set(x, y, color.RGBA{R: uint8(v), G: uint8(v), B: uint8(v), A: 255})
+		}
+	}
+	darkened := imaging.AdjustGamma(scene, 0.3)
+
+	similarity := func(opts im.FeatureOptions) float64 {
+		a, err := im.ExtractImageFeaturesWithOptions(context.Background(), scene, opts)
+		assert.NoError(t, err)
+		b, err := im.ExtractImageFeaturesWithOptions(context.Background(), darkened, opts)
+		assert.NoError(t, err)
+		return im.CosineSimilarity(a, b)
+	}
+
+	plain := similarity(im.FeatureOptions{})
+	equalized := similarity(im.FeatureOptions{Equalize: true})
+	assert.Greater(t, equalized, plain)
+	assert.Greater(t, equalized, 98.0)
+}
+
+func TestQuantizedSimilarityStaysClose(t *testing.T) {
+	a := im.ExtractImageFeatures(imaging.Blur(createGradient(), 1))
+	b := im.ExtractImageFeatures(createGradient())
+	full := im.CosineSimilarity(a, b)
+
+	for mode, epsilon := range map[im.QuantizationMode]float64{im.QuantizeFloat16: 0.01, im.QuantizeInt8: 0.5} {
+		qa, qb := im.Quantize(a, mode), im.Quantize(b, mode)
+		assert.InDelta(t, full, im.CosineSimilarity(qa.Dequantize(), qb.Dequantize()), epsilon, mode)
+		assert.InDelta(t, 100, im.CosineSimilarity(a, qa.Dequantize()), epsilon, mode)
+	}
+	assert.Nil(t, im.Quantize(a, im.QuantizeNone))
+
+	_, err := im.ParseQuantizationMode("int4")
+	assert.Error(t, err)
+}
+
+func TestWaveletHashSurvivesCompression(t *testing.T) {
+	similarity := func(hash func(image.Image) string, a, b image.Image) float64 {
+		ha, hb := hash(a), hash(b)
+		distance, err := im.HammingDistance(ha, hb)
+		assert.NoError(t, err)
+		return 100 - float64(distance)*100/float64(len(ha))
+	}
+
+	const samples, threshold = 20, 95.0
+	var dctMatches, waveletMatches int
+	for seed := int64(1); seed <= samples; seed++ {
+		scene := createScene(seed)
+		var buf bytes.Buffer
+		assert.NoError(t, jpeg.Encode(&buf, scene, &jpeg.Options{Quality: 5}))
+		compressed, err := jpeg.Decode(&buf)
+		assert.NoError(t, err)
+
+		if similarity(im.ComputeDCTHash, scene, compressed) >= threshold {
+			dctMatches++
+		}
+		if similarity(im.ComputeWaveletHash, scene, compressed) >= threshold {
+			waveletMatches++
+		}
+		assert.Less(t, similarity(im.ComputeWaveletHash, scene, createScene(seed+samples)), threshold)
+	}
+	t.Logf("matches at %g%% after quality 5 JPEG: dct %d/%d, wavelet %d/%d", threshold, dctMatches, samples, waveletMatches, samples)
+	assert.Greater(t, waveletMatches, dctMatches)
+	assert.Len(t, im.ComputeWaveletHash(createGradient()), 64)
+}
+
+// createScene draws a gradient overlaid with a few random discs
+func createScene(seed int64) image.Image {
+	r := rand.New(rand.NewSource(seed))
+	type disc struct {
+		x, y, radius int
+		color        color.RGBA
+	}
+	discs := make([]disc, 6)
+	for i := range discs {
+		discs[i] = disc{r.Intn(256), r.Intn(256), 20 + r.Intn(60),
+			color.RGBA{R: uint8(r.Intn(256)), G: uint8(r.Intn(256)), B: uint8(r.Intn(256)), A: 255}}
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, 256, 256))
+	for y := 0; y < 256; y++ {
+		for x := 0; x < 256; x++ {
+			c := color.RGBA{R: uint8(x / 2), G: uint8(y / 2), B: 128, A: 255}
+			for _, d := range discs {
+				if (x-d.x)*(x-d.x)+(y-d.y)*(y-d.y) < d.radius*d.radius {
+					c = d.color
+				}
+			}
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+func createGradient() image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	for y := 0; y < 100; y++ {
+		for x := 0; x < 100; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(x * 2), G: uint8(y * 2), B: uint8((x * y) % 256), A: 255})
+		}
+	}
+	return img
+}
+
+func TestTilesCoverEdges(t *testing.T) {
+	tiles := im.Tiles(image.Rect(10, 10, 260, 110), 100, 80)
+
+	// Columns start at 0, 80 and the edge-aligned 150; the single row fits exactly
+	if !assert.Len(t, tiles, 3) {
+		return
+	}
+	assert.Equal(t, image.Rect(10, 10, 110, 110), tiles[0])
+	assert.Equal(t, image.Rect(90, 10, 190, 110), tiles[1])
+	assert.Equal(t, image.Rect(160, 10, 260, 110), tiles[2])
+
+	assert.Empty(t, im.Tiles(image.Rect(0, 0, 50, 50), 100, 10), "a window larger than the image")
+}
+
+func TestTileCount(t *testing.T) {
+	for _, c := range []struct {
+		bounds       image.Rectangle
+		size, stride int
+	}{
+		{image.Rect(10, 10, 260, 110), 100, 80},
+		{image.Rect(0, 0, 300, 200), 128, 64},
+		{image.Rect(0, 0, 256, 256), 16, 16},
+		{image.Rect(0, 0, 97, 61), 16, 7},
+		{image.Rect(0, 0, 50, 50), 100, 10},
+	} {
+		assert.Equal(t, len(im.Tiles(c.bounds, c.size, c.stride)), im.TileCount(c.bounds, c.size, c.stride), c)
+	}
+
+	// A 40 megapixel image in 16px windows one pixel apart is counted, not built
+	assert.Equal(t, 7285*5469, im.TileCount(image.Rect(0, 0, 7300, 5484), 16, 1))
+}
+
+func TestThumbnailQuality(t *testing.T) {
+	sizes := map[int]int{}
+	for _, quality := range []int{10, 100} {
+		opts := im.ThumbnailOptions{Width: 100, Format: im.ThumbnailJPEG, Quality: quality}
+		encoded := im.GenerateThumbnailWithOptions(createScene(1), opts)
+		data, err := base64.StdEncoding.DecodeString(encoded)
+		assert.NoError(t, err)
+
+		thumbnail, err := jpeg.Decode(bytes.NewReader(data))
+		if assert.NoError(t, err, "quality %d", quality) {
+			assert.Equal(t, 100, thumbnail.Bounds().Dx())
+		}
+		sizes[quality] = len(data)
+	}
+	assert.Less(t, sizes[10], sizes[100])
+}
+
+func TestContentHash(t *testing.T) {
+	scene := createScene(3)
+	nrgba := imaging.Clone(scene)
+	hash := im.ContentHash(scene)
+	assert.Len(t, hash, 64)
+	assert.Equal(t, hash, im.ContentHash(nrgba), "the same pixels in another image type")
+
+	// Sub-images hash their own rows, wherever their bounds start
+	rect := image.Rect(10, 20, 110, 70)
+	assert.Equal(t, im.ContentHash(imaging.Crop(scene, rect)), im.ContentHash(nrgba.SubImage(rect)))
+	assert.Equal(t, im.ContentHash(imaging.Crop(scene, rect)), im.ContentHash(scene.(*image.RGBA).SubImage(rect)))
+
+	nrgba.Set(5, 5, color.NRGBA{A: 255})
+	assert.NotEqual(t, hash, im.ContentHash(nrgba))
+}
+
+func TestWebPThumbnail(t *testing.T) {
+	format, err := im.ParseThumbnailFormat("WebP")
+	assert.NoError(t, err)
+	assert.Equal(t, im.ThumbnailWebP, format)
+	format, err = im.ParseThumbnailFormat("jpg")
+	assert.NoError(t, err)
+	assert.Equal(t, im.ThumbnailJPEG, format)
+	_, err = im.ParseThumbnailFormat("gif")
+	assert.Error(t, err)
+
+	encode := func(format im.ThumbnailFormat, quality int) []byte {
+		opts := im.ThumbnailOptions{Width: 100, Format: format, Quality: quality}
+		data, err := base64.StdEncoding.DecodeString(im.GenerateThumbnailWithOptions(createScene(2), opts))
+		assert.NoError(t, err)
+		return data
+	}
+	webp := encode(im.ThumbnailWebP, 75)
+	assert.Equal(t, "webp", im.DetectFormat(webp))
+	thumbnail, err := im.DecodeImage(bytes.NewReader(webp))
+	if assert.NoError(t, err) {
+		assert.Equal(t, 100, thumbnail.Bounds().Dx())
+	}
+	assert.Less(t, len(webp), len(encode(im.ThumbnailJPEG, 75)), "WebP beats JPEG at the same quality")
+	assert.Less(t, len(encode(im.ThumbnailWebP, 10)), len(webp), "quality drives the WebP size")
+}
+
+func TestHashConfigLength(t *testing.T) {
+	scene := createScene(4)
+	assert.Equal(t, im.ComputeDCTHash(scene), im.ComputeDCTHashWithConfig(scene, im.DefaultHashConfig))
+	assert.Len(t, im.ComputeDCTHash(scene), 72)
+
+	fine := im.HashConfig{Size: 64, Grid: 8}
+	assert.NoError(t, fine.Validate())
+	assert.Len(t, im.ComputeDCTHashWithConfig(scene, fine), fine.Length())
+	assert.Equal(t, 304, fine.Length())
+	assert.NotEqual(t, im.DefaultHashConfig.ID(), fine.ID())
+
+	assert.Error(t, im.HashConfig{Size: 30, Grid: 4}.Validate())
+	assert.Error(t, im.HashConfig{Size: 32, Grid: 1}.Validate())
+}
+
+func TestGrayWeights(t *testing.T) {
+	// Red and green halves of equal luminance, swapped between the two. At
+	// the hash size they are not resized, so no filtering blurs the edge.
+	red, green := color.NRGBA{255, 0, 0, 255}, color.NRGBA{0, 130, 0, 255}
+	halves := func(left, right color.Color) image.Image {
+		img := image.NewNRGBA(image.Rect(0, 0, 32, 32))
+		for y := 0; y < 32; y++ {
+			for x := 0; x < 32; x++ {
+				if x < 16 {
+					img.Set(x, y, left)
+				} else {
+					img.Set(x, y, right)
+				}
+			}
+		}
+		return img
+	}
+	a, b := halves(red, green), halves(green, red)
+	assert.Equal(t, im.ComputeDCTHash(a), im.ComputeDCTHash(b))
+
+	redHeavy, err := im.ParseGrayWeights("2, 0, 0")
+	assert.NoError(t, err)
+	assert.Equal(t, im.GrayWeights{R: 1}, redHeavy)
+	cfg := im.HashConfig{Size: 32, Grid: 4, Gray: redHeavy}
+	assert.NotEqual(t, im.ComputeDCTHashWithConfig(a, cfg), im.ComputeDCTHashWithConfig(b, cfg))
+	assert.Equal(t, "dct-32x4-gray1,0,0", cfg.ID())
+
+	// The default weighting leaves hashes and their config ID unchanged
+	scene := createScene(5)
+	luminance, err := im.ParseGrayWeights("")
+	assert.NoError(t, err)
+	standard := im.HashConfig{Size: 32, Grid: 4, Gray: luminance}
+	assert.Equal(t, im.ComputeDCTHash(scene), im.ComputeDCTHashWithConfig(scene, standard))
+	assert.Equal(t, im.DefaultHashConfig.ID(), standard.ID())
+	assert.Equal(t, imaging.Grayscale(scene).Pix, im.Grayscale(scene, im.Luminance).Pix)
+
+	for _, bad := range []string{"1,2", "a,b,c", "-1,1,1", "0,0,0"} {
+		_, err := im.ParseGrayWeights(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestIsGrayscale(t *testing.T) {
+	scene := createScene(3)
+	assert.False(t, im.IsGrayscale(scene))
+	assert.True(t, im.IsGrayscale(imaging.Grayscale(scene)))
+
+	// JPEG chroma noise on a desaturated copy is tolerated
+	var buf bytes.Buffer
+	assert.NoError(t, jpeg.Encode(&buf, imaging.Grayscale(scene), &jpeg.Options{Quality: 60}))
+	decoded, err := jpeg.Decode(&buf)
+	assert.NoError(t, err)
+	assert.True(t, im.IsGrayscale(decoded))
+}
+
+func TestSSIM(t *testing.T) {
+	scene := createScene(4)
+	assert.InDelta(t, 1, im.SSIM(scene, scene), 1e-9)
+
+	var buf bytes.Buffer
+	assert.NoError(t, jpeg.Encode(&buf, scene, &jpeg.Options{Quality: 75}))
+	compressed, err := jpeg.Decode(&buf)
+	assert.NoError(t, err)
+	recompressed := im.SSIM(scene, compressed)
+	other := im.SSIM(scene, createScene(5))
+	t.Logf("ssim: recompressed %.3f, other scene %.3f", recompressed, other)
+	assert.Greater(t, recompressed, 0.9)
+	assert.Less(t, other, recompressed)
+}
+
+// formImage draws a blank form: a ruled line at each of rows and a box at
+// each of boxes, in black on a white A4-like page
+func formImage(rows []int, boxes []image.Rectangle) *image.NRGBA {
+	page := imaging.New(600, 800, color.White)
+	black := image.NewUniform(color.Black)
+	for _, y := range rows {
+		draw.Draw(page, image.Rect(60, y, 540, y+3), black, image.Point{}, draw.Src)
+	}
+	for _, box := range boxes {
+		for _, side := range []image.Rectangle{
+			{box.Min, image.Pt(box.Max.X, box.Min.Y+3)},
+			{image.Pt(box.Min.X, box.Max.Y-3), box.Max},
+			{box.Min, image.Pt(box.Min.X+3, box.Max.Y)},
+			{image.Pt(box.Max.X-3, box.Min.Y), box.Max},
+		} {
+			draw.Draw(page, side, black, image.Point{}, draw.Src)
+		}
+	}
+	return page
+}
+
+func TestEdgeExtractorDocuments(t *testing.T) {
+	invoice := formImage([]int{120, 200, 280, 360, 440}, []image.Rectangle{image.Rect(60, 520, 300, 700)})
+	receipt := formImage([]int{150, 600, 650}, []image.Rectangle{image.Rect(320, 100, 540, 400), image.Rect(60, 250, 280, 500)})
+
+	// A scan of the invoice: slightly blurred, darker paper, JPEG artifacts
+	var buf bytes.Buffer
+	assert.NoError(t, jpeg.Encode(&buf, imaging.AdjustBrightness(imaging.Blur(invoice, 1), -10), &jpeg.Options{Quality: 60}))
+	scanned, err := jpeg.Decode(&buf)
+	assert.NoError(t, err)
+
+	ctx := context.Background()
+	extract := func(img image.Image) []float64 {
+		features, err := im.EdgeExtractor{}.ExtractFeatures(ctx, img)
+		assert.NoError(t, err)
+		assert.Len(t, features, im.EdgeGrid*im.EdgeGrid)
+		return features
+	}
+	same := im.CosineSimilarity(extract(invoice), extract(scanned))
+	different := im.CosineSimilarity(extract(invoice), extract(receipt))
+	assert.Greater(t, same, 95.0)
+	assert.Less(t, different, 50.0)
+
+	// HOG over the mostly white pages finds the two forms far more alike
+	hogDifferent := im.CosineSimilarity(im.ExtractImageFeatures(invoice), im.ExtractImageFeatures(receipt))
+	assert.Greater(t, hogDifferent, different+10)
+
+	// A blank page has no edges rather than amplified scanner noise
+	assert.Equal(t, make([]float64, im.EdgeGrid*im.EdgeGrid), extract(imaging.New(600, 800, color.Gray{Y: 250})))
+
+	assert.Equal(t, im.FeatureTypeEdge, im.FeatureTypeOf(im.EdgeExtractor{}))
+	assert.Equal(t, im.FeatureTypeHOG, im.FeatureTypeOf(nil))
+	_, err = im.ParseFeatureExtractor("sift")
+	assert.Error(t, err)
+}
+
+func TestResizeFilters(t *testing.T) {
+	scene := createScene(1)
+	lanczos := im.HashConfig{Size: 32, Grid: 4, Filter: im.FilterLanczos}
+	fast := lanczos
+	fast.Filter = ""
+	distance, err := im.HammingDistance(im.ComputeDCTHashWithConfig(scene, lanczos), im.ComputeDCTHashWithConfig(scene, fast))
+	assert.NoError(t, err)
+	assert.LessOrEqual(t, distance, 3, "the filter shifts a few of the 72 bits at most")
+	assert.Equal(t, lanczos.ID(), fast.ID())
+
+	filter, err := im.ParseResizeFilter("Box")
+	assert.NoError(t, err)
+	assert.Equal(t, im.FilterBox, filter)
+	_, err = im.ParseResizeFilter("bicubic")
+	assert.Error(t, err)
+}
+
+// BenchmarkResizeFilters compares the hash, feature and thumbnail throughput
+// of each filter on a 12 megapixel photo, the size of a typical upload:
+//
+//	go test ./image_test -run '^$' -bench ResizeFilters
+func BenchmarkResizeFilters(b *testing.B) {
+	photo := imaging.Resize(createScene(1), 4000, 3000, imaging.Linear)
+	for _, filter := range []string{im.FilterLanczos, im.FilterCatmullRom, im.FilterLinear, im.FilterBox} {
+		b.Run("hash/"+filter, func(b *testing.B) {
+			cfg := im.HashConfig{Size: 32, Grid: 4, Filter: filter}
+			for range b.N {
+				im.ComputeDCTHashWithConfig(photo, cfg)
+			}
+		})
+		b.Run("features/"+filter, func(b *testing.B) {
+			opts := im.FeatureOptions{Filter: filter}
+			for range b.N {
+				im.ExtractImageFeaturesWithOptions(context.Background(), photo, opts)
+			}
+		})
+		b.Run("thumbnail/"+filter, func(b *testing.B) {
+			opts := im.ThumbnailOptions{Width: 100, Format: im.ThumbnailJPEG, Filter: filter}
+			for range b.N {
+				im.GenerateThumbnailWithOptions(photo, opts)
+			}
+		})
+	}
+}
+
+func TestContrast(t *testing.T) {
+	solid := imaging.New(200, 150, color.NRGBA{R: 250, G: 250, B: 250, A: 255})
+	var buf bytes.Buffer
+	assert.NoError(t, jpeg.Encode(&buf, solid, &jpeg.Options{Quality: 20}))
+	compressed, err := jpeg.Decode(&buf)
+	assert.NoError(t, err)
+
+	assert.Equal(t, 0.0, im.Contrast(solid))
+	assert.Less(t, im.Contrast(compressed), 1.0, "compression noise is averaged away")
+	assert.Greater(t, im.Contrast(createScene(1)), 20.0)
+	assert.Greater(t, im.Contrast(createGradient()), 20.0)
+}
+
+func TestRegisteredFormat(t *testing.T) {
+	// A minimal plugin: "TOY1" followed by one gray level, decoded as a
+	// 16x16 image of that level
+	image.RegisterFormat("toy", "TOY1", func(r io.Reader) (image.Image, error) {
+		header := make([]byte, 5)
+		if _, err := io.ReadFull(r, header); err != nil {
+			return nil, err
+		}
+		return imaging.New(16, 16, color.Gray{Y: header[4]}), nil
+	}, func(r io.Reader) (image.Config, error) {
+		return image.Config{ColorModel: color.GrayModel, Width: 16, Height: 16}, nil
+	})
+
+	data := []byte("TOY1\x80")
+	assert.Equal(t, "toy", im.DetectFormat(data))
+	img, err := im.DecodeImage(bytes.NewReader(data))
+	assert.NoError(t, err)
+	assert.Equal(t, 16, img.Bounds().Dx())
+
+	assert.False(t, im.IsImageFile(".toy"))
+	assert.NoError(t, im.RegisterExtension(".TOY", "toy"))
+	assert.True(t, im.IsImageFile(".toy"))
+	assert.Equal(t, "toy", im.SupportedImageFormats[".toy"])
+	assert.NoError(t, im.RegisterExtension(".toy", "toy"))
+	assert.Error(t, im.RegisterExtension(".jpg", "toy"))
+	assert.Error(t, im.RegisterExtension("toy", "toy"))
+}
@@ -0,0 +1,73 @@
+package jobs_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"photot/helper/jobs"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// waitFor polls until the job leaves the pending and running states
+func waitFor(t *testing.T, m *jobs.Manager, id string) jobs.Job {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		job, ok := m.Get(id)
+		require.True(t, ok)
+		if job.Status == jobs.StatusDone || job.Status == jobs.StatusFailed {
+			return job
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("job %s did not finish", id)
+	return jobs.Job{}
+}
+
+func TestManager(t *testing.T) {
+	m := jobs.NewManager(2, time.Hour)
+
+	id, err := m.Submit("sum", func(ctx context.Context, progress func(float64)) (any, error) {
+		progress(0.5)
+		return 42, nil
+	})
+	require.NoError(t, err)
+	job := waitFor(t, m, id)
+	assert.Equal(t, jobs.StatusDone, job.Status)
+	assert.Equal(t, 42, job.Result)
+	assert.Equal(t, 1.0, job.Progress)
+	assert.NotNil(t, job.FinishedAt)
+
+	id, _ = m.Submit("fail", func(ctx context.Context, progress func(float64)) (any, error) {
+		return nil, errors.New("boom")
+	})
+	job = waitFor(t, m, id)
+	assert.Equal(t, jobs.StatusFailed, job.Status)
+	assert.Equal(t, "boom", job.Error)
+
+	id, _ = m.Submit("panic", func(ctx context.Context, progress func(float64)) (any, error) {
+		panic("bad input")
+	})
+	job = waitFor(t, m, id)
+	assert.Equal(t, jobs.StatusFailed, job.Status)
+	assert.Contains(t, job.Error, "bad input")
+
+	_, ok := m.Get("missing")
+	assert.False(t, ok)
+}
+
+func TestManagerExpiresFinishedJobs(t *testing.T) {
+	m := jobs.NewManager(1, 10*time.Millisecond)
+	id, err := m.Submit("quick", func(ctx context.Context, progress func(float64)) (any, error) {
+		return nil, nil
+	})
+	require.NoError(t, err)
+	waitFor(t, m, id)
+
+	time.Sleep(20 * time.Millisecond)
+	_, ok := m.Get(id)
+	assert.False(t, ok)
+}
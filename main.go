@@ -1,39 +1,98 @@
 package main
 
 import (
-	"log"
+	"log/slog"
 	"os"
+	"path/filepath"
 	"photot/api"
 	"photot/api/handler"
+	"photot/api/middleware"
+	"photot/helper/config"
 	"photot/helper/database"
+	"photot/helper/jobs"
+	"photot/helper/logging"
+	"photot/helper/webhook"
+	"time"
 )
 
 func main() {
-	hand := NewHandler()
+	logging.Init(os.Stderr)
+
+	cfg, err := config.Load()
+	if err != nil {
+		fatal("could not load config", "error", err)
+	}
+
+	switch {
+	case cfg.AdminAuthDisabled:
+		slog.Warn("PHOTOT_ADMIN_AUTH_DISABLED is set, /admin routes are unauthenticated")
+	case cfg.AdminAPIKey == "":
+		slog.Warn("PHOTOT_ADMIN_API_KEY is not set, /admin routes answer 503")
+	}
+
+	hand := NewHandler(cfg)
 	router := api.Router(hand)
-	log.Printf("server is running...")
-	log.Fatal(router.Run(":8080"))
+	slog.Info("server is running", "addr", cfg.Addr)
+	fatal("server stopped", "error", router.Run(cfg.Addr))
+}
+
+// fatal logs msg and its attributes as an error and exits. Errors are
+// logged at every level, so the reason for exiting is never filtered out.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
 }
 
-func NewHandler() *handler.Handler {
-	log.SetFlags(log.Ldate | log.Ltime | log.Lshortfile)
-	log.Println("server is preparing for run...")
+func NewHandler(cfg *config.Config) *handler.Handler {
+	slog.Info("server is preparing for run")
 
-	imageDir := "./images"
+	imageDir := cfg.ImageDir
 	if _, err := os.Stat(imageDir); os.IsNotExist(err) {
 		err = os.MkdirAll(imageDir, 0755)
 		if err != nil {
-			log.Fatalf("Unable to create pictures folder: %v", err)
+			fatal("unable to create pictures folder", "dir", imageDir, "error", err)
 		}
-		log.Printf("pictures folder is created: %s", imageDir)
+		slog.Info("pictures folder is created", "dir", imageDir)
 	}
 
 	db := database.NewImageDatabase()
-	if err := db.LoadImages(imageDir); err != nil {
-		log.Fatalf("Could not load images: %v", err)
-	}
-	return &handler.Handler{
+	hand := &handler.Handler{
 		DB:       db,
 		ImageDir: imageDir,
 	}
+	hand.Webhooks = webhook.NewNotifier(hand.WebhookSettings)
+	hand.Recognitions = middleware.NewConcurrencyLimiter(hand.RecognizeConcurrency)
+	if cfg.JobWorkers > 0 {
+		hand.Jobs = jobs.NewManager(cfg.JobWorkers, time.Duration(cfg.JobTTLMinutes)*time.Minute)
+	}
+	if cfg.MaxTenants > 0 {
+		hand.Tenants = database.NewRegistry(filepath.Join(imageDir, "tenants"), cfg.MaxTenants, db)
+	}
+	if err := hand.SetConfig(cfg); err != nil {
+		fatal("invalid config", "error", err)
+	}
+	if err := db.LoadImagesWithOptions(imageDir, loadOptions(cfg)); err != nil {
+		fatal("could not load images", "dir", imageDir, "error", err)
+	}
+	return hand
+}
+
+// loadProgressInterval is how often the startup load logs its progress
+const loadProgressInterval = 5 * time.Second
+
+// loadOptions loads with the configured workers, logging "loaded X/Y" every
+// loadProgressInterval so a long startup load can be told apart from a hang
+func loadOptions(cfg *config.Config) database.LoadOptions {
+	var lastReport time.Time
+	return database.LoadOptions{
+		Workers: cfg.LoadWorkers,
+		Progress: func(p database.LoadProgress) {
+			done := p.Loaded + p.Failed
+			if done < p.Total && time.Since(lastReport) < loadProgressInterval {
+				return
+			}
+			lastReport = time.Now()
+			slog.Info("loading images", "done", done, "total", p.Total, "failed", p.Failed)
+		},
+	}
 }
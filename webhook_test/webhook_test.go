@@ -0,0 +1,59 @@
+package webhook_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"photot/helper/webhook"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNotifierRetriesAndSigns(t *testing.T) {
+	var attempts atomic.Int32
+	received := make(chan *http.Request, 1)
+	bodies := make(chan []byte, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if attempts.Add(1) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		received <- r
+		bodies <- body
+	}))
+	defer server.Close()
+
+	notifier := webhook.NewNotifier(func() (string, string) { return server.URL, "secret" })
+	notifier.Backoff = time.Millisecond
+	notifier.Notify(webhook.Event{RequestID: "req-1", MatchedImage: "logo.png", Similarity: 97.5, Method: "ml"})
+
+	select {
+	case r := <-received:
+		body := <-bodies
+		assert.Equal(t, "sha256="+webhook.Sign(body, "secret"), r.Header.Get("X-Photot-Signature"))
+
+		var event webhook.Event
+		require.NoError(t, json.Unmarshal(body, &event))
+		assert.Equal(t, "match", event.Event)
+		assert.Equal(t, "req-1", event.RequestID)
+		assert.Equal(t, "logo.png", event.MatchedImage)
+		assert.Equal(t, int32(2), attempts.Load())
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook was not delivered")
+	}
+}
+
+func TestNotifierDisabled(t *testing.T) {
+	var nilNotifier *webhook.Notifier
+	nilNotifier.Notify(webhook.Event{RequestID: "ignored"})
+
+	notifier := webhook.NewNotifier(func() (string, string) { return "", "" })
+	notifier.Notify(webhook.Event{RequestID: "ignored"})
+}